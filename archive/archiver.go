@@ -0,0 +1,95 @@
+// Package archive moves old months of messages out of the database. Messages
+// are partitioned by month of sent_at; the archiver keeps partitions created
+// ahead of time and exports partitions past the retention window to object
+// storage before detaching them.
+package archive
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"internal_chat_system/repository"
+)
+
+const (
+	defaultInterval     = 6 * time.Hour
+	defaultRetainMonths = 12
+	defaultMonthsAhead  = 3
+)
+
+// Archiver runs partition maintenance every Interval. Partitions whose
+// month ended more than RetainMonths months ago are archived, oldest first.
+type Archiver struct {
+	Repo         repository.ArchiveStore
+	Interval     time.Duration
+	RetainMonths int
+	MonthsAhead  int
+}
+
+// Run maintains partitions until ctx is done.
+func (a *Archiver) Run(ctx context.Context) {
+	interval := a.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Println("🗄️ Message archiver started")
+	for {
+		a.RunOnce(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			log.Println("🗄️ Message archiver stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce creates upcoming partitions and archives expired ones as of now.
+// A partition that fails to archive stops the pass, so months are always
+// archived in order.
+func (a *Archiver) RunOnce(ctx context.Context, now time.Time) {
+	retain, ahead := a.RetainMonths, a.MonthsAhead
+	if retain <= 0 {
+		retain = defaultRetainMonths
+	}
+	if ahead <= 0 {
+		ahead = defaultMonthsAhead
+	}
+
+	if err := a.Repo.EnsureMessagePartitions(ctx, now, ahead); err != nil {
+		log.Printf("❌ Failed to create upcoming message partitions: %v", err)
+	}
+
+	names, err := a.Repo.ListMessagePartitions(ctx)
+	if err != nil {
+		log.Printf("❌ Failed to list message partitions: %v", err)
+		return
+	}
+
+	cutoff := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -retain, 0)
+	for _, name := range names {
+		end, ok := partitionMonth(name)
+		if !ok {
+			continue
+		}
+		if end = end.AddDate(0, 1, 0); end.After(cutoff) {
+			break
+		}
+		if _, err := a.Repo.ArchiveMessagePartition(ctx, name); err != nil {
+			log.Printf("❌ Failed to archive %s: %v", name, err)
+			return
+		}
+	}
+}
+
+// partitionMonth returns the first day of the month a partition holds.
+func partitionMonth(partition string) (time.Time, bool) {
+	t, err := time.Parse("messages_p2006_01", partition)
+	return t, err == nil
+}
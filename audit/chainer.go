@@ -0,0 +1,64 @@
+// Package audit runs the job that moves queued audit entries into each
+// location's hash chain.
+package audit
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	defaultInterval  = time.Second
+	defaultBatchSize = 500
+)
+
+// Queue chains queued entries. repository.AuditRepo implements it.
+type Queue interface {
+	ChainPending(ctx context.Context, limit int) (int, error)
+}
+
+// Chainer chains queued entries every Interval, in batches of BatchSize.
+// Entries are durable once queued; until chained they don't show up in
+// audit queries or verification.
+type Chainer struct {
+	Queue     Queue
+	Interval  time.Duration
+	BatchSize int
+}
+
+// Run chains entries until ctx is done.
+func (c *Chainer) Run(ctx context.Context) {
+	interval, batch := c.Interval, c.BatchSize
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	if batch <= 0 {
+		batch = defaultBatchSize
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Println("🔗 Audit chainer started")
+	for {
+		// Drain full batches before waiting again
+		for {
+			n, err := c.Queue.ChainPending(ctx, batch)
+			if err != nil {
+				log.Printf("❌ Chaining audit entries failed: %v", err)
+				break
+			}
+			if n < batch {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("🔗 Audit chainer stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
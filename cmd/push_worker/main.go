@@ -13,7 +13,7 @@ type PushEvent struct {
 	LocationID   string `json:"location_id"`
 	ReceiverID   string `json:"receiver_id"`
 	ReceiverType string `json:"receiver_type"`
-	Content      string `json:"content"`
+	Silent       bool   `json:"silent,omitempty"`
 }
 
 var ctx = context.Background()
@@ -40,10 +40,13 @@ func main() {
 			continue
 		}
 
+		if event.Silent {
+			log.Printf("🔕 Silent push (receiver unavailable): [%s] -> %s:%s", event.MessageID, event.ReceiverType, event.ReceiverID)
+			continue
+		}
+
 		// 🔔 Simulated push action (replace this with Firebase/Twilio/Mailgun/etc.)
-		log.Printf("🔔 New push: [%s] -> %s:%s — \"%s\"",
-			event.MessageID, event.ReceiverType, event.ReceiverID, event.Content,
-		)
+		log.Printf("🔔 New push: [%s] -> %s:%s", event.MessageID, event.ReceiverType, event.ReceiverID)
 
 		// TODO: SendPushNotification(event)
 	}
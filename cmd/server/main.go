@@ -2,18 +2,33 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
+	"strings"
 
+	"internal_chat_system/archive"
+	"internal_chat_system/audit"
+	"internal_chat_system/encryption"
 	"internal_chat_system/handlers"
+	"internal_chat_system/historycache"
 	"internal_chat_system/internal/s3"
+	"internal_chat_system/middleware/auth"
+	"internal_chat_system/migrations"
 	"internal_chat_system/notifications"
+	"internal_chat_system/outbox"
+	"internal_chat_system/presence"
 	"internal_chat_system/redis"
 	"internal_chat_system/repository"
+	"internal_chat_system/retention"
 	"internal_chat_system/ws"
 
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
@@ -21,11 +36,25 @@ import (
 )
 
 func main() {
-
-	err := notifications.Init("config/firebase-service-account.json")
-	if err != nil {
-		log.Fatal("Failed to initialize Firebase")
-	}
+	autoMigrate := flag.Bool("migrate", false, "apply pending database migrations before starting")
+	replicaDSNs := flag.String("replicas", "", "comma-separated read replica connection strings")
+	keyfile := flag.String("keyfile", "", "master key file for encrypting message content at rest")
+	kmsKey := flag.String("kms-key", "", "KMS key id, ARN or alias to use as the master key instead of -keyfile")
+	prevKeyfile := flag.String("previous-keyfile", "", "master key file being rotated out, until its data keys are rewrapped")
+	prevKMSKey := flag.String("previous-kms-key", "", "KMS master key being rotated out, until its data keys are rewrapped")
+	historyCacheConfig := flag.String("history-cache-config", "", "JSON file with the history cache defaults and per-location overrides")
+	var timeouts repository.Timeouts
+	flag.DurationVar(&timeouts.Read, "read-timeout", repository.DefaultTimeouts.Read, "limit for a database lookup, history page or listing")
+	flag.DurationVar(&timeouts.Write, "write-timeout", repository.DefaultTimeouts.Write, "limit for a database write and its transaction")
+	flag.DurationVar(&timeouts.Search, "search-timeout", repository.DefaultTimeouts.Search, "limit for a full-text search or delta sync")
+	flag.DurationVar(&timeouts.Outbox, "outbox-timeout", repository.DefaultTimeouts.Outbox, "limit for handling one outbox event")
+	flag.DurationVar(&timeouts.Archive, "archive-timeout", repository.DefaultTimeouts.Archive, "limit for archiving a partition or reading archived history")
+	flag.DurationVar(&timeouts.Purge, "purge-timeout", repository.DefaultTimeouts.Purge, "limit for one batch of a retention purge")
+	flag.DurationVar(&timeouts.Audit, "audit-timeout", repository.DefaultTimeouts.Audit, "limit for verifying a location's audit chain")
+	flag.DurationVar(&timeouts.Reencrypt, "reencrypt-timeout", repository.DefaultTimeouts.Reencrypt, "limit for one batch of re-encryption")
+	redisTimeout := flag.Duration("redis-timeout", redis.DefaultTimeout, "limit for a single Redis command")
+	presenceTimeout := flag.Duration("presence-timeout", presence.DefaultTimeout, "limit for a single presence lookup or update")
+	flag.Parse()
 
 	db, err := sql.Open("postgres", "postgres://postgres@localhost:5432/chat_db?sslmode=disable")
 	if err != nil {
@@ -37,7 +66,83 @@ func main() {
 		log.Fatal("Cannot connect to PostgreSQL:", err)
 	}
 
+	// server migrate [up|down N|status]
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(db, flag.Args()[1:]); err != nil {
+			log.Fatal("❌ Migration failed: ", err)
+		}
+		return
+	}
+
+	if *autoMigrate {
+		if _, err := migrations.Up(context.Background(), db); err != nil {
+			log.Fatal("❌ Migration failed: ", err)
+		}
+	}
+	// Refuse to serve against a schema older than the queries expect
+	if err := migrations.Check(context.Background(), db); err != nil {
+		log.Fatal("❌ ", err)
+	}
+
+	err = notifications.Init("config/firebase-service-account.json")
+	if err != nil {
+		log.Fatal("Failed to initialize Firebase")
+	}
+
+	// History pages, search and session listings read from the replicas,
+	// except for callers who wrote in the last few seconds
+	var replicas []*sql.DB
+	for _, dsn := range strings.Split(*replicaDSNs, ",") {
+		if dsn = strings.TrimSpace(dsn); dsn == "" {
+			continue
+		}
+		replica, err := sql.Open("postgres", dsn)
+		if err != nil {
+			log.Fatal("Failed to connect to replica:", err)
+		}
+		defer replica.Close()
+		if err := replica.Ping(); err != nil {
+			log.Fatal("Cannot connect to PostgreSQL replica:", err)
+		}
+		replicas = append(replicas, replica)
+	}
+	repository.SetReplicas(replicas, repository.DefaultStickyWindow)
+
+	// Every query and Redis command runs under the caller's context, bounded
+	// by these per-operation timeouts
+	repository.SetTimeouts(timeouts)
+
+	// Message content and file fields are sealed with per-location data
+	// keys wrapped by the master key; without one they are stored as
+	// plaintext
+	encryptionRepo := repository.NewEncryptionRepo(db)
+	master, err := loadMasterKey(*keyfile, *kmsKey)
+	if err != nil {
+		log.Fatal("❌ Failed to load master key: ", err)
+	}
+	if master != nil {
+		var previous []encryption.MasterKey
+		prev, err := loadMasterKey(*prevKeyfile, *prevKMSKey)
+		if err != nil {
+			log.Fatal("❌ Failed to load previous master key: ", err)
+		}
+		if prev != nil {
+			previous = append(previous, prev)
+		}
+		repository.SetKeyring(encryption.NewKeyring(master, encryptionRepo, previous...))
+		log.Printf("🔐 Encrypting message content at rest with master key %s", master.ID())
+	}
+
 	redis.Init("localhost:6379", "", 0)
+	redis.SetTimeout(*redisTimeout)
+	// Callers who just wrote read from the primary on every instance
+	repository.SetWriteMarks(redis.StickyWrites{})
+	presence.Init(redis.Client())
+	presence.SetTimeout(*presenceTimeout)
+
+	// ctx scopes the background workers: presence fan-out, location
+	// subscriptions and the outbox relay
+	ctx := context.Background()
 
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
@@ -49,43 +154,163 @@ func main() {
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
-	// r.Use(auth.JWTMiddleware)
 
 	hub := ws.NewHub()
-	go hub.Run()
+
+	// Fan presence transitions from every instance out to local watchers
+	go presence.Watch(ctx, func(e presence.Event) {
+		hub.Presence <- e
+	})
 
 	// Subscribe to active location(s)
-	go redis.Subscribe("default-location-id", hub)
+	redis.Subscribe(ctx, "default-location-id", hub)
 
 	// repo := repository.NewMessageRepo(db)
 	// handlers.Init(repo)
 
-	repo := repository.NewMessageRepo(db)
-	sessionRepo := repository.NewChatSessionRepo(db)
-	handlers.Init(repo, sessionRepo)
 	s3.Init()
+	repo := repository.NewMessageRepo(db)
+	// Scrolling back past the database reads archived months from S3
+	repo.Storage = s3.Storage{}
+	// Newest history pages of busy sessions are served from Redis; every
+	// send, edit, delete, read, reaction and pin goes through the cache
+	cacheDefaults, cacheLocations, err := historycache.LoadConfig(*historyCacheConfig)
+	if err != nil {
+		log.Fatal("Failed to load history cache config:", err)
+	}
+	history := historycache.New(redis.Client(), repo, repo, repo, cacheDefaults, cacheLocations)
+	auditRepo := repository.NewAuditRepo(db)
+	retentionRepo := repository.NewRetentionRepo(db)
+	// Purges rewrite archived months in S3 too
+	retentionRepo.Storage = s3.Storage{}
+	api := handlers.NewAPI(handlers.Deps{
+		Messages:    history,
+		Sessions:    repository.NewChatSessionRepo(db),
+		Reactions:   history,
+		Pins:        history,
+		Devices:     repository.NewDeviceTokenRepo(db),
+		OfficeHours: repository.NewOfficeHoursRepo(db),
+		Audit:       auditRepo,
+		Retention:   retentionRepo,
+		LegalHolds:  repository.NewLegalHoldRepo(db),
+		Encryption:  encryptionRepo,
+		Files:       s3.Storage{},
+		Presence:    presence.Redis{},
+		CacheStats:  historycache.Snapshot,
+		Hub:         hub,
+	})
+	hub.CanWatch = api.CanWatchPresence
+	hub.SendMessage = api.SendSocketMessage
+	go hub.Run()
+
+	// Deliveries, pushes and auto-replies are queued in the outbox with the
+	// message that caused them and dispatched from here
+	relay := &outbox.Relay{
+		Repo:   repository.NewOutboxRepo(db),
+		Handle: api.DispatchOutboxEvent,
+	}
+	go relay.Run(ctx)
+
+	// Audit entries are queued by requests and chained here in batches
+	chainer := &audit.Chainer{Queue: auditRepo}
+	go chainer.Run(ctx)
 
-	r.Post("/chat/send", wrapJSON(handlers.SendMessage(hub)))
-	r.Get("/chat/history", wrapJSON(handlers.GetMessageHistory))
-	r.Get("/ws", handlers.HandleWebSocket(hub))
-	r.Put("/chat/read", wrapJSON(handlers.MarkMessageAsRead))
-	r.Get("/chat/sessions", wrapJSON(handlers.ListChatSessions(repo)))
-	r.Get("/chat/search", handlers.SearchMessages(repo))
-	r.Get("/admin/chat/sessions", handlers.AdminListSessions(repo))
-	r.Put("/admin/chat/messages/delete", handlers.AdminDeleteMessages(repo))
-	r.Get("/chat/presence", handlers.GetPresenceStatus)
-	r.Post("/chat/upload", handlers.UploadChatFile)
-	r.Delete("/chat/message/{id}", handlers.DeleteChatMessage(repo))
-	r.Post("/chat/message/reaction", handlers.AddReaction(repo))
-	r.Delete("/chat/message/reaction", handlers.RemoveReaction(repo))
-	r.Put("/chat/message/{id}/pin", handlers.PinMessage(repo))
-	r.Put("/chat/message/{id}/unpin", handlers.UnpinMessage(repo))
-	r.Get("/chat/session/{session_id}/pinned", handlers.GetPinnedMessages(repo))
+	// Messages are partitioned by month; the archiver creates partitions
+	// ahead of time and moves months past retention to S3
+	archiver := &archive.Archiver{
+		Repo:         repository.NewArchiveRepo(db, s3.Storage{}),
+		RetainMonths: 12,
+	}
+	go archiver.Run(ctx)
+
+	// Daily purge of messages past each location's retention policy
+	purger := &retention.Purger{
+		Repo:     retentionRepo,
+		Files:    s3.Storage{},
+		Audit:    auditRepo,
+		OnPurged: history.InvalidateSessions,
+	}
+	go purger.Run(ctx)
+
+	// Endpoints that predate JWT enforcement still serve callers without a
+	// token, as they always have; a token, when sent, must be valid. The
+	// socket verifies its own token, sent as ?access_token= since browsers
+	// can't set headers on sockets
+	r.Group(func(r chi.Router) {
+		r.Use(auth.OptionalJWTMiddleware)
+		r.Use(handlers.TagCaller)
+
+		r.Get("/chat/history", wrapJSON(api.GetMessageHistory))
+		r.Get("/ws", api.HandleWebSocket)
+		r.Put("/chat/read", wrapJSON(api.MarkMessageAsRead))
+		r.Get("/chat/sessions", wrapJSON(api.ListChatSessions))
+		r.Get("/chat/search", api.SearchMessages)
+		r.Get("/admin/chat/sessions", api.AdminListSessions)
+		r.Put("/admin/chat/messages/delete", api.AdminDeleteMessages)
+		r.Get("/chat/presence", api.GetPresenceStatus)
+		r.Post("/chat/upload", api.UploadChatFile)
+		r.Delete("/chat/message/{id}", api.DeleteChatMessage)
+		r.Post("/chat/message/reaction", api.AddReaction)
+		r.Delete("/chat/message/reaction", api.RemoveReaction)
+		r.Put("/chat/message/{id}/pin", api.PinMessage)
+		r.Put("/chat/message/{id}/unpin", api.UnpinMessage)
+		r.Get("/chat/session/{session_id}/pinned", api.GetPinnedMessages)
+	})
+
+	// Everything added since acts as the caller named by the JWT, and so
+	// does sending, whose retries are keyed on the authenticated sender
+	r.Group(func(r chi.Router) {
+		r.Use(auth.JWTMiddleware)
+		r.Use(handlers.TagCaller)
+
+		r.Post("/chat/send", wrapJSON(api.SendMessage))
+		r.Get("/chat/search/all", wrapJSON(api.SearchAllConversations))
+		r.Get("/admin/chat/search", wrapJSON(api.AdminSearchMessages))
+		r.Get("/admin/chat/cache/stats", wrapJSON(api.GetHistoryCacheStats))
+		r.Get("/admin/chat/retention", wrapJSON(api.GetRetentionPolicy))
+		r.Put("/admin/chat/retention", wrapJSON(api.UpsertRetentionPolicy))
+		r.Get("/admin/chat/retention/reports", wrapJSON(api.ListRetentionReports))
+		r.Post("/admin/chat/legal-holds", wrapJSON(api.PlaceLegalHold))
+		r.Get("/admin/chat/legal-holds", wrapJSON(api.ListLegalHolds))
+		r.Put("/admin/chat/legal-holds/{id}/release", wrapJSON(api.ReleaseLegalHold))
+		r.Get("/admin/chat/audit", wrapJSON(api.ListAuditEntries))
+		r.Get("/admin/chat/audit/verify", wrapJSON(api.VerifyAuditChain))
+		r.Get("/admin/chat/encryption", wrapJSON(api.GetEncryptionStatus))
+		r.Post("/admin/chat/encryption/rotate", wrapJSON(api.RotateEncryptionKey))
+		r.Post("/admin/chat/encryption/reencrypt", wrapJSON(api.ReencryptMessages))
+		r.Post("/admin/chat/encryption/rewrap", wrapJSON(api.RewrapEncryptionKeys))
+		r.Put("/chat/presence/status", api.SetPresenceStatus)
+		r.Post("/chat/presence/bulk", wrapJSON(api.GetBulkPresenceStatus))
+		r.Get("/chat/office-hours", api.GetOfficeHours)
+		r.Put("/chat/office-hours", api.UpsertOfficeHours)
+		r.Post("/chat/office-hours/holidays", api.AddOfficeHoliday)
+		r.Delete("/chat/office-hours/holidays/{id}", api.DeleteOfficeHoliday)
+		r.Put("/chat/message/{id}", wrapJSON(api.EditMessage))
+		r.Get("/chat/message/{id}/revisions", wrapJSON(api.GetMessageRevisions))
+		r.Get("/chat/message/{id}/thread", wrapJSON(api.GetMessageThread))
+		r.Get("/chat/message/{id}/file", api.DownloadChatFile)
+		r.Get("/chat/session/{session_id}/events", wrapJSON(api.GetSessionEvents))
+		r.Post("/chat/sync", wrapJSON(api.SyncChanges))
+	})
 
 	log.Println("✅ Server started on :8080")
 	http.ListenAndServe(":8080", r)
 }
 
+// loadMasterKey returns the master key named by a keyfile or a KMS key,
+// or nil if neither is set.
+func loadMasterKey(keyfile, kmsKey string) (encryption.MasterKey, error) {
+	switch {
+	case keyfile != "" && kmsKey != "":
+		return nil, errors.New("use either a keyfile or a KMS key, not both")
+	case keyfile != "":
+		return encryption.LoadKeyFile(keyfile)
+	case kmsKey != "":
+		return encryption.KMSKey{Client: kms.New(session.Must(session.NewSession())), KeyID: kmsKey}, nil
+	}
+	return nil, nil
+}
+
 // wrapJSON ensures content-type JSON and proper error message format
 func wrapJSON(h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
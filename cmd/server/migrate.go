@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"internal_chat_system/migrations"
+)
+
+// runMigrate implements the migrate subcommand:
+//
+//	migrate [up]    apply every pending migration
+//	migrate down N  revert the newest N migrations (default 1)
+//	migrate status  list migrations and when they were applied
+func runMigrate(db *sql.DB, args []string) error {
+	ctx := context.Background()
+
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	switch cmd {
+	case "up":
+		applied, err := migrations.Up(ctx, db)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ Applied %d migration(s), schema at version %d\n", len(applied), migrations.Latest())
+		return nil
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid step count %q", args[1])
+			}
+			steps = n
+		}
+		reverted, err := migrations.Down(ctx, db, steps)
+		if err != nil {
+			return err
+		}
+		current, err := migrations.Current(ctx, db)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ Reverted %d migration(s), schema at version %d\n", len(reverted), current)
+		return nil
+
+	case "status":
+		statuses, err := migrations.List(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-28s %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown migrate command %q (want up, down or status)", cmd)
+}
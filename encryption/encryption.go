@@ -0,0 +1,220 @@
+// Package encryption seals message content at rest with envelope
+// encryption. Each location has its own data keys, stored wrapped by a
+// master key that never leaves its keyfile or KMS; the Keyring unwraps and
+// caches them. Sealed values are self-describing strings, so a column can
+// hold plaintext from before encryption was enabled next to values sealed
+// under any of the location's keys.
+//
+// Sealed content can't be searched by the database, so each data key also
+// derives a blind index key: messages store keyed hashes of their words,
+// and a search hashes its terms the same way and matches them exactly.
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"internal_chat_system/models"
+
+	"github.com/google/uuid"
+)
+
+// sealedPrefix starts every sealed value: "enc:1:<key id>:<base64>". The
+// 1 is the format version.
+const sealedPrefix = "enc:1:"
+
+const (
+	keySize   = 32 // AES-256
+	tokenSize = 12 // bytes of HMAC kept per blind index token
+
+	// minWordLength drops single letters, which would match nearly every
+	// message and only help frequency analysis of the tokens.
+	minWordLength = 2
+)
+
+var (
+	// ErrKeyNotFound means there is no such data key, or the location has
+	// no active key yet.
+	ErrKeyNotFound = errors.New("encryption key not found")
+
+	// ErrCorrupt means a sealed value failed authentication: it was altered,
+	// or opened with the wrong message.
+	ErrCorrupt = errors.New("sealed value failed authentication")
+)
+
+// MasterKey wraps and unwraps data keys. ID names the key a data key was
+// wrapped with, so data keys survive a change of master key.
+type MasterKey interface {
+	ID() string
+	Wrap(ctx context.Context, key []byte) ([]byte, error)
+	Unwrap(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// DataKey is an unwrapped data key, ready to seal and open values and to
+// make blind index tokens.
+type DataKey struct {
+	models.EncryptionKey
+	aead  cipher.AEAD
+	index []byte
+}
+
+// newDataKey derives separate content and index keys from raw, so the
+// tokens say nothing about the content key.
+func newDataKey(rec models.EncryptionKey, raw []byte) (*DataKey, error) {
+	if len(raw) != keySize {
+		return nil, fmt.Errorf("data key %s has %d bytes, want %d", rec.ID, len(raw), keySize)
+	}
+	contentKey, err := hkdf.Key(sha256.New, raw, nil, "chat content v1", keySize)
+	if err != nil {
+		return nil, err
+	}
+	indexKey, err := hkdf.Key(sha256.New, raw, nil, "chat blind index v1", keySize)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	return &DataKey{EncryptionKey: rec, aead: aead, index: indexKey}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts plaintext bound to aad, the ID of the row it belongs to,
+// so a sealed value copied onto another row won't open. Empty values stay
+// empty, so "no file" and "no text" read the same as before.
+func (k *DataKey) Seal(plaintext, aad string) string {
+	if plaintext == "" {
+		return ""
+	}
+	nonce := make([]byte, k.aead.NonceSize(), k.aead.NonceSize()+len(plaintext)+k.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	payload := k.aead.Seal(nonce, nonce, []byte(plaintext), []byte(aad))
+	return sealedPrefix + k.ID + ":" + base64.RawStdEncoding.EncodeToString(payload)
+}
+
+func (k *DataKey) open(payload []byte, aad string) (string, error) {
+	n := k.aead.NonceSize()
+	if len(payload) < n {
+		return "", ErrCorrupt
+	}
+	plaintext, err := k.aead.Open(nil, payload[:n], payload[n:], []byte(aad))
+	if err != nil {
+		return "", ErrCorrupt
+	}
+	return string(plaintext), nil
+}
+
+// Tokens returns the sorted, distinct blind index tokens of the words in
+// texts.
+func (k *DataKey) Tokens(texts ...string) []string {
+	seen := make(map[string]bool)
+	for _, text := range texts {
+		for _, w := range Words(text) {
+			seen[k.token(w)] = true
+		}
+	}
+	tokens := make([]string, 0, len(seen))
+	for t := range seen {
+		tokens = append(tokens, t)
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+// WordTokens hashes words already split by Words, e.g. search terms.
+func (k *DataKey) WordTokens(words []string) []string {
+	tokens := make([]string, len(words))
+	for i, w := range words {
+		tokens[i] = k.token(w)
+	}
+	return tokens
+}
+
+func (k *DataKey) token(word string) string {
+	mac := hmac.New(sha256.New, k.index)
+	mac.Write([]byte(word))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:tokenSize])
+}
+
+// Words splits text into the lowercased words the blind index keeps:
+// runs of letters and digits, at least minWordLength long, each once.
+func Words(text string) []string {
+	var words []string
+	seen := make(map[string]bool)
+	for _, f := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if utf8.RuneCountInString(f) < minWordLength || seen[f] {
+			continue
+		}
+		seen[f] = true
+		words = append(words, f)
+	}
+	return words
+}
+
+// IsSealed reports whether s looks like a sealed value rather than
+// plaintext.
+func IsSealed(s string) bool {
+	_, _, ok := parseSealed(s)
+	return ok
+}
+
+// KeyIDOf returns the ID of the key s was sealed with, or "" for plaintext.
+func KeyIDOf(s string) string {
+	id, _, _ := parseSealed(s)
+	return id
+}
+
+// parseSealed splits a sealed value into its key ID and payload. Anything
+// that doesn't parse is plaintext, e.g. a message that happens to start
+// with "enc:" from before encryption was enabled.
+func parseSealed(s string) (string, []byte, bool) {
+	rest, ok := strings.CutPrefix(s, sealedPrefix)
+	if !ok {
+		return "", nil, false
+	}
+	id, encoded, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", nil, false
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return "", nil, false
+	}
+	payload, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, false
+	}
+	return id, payload, true
+}
+
+// newRawKey returns a fresh random data key.
+func newRawKey() []byte {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
@@ -0,0 +1,82 @@
+package encryption
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"internal_chat_system/models"
+
+	"github.com/google/uuid"
+)
+
+func newTestKey(t *testing.T) *DataKey {
+	t.Helper()
+	k, err := newDataKey(models.EncryptionKey{ID: uuid.NewString()}, newRawKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestSealOpen(t *testing.T) {
+	k := newTestKey(t)
+	ring := &Keyring{keys: map[string]*DataKey{k.ID: k}}
+	ctx := context.Background()
+
+	sealed := k.Seal("take two tablets", "msg-1")
+	if !IsSealed(sealed) || KeyIDOf(sealed) != k.ID || strings.Contains(sealed, "tablets") {
+		t.Fatalf("sealed = %q", sealed)
+	}
+	if got, err := ring.Open(ctx, sealed, "msg-1"); err != nil || got != "take two tablets" {
+		t.Errorf("Open = %q, %v", got, err)
+	}
+	if sealed == k.Seal("take two tablets", "msg-1") {
+		t.Error("sealing twice gave the same value")
+	}
+
+	// A value copied onto another row doesn't open
+	if _, err := ring.Open(ctx, sealed, "msg-2"); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Open with another row = %v, want ErrCorrupt", err)
+	}
+
+	// Empty values stay empty and plaintext passes through
+	if got := k.Seal("", "msg-1"); got != "" {
+		t.Errorf("Seal of empty = %q", got)
+	}
+	for _, plain := range []string{"hello", "enc:1:not-a-key:abc", "enc:1:" + k.ID + ":***"} {
+		if got, err := ring.Open(ctx, plain, "msg-1"); err != nil || got != plain {
+			t.Errorf("Open(%q) = %q, %v", plain, got, err)
+		}
+	}
+}
+
+func TestWords(t *testing.T) {
+	got := Words("Take 2 tablets, then TAKE 20mg… Ça va?")
+	want := []string{"take", "tablets", "then", "20mg", "ça", "va"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Words = %q, want %q", got, want)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	k, other := newTestKey(t), newTestKey(t)
+
+	tokens := k.Tokens("Blood test tomorrow", "blood TEST")
+	if len(tokens) != 3 {
+		t.Fatalf("Tokens = %q, want 3 distinct", tokens)
+	}
+	search := k.WordTokens(Words("test"))
+	found := false
+	for _, tok := range tokens {
+		found = found || tok == search[0]
+	}
+	if !found {
+		t.Errorf("search token %q not among %q", search[0], tokens)
+	}
+	if other.WordTokens([]string{"test"})[0] == search[0] {
+		t.Error("two keys made the same token")
+	}
+}
@@ -0,0 +1,250 @@
+package encryption
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"internal_chat_system/models"
+)
+
+// activeKeyTTL bounds how long a location's active key is cached. After
+// a rotation on another instance, this one keeps sealing under the old key
+// for at most this long; re-encryption picks those rows up later.
+const activeKeyTTL = time.Minute
+
+// KeyStore keeps the wrapped data keys. ActiveEncryptionKey and
+// GetEncryptionKey return ErrKeyNotFound when there is no such key.
+type KeyStore interface {
+	ActiveEncryptionKey(ctx context.Context, locationID string) (models.EncryptionKey, error)
+	GetEncryptionKey(ctx context.Context, id string) (models.EncryptionKey, error)
+	// ListEncryptionKeys lists a location's keys, newest first, or every
+	// location's for an empty locationID.
+	ListEncryptionKeys(ctx context.Context, locationID string) ([]models.EncryptionKey, error)
+	// CreateEncryptionKey stores k as its location's active key and sets
+	// its ID, Version and CreatedAt. With rotate, the current active key is
+	// retired; without, a location that already has an active key keeps
+	// it and k is filled in with that key instead.
+	CreateEncryptionKey(ctx context.Context, k *models.EncryptionKey, rotate bool) error
+	RewrapEncryptionKey(ctx context.Context, id string, wrapped []byte, masterKeyID string) error
+}
+
+type activeKey struct {
+	id        string
+	checkedAt time.Time
+}
+
+// Keyring hands out unwrapped data keys, creating a location's first key
+// when it first seals something. Unwrapped keys are cached for the life of
+// the process, since they never change.
+type Keyring struct {
+	master   MasterKey
+	previous map[string]MasterKey
+	store    KeyStore
+
+	mu     sync.Mutex
+	keys   map[string]*DataKey
+	active map[string]activeKey
+}
+
+// NewKeyring seals with data keys wrapped by master. previous are master
+// keys being rotated out: they still unwrap the data keys they wrapped
+// until Rewrap moves those to master.
+func NewKeyring(master MasterKey, store KeyStore, previous ...MasterKey) *Keyring {
+	r := &Keyring{
+		master:   master,
+		previous: make(map[string]MasterKey),
+		store:    store,
+		keys:     make(map[string]*DataKey),
+		active:   make(map[string]activeKey),
+	}
+	for _, m := range previous {
+		r.previous[m.ID()] = m
+	}
+	return r
+}
+
+// MasterKeyID names the master key new data keys are wrapped with.
+func (r *Keyring) MasterKeyID() string {
+	return r.master.ID()
+}
+
+// Active returns the key a location seals with, creating it on first use.
+func (r *Keyring) Active(ctx context.Context, locationID string) (*DataKey, error) {
+	r.mu.Lock()
+	a, ok := r.active[locationID]
+	r.mu.Unlock()
+	if ok && time.Since(a.checkedAt) < activeKeyTTL {
+		return r.Key(ctx, a.id)
+	}
+
+	rec, err := r.store.ActiveEncryptionKey(ctx, locationID)
+	if errors.Is(err, ErrKeyNotFound) {
+		return r.create(ctx, locationID, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.remember(ctx, rec, true)
+}
+
+// Rotate makes a new active key for a location and retires the old one.
+// Values sealed under the old key still open; ReencryptMessages moves them
+// to the new one.
+func (r *Keyring) Rotate(ctx context.Context, locationID string) (*DataKey, error) {
+	return r.create(ctx, locationID, true)
+}
+
+func (r *Keyring) create(ctx context.Context, locationID string, rotate bool) (*DataKey, error) {
+	raw := newRawKey()
+	wrapped, err := r.master.Wrap(ctx, raw)
+	if err != nil {
+		log.Printf("❌ Failed to wrap data key for location %s: %v", locationID, err)
+		return nil, err
+	}
+	rec := models.EncryptionKey{LocationID: locationID, WrappedKey: wrapped, MasterKeyID: r.master.ID()}
+	if err := r.store.CreateEncryptionKey(ctx, &rec, rotate); err != nil {
+		return nil, err
+	}
+	if rotate {
+		log.Printf("🔑 Rotated location %s to data key %s (version %d)", locationID, rec.ID, rec.Version)
+	} else {
+		log.Printf("🔑 Created data key %s for location %s", rec.ID, locationID)
+	}
+	// Another instance may have created the location's first key
+	// concurrently, in which case rec is theirs and must be unwrapped
+	return r.remember(ctx, rec, true)
+}
+
+// Key returns the data key with the given ID, whichever location and
+// state it has.
+func (r *Keyring) Key(ctx context.Context, id string) (*DataKey, error) {
+	r.mu.Lock()
+	k, ok := r.keys[id]
+	r.mu.Unlock()
+	if ok {
+		return k, nil
+	}
+
+	rec, err := r.store.GetEncryptionKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return r.remember(ctx, rec, false)
+}
+
+// Keys returns every data key of a location, active first. Search needs
+// all of them, since messages keep the tokens of the key they were sealed
+// with until they are re-encrypted.
+func (r *Keyring) Keys(ctx context.Context, locationID string) ([]*DataKey, error) {
+	recs, err := r.store.ListEncryptionKeys(ctx, locationID)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]*DataKey, 0, len(recs))
+	for _, rec := range recs {
+		k, err := r.Key(ctx, rec.ID)
+		if err != nil {
+			return nil, err
+		}
+		if rec.RetiredAt == nil {
+			keys = append([]*DataKey{k}, keys...)
+		} else {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// remember unwraps rec and caches it, as the location's active key too if
+// active is set.
+func (r *Keyring) remember(ctx context.Context, rec models.EncryptionKey, active bool) (*DataKey, error) {
+	r.mu.Lock()
+	k, ok := r.keys[rec.ID]
+	r.mu.Unlock()
+	if !ok {
+		master, err := r.masterFor(rec.MasterKeyID)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := master.Unwrap(ctx, rec.WrappedKey)
+		if err != nil {
+			log.Printf("❌ Failed to unwrap data key %s with %s: %v", rec.ID, rec.MasterKeyID, err)
+			return nil, err
+		}
+		if k, err = newDataKey(rec, raw); err != nil {
+			return nil, err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys[rec.ID] = k
+	if active {
+		r.active[rec.LocationID] = activeKey{id: rec.ID, checkedAt: time.Now()}
+	}
+	return k, nil
+}
+
+func (r *Keyring) masterFor(id string) (MasterKey, error) {
+	if id == r.master.ID() {
+		return r.master, nil
+	}
+	if m, ok := r.previous[id]; ok {
+		return m, nil
+	}
+	return nil, fmt.Errorf("master key %s is not configured", id)
+}
+
+// Open decrypts a sealed value with the key it names. aad must be the row
+// ID it was sealed with. Plaintext is returned as is.
+func (r *Keyring) Open(ctx context.Context, s, aad string) (string, error) {
+	id, payload, ok := parseSealed(s)
+	if !ok {
+		return s, nil
+	}
+	k, err := r.Key(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return k.open(payload, aad)
+}
+
+// Rewrap moves every data key wrapped by a previous master key to the
+// current one, after which the previous key can be retired. It returns how
+// many keys it rewrapped.
+func (r *Keyring) Rewrap(ctx context.Context) (int, error) {
+	recs, err := r.store.ListEncryptionKeys(ctx, "")
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, rec := range recs {
+		if rec.MasterKeyID == r.master.ID() {
+			continue
+		}
+		old, err := r.masterFor(rec.MasterKeyID)
+		if err != nil {
+			return n, err
+		}
+		raw, err := old.Unwrap(ctx, rec.WrappedKey)
+		if err != nil {
+			return n, fmt.Errorf("unwrap data key %s: %w", rec.ID, err)
+		}
+		wrapped, err := r.master.Wrap(ctx, raw)
+		if err != nil {
+			return n, err
+		}
+		if err := r.store.RewrapEncryptionKey(ctx, rec.ID, wrapped, r.master.ID()); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if n > 0 {
+		log.Printf("🔑 Rewrapped %d data key(s) with master key %s", n, r.master.ID())
+	}
+	return n, nil
+}
@@ -0,0 +1,193 @@
+package encryption
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"internal_chat_system/models"
+
+	"github.com/google/uuid"
+)
+
+// keyStore keeps data keys in memory like the encryption_keys table.
+type keyStore struct {
+	keys []models.EncryptionKey
+}
+
+func (s *keyStore) ActiveEncryptionKey(_ context.Context, locationID string) (models.EncryptionKey, error) {
+	for _, k := range s.keys {
+		if k.LocationID == locationID && k.RetiredAt == nil {
+			return k, nil
+		}
+	}
+	return models.EncryptionKey{}, ErrKeyNotFound
+}
+
+func (s *keyStore) GetEncryptionKey(_ context.Context, id string) (models.EncryptionKey, error) {
+	for _, k := range s.keys {
+		if k.ID == id {
+			return k, nil
+		}
+	}
+	return models.EncryptionKey{}, ErrKeyNotFound
+}
+
+func (s *keyStore) ListEncryptionKeys(_ context.Context, locationID string) ([]models.EncryptionKey, error) {
+	var keys []models.EncryptionKey
+	for i := len(s.keys) - 1; i >= 0; i-- {
+		if locationID == "" || s.keys[i].LocationID == locationID {
+			keys = append(keys, s.keys[i])
+		}
+	}
+	return keys, nil
+}
+
+func (s *keyStore) CreateEncryptionKey(_ context.Context, k *models.EncryptionKey, rotate bool) error {
+	version := 0
+	for i := range s.keys {
+		if s.keys[i].LocationID != k.LocationID {
+			continue
+		}
+		version = max(version, s.keys[i].Version)
+		if s.keys[i].RetiredAt != nil {
+			continue
+		}
+		if !rotate {
+			*k = s.keys[i]
+			return nil
+		}
+		now := time.Now()
+		s.keys[i].RetiredAt = &now
+	}
+	k.ID, k.Version, k.CreatedAt = uuid.NewString(), version+1, time.Now()
+	s.keys = append(s.keys, *k)
+	return nil
+}
+
+func (s *keyStore) RewrapEncryptionKey(_ context.Context, id string, wrapped []byte, masterKeyID string) error {
+	for i := range s.keys {
+		if s.keys[i].ID == id {
+			s.keys[i].WrappedKey, s.keys[i].MasterKeyID = wrapped, masterKeyID
+			return nil
+		}
+	}
+	return ErrKeyNotFound
+}
+
+func newMasterKey(t *testing.T) *FileKey {
+	t.Helper()
+	raw := make([]byte, keySize)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewFileKey(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestKeyringActive(t *testing.T) {
+	ctx := context.Background()
+	store := &keyStore{}
+	ring := NewKeyring(newMasterKey(t), store)
+	location := uuid.NewString()
+
+	first, err := ring.Active(ctx, location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := ring.Active(ctx, location)
+	if err != nil || again.ID != first.ID || len(store.keys) != 1 {
+		t.Fatalf("second Active = %v, %v with %d stored keys, want the first key", again, err, len(store.keys))
+	}
+
+	// Another instance reads the same key from the store
+	other := NewKeyring(ring.master, store)
+	sealed := first.Seal("results are in", "msg-1")
+	if got, err := other.Open(ctx, sealed, "msg-1"); err != nil || got != "results are in" {
+		t.Errorf("Open on another keyring = %q, %v", got, err)
+	}
+}
+
+func TestKeyringRotate(t *testing.T) {
+	ctx := context.Background()
+	ring := NewKeyring(newMasterKey(t), &keyStore{})
+	location := uuid.NewString()
+
+	old, err := ring.Active(ctx, location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := old.Seal("before rotation", "msg-1")
+	rotated, err := ring.Rotate(ctx, location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.ID == old.ID || rotated.Version != old.Version+1 {
+		t.Fatalf("rotated to %s v%d from %s v%d", rotated.ID, rotated.Version, old.ID, old.Version)
+	}
+	if active, err := ring.Active(ctx, location); err != nil || active.ID != rotated.ID {
+		t.Errorf("Active after rotation = %v, %v", active, err)
+	}
+
+	// Values under the retired key still open, and search covers both keys
+	if got, err := ring.Open(ctx, sealed, "msg-1"); err != nil || got != "before rotation" {
+		t.Errorf("Open under retired key = %q, %v", got, err)
+	}
+	keys, err := ring.Keys(ctx, location)
+	if err != nil || len(keys) != 2 || keys[0].ID != rotated.ID {
+		t.Errorf("Keys = %v, %v, want the active key first", keys, err)
+	}
+}
+
+func TestKeyringRewrap(t *testing.T) {
+	ctx := context.Background()
+	store := &keyStore{}
+	oldMaster, newMaster := newMasterKey(t), newMasterKey(t)
+	location := uuid.NewString()
+
+	k, err := NewKeyring(oldMaster, store).Active(ctx, location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := k.Seal("wrapped twice", "msg-1")
+
+	// Without the old master key its data keys can't be unwrapped
+	if _, err := NewKeyring(newMaster, store).Open(ctx, sealed, "msg-1"); err == nil {
+		t.Error("opened a value whose master key isn't configured")
+	}
+
+	ring := NewKeyring(newMaster, store, oldMaster)
+	if n, err := ring.Rewrap(ctx); err != nil || n != 1 {
+		t.Fatalf("Rewrap = %d, %v, want 1", n, err)
+	}
+	if n, err := ring.Rewrap(ctx); err != nil || n != 0 {
+		t.Errorf("second Rewrap = %d, %v, want 0", n, err)
+	}
+
+	// Once rewrapped, the old master key can go
+	fresh := NewKeyring(newMaster, store)
+	if got, err := fresh.Open(ctx, sealed, "msg-1"); err != nil || got != "wrapped twice" {
+		t.Errorf("Open after rewrap = %q, %v", got, err)
+	}
+}
+
+func TestNewFileKey(t *testing.T) {
+	if _, err := NewFileKey(make([]byte, 16)); err == nil {
+		t.Error("accepted a 16-byte master key")
+	}
+	m := newMasterKey(t)
+	wrapped, err := m.Wrap(context.Background(), []byte("data key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newMasterKey(t).Unwrap(context.Background(), wrapped); err == nil {
+		t.Error("another master key unwrapped the data key")
+	}
+	if _, err := m.Unwrap(context.Background(), wrapped[:5]); err == nil {
+		t.Error("unwrapped a truncated key")
+	}
+}
@@ -0,0 +1,128 @@
+package encryption
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// FileKey is a master key read from a local keyfile. It suits development
+// and single-host installs; use KMSKey where the key must never sit on the
+// application's disk.
+type FileKey struct {
+	id  string
+	key []byte
+}
+
+// LoadKeyFile reads a master key stored as base64 of 32 random bytes, e.g.
+// made with `head -c 32 /dev/urandom | base64`.
+func LoadKeyFile(path string) (*FileKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("keyfile %s: %w", path, err)
+	}
+	return NewFileKey(key)
+}
+
+// NewFileKey uses key, 32 bytes, as a master key.
+func NewFileKey(key []byte) (*FileKey, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("master key has %d bytes, want %d", len(key), keySize)
+	}
+	// The ID is a fingerprint, so data keys record which keyfile wrapped
+	// them without revealing anything about it
+	sum := sha256.Sum256(append([]byte("chat master key id\x00"), key...))
+	return &FileKey{id: "file:" + hex.EncodeToString(sum[:8]), key: key}, nil
+}
+
+func (k *FileKey) ID() string { return k.id }
+
+func (k *FileKey) Wrap(_ context.Context, key []byte) ([]byte, error) {
+	aead, err := newGCM(k.key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(key)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, key, []byte(k.id)), nil
+}
+
+func (k *FileKey) Unwrap(_ context.Context, wrapped []byte) ([]byte, error) {
+	aead, err := newGCM(k.key)
+	if err != nil {
+		return nil, err
+	}
+	n := aead.NonceSize()
+	if len(wrapped) < n {
+		return nil, ErrCorrupt
+	}
+	key, err := aead.Open(nil, wrapped[:n], wrapped[n:], []byte(k.id))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	return key, nil
+}
+
+// KMSClient is the part of the AWS KMS API that KMSKey uses. *kms.KMS
+// satisfies it, as do KMS-compatible services reached through the same
+// SDK with a custom endpoint.
+type KMSClient interface {
+	EncryptWithContext(ctx aws.Context, input *kms.EncryptInput, opts ...request.Option) (*kms.EncryptOutput, error)
+	DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error)
+}
+
+// kmsContext is bound to every wrapped data key, so KMS won't decrypt
+// them for any other purpose and its audit trail says what they are.
+var kmsContext = map[string]*string{"purpose": aws.String("chat-data-key")}
+
+// KMSKey is a master key held in KMS. Data keys are sent to KMS to be
+// wrapped and unwrapped; the master key itself is never seen.
+type KMSKey struct {
+	Client KMSClient
+	KeyID  string // key ID, ARN or alias
+}
+
+func (k KMSKey) ID() string { return "kms:" + k.KeyID }
+
+func (k KMSKey) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	out, err := k.Client.EncryptWithContext(ctx, &kms.EncryptInput{
+		KeyId:             aws.String(k.KeyID),
+		Plaintext:         key,
+		EncryptionContext: kmsContext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.CiphertextBlob, nil
+}
+
+func (k KMSKey) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	out, err := k.Client.DecryptWithContext(ctx, &kms.DecryptInput{
+		KeyId:             aws.String(k.KeyID),
+		CiphertextBlob:    wrapped,
+		EncryptionContext: kmsContext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Plaintext) != keySize {
+		return nil, errors.New("KMS returned a data key of the wrong size")
+	}
+	return out.Plaintext, nil
+}
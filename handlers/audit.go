@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"internal_chat_system/middleware/auth"
+	"internal_chat_system/models"
+	"internal_chat_system/repository"
+
+	"github.com/google/uuid"
+)
+
+// Audit log actions. Reads are recorded before their results are returned,
+// and fail the request if they can't be. Changes to messages and admin
+// changes are recorded in the transaction that makes them (see
+// auditWrites); uploads are recorded once stored, and the request fails if
+// they can't be.
+const (
+	auditHistoryRead   = "message.history"
+	auditSearch        = "message.search"
+	auditRevisionsRead = "message.revisions"
+	auditThreadRead    = "message.thread"
+	auditEventsRead    = "session.events"
+	auditSync          = "session.sync"
+	auditSessionsList  = "session.list"
+	auditPinnedRead    = "message.pinned"
+	auditSend          = "message.send"
+	auditEdit          = "message.edit"
+	auditDelete        = "message.delete"
+	auditAdminDelete   = "message.admin_delete"
+	auditMarkRead      = "message.mark_read"
+	auditPin           = "message.pin"
+	auditUnpin         = "message.unpin"
+	auditReactionAdd   = "reaction.add"
+	auditReactionDrop  = "reaction.remove"
+	auditUpload        = "file.upload"
+	auditDownload      = "file.download"
+	auditLogQuery      = "audit.query"
+	auditChainVerify   = "audit.verify"
+	auditRetention     = "retention.update"
+	auditHoldPlace     = "legal_hold.place"
+	auditHoldRelease   = "legal_hold.release"
+	auditKeyRotate     = "encryption.rotate"
+	auditReencrypt     = "encryption.reencrypt"
+	auditKeyRewrap     = "encryption.rewrap"
+)
+
+// noLocation chains entries that aren't tied to a location, such as
+// uploads and listings across every location.
+var noLocation = uuid.Nil.String()
+
+// recordAudit appends what actor did to the audit log, from the client
+// address on ctx. The entry is written even if the client has gone away,
+// since what it records already happened. Store failures are logged by the
+// store.
+func (a *API) recordAudit(ctx context.Context, actor auth.AuthContext, e models.AuditEntry) error {
+	e.ActorID, e.ActorType, e.IP = actor.UserID, actor.UserType, clientIP(ctx)
+	if e.LocationID == "" {
+		e.LocationID = noLocation
+	}
+	return a.audit.Record(context.WithoutCancel(ctx), &e)
+}
+
+func auditDetails(v any) json.RawMessage {
+	b, _ := json.Marshal(v)
+	return b
+}
+
+// auditWrites returns ctx for a store write whose session events are each
+// audited as action, with details plus the event's session and seq. Admin
+// changes (policies, holds, keys) are audited as action with what changed
+// instead. The entries are written in the write's transaction, so the
+// change and its audit trail commit or fail together.
+func (a *API) auditWrites(ctx context.Context, actor auth.AuthContext, action string, details map[string]any) context.Context {
+	e := models.AuditEntry{ActorID: actor.UserID, ActorType: actor.UserType, IP: clientIP(ctx), Action: action}
+	if details != nil {
+		e.Details = auditDetails(details)
+	}
+	return repository.WithAudit(ctx, e)
+}
+
+// GET /admin/chat/audit?location_id=loc1&actor_id=&action=&target_type=&target_id=&from=&to=&before_seq=&limit=
+// Lists a location's audit entries, newest first. Querying the log is
+// itself audited.
+func (a *API) ListAuditEntries(w http.ResponseWriter, r *http.Request) {
+	authCtx := auth.GetAuthContext(r)
+	if !isAdmin(authCtx) {
+		writeError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	q := r.URL.Query()
+	f := models.AuditFilter{
+		LocationID: q.Get("location_id"),
+		ActorID:    q.Get("actor_id"),
+		Action:     q.Get("action"),
+		TargetType: q.Get("target_type"),
+		TargetID:   q.Get("target_id"),
+	}
+	if _, err := uuid.Parse(f.LocationID); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid location_id")
+		return
+	}
+	if f.ActorID != "" {
+		if _, err := uuid.Parse(f.ActorID); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid actor_id")
+			return
+		}
+	}
+	for name, dst := range map[string]**time.Time{"from": &f.From, "to": &f.To} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid "+name+"; use RFC 3339")
+				return
+			}
+			*dst = &t
+		}
+	}
+	if v := q.Get("before_seq"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "Invalid before_seq")
+			return
+		}
+		f.BeforeSeq = n
+	}
+	f.Limit = 100
+	if l, err := strconv.Atoi(q.Get("limit")); err == nil && l > 0 {
+		f.Limit = l
+	}
+
+	entries, err := a.audit.ListAuditEntries(r.Context(), f)
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to fetch audit log")
+		return
+	}
+	err = a.recordAudit(r.Context(), authCtx, models.AuditEntry{
+		LocationID: f.LocationID,
+		Action:     auditLogQuery,
+		Details:    auditDetails(map[string]any{"filters": q, "results": len(entries)}),
+	})
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to fetch audit log")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// GET /admin/chat/audit/verify?location_id=loc1
+// Recomputes a location's hash chain and reports whether it is intact.
+func (a *API) VerifyAuditChain(w http.ResponseWriter, r *http.Request) {
+	authCtx := auth.GetAuthContext(r)
+	if !isAdmin(authCtx) {
+		writeError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+	locationID := r.URL.Query().Get("location_id")
+	if _, err := uuid.Parse(locationID); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid location_id")
+		return
+	}
+
+	status, err := a.audit.VerifyAuditChain(r.Context(), locationID)
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to verify audit log")
+		return
+	}
+	err = a.recordAudit(r.Context(), authCtx, models.AuditEntry{
+		LocationID: locationID,
+		Action:     auditChainVerify,
+		Details:    auditDetails(status),
+	})
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to verify audit log")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
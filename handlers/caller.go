@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"internal_chat_system/middleware/auth"
+	"internal_chat_system/repository"
+)
+
+// TagCaller tags each request's context with who is calling, so the
+// repository can send a caller's reads to the primary right after they
+// write, and with the client address for the audit log. The caller is the
+// authenticated user, or the client address for requests without an auth
+// context. It must run after the auth middleware.
+func TagCaller(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := hostOf(r.RemoteAddr)
+		caller := auth.GetAuthContext(r).UserID
+		if caller == "" {
+			caller = ip
+		}
+		ctx := repository.WithCaller(withClientIP(r.Context(), ip), caller)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type clientIPKey struct{}
+
+func withClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+func clientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+// hostOf strips the port from a host:port address.
+func hostOf(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -2,21 +2,28 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
+	"mime"
 	"net/http"
 	"strconv"
+	"time"
 
+	"internal_chat_system/historycache"
 	"internal_chat_system/internal/s3"
 	"internal_chat_system/middleware/auth"
 	"internal_chat_system/models"
 	"internal_chat_system/notifications"
+	"internal_chat_system/outbox"
 	"internal_chat_system/presence"
 	"internal_chat_system/redis"
 	"internal_chat_system/repository"
 	"internal_chat_system/ws"
 
-	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
@@ -24,97 +31,356 @@ import (
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
-var (
-	messageRepo *repository.MessageRepo
-	sessionRepo *repository.ChatSessionRepo
-)
 
-func Init(repo *repository.MessageRepo, session *repository.ChatSessionRepo) {
-	messageRepo = repo
-	sessionRepo = session
+// Deps are the stores and hub the HTTP and WebSocket handlers work with.
+// Main wires in the PostgreSQL repos; package repository/memory provides
+// stand-ins with the same semantics.
+type Deps struct {
+	Messages    repository.MessageStore
+	Sessions    repository.SessionStore
+	Reactions   repository.ReactionStore
+	Pins        repository.PinStore
+	Devices     repository.DeviceTokenStore
+	OfficeHours repository.OfficeHoursStore
+	Audit       repository.AuditStore
+	Retention   repository.RetentionStore
+	LegalHolds  repository.LegalHoldStore
+	Encryption  repository.EncryptionStore
+	Files       FileStore
+	Presence    PresenceStore
+	CacheStats  func() historycache.Stats // nil when the history cache is off
+	Hub         *ws.Hub
 }
 
-func SendMessage(hub *ws.Hub) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		var msg models.Message
-		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
-			writeError(w, http.StatusBadRequest, "Invalid JSON payload")
-			return
-		}
-		msg.ID = uuid.New().String()
+// FileStore reads back and removes attachments stored by the upload
+// endpoint, given the URL it returned.
+type FileStore interface {
+	GetFile(ctx context.Context, fileURL string) ([]byte, error)
+	DeleteFile(ctx context.Context, fileURL string) error
+}
 
-		auth := auth.GetAuthContext(r)
-		log.Printf("🔐 Authenticated User: ID=%s, Type=%s", auth.UserID, auth.UserType)
+// PresenceStore resolves participants' presence and sets their
+// availability. presence.Redis is the production implementation.
+type PresenceStore interface {
+	Get(ctx context.Context, p presence.Participant) (models.PresenceStatus, error)
+	GetMany(ctx context.Context, participants []presence.Participant) (map[presence.Participant]models.PresenceStatus, error)
+	SetAvailability(ctx context.Context, p presence.Participant, a presence.Availability) error
+}
 
-		// Enforce access rules
-		if auth.UserType == "DOCTOR" && auth.UserID != msg.SenderUserID {
-			writeError(w, http.StatusForbidden, "Unauthorized doctor")
-			return
+// API serves the chat endpoints. Build it with NewAPI.
+type API struct {
+	messages    repository.MessageStore
+	sessions    repository.SessionStore
+	reactions   repository.ReactionStore
+	pins        repository.PinStore
+	devices     repository.DeviceTokenStore
+	officeHours repository.OfficeHoursStore
+	audit       repository.AuditStore
+	retention   repository.RetentionStore
+	legalHolds  repository.LegalHoldStore
+	encryption  repository.EncryptionStore
+	files       FileStore
+	presence    PresenceStore
+	cacheStats  func() historycache.Stats
+	hub         *ws.Hub
+}
+
+func NewAPI(d Deps) *API {
+	return &API{
+		messages:    d.Messages,
+		sessions:    d.Sessions,
+		reactions:   d.Reactions,
+		pins:        d.Pins,
+		devices:     d.Devices,
+		officeHours: d.OfficeHours,
+		audit:       d.Audit,
+		retention:   d.Retention,
+		legalHolds:  d.LegalHolds,
+		encryption:  d.Encryption,
+		files:       d.Files,
+		presence:    d.Presence,
+		cacheStats:  d.CacheStats,
+		hub:         d.Hub,
+	}
+}
+
+// maxClientMessageIDLength bounds the idempotency key clients may supply.
+const maxClientMessageIDLength = 128
+
+// sendError is a send failure the caller can report with an HTTP status.
+type sendError struct {
+	status  int
+	message string
+}
+
+func (e *sendError) Error() string { return e.message }
+
+func (a *API) SendMessage(w http.ResponseWriter, r *http.Request) {
+	var msg models.Message
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON payload")
+		return
+	}
+
+	auth := auth.GetAuthContext(r)
+	log.Printf("🔐 Authenticated User: ID=%s, Type=%s", auth.UserID, auth.UserType)
+
+	existing, err := a.sendMessage(r.Context(), auth, &msg)
+	var sendErr *sendError
+	if errors.As(err, &sendErr) {
+		writeError(w, sendErr.status, sendErr.message)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// A retry gets the message stored by the first attempt
+	if existing != nil {
+		writeJSON(w, http.StatusOK, existing)
+		return
+	}
+	writeJSON(w, http.StatusCreated, msg)
+}
+
+// SendSocketMessage sends a message received over a client's socket, as
+// the identity verified when the socket was opened.
+func (a *API) SendSocketMessage(ctx context.Context, c *ws.Client, msg models.Message) ws.MessageAck {
+	authCtx := c.Auth
+	if authCtx.UserID == "" {
+		return ws.MessageAck{Type: "message_error", ClientMessageID: msg.ClientMessageID, Error: "Not authenticated"}
+	}
+	ctx = repository.WithCaller(ctx, authCtx.UserID)
+	if c.Conn != nil {
+		ctx = withClientIP(ctx, hostOf(c.Conn.RemoteAddr().String()))
+	}
+	ack := ws.MessageAck{Type: "message_ack", ClientMessageID: msg.ClientMessageID}
+	if msg.LocationID == "" {
+		msg.LocationID = c.LocationID
+	}
+	if msg.LocationID != c.LocationID {
+		ack.Type, ack.Error = "message_error", "location_id does not match the connection"
+		return ack
+	}
+
+	existing, err := a.sendMessage(ctx, authCtx, &msg)
+	switch {
+	case err != nil:
+		ack.Type, ack.Error = "message_error", err.Error()
+	case existing != nil:
+		ack.Duplicate, ack.Message = true, existing
+	default:
+		ack.Message = msg
+	}
+	return ack
+}
+
+// sendMessage validates and stores a message on behalf of the caller; the
+// outbox relay delivers it once the transaction commits. If the sender
+// already stored a message under msg.ClientMessageID, nothing is sent again
+// and that message is returned instead.
+func (a *API) sendMessage(ctx context.Context, auth auth.AuthContext, msg *models.Message) (*models.DBMessage, error) {
+	msg.ID = uuid.New().String()
+
+	// Enforce access rules. The direction of the message follows from who
+	// sends it, whatever the payload says.
+	switch auth.UserType {
+	case "DOCTOR":
+		if auth.UserID != msg.SenderUserID {
+			return nil, &sendError{http.StatusForbidden, "Unauthorized doctor"}
 		}
-		if auth.UserType == "PATIENT" && auth.UserID != msg.ReceiverContactID {
-			writeError(w, http.StatusForbidden, "Unauthorized patient")
-			return
+		msg.SenderContactID, msg.ReceiverUserID = "", ""
+	case "PATIENT":
+		if auth.UserID != msg.ReceiverContactID {
+			return nil, &sendError{http.StatusForbidden, "Unauthorized patient"}
 		}
+		msg.SenderContactID, msg.ReceiverUserID = auth.UserID, msg.SenderUserID
+	default:
+		return nil, &sendError{http.StatusForbidden, "Unauthorized"}
+	}
 
-		if msg.LocationID == "" || msg.SenderUserID == "" || msg.ReceiverContactID == "" {
-			writeError(w, http.StatusBadRequest, "Missing required fields")
-			return
-		}
+	if msg.LocationID == "" || msg.SenderUserID == "" || msg.ReceiverContactID == "" {
+		return nil, &sendError{http.StatusBadRequest, "Missing required fields"}
+	}
 
-		if msg.Content == "" && msg.FileURL == "" {
-			writeError(w, http.StatusBadRequest, "Either message content or file must be provided")
-			return
+	if msg.Content == "" && msg.FileURL == "" {
+		return nil, &sendError{http.StatusBadRequest, "Either message content or file must be provided"}
+	}
+
+	if len(msg.ClientMessageID) > maxClientMessageIDLength {
+		return nil, &sendError{http.StatusBadRequest, "client_message_id is too long"}
+	}
+	if existing, err := a.findClientMessage(ctx, auth, *msg); existing != nil || err != nil {
+		return existing, err
+	}
+
+	// Replies must quote a message from the same conversation
+	if msg.ReplyToID != nil {
+		parent, err := a.messages.GetMessageByID(ctx, *msg.ReplyToID)
+		if err != nil || parent.LocationID.String() != msg.LocationID ||
+			parent.SenderUserID.String() != msg.SenderUserID || parent.ReceiverContactID.String() != msg.ReceiverContactID {
+			return nil, &sendError{http.StatusBadRequest, "reply_to_id must reference a message in this session"}
 		}
+	}
 
-		sessionID, err := sessionRepo.GetOrCreateSession(msg.ReceiverContactID, msg.SenderUserID, msg.LocationID)
-		if err != nil {
-			writeError(w, http.StatusInternalServerError, "Failed to create or fetch session")
-			return
+	audited := a.auditWrites(ctx, auth, auditSend, map[string]any{
+		"user_id":    msg.SenderUserID,
+		"contact_id": msg.ReceiverContactID,
+		"file_url":   msg.FileURL,
+	})
+	if err := a.messages.SaveMessage(audited, msg); err != nil {
+		// A concurrent retry won the race to insert
+		if errors.Is(err, repository.ErrDuplicateClientMessage) {
+			if existing, err := a.findClientMessage(ctx, auth, *msg); existing != nil || err != nil {
+				return existing, err
+			}
 		}
-		msg.SessionID = sessionID
+		log.Printf("❌ DB Error on SaveMessage: %v", err)
+		return nil, &sendError{errorStatus(err), "Could not save message"}
+	}
 
-		if err := messageRepo.SaveMessage(&msg); err != nil {
-			log.Printf("❌ DB Error on SaveMessage: %v", err)
-			writeError(w, http.StatusInternalServerError, "Could not save message")
-			return
+	outbox.Wake()
+	return nil, nil
+}
+
+// findClientMessage looks up the message the caller previously stored
+// under msg.ClientMessageID, if one was given. The key belongs to the
+// authenticated sender, so nobody can fetch another sender's messages by
+// guessing their keys. A retry that doesn't match the stored message is a
+// conflict rather than a duplicate.
+func (a *API) findClientMessage(ctx context.Context, auth auth.AuthContext, msg models.Message) (*models.DBMessage, error) {
+	if msg.ClientMessageID == "" {
+		return nil, nil
+	}
+	existing, err := a.messages.GetMessageByClientID(ctx, auth.UserID, msg.ClientMessageID)
+	if errors.Is(err, repository.ErrMessageNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &sendError{errorStatus(err), "Could not check client_message_id"}
+	}
+	if existing.LocationID.String() != msg.LocationID || existing.SenderUserID.String() != msg.SenderUserID ||
+		existing.ReceiverContactID.String() != msg.ReceiverContactID ||
+		existing.Content != msg.Content || existing.FileURL != msg.FileURL {
+		return nil, &sendError{http.StatusConflict, "client_message_id was already used for a different message"}
+	}
+	log.Printf("🔁 Returning stored message %s for client_message_id %s", existing.ID, msg.ClientMessageID)
+	return &existing, nil
+}
+
+// DispatchOutboxEvent performs the side effects queued by SaveMessage.
+// Returning an error makes the relay retry the event later.
+func (a *API) DispatchOutboxEvent(ctx context.Context, ev models.OutboxEvent) error {
+	var msg models.Message
+	if err := json.Unmarshal(ev.Payload, &msg); err != nil {
+		// Retrying won't make a corrupt payload readable
+		log.Printf("❌ Dropping outbox event %d with bad payload: %v", ev.ID, err)
+		return nil
+	}
+
+	switch ev.EventType {
+	case models.OutboxMessageDeliver:
+		return a.deliverMessage(ctx, msg)
+	case models.OutboxMessagePush:
+		return a.notifyReceiver(ctx, msg)
+	case models.OutboxMessageAutoReply:
+		return a.sendOfficeHoursAutoReply(ctx, msg)
+	}
+	log.Printf("⚠️ Unknown outbox event type %q", ev.EventType)
+	return nil
+}
+
+// messageTarget is the receiver of a message: the doctor for patient
+// messages, otherwise the patient.
+func messageTarget(msg models.Message) (targetType, targetID string) {
+	if msg.ReceiverUserID != "" {
+		return "user", msg.ReceiverUserID
+	}
+	return "contact", msg.ReceiverContactID
+}
+
+// deliverMessage hands a saved message to its receiver: live over the hub when
+// connected, otherwise through the offline queue.
+func (a *API) deliverMessage(ctx context.Context, msg models.Message) error {
+	targetType, targetID := messageTarget(msg)
+
+	if redis.IsClientConnected(msg.LocationID, targetID, a.hub.Clients) {
+		log.Printf("🚀 Delivering message live to %s:%s", targetType, targetID)
+		a.hub.Broadcast <- ws.BroadcastMessage{
+			LocationID: msg.LocationID,
+			Message:    msg,
+			Participants: []presence.Participant{
+				presence.User(msg.LocationID, msg.SenderUserID),
+				presence.Contact(msg.LocationID, msg.ReceiverContactID),
+			},
 		}
+		return nil
+	}
 
-		data, _ := json.Marshal(msg)
-		targetID := msg.ReceiverContactID
-		targetType := "contact"
-		if msg.ReceiverUserID != "" {
-			targetID = msg.ReceiverUserID
-			targetType = "user"
+	log.Printf("📥 Queuing offline message for %s:%s", targetType, targetID)
+	return redis.QueueOfflineMessage(ctx, targetType, msg.LocationID, targetID, msg.ID)
+}
+
+// deliverQueued sends a newly connected client the messages queued while
+// it was away, as they are now, and marks them delivered. Messages deleted
+// meanwhile are skipped.
+func (a *API) deliverQueued(ctx context.Context, client *ws.Client, ids []string) {
+	var delivered []uuid.UUID
+	for _, id := range ids {
+		msg, err := a.messages.GetMessageByID(ctx, id)
+		if errors.Is(err, repository.ErrMessageNotFound) {
+			continue
+		}
+		if err != nil {
+			log.Printf("⚠️ Failed to load queued message %s: %v", id, err)
+			continue
 		}
+		data, err := json.Marshal(msg)
+		if err != nil {
+			continue
+		}
+		client.Deliver(data)
+		delivered = append(delivered, msg.ID)
+	}
+	log.Printf("✅ Delivered %d offline message(s) to %s", len(delivered), client.Participant().ID)
 
-		if redis.IsClientConnected(msg.LocationID, targetID, hub.Clients) {
-			log.Printf("🚀 Delivering message live to %s:%s", targetType, targetID)
-			hub.Broadcast <- ws.BroadcastMessage{
-				LocationID: msg.LocationID,
-				Message:    msg,
-			}
+	if len(delivered) > 0 {
+		if err := a.messages.MarkMessagesDelivered(ctx, delivered); err != nil {
+			log.Printf("⚠️ Failed to mark messages as delivered: %v", err)
 		} else {
-			log.Printf("📥 Queuing offline message for %s:%s", targetType, targetID)
-			_ = redis.QueueOfflineMessage(targetType, msg.LocationID, targetID, data)
+			log.Printf("✅ Marked %d messages as delivered", len(delivered))
 		}
+	}
+}
 
-		if !presence.IsUserOnline(targetID) {
-			token, err := messageRepo.GetDeviceToken(targetID) // You must implement this
-			if err == nil && token != "" {
-				notifications.SendPush(token, "New message", msg.Content)
+// notifyReceiver sends the push notification for a saved message.
+// Availability decides whether the push may interrupt the receiver;
+// suppressed pushes still go out silently for badge updates.
+func (a *API) notifyReceiver(ctx context.Context, msg models.Message) error {
+	targetType, targetID := messageTarget(msg)
+
+	target := presence.Participant{LocationID: msg.LocationID, Kind: targetType, ID: targetID}
+	notify := false
+	if status, err := a.presence.Get(ctx, target); err == nil {
+		notify = presence.ShouldNotify(status)
+	}
+	if notify {
+		token, err := a.devices.GetDeviceToken(ctx, targetID)
+		if err == nil && token != "" {
+			if err := notifications.SendPush(token, "New message", msg.Content); err != nil {
+				return err
 			}
 		}
-
-		_ = redis.PublishPushEvent(redis.PushEvent{
-			MessageID:    msg.ID,
-			LocationID:   msg.LocationID,
-			ReceiverID:   targetID,
-			ReceiverType: targetType,
-			Content:      msg.Content,
-		})
-
-		writeJSON(w, http.StatusCreated, msg)
 	}
+
+	return redis.PublishPushEvent(ctx, redis.PushEvent{
+		MessageID:    msg.ID,
+		LocationID:   msg.LocationID,
+		ReceiverID:   targetID,
+		ReceiverType: targetType,
+		Silent:       !notify,
+	})
 }
 
 // func HandleWebSocket(hub *ws.Hub) http.HandlerFunc {
@@ -145,72 +411,93 @@ func SendMessage(hub *ws.Hub) http.HandlerFunc {
 // 	}
 // }
 
-func HandleWebSocket(hub *ws.Hub) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		locationID := r.URL.Query().Get("location_id")
-		userID := r.URL.Query().Get("user_id")
-		contactID := r.URL.Query().Get("contact_id")
+// HandleWebSocket opens a socket for the caller of the request's JWT, sent
+// as a bearer header or the access_token query parameter. The socket acts
+// as that doctor or patient for its whole life; user_id and contact_id in
+// the query may only repeat the caller's own ID.
+func (a *API) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	locationID := r.URL.Query().Get("location_id")
 
-		conn, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			writeError(w, http.StatusInternalServerError, "WebSocket Upgrade Failed")
+	authCtx, err := auth.Authenticate(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	var userID, contactID string
+	switch authCtx.UserType {
+	case "DOCTOR":
+		userID = authCtx.UserID
+		if id := r.URL.Query().Get("user_id"); id != "" && id != userID {
+			writeError(w, http.StatusForbidden, "Unauthorized doctor")
 			return
 		}
-
-		client := &ws.Client{
-			Conn:       conn,
-			Send:       make(chan []byte),
-			UserID:     userID,
-			ContactID:  contactID,
-			LocationID: locationID,
-			Hub:        hub,
+	case "PATIENT":
+		contactID = authCtx.UserID
+		if id := r.URL.Query().Get("contact_id"); id != "" && id != contactID {
+			writeError(w, http.StatusForbidden, "Unauthorized patient")
+			return
 		}
+	default:
+		writeError(w, http.StatusForbidden, "Only doctors and patients can open a socket")
+		return
+	}
+	if locationID == "" {
+		writeError(w, http.StatusBadRequest, "Missing location_id")
+		return
+	}
 
-		hub.Register <- client
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "WebSocket Upgrade Failed")
+		return
+	}
 
-		// 📨 Flush offline messages on connect + mark delivered
-		targetType := "user"
-		targetID := userID
-		if contactID != "" {
-			targetType = "contact"
-			targetID = contactID
-		}
-		// Track presence
-		go presence.MarkUserOnline(userID, contactID, locationID)
-
-		go func() {
-			<-r.Context().Done()
-			presence.MarkUserOffline(userID, contactID, locationID)
-		}()
-
-		if offlineMsgs, err := redis.FlushQueuedMessages(targetType, locationID, targetID, func(ids []string) {
-			var uuids []uuid.UUID
-			for _, id := range ids {
-				if uid, err := uuid.Parse(id); err == nil {
-					uuids = append(uuids, uid)
-				}
-			}
-			if len(uuids) > 0 {
-				if err := messageRepo.MarkMessagesDelivered(uuids); err != nil {
-					log.Printf("⚠️ Failed to mark messages as delivered: %v", err)
-				} else {
-					log.Printf("✅ Marked %d messages as delivered", len(uuids))
-				}
-			}
-		}); err == nil {
-			for _, msg := range offlineMsgs {
-				client.Send <- msg
-			}
-		}
+	client := &ws.Client{
+		Conn:       conn,
+		Send:       make(chan []byte, ws.SendBuffer),
+		UserID:     userID,
+		ContactID:  contactID,
+		LocationID: locationID,
+		Hub:        a.hub,
+		Auth:       authCtx,
+	}
+
+	a.hub.Register <- client
+	go client.WritePump()
+
+	// 📨 Flush offline messages on connect + mark delivered
+	targetType := "user"
+	targetID := userID
+	if contactID != "" {
+		targetType = "contact"
+		targetID = contactID
+	}
+	if ids, err := redis.FlushQueuedMessages(r.Context(), targetType, locationID, targetID); err == nil {
+		a.deliverQueued(r.Context(), client, ids)
+	}
+
+	// Presence is tracked by the read pump for the lifetime of the connection
+	go client.ReadPump()
+}
 
-		go client.ReadPump()
-		go client.WritePump()
-		go presence.MarkUserOnline(client.UserID, client.ContactID, client.LocationID)
+// CanWatchPresence only lets clients follow the presence of their session
+// counterparts: doctors watch their patients and patients watch their doctors.
+func (a *API) CanWatchPresence(ctx context.Context, c *ws.Client, p presence.Participant) bool {
+	self := c.Participant()
+	if self.Kind == p.Kind {
+		return false
+	}
 
+	contactID, userID := self.ID, p.ID
+	if self.Kind == presence.KindUser {
+		contactID, userID = p.ID, self.ID
 	}
+
+	ok, err := a.sessions.SessionExists(ctx, contactID, userID, c.LocationID)
+	return err == nil && ok
 }
 
-func GetMessageHistory(w http.ResponseWriter, r *http.Request) {
+func (a *API) GetMessageHistory(w http.ResponseWriter, r *http.Request) {
 	locationID := r.URL.Query().Get("location_id")
 	contactID := r.URL.Query().Get("contact_id")
 	userID := r.URL.Query().Get("user_id")
@@ -232,17 +519,64 @@ func GetMessageHistory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	messages, err := messageRepo.GetConversation(locationID, contactID, userID)
+	page := models.PageRequest{
+		Before: r.URL.Query().Get("before"),
+		After:  r.URL.Query().Get("after"),
+	}
+	for name, dst := range map[string]*int64{"before_seq": &page.BeforeSeq, "after_seq": &page.AfterSeq} {
+		if v := r.URL.Query().Get(name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 1 {
+				writeError(w, http.StatusBadRequest, "Invalid "+name)
+				return
+			}
+			*dst = n
+		}
+	}
+	bounds := 0
+	for _, set := range []bool{page.Before != "", page.After != "", page.BeforeSeq > 0, page.AfterSeq > 0} {
+		if set {
+			bounds++
+		}
+	}
+	if bounds > 1 {
+		writeError(w, http.StatusBadRequest, "Use only one of before, after, before_seq or after_seq")
+		return
+	}
+	if parsedLimit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && parsedLimit > 0 {
+		page.Limit = parsedLimit
+	}
+
+	history, err := a.messages.GetConversation(r.Context(), locationID, contactID, userID, page)
+	if errors.Is(err, repository.ErrInvalidCursor) {
+		writeError(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
 	if err != nil {
 		log.Printf("❌ Error fetching conversation: %v", err)
-		writeError(w, http.StatusInternalServerError, "Failed to fetch messages")
+		writeError(w, errorStatus(err), "Failed to fetch messages")
+		return
+	}
+	details := map[string]any{"user_id": userID, "messages": len(history.Messages)}
+	if n := len(history.Messages); n > 0 {
+		details["first_seq"], details["last_seq"] = history.Messages[0].Seq, history.Messages[n-1].Seq
+	}
+	err = a.recordAudit(r.Context(), auth, models.AuditEntry{
+		LocationID: locationID,
+		Action:     auditHistoryRead,
+		TargetType: "contact",
+		TargetID:   contactID,
+		Details:    auditDetails(details),
+	})
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to fetch messages")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, messages)
+	writeJSON(w, http.StatusOK, history)
 }
 
-func MarkMessageAsRead(w http.ResponseWriter, r *http.Request) {
+func (a *API) MarkMessageAsRead(w http.ResponseWriter, r *http.Request) {
 	auth := auth.GetAuthContext(r)
 	log.Printf("📝 MarkMessageAsRead called by userID=%s", auth.UserID)
 
@@ -255,17 +589,19 @@ func MarkMessageAsRead(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := messageRepo.MarkMessagesRead(payload.MessageIDs)
+	ctx := a.auditWrites(r.Context(), auth, auditMarkRead, map[string]any{"message_ids": payload.MessageIDs})
+	events, err := a.messages.MarkMessagesRead(ctx, payload.MessageIDs)
 	if err != nil {
 		log.Printf("❌ Failed to mark messages read: %v", err)
-		writeError(w, http.StatusInternalServerError, "Failed to mark messages as read")
+		writeError(w, errorStatus(err), "Failed to mark messages as read")
 		return
 	}
 
-	writeSuccess(w, http.StatusOK, "Messages marked as read")
+	a.publishSessionEvents(events...)
+	writeEvents(w, "Messages marked as read", events...)
 }
 
-func GetPresenceStatus(w http.ResponseWriter, r *http.Request) {
+func (a *API) GetPresenceStatus(w http.ResponseWriter, r *http.Request) {
 	locationID := r.URL.Query().Get("location_id")
 	userID := r.URL.Query().Get("user_id")       // For checking doctors/staff
 	contactID := r.URL.Query().Get("contact_id") // For checking patients
@@ -275,24 +611,240 @@ func GetPresenceStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	status, err := redis.GetPresenceStatus(userID, contactID, locationID)
+	participant := presence.User(locationID, userID)
+	if userID == "" {
+		participant = presence.Contact(locationID, contactID)
+	}
+	visible, err := a.visiblePresence(r.Context(), auth.GetAuthContext(r), locationID, []presence.Participant{participant})
+	if err != nil {
+		writeError(w, errorStatus(err), "Presence check failed")
+		return
+	}
+	if len(visible) == 0 {
+		writeError(w, http.StatusForbidden, "Unauthorized")
+		return
+	}
+
+	status, err := a.presence.Get(r.Context(), participant)
 	if err != nil {
 		log.Printf("❌ Presence lookup failed: %v", err)
-		writeError(w, http.StatusInternalServerError, "Presence check failed")
+		writeError(w, errorStatus(err), "Presence check failed")
 		return
 	}
+	if participant.Kind == presence.KindUser {
+		a.applyOfficeHours(r.Context(), locationID, userID, &status)
+	}
 
-	writeJSON(w, http.StatusOK, map[string]string{
-		"status": status, // "online" or "last seen at ..."
+	writeJSON(w, http.StatusOK, status)
+}
+
+// maxBulkPresence caps how many participants one bulk lookup may resolve.
+const maxBulkPresence = 200
+
+// POST /chat/presence/bulk
+// Resolves the presence of many users and contacts in one location with a
+// single Redis round trip, so session lists don't need a request per row.
+func (a *API) GetBulkPresenceStatus(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		LocationID string   `json:"location_id"`
+		UserIDs    []string `json:"user_ids"`
+		ContactIDs []string `json:"contact_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if payload.LocationID == "" || len(payload.UserIDs)+len(payload.ContactIDs) == 0 {
+		writeError(w, http.StatusBadRequest, "Missing location_id and user/contact IDs")
+		return
+	}
+	if len(payload.UserIDs)+len(payload.ContactIDs) > maxBulkPresence {
+		writeError(w, http.StatusBadRequest, "Too many IDs in one request")
+		return
+	}
+
+	var participants []presence.Participant
+	for _, id := range payload.UserIDs {
+		participants = append(participants, presence.User(payload.LocationID, id))
+	}
+	for _, id := range payload.ContactIDs {
+		participants = append(participants, presence.Contact(payload.LocationID, id))
+	}
+	authCtx := auth.GetAuthContext(r)
+	if authCtx.UserType != "DOCTOR" && authCtx.UserType != "PATIENT" && !isAdmin(authCtx) {
+		writeError(w, http.StatusForbidden, "Unauthorized")
+		return
+	}
+	// IDs the caller may not see are left out of the response
+	participants, err := a.visiblePresence(r.Context(), authCtx, payload.LocationID, participants)
+	if err != nil {
+		writeError(w, errorStatus(err), "Presence check failed")
+		return
+	}
+
+	statuses, err := a.presence.GetMany(r.Context(), participants)
+	if err != nil {
+		log.Printf("❌ Bulk presence lookup failed: %v", err)
+		writeError(w, errorStatus(err), "Presence check failed")
+		return
+	}
+
+	resp := struct {
+		Users    map[string]models.PresenceStatus `json:"users"`
+		Contacts map[string]models.PresenceStatus `json:"contacts"`
+	}{
+		Users:    make(map[string]models.PresenceStatus),
+		Contacts: make(map[string]models.PresenceStatus),
+	}
+	a.applyOfficeHoursMany(r.Context(), statuses)
+	for p, status := range statuses {
+		if p.Kind == presence.KindUser {
+			resp.Users[p.ID] = status
+		} else {
+			resp.Contacts[p.ID] = status
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// visiblePresence keeps the participants whose presence the caller may see,
+// by the rule socket subscriptions follow: doctors see their patients and
+// patients their doctors, everyone sees themselves, and admins see all.
+func (a *API) visiblePresence(ctx context.Context, authCtx auth.AuthContext, locationID string, participants []presence.Participant) ([]presence.Participant, error) {
+	if isAdmin(authCtx) {
+		return participants, nil
+	}
+
+	var selfKind, otherKind, userID, contactID string
+	switch authCtx.UserType {
+	case "DOCTOR":
+		selfKind, otherKind, userID = presence.KindUser, presence.KindContact, authCtx.UserID
+	case "PATIENT":
+		selfKind, otherKind, contactID = presence.KindContact, presence.KindUser, authCtx.UserID
+	default:
+		return nil, nil
+	}
+
+	var others []string
+	for _, p := range participants {
+		if p.Kind == otherKind {
+			others = append(others, p.ID)
+		}
+	}
+	counterparts := map[string]bool{}
+	if len(others) > 0 {
+		var err error
+		if counterparts, err = a.sessions.SessionCounterparts(ctx, userID, contactID, locationID, others); err != nil {
+			return nil, err
+		}
+	}
+
+	var visible []presence.Participant
+	for _, p := range participants {
+		if (p.Kind == selfKind && p.ID == authCtx.UserID) || (p.Kind == otherKind && counterparts[p.ID]) {
+			visible = append(visible, p)
+			continue
+		}
+		log.Printf("🚫 Presence lookup denied: %s %s target=%s:%s", authCtx.UserType, authCtx.UserID, p.Kind, p.ID)
+	}
+	return visible, nil
+}
+
+// attachCounterpartPresence fills in the presence of the other side of each
+// session: the patient for doctors, the doctor for patients.
+func (a *API) attachCounterpartPresence(ctx context.Context, sessions []models.ChatSessionResponse, userType string) {
+	counterparts := make([]presence.Participant, len(sessions))
+	for i, s := range sessions {
+		counterparts[i] = presence.Contact(s.LocationID.String(), s.ContactID.String())
+		if userType == "PATIENT" {
+			counterparts[i] = presence.User(s.LocationID.String(), s.UserID.String())
+		}
+	}
+
+	statuses, err := a.presence.GetMany(ctx, counterparts)
+	if err != nil {
+		log.Printf("⚠️ Failed to attach presence to sessions: %v", err)
+		return
+	}
+	a.applyOfficeHoursMany(ctx, statuses)
+	for i := range sessions {
+		if status, ok := statuses[counterparts[i]]; ok {
+			sessions[i].CounterpartPresence = &status
+		}
+	}
+}
+
+// PUT /chat/presence/status
+// Sets the caller's availability (busy, in surgery, on call, do not disturb)
+// with an optional message and expiry. "available" clears it.
+func (a *API) SetPresenceStatus(w http.ResponseWriter, r *http.Request) {
+	authCtx := auth.GetAuthContext(r)
+
+	var payload struct {
+		LocationID   string     `json:"location_id"`
+		Availability string     `json:"availability"`
+		Message      string     `json:"message"`
+		Until        *time.Time `json:"until"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if payload.LocationID == "" || payload.Availability == "" {
+		writeError(w, http.StatusBadRequest, "Missing location_id or availability")
+		return
+	}
+
+	var participant presence.Participant
+	switch authCtx.UserType {
+	case "DOCTOR":
+		participant = presence.User(payload.LocationID, authCtx.UserID)
+	case "PATIENT":
+		participant = presence.Contact(payload.LocationID, authCtx.UserID)
+	default:
+		writeError(w, http.StatusForbidden, "Unauthorized")
+		return
+	}
+
+	err := a.presence.SetAvailability(r.Context(), participant, presence.Availability{
+		State:   payload.Availability,
+		Message: payload.Message,
+		Until:   payload.Until,
 	})
+	if errors.Is(err, presence.ErrInvalidAvailability) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Failed to set availability: %v", err)
+		writeError(w, errorStatus(err), "Failed to update status")
+		return
+	}
+
+	status, err := a.presence.Get(r.Context(), participant)
+	if err != nil {
+		log.Printf("❌ Presence lookup failed: %v", err)
+		writeError(w, errorStatus(err), "Presence check failed")
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
 }
 
-func UploadChatFile(w http.ResponseWriter, r *http.Request) {
+// POST /chat/upload
+// Stores an attachment to send with a message. The optional location_id
+// form field files the upload under that location in the audit log.
+func (a *API) UploadChatFile(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseMultipartForm(10 << 20) // 10 MB
 	if err != nil {
 		writeError(w, http.StatusBadRequest, "Invalid file upload")
 		return
 	}
+	if loc := r.FormValue("location_id"); loc != "" {
+		if _, err := uuid.Parse(loc); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid location_id")
+			return
+		}
+	}
 
 	file, fileHeader, err := r.FormFile("file")
 	if err != nil {
@@ -318,6 +870,23 @@ func UploadChatFile(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, "Failed to upload file")
 		return
 	}
+	err = a.recordAudit(r.Context(), auth.GetAuthContext(r), models.AuditEntry{
+		LocationID: r.FormValue("location_id"),
+		Action:     auditUpload,
+		TargetType: "file",
+		TargetID:   url,
+		Details:    auditDetails(map[string]any{"file_name": fileHeader.Filename, "file_type": fileType, "size": fileHeader.Size}),
+	})
+	if err != nil {
+		// No upload may outlive a missing audit entry
+		if a.files != nil {
+			if err := a.files.DeleteFile(context.WithoutCancel(r.Context()), url); err != nil {
+				log.Printf("❌ Failed to remove unaudited upload %s: %v", url, err)
+			}
+		}
+		writeError(w, errorStatus(err), "Failed to upload file")
+		return
+	}
 
 	writeJSON(w, http.StatusOK, map[string]string{
 		"file_url":  url,
@@ -326,255 +895,687 @@ func UploadChatFile(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func ListChatSessions(repo *repository.MessageRepo) http.HandlerFunc {
-	// Supports optional ?location_id, ?limit, and ?offset params
-	return func(w http.ResponseWriter, r *http.Request) {
-		authCtx := auth.GetAuthContext(r)
-		userID := authCtx.UserID
-		userType := authCtx.UserType
-
-		if userID == "" || (userType != "DOCTOR" && userType != "PATIENT") {
-			writeError(w, http.StatusUnauthorized, "Unauthorized access")
-			return
-		}
-
-		var contactID string
-		if userType == "PATIENT" {
-			contactID = userID
-		}
-
-		locationID := r.URL.Query().Get("location_id")
-		limitParam := r.URL.Query().Get("limit")
-		offsetParam := r.URL.Query().Get("offset")
-
-		limit := 20
-		offset := 0
-
-		if parsedLimit, err := strconv.Atoi(limitParam); err == nil && parsedLimit > 0 {
-			limit = parsedLimit
-		}
-		if parsedOffset, err := strconv.Atoi(offsetParam); err == nil && parsedOffset >= 0 {
-			offset = parsedOffset
-		}
-
-		sessions, err := repo.ListEnrichedChatSessionsWithFilter(userID, contactID, locationID, limit, offset)
-		if err != nil {
-			log.Printf("❌ Failed to fetch chat sessions: %v", err)
-			writeError(w, http.StatusInternalServerError, "Could not fetch sessions")
-			return
-		}
+// GET /chat/message/{id}/file
+// Serves a message's attachment to its participants and admins, recording
+// the download in the audit log first.
+func (a *API) DownloadChatFile(w http.ResponseWriter, r *http.Request) {
+	auth := auth.GetAuthContext(r)
 
-		writeJSON(w, http.StatusOK, sessions)
+	msgID := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(msgID); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid message ID")
+		return
 	}
-}
-
-func SearchMessages(repo *repository.MessageRepo) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		authCtx := auth.GetAuthContext(r)
-		query := r.URL.Query().Get("q")
-		locationID := r.URL.Query().Get("location_id")
-		limitParam := r.URL.Query().Get("limit")
 
-		if query == "" || locationID == "" {
-			writeError(w, http.StatusBadRequest, "Missing required parameters: q, location_id")
-			return
-		}
+	msg, err := a.messages.GetMessageByID(r.Context(), msgID)
+	if errors.Is(err, repository.ErrMessageNotFound) {
+		writeError(w, http.StatusNotFound, "Message not found")
+		return
+	}
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to fetch message")
+		return
+	}
+	if !isMessageParticipant(auth, msg) {
+		writeError(w, http.StatusForbidden, "Unauthorized")
+		return
+	}
+	if msg.FileURL == "" {
+		writeError(w, http.StatusNotFound, "Message has no attachment")
+		return
+	}
 
-		limit := 20
-		if parsedLimit, err := strconv.Atoi(limitParam); err == nil && parsedLimit > 0 {
-			limit = parsedLimit
-		}
+	body, err := a.files.GetFile(r.Context(), msg.FileURL)
+	if err != nil {
+		log.Printf("❌ Failed to fetch attachment of message %s: %v", msgID, err)
+		writeError(w, http.StatusBadGateway, "Failed to fetch file")
+		return
+	}
+	err = a.recordAudit(r.Context(), auth, models.AuditEntry{
+		LocationID: msg.LocationID.String(),
+		Action:     auditDownload,
+		TargetType: "message",
+		TargetID:   msgID,
+		Details:    auditDetails(map[string]any{"file_url": msg.FileURL, "size": len(body)}),
+	})
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to fetch file")
+		return
+	}
 
-		var contactID string
-		if authCtx.UserType == "PATIENT" {
-			contactID = authCtx.UserID
-		}
+	contentType := msg.FileType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": msg.FileName}))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
 
-		results, err := repo.SearchMessages(authCtx.UserID, contactID, locationID, query, limit)
-		if err != nil {
-			log.Printf("❌ Failed to search messages: %v", err)
-			writeError(w, http.StatusInternalServerError, "Search failed")
-			return
-		}
+func (a *API) ListChatSessions(w http.ResponseWriter, r *http.Request) {
+	// Supports optional ?location_id, ?limit, and ?offset params
+	authCtx := auth.GetAuthContext(r)
+	userID := authCtx.UserID
+	userType := authCtx.UserType
+
+	if userID == "" || (userType != "DOCTOR" && userType != "PATIENT") {
+		writeError(w, http.StatusUnauthorized, "Unauthorized access")
+		return
+	}
+
+	var contactID string
+	if userType == "PATIENT" {
+		contactID = userID
+	}
+
+	locationID := r.URL.Query().Get("location_id")
+	limitParam := r.URL.Query().Get("limit")
+	offsetParam := r.URL.Query().Get("offset")
+
+	limit := 20
+	offset := 0
+
+	if parsedLimit, err := strconv.Atoi(limitParam); err == nil && parsedLimit > 0 {
+		limit = parsedLimit
+	}
+	if parsedOffset, err := strconv.Atoi(offsetParam); err == nil && parsedOffset >= 0 {
+		offset = parsedOffset
+	}
+
+	sessions, err := a.sessions.ListEnrichedChatSessionsWithFilter(r.Context(), userID, contactID, locationID, limit, offset)
+	if err != nil {
+		log.Printf("❌ Failed to fetch chat sessions: %v", err)
+		writeError(w, errorStatus(err), "Could not fetch sessions")
+		return
+	}
+	err = a.recordAudit(r.Context(), authCtx, models.AuditEntry{
+		LocationID: locationID,
+		Action:     auditSessionsList,
+		Details:    auditDetails(map[string]any{"limit": limit, "offset": offset, "sessions": len(sessions)}),
+	})
+	if err != nil {
+		writeError(w, errorStatus(err), "Could not fetch sessions")
+		return
+	}
 
-		writeJSON(w, http.StatusOK, results)
+	// Show the counterpart's presence and availability on each row,
+	// unless the client opts out with ?include_presence=false
+	if r.URL.Query().Get("include_presence") != "false" {
+		a.attachCounterpartPresence(r.Context(), sessions, userType)
 	}
+
+	writeJSON(w, http.StatusOK, sessions)
 }
 
-func AdminListSessions(repo *repository.MessageRepo) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		authCtx := auth.GetAuthContext(r)
+func (a *API) AdminListSessions(w http.ResponseWriter, r *http.Request) {
+	authCtx := auth.GetAuthContext(r)
 
-		// Only allow users with type ADMIN or SUPERADMIN
-		if authCtx.UserType != "ADMIN" && authCtx.UserType != "SUPERADMIN" {
-			writeError(w, http.StatusForbidden, "Admin access required")
-			return
-		}
+	// Only allow users with type ADMIN or SUPERADMIN
+	if authCtx.UserType != "ADMIN" && authCtx.UserType != "SUPERADMIN" {
+		writeError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
 
-		locationID := r.URL.Query().Get("location_id")
-		limit := 50
-		offset := 0
+	locationID := r.URL.Query().Get("location_id")
+	limit := 50
+	offset := 0
 
-		if val := r.URL.Query().Get("limit"); val != "" {
-			if l, err := strconv.Atoi(val); err == nil && l > 0 {
-				limit = l
-			}
+	if val := r.URL.Query().Get("limit"); val != "" {
+		if l, err := strconv.Atoi(val); err == nil && l > 0 {
+			limit = l
 		}
-		if val := r.URL.Query().Get("offset"); val != "" {
-			if o, err := strconv.Atoi(val); err == nil && o >= 0 {
-				offset = o
-			}
+	}
+	if val := r.URL.Query().Get("offset"); val != "" {
+		if o, err := strconv.Atoi(val); err == nil && o >= 0 {
+			offset = o
 		}
+	}
 
-		sessions, err := repo.AdminListAllSessions(locationID, limit, offset)
-		if err != nil {
-			log.Printf("❌ AdminListSessions query failed: %v", err)
-			writeError(w, http.StatusInternalServerError, "Failed to fetch admin sessions")
-			return
-		}
+	sessions, err := a.sessions.AdminListAllSessions(r.Context(), locationID, limit, offset)
+	if err != nil {
+		log.Printf("❌ AdminListSessions query failed: %v", err)
+		writeError(w, errorStatus(err), "Failed to fetch admin sessions")
+		return
+	}
+	err = a.recordAudit(r.Context(), authCtx, models.AuditEntry{
+		LocationID: locationID,
+		Action:     auditSessionsList,
+		Details:    auditDetails(map[string]any{"limit": limit, "offset": offset, "sessions": len(sessions)}),
+	})
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to fetch admin sessions")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, sessions)
+}
 
-		writeJSON(w, http.StatusOK, sessions)
+// GET /admin/chat/cache/stats
+// Reports this instance's history cache hits and misses per location.
+func (a *API) GetHistoryCacheStats(w http.ResponseWriter, r *http.Request) {
+	authCtx := auth.GetAuthContext(r)
+	if authCtx.UserType != "ADMIN" && authCtx.UserType != "SUPERADMIN" {
+		writeError(w, http.StatusForbidden, "Admin access required")
+		return
 	}
+
+	if a.cacheStats == nil {
+		writeError(w, http.StatusNotFound, "History cache is not enabled")
+		return
+	}
+	writeJSON(w, http.StatusOK, a.cacheStats())
 }
 
-func AdminDeleteMessages(repo *repository.MessageRepo) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		authCtx := auth.GetAuthContext(r)
-		if authCtx.UserType != "ADMIN" && authCtx.UserType != "SUPERADMIN" {
-			writeError(w, http.StatusForbidden, "Unauthorized")
-			return
-		}
+func (a *API) AdminDeleteMessages(w http.ResponseWriter, r *http.Request) {
+	authCtx := auth.GetAuthContext(r)
+	if authCtx.UserType != "ADMIN" && authCtx.UserType != "SUPERADMIN" {
+		writeError(w, http.StatusForbidden, "Unauthorized")
+		return
+	}
 
-		var payload struct {
-			MessageIDs []string `json:"message_ids"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-			writeError(w, http.StatusBadRequest, "Invalid request")
-			return
-		}
+	var payload struct {
+		MessageIDs []string `json:"message_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
 
-		var uuids []uuid.UUID
-		for _, id := range payload.MessageIDs {
-			if u, err := uuid.Parse(id); err == nil {
-				uuids = append(uuids, u)
-			}
+	var uuids []uuid.UUID
+	for _, id := range payload.MessageIDs {
+		if u, err := uuid.Parse(id); err == nil {
+			uuids = append(uuids, u)
 		}
+	}
 
-		if err := repo.AdminDeleteMessages(uuids); err != nil {
-			writeError(w, http.StatusInternalServerError, "Failed to delete messages")
-			return
-		}
+	events, err := a.messages.AdminDeleteMessages(a.auditWrites(r.Context(), authCtx, auditAdminDelete, nil), uuids)
+	if errors.Is(err, repository.ErrLegalHold) {
+		writeError(w, http.StatusLocked, "Some messages are under legal hold")
+		return
+	}
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to delete messages")
+		return
+	}
+
+	a.publishSessionEvents(events...)
+	writeEvents(w, "Messages soft-deleted", events...)
+}
 
-		writeSuccess(w, http.StatusOK, "Messages soft-deleted")
+func (a *API) DeleteChatMessage(w http.ResponseWriter, r *http.Request) {
+	auth := auth.GetAuthContext(r)
+	if auth.UserType != "ADMIN" && auth.UserType != "DOCTOR" && auth.UserType != "SUPERADMIN" {
+		writeError(w, http.StatusForbidden, "Unauthorized")
+		return
+	}
+
+	idStr := chi.URLParam(r, "id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid message ID")
+		return
 	}
+
+	ev, err := a.messages.DeleteMessage(a.auditWrites(r.Context(), auth, auditDelete, nil), id)
+	if errors.Is(err, repository.ErrLegalHold) {
+		writeError(w, http.StatusLocked, "Message is under legal hold")
+		return
+	}
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to delete message")
+		return
+	}
+
+	a.publishSessionEvents(ev)
+	writeEvents(w, "Message deleted", ev)
 }
 
-func DeleteChatMessage(repo *repository.MessageRepo) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		auth := auth.GetAuthContext(r)
-		if auth.UserType != "ADMIN" && auth.UserType != "DOCTOR" && auth.UserType != "SUPERADMIN" {
-			writeError(w, http.StatusForbidden, "Unauthorized")
-			return
-		}
+func (a *API) AddReaction(w http.ResponseWriter, r *http.Request) {
+	auth := auth.GetAuthContext(r)
+	var payload struct {
+		MessageID string `json:"message_id"`
+		Emoji     string `json:"emoji"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	ctx := a.auditWrites(r.Context(), auth, auditReactionAdd, map[string]any{"emoji": payload.Emoji})
+	ev, err := a.reactions.AddReaction(ctx, payload.MessageID, auth.UserID, payload.Emoji)
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to add reaction")
+		return
+	}
+	a.publishSessionEvents(ev)
+	writeEvents(w, "Reaction added", ev)
+}
 
-		idStr := chi.URLParam(r, "id")
-		id, err := uuid.Parse(idStr)
-		if err != nil {
-			writeError(w, http.StatusBadRequest, "Invalid message ID")
-			return
-		}
+func (a *API) RemoveReaction(w http.ResponseWriter, r *http.Request) {
+	auth := auth.GetAuthContext(r)
+	var payload struct {
+		MessageID string `json:"message_id"`
+		Emoji     string `json:"emoji"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	ctx := a.auditWrites(r.Context(), auth, auditReactionDrop, map[string]any{"emoji": payload.Emoji})
+	ev, err := a.reactions.RemoveReaction(ctx, payload.MessageID, auth.UserID, payload.Emoji)
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to remove reaction")
+		return
+	}
+	a.publishSessionEvents(ev)
+	writeEvents(w, "Reaction removed", ev)
+}
 
-		if err := repo.DeleteMessage(id); err != nil {
-			writeError(w, http.StatusInternalServerError, "Failed to delete message")
-			return
-		}
+// MessageEditWindow is how long after sending a message its sender may edit it.
+var MessageEditWindow = 15 * time.Minute
+
+// PUT /chat/message/{id}
+// Lets the sender correct a message within MessageEditWindow. The replaced
+// wording is kept as a revision.
+func (a *API) EditMessage(w http.ResponseWriter, r *http.Request) {
+	auth := auth.GetAuthContext(r)
+
+	msgID := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(msgID); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid message ID")
+		return
+	}
+
+	var payload struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if payload.Content == "" {
+		writeError(w, http.StatusBadRequest, "Content must not be empty")
+		return
+	}
+
+	msg, err := a.messages.GetMessageByID(r.Context(), msgID)
+	if errors.Is(err, repository.ErrMessageNotFound) {
+		writeError(w, http.StatusNotFound, "Message not found")
+		return
+	}
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to fetch message")
+		return
+	}
 
-		writeSuccess(w, http.StatusOK, "Message deleted")
+	if msg.IsSystem || !isMessageSender(auth, msg) {
+		writeError(w, http.StatusForbidden, "Only the sender can edit this message")
+		return
+	}
+	if time.Since(msg.SentAt) > MessageEditWindow {
+		writeError(w, http.StatusForbidden, "Edit window has expired")
+		return
+	}
+	if payload.Content == msg.Content {
+		writeJSON(w, http.StatusOK, msg)
+		return
 	}
+
+	editedAt, ev, err := a.messages.UpdateMessageContent(a.auditWrites(r.Context(), auth, auditEdit, nil), msgID, auth.UserID, payload.Content)
+	if errors.Is(err, repository.ErrMessageNotFound) {
+		writeError(w, http.StatusNotFound, "Message not found")
+		return
+	}
+	if errors.Is(err, repository.ErrLegalHold) {
+		writeError(w, http.StatusLocked, "Message is under legal hold")
+		return
+	}
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to edit message")
+		return
+	}
+	msg.Content = payload.Content
+	msg.EditedAt = &editedAt
+
+	a.publishSessionEvents(ev)
+
+	writeJSON(w, http.StatusOK, msg)
 }
 
-func AddReaction(repo *repository.MessageRepo) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		auth := auth.GetAuthContext(r)
-		var payload struct {
-			MessageID string `json:"message_id"`
-			Emoji     string `json:"emoji"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-			writeError(w, http.StatusBadRequest, "Invalid JSON")
-			return
-		}
-		err := repo.AddReaction(payload.MessageID, auth.UserID, payload.Emoji)
-		if err != nil {
-			writeError(w, http.StatusInternalServerError, "Failed to add reaction")
+// GET /chat/message/{id}/revisions
+// Lists every prior version of a message for its participants and admins.
+func (a *API) GetMessageRevisions(w http.ResponseWriter, r *http.Request) {
+	auth := auth.GetAuthContext(r)
+
+	msgID := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(msgID); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid message ID")
+		return
+	}
+
+	msg, err := a.messages.GetMessageByID(r.Context(), msgID)
+	if errors.Is(err, repository.ErrMessageNotFound) {
+		writeError(w, http.StatusNotFound, "Message not found")
+		return
+	}
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to fetch message")
+		return
+	}
+	if !isMessageParticipant(auth, msg) {
+		writeError(w, http.StatusForbidden, "Unauthorized")
+		return
+	}
+
+	revisions, err := a.messages.GetMessageRevisions(r.Context(), msgID)
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to fetch revisions")
+		return
+	}
+	err = a.recordAudit(r.Context(), auth, models.AuditEntry{
+		LocationID: msg.LocationID.String(),
+		Action:     auditRevisionsRead,
+		TargetType: "message",
+		TargetID:   msgID,
+		Details:    auditDetails(map[string]any{"revisions": len(revisions)}),
+	})
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to fetch revisions")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"message":   msg,
+		"revisions": revisions,
+	})
+}
+
+// GET /chat/message/{id}/thread?after=...&limit=...
+// Returns the message and every reply beneath it, oldest first, paginated
+// with the next_cursor of the previous page.
+func (a *API) GetMessageThread(w http.ResponseWriter, r *http.Request) {
+	auth := auth.GetAuthContext(r)
+
+	msgID := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(msgID); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid message ID")
+		return
+	}
+
+	limit := 0
+	if parsedLimit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && parsedLimit > 0 {
+		limit = parsedLimit
+	}
+
+	thread, err := a.messages.GetThread(r.Context(), msgID, r.URL.Query().Get("after"), limit)
+	switch {
+	case errors.Is(err, repository.ErrMessageNotFound):
+		writeError(w, http.StatusNotFound, "Message not found")
+		return
+	case errors.Is(err, repository.ErrInvalidCursor):
+		writeError(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	case err != nil:
+		writeError(w, errorStatus(err), "Failed to fetch thread")
+		return
+	}
+	if !isMessageParticipant(auth, thread.Root) {
+		writeError(w, http.StatusForbidden, "Unauthorized")
+		return
+	}
+	err = a.recordAudit(r.Context(), auth, models.AuditEntry{
+		LocationID: thread.Root.LocationID.String(),
+		Action:     auditThreadRead,
+		TargetType: "message",
+		TargetID:   msgID,
+		Details:    auditDetails(map[string]any{"replies": len(thread.Replies)}),
+	})
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to fetch thread")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, thread)
+}
+
+// GET /chat/session/{session_id}/events?after_seq=...&limit=...
+// Replays a session's numbered events after after_seq so clients can fill
+// gaps or catch up after reconnecting.
+func (a *API) GetSessionEvents(w http.ResponseWriter, r *http.Request) {
+	auth := auth.GetAuthContext(r)
+
+	sessionID := chi.URLParam(r, "session_id")
+	if _, err := uuid.Parse(sessionID); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid session ID")
+		return
+	}
+
+	var afterSeq int64
+	if v := r.URL.Query().Get("after_seq"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "Invalid after_seq")
 			return
 		}
-		writeSuccess(w, http.StatusOK, "Reaction added")
+		afterSeq = n
+	}
+	limit := 0
+	if parsedLimit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && parsedLimit > 0 {
+		limit = parsedLimit
+	}
+
+	session, err := a.sessions.GetSessionByID(r.Context(), sessionID)
+	if errors.Is(err, repository.ErrSessionNotFound) {
+		writeError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to fetch session")
+		return
+	}
+	if !isSessionParticipant(auth, session) {
+		writeError(w, http.StatusForbidden, "Unauthorized")
+		return
+	}
+
+	page, err := a.messages.GetSessionEvents(r.Context(), sessionID, afterSeq, limit)
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to replay session")
+		return
 	}
+	err = a.recordAudit(r.Context(), auth, models.AuditEntry{
+		LocationID: session.LocationID.String(),
+		Action:     auditEventsRead,
+		TargetType: "session",
+		TargetID:   sessionID,
+		Details:    auditDetails(map[string]any{"after_seq": afterSeq, "events": len(page.Events)}),
+	})
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to replay session")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
 }
 
-func RemoveReaction(repo *repository.MessageRepo) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		auth := auth.GetAuthContext(r)
-		var payload struct {
-			MessageID string `json:"message_id"`
-			Emoji     string `json:"emoji"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-			writeError(w, http.StatusBadRequest, "Invalid JSON")
-			return
+// POST /chat/sync
+// Returns every change across the caller's sessions since the sync token:
+// new and changed messages, deleted message IDs, reaction changes and
+// session changes, plus the token to pass next time. Omit token for a
+// full initial sync. The token travels in the body like the other sync
+// options.
+func (a *API) SyncChanges(w http.ResponseWriter, r *http.Request) {
+	auth := auth.GetAuthContext(r)
+
+	var payload struct {
+		Token      string `json:"token"`
+		LocationID string `json:"location_id"`
+		Limit      int    `json:"limit"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	var userID, contactID string
+	switch auth.UserType {
+	case "DOCTOR":
+		userID = auth.UserID
+	case "PATIENT":
+		contactID = auth.UserID
+	default:
+		writeError(w, http.StatusForbidden, "Unauthorized")
+		return
+	}
+
+	changes, err := a.messages.Sync(r.Context(), userID, contactID, payload.LocationID, payload.Token, max(payload.Limit, 0))
+	if errors.Is(err, repository.ErrInvalidSyncToken) {
+		writeError(w, http.StatusBadRequest, "Invalid sync token")
+		return
+	}
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to sync")
+		return
+	}
+	err = a.recordAudit(r.Context(), auth, models.AuditEntry{
+		LocationID: payload.LocationID,
+		Action:     auditSync,
+		Details: auditDetails(map[string]any{
+			"messages": len(changes.Messages),
+			"deleted":  len(changes.DeletedMessageIDs),
+			"sessions": len(changes.Sessions),
+		}),
+	})
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to sync")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, changes)
+}
+
+// isSessionParticipant reports whether the caller may read the session.
+func isSessionParticipant(authCtx auth.AuthContext, s models.ChatSession) bool {
+	switch authCtx.UserType {
+	case "ADMIN", "SUPERADMIN":
+		return true
+	case "DOCTOR":
+		return authCtx.UserID == s.UserID.String()
+	case "PATIENT":
+		return authCtx.UserID == s.ContactID.String()
+	}
+	return false
+}
+
+// isMessageSender reports whether the caller wrote the message. Messages with a
+// sender contact come from the patient; all others from the doctor.
+func isMessageSender(authCtx auth.AuthContext, msg models.DBMessage) bool {
+	if msg.SenderContactID != uuid.Nil {
+		return authCtx.UserType == "PATIENT" && authCtx.UserID == msg.SenderContactID.String()
+	}
+	return authCtx.UserType == "DOCTOR" && authCtx.UserID == msg.SenderUserID.String()
+}
+
+// isMessageParticipant reports whether the caller may read the message.
+func isMessageParticipant(authCtx auth.AuthContext, msg models.DBMessage) bool {
+	switch authCtx.UserType {
+	case "ADMIN", "SUPERADMIN":
+		return true
+	case "DOCTOR":
+		return authCtx.UserID == msg.SenderUserID.String() || authCtx.UserID == msg.ReceiverUserID.String()
+	case "PATIENT":
+		return authCtx.UserID == msg.ReceiverContactID.String() || authCtx.UserID == msg.SenderContactID.String()
+	}
+	return false
+}
+
+// publishSessionEvents sends numbered session events to the connections of
+// each session's doctor and patient. Zero events (changes that turned out to
+// be no-ops) are skipped.
+func (a *API) publishSessionEvents(events ...models.SessionEvent) {
+	for _, ev := range events {
+		if ev.Seq == 0 {
+			continue
 		}
-		err := repo.RemoveReaction(payload.MessageID, auth.UserID, payload.Emoji)
+		data, err := json.Marshal(ev)
 		if err != nil {
-			writeError(w, http.StatusInternalServerError, "Failed to remove reaction")
-			return
+			log.Printf("❌ Failed to encode event: %v", err)
+			continue
+		}
+		a.hub.Broadcast <- ws.BroadcastMessage{
+			LocationID: ev.LocationID,
+			RawData:    data,
+			Participants: []presence.Participant{
+				presence.User(ev.LocationID, ev.UserID),
+				presence.Contact(ev.LocationID, ev.ContactID),
+			},
 		}
-		writeSuccess(w, http.StatusOK, "Reaction removed")
 	}
 }
 
-func PinMessage(repo *repository.MessageRepo) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		msgID := chi.URLParam(r, "id")
-		err := repo.TogglePinMessage(msgID, true)
-		if err != nil {
-			log.Printf("❌ Failed to pin message %s: %v", msgID, err)
-			writeError(w, http.StatusInternalServerError, "Failed to pin message")
-			return
+// writeEvents answers a mutation with the events it produced, so the caller
+// can advance its last seen seq without waiting for the broadcast.
+func writeEvents(w http.ResponseWriter, message string, events ...models.SessionEvent) {
+	recorded := []models.SessionEvent{}
+	for _, ev := range events {
+		if ev.Seq > 0 {
+			recorded = append(recorded, ev)
 		}
-		writeSuccess(w, http.StatusOK, "Message pinned")
 	}
+	writeJSON(w, http.StatusOK, map[string]any{"message": message, "events": recorded})
 }
 
-func UnpinMessage(repo *repository.MessageRepo) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		msgID := chi.URLParam(r, "id")
-		err := repo.TogglePinMessage(msgID, false)
-		if err != nil {
-			log.Printf("❌ Failed to unpin message %s: %v", msgID, err)
-			writeError(w, http.StatusInternalServerError, "Failed to unpin message")
-			return
-		}
-		writeSuccess(w, http.StatusOK, "Message unpinned")
+func (a *API) PinMessage(w http.ResponseWriter, r *http.Request) {
+	msgID := chi.URLParam(r, "id")
+	ev, err := a.pins.TogglePinMessage(a.auditWrites(r.Context(), auth.GetAuthContext(r), auditPin, nil), msgID, true)
+	if err != nil {
+		log.Printf("❌ Failed to pin message %s: %v", msgID, err)
+		writeError(w, errorStatus(err), "Failed to pin message")
+		return
 	}
+	a.publishSessionEvents(ev)
+	writeEvents(w, "Message pinned", ev)
 }
 
-func GetPinnedMessages(repo *repository.MessageRepo) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		sessionID := chi.URLParam(r, "session_id")
-		messages, err := repo.GetPinnedMessages(sessionID)
-		if err != nil {
-			log.Printf("❌ Failed to fetch pinned messages: %v", err)
-			writeError(w, http.StatusInternalServerError, "Failed to fetch pinned messages")
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(messages)
+func (a *API) UnpinMessage(w http.ResponseWriter, r *http.Request) {
+	msgID := chi.URLParam(r, "id")
+	ev, err := a.pins.TogglePinMessage(a.auditWrites(r.Context(), auth.GetAuthContext(r), auditUnpin, nil), msgID, false)
+	if err != nil {
+		log.Printf("❌ Failed to unpin message %s: %v", msgID, err)
+		writeError(w, errorStatus(err), "Failed to unpin message")
+		return
 	}
+	a.publishSessionEvents(ev)
+	writeEvents(w, "Message unpinned", ev)
+}
+
+func (a *API) GetPinnedMessages(w http.ResponseWriter, r *http.Request) {
+	sessionID := chi.URLParam(r, "session_id")
+	messages, err := a.pins.GetPinnedMessages(r.Context(), sessionID)
+	if err != nil {
+		log.Printf("❌ Failed to fetch pinned messages: %v", err)
+		writeError(w, errorStatus(err), "Failed to fetch pinned messages")
+		return
+	}
+	// Unknown sessions are audited without a location
+	session, _ := a.sessions.GetSessionByID(r.Context(), sessionID)
+	err = a.recordAudit(r.Context(), auth.GetAuthContext(r), models.AuditEntry{
+		LocationID: session.LocationID.String(),
+		Action:     auditPinnedRead,
+		TargetType: "session",
+		TargetID:   sessionID,
+		Details:    auditDetails(map[string]any{"messages": len(messages)}),
+	})
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to fetch pinned messages")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
 }
 
 // POST /auth/device-token
-func SaveDeviceToken(w http.ResponseWriter, r *http.Request) {
+func (a *API) SaveDeviceToken(w http.ResponseWriter, r *http.Request) {
 	var payload struct {
 		UserID string `json:"user_id"`
 		Token  string `json:"token"`
@@ -585,9 +1586,9 @@ func SaveDeviceToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := messageRepo.UpsertDeviceToken(payload.UserID, payload.Token)
+	err := a.devices.UpsertDeviceToken(r.Context(), payload.UserID, payload.Token)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Failed to save token")
+		writeError(w, errorStatus(err), "Failed to save token")
 		return
 	}
 
@@ -607,6 +1608,22 @@ func writeError(w http.ResponseWriter, status int, errMsg string) {
 	writeJSON(w, status, map[string]string{"error": errMsg})
 }
 
+// statusClientClosedRequest is the non-standard status logged when the
+// client went away before the response was ready.
+const statusClientClosedRequest = 499
+
+// errorStatus picks the status for a failed store or Redis call: 504 if it
+// ran out of time, 499 if the client gave up first, otherwise 500.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		return statusClientClosedRequest
+	}
+	return http.StatusInternalServerError
+}
+
 func writeSuccess(w http.ResponseWriter, status int, message string) {
 	writeJSON(w, status, map[string]string{"message": message})
 }
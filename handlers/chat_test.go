@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"internal_chat_system/historycache"
+	"internal_chat_system/middleware/auth"
+	"internal_chat_system/models"
+	"internal_chat_system/presence"
+
+	"github.com/google/uuid"
+)
+
+func TestSendMessage(t *testing.T) {
+	api, _ := newTestAPI(t)
+
+	first := send(t, api, "hello")
+	second := send(t, api, "are you there?")
+	if first.Seq != 1 || second.Seq != 2 {
+		t.Errorf("seqs = %d, %d, want 1, 2", first.Seq, second.Seq)
+	}
+	if first.SessionID == uuid.Nil || first.SessionID != second.SessionID {
+		t.Errorf("messages landed in sessions %s and %s", first.SessionID, second.SessionID)
+	}
+
+	reply := newMessage("yes")
+	decode(t, call(t, api.SendMessage, http.MethodPost, "/chat/send", patient, reply), http.StatusCreated, nil)
+	page := history(t, api, patient)
+	if n := len(page.Messages); n != 3 || page.Messages[2].SenderContactID.String() != testPatient {
+		t.Fatalf("history after patient reply = %+v", page.Messages)
+	}
+
+	// Doctors only send as themselves, patients only into their own conversation
+	other := auth.AuthContext{UserID: uuid.NewString(), UserType: "DOCTOR"}
+	decode(t, call(t, api.SendMessage, http.MethodPost, "/chat/send", other, newMessage("hi")), http.StatusForbidden, nil)
+	stranger := auth.AuthContext{UserID: uuid.NewString(), UserType: "PATIENT"}
+	decode(t, call(t, api.SendMessage, http.MethodPost, "/chat/send", stranger, newMessage("hi")), http.StatusForbidden, nil)
+
+	decode(t, call(t, api.SendMessage, http.MethodPost, "/chat/send", doctor, newMessage("")), http.StatusBadRequest, nil)
+}
+
+func TestSendMessageIdempotent(t *testing.T) {
+	api, _ := newTestAPI(t)
+
+	msg := newMessage("take two tablets")
+	msg.ClientMessageID = "retry-1"
+	var first, retry models.DBMessage
+	decode(t, call(t, api.SendMessage, http.MethodPost, "/chat/send", doctor, msg), http.StatusCreated, &first)
+	decode(t, call(t, api.SendMessage, http.MethodPost, "/chat/send", doctor, msg), http.StatusOK, &retry)
+	if retry.ID.String() != first.ID.String() {
+		t.Errorf("retry returned message %s, want %s", retry.ID, first.ID)
+	}
+	if n := len(history(t, api, doctor).Messages); n != 1 {
+		t.Errorf("history has %d messages after a retry, want 1", n)
+	}
+
+	// The same key for a different message is a conflict
+	changed := msg
+	changed.Content = "take three tablets"
+	decode(t, call(t, api.SendMessage, http.MethodPost, "/chat/send", doctor, changed), http.StatusConflict, nil)
+
+	// Keys belong to the sender: the patient's own "retry-1" is a new message
+	reply := newMessage("ok")
+	reply.ClientMessageID = "retry-1"
+	decode(t, call(t, api.SendMessage, http.MethodPost, "/chat/send", patient, reply), http.StatusCreated, nil)
+	if n := len(history(t, api, doctor).Messages); n != 2 {
+		t.Errorf("history has %d messages, want 2", n)
+	}
+}
+
+func TestEditMessage(t *testing.T) {
+	api, _ := newTestAPI(t)
+	msg := send(t, api, "see you at 3")
+	id := msg.ID.String()
+
+	var edited models.DBMessage
+	w := call(t, api.EditMessage, http.MethodPut, "/chat/message/"+id, doctor, map[string]string{"content": "see you at 4"}, "id", id)
+	decode(t, w, http.StatusOK, &edited)
+	if edited.Content != "see you at 4" || edited.EditedAt == nil {
+		t.Errorf("edited message = %+v", edited)
+	}
+	if got := history(t, api, doctor).Messages[0].Content; got != "see you at 4" {
+		t.Errorf("history content = %q after edit", got)
+	}
+
+	var revisions struct {
+		Revisions []models.MessageRevision `json:"revisions"`
+	}
+	w = call(t, api.GetMessageRevisions, http.MethodGet, "/chat/message/"+id+"/revisions", patient, nil, "id", id)
+	decode(t, w, http.StatusOK, &revisions)
+	if len(revisions.Revisions) != 1 || revisions.Revisions[0].Content != "see you at 3" {
+		t.Errorf("revisions = %+v", revisions.Revisions)
+	}
+
+	// Only the sender edits
+	w = call(t, api.EditMessage, http.MethodPut, "/chat/message/"+id, patient, map[string]string{"content": "never"}, "id", id)
+	decode(t, w, http.StatusForbidden, nil)
+
+	missing := uuid.NewString()
+	w = call(t, api.EditMessage, http.MethodPut, "/chat/message/"+missing, doctor, map[string]string{"content": "x"}, "id", missing)
+	decode(t, w, http.StatusNotFound, nil)
+}
+
+func TestDeleteChatMessage(t *testing.T) {
+	api, _ := newTestAPI(t)
+	msg := send(t, api, "wrong chat")
+	id := msg.ID.String()
+
+	decode(t, call(t, api.DeleteChatMessage, http.MethodDelete, "/chat/message/"+id, patient, nil, "id", id), http.StatusForbidden, nil)
+
+	var resp struct {
+		Events []models.SessionEvent `json:"events"`
+	}
+	decode(t, call(t, api.DeleteChatMessage, http.MethodDelete, "/chat/message/"+id, doctor, nil, "id", id), http.StatusOK, &resp)
+	if len(resp.Events) != 1 || resp.Events[0].Type != models.EventMessageDeleted || resp.Events[0].Seq != 2 {
+		t.Errorf("delete events = %+v", resp.Events)
+	}
+	for _, m := range history(t, api, doctor).Messages {
+		if m.ID == msg.ID {
+			t.Errorf("deleted message still in history")
+		}
+	}
+
+	decode(t, call(t, api.DeleteChatMessage, http.MethodDelete, "/chat/message/x", doctor, nil, "id", "x"), http.StatusBadRequest, nil)
+}
+
+func TestSyncChanges(t *testing.T) {
+	api, _ := newTestAPI(t)
+	kept := send(t, api, "first")
+	gone := send(t, api, "second")
+
+	// An empty body is a full initial sync
+	var initial models.SyncResponse
+	decode(t, call(t, api.SyncChanges, http.MethodPost, "/chat/sync", patient, nil), http.StatusOK, &initial)
+	if len(initial.Messages) != 2 || initial.SyncToken == "" {
+		t.Fatalf("initial sync = %+v", initial)
+	}
+
+	id := gone.ID.String()
+	decode(t, call(t, api.DeleteChatMessage, http.MethodDelete, "/chat/message/"+id, doctor, nil, "id", id), http.StatusOK, nil)
+	send(t, api, "third")
+
+	var next models.SyncResponse
+	w := call(t, api.SyncChanges, http.MethodPost, "/chat/sync", patient, map[string]string{"token": initial.SyncToken})
+	decode(t, w, http.StatusOK, &next)
+	if len(next.Messages) != 1 || next.Messages[0].Content != "third" {
+		t.Errorf("messages since token = %+v", next.Messages)
+	}
+	if len(next.DeletedMessageIDs) != 1 || next.DeletedMessageIDs[0] != id {
+		t.Errorf("deleted since token = %v, want [%s]", next.DeletedMessageIDs, id)
+	}
+	for _, m := range next.Messages {
+		if m.ID == kept.ID {
+			t.Errorf("unchanged message %s synced again", kept.ID)
+		}
+	}
+
+	var idle models.SyncResponse
+	w = call(t, api.SyncChanges, http.MethodPost, "/chat/sync", patient, map[string]string{"token": next.SyncToken})
+	decode(t, w, http.StatusOK, &idle)
+	if len(idle.Messages) != 0 || len(idle.DeletedMessageIDs) != 0 {
+		t.Errorf("sync without changes = %+v", idle)
+	}
+
+	w = call(t, api.SyncChanges, http.MethodPost, "/chat/sync", patient, map[string]string{"token": "not-a-token"})
+	decode(t, w, http.StatusBadRequest, nil)
+	decode(t, call(t, api.SyncChanges, http.MethodPost, "/chat/sync", admin, nil), http.StatusForbidden, nil)
+}
+
+func TestSetPresenceStatus(t *testing.T) {
+	api, _ := newTestAPI(t)
+
+	var status models.PresenceStatus
+	body := map[string]string{"location_id": testLocation, "availability": presence.AvailabilityInSurgery, "message": "back at 5"}
+	decode(t, call(t, api.SetPresenceStatus, http.MethodPut, "/chat/presence/status", doctor, body), http.StatusOK, &status)
+	if status.Availability != presence.AvailabilityInSurgery || status.Message != "back at 5" {
+		t.Errorf("status = %+v", status)
+	}
+
+	decode(t, call(t, api.SetPresenceStatus, http.MethodPut, "/chat/presence/status", admin, body), http.StatusForbidden, nil)
+	delete(body, "availability")
+	decode(t, call(t, api.SetPresenceStatus, http.MethodPut, "/chat/presence/status", doctor, body), http.StatusBadRequest, nil)
+}
+
+func TestGetHistoryCacheStats(t *testing.T) {
+	api, _ := newTestAPI(t)
+	decode(t, call(t, api.GetHistoryCacheStats, http.MethodGet, "/admin/chat/cache/stats", admin, nil), http.StatusNotFound, nil)
+
+	api.cacheStats = func() historycache.Stats {
+		return historycache.Stats{Total: historycache.LocationStats{Hits: 3}}
+	}
+	var stats historycache.Stats
+	decode(t, call(t, api.GetHistoryCacheStats, http.MethodGet, "/admin/chat/cache/stats", admin, nil), http.StatusOK, &stats)
+	if stats.Total.Hits != 3 {
+		t.Errorf("stats = %+v", stats)
+	}
+	decode(t, call(t, api.GetHistoryCacheStats, http.MethodGet, "/admin/chat/cache/stats", doctor, nil), http.StatusForbidden, nil)
+}
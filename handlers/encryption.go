@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"internal_chat_system/middleware/auth"
+	"internal_chat_system/repository"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultReencryptLimit = 10_000
+	maxReencryptLimit     = 100_000
+)
+
+// GET /admin/chat/encryption?location_id=loc1
+// Shows a location's data keys and how many messages each one seals.
+func (a *API) GetEncryptionStatus(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(auth.GetAuthContext(r)) {
+		writeError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+	locationID := r.URL.Query().Get("location_id")
+	if _, err := uuid.Parse(locationID); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid location_id")
+		return
+	}
+
+	status, err := a.encryption.GetEncryptionStatus(r.Context(), locationID)
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to fetch encryption status")
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// POST /admin/chat/encryption/rotate
+// Starts sealing a location's messages with a new data key. Existing
+// messages keep opening with the old key until they are re-encrypted.
+func (a *API) RotateEncryptionKey(w http.ResponseWriter, r *http.Request) {
+	authCtx := auth.GetAuthContext(r)
+	if !isAdmin(authCtx) {
+		writeError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+	var payload struct {
+		LocationID string `json:"location_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON payload")
+		return
+	}
+	if _, err := uuid.Parse(payload.LocationID); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid location_id")
+		return
+	}
+
+	ctx := a.auditWrites(r.Context(), authCtx, auditKeyRotate, nil)
+	key, err := a.encryption.RotateEncryptionKey(ctx, payload.LocationID)
+	if errors.Is(err, repository.ErrEncryptionDisabled) {
+		writeError(w, http.StatusConflict, "Encryption at rest is not enabled")
+		return
+	}
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to rotate encryption key")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, key)
+}
+
+// POST /admin/chat/encryption/reencrypt
+// Reseals up to limit of a location's messages that are still plaintext or
+// under a retired key. Call it again while the report shows some remaining.
+func (a *API) ReencryptMessages(w http.ResponseWriter, r *http.Request) {
+	authCtx := auth.GetAuthContext(r)
+	if !isAdmin(authCtx) {
+		writeError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+	var payload struct {
+		LocationID string `json:"location_id"`
+		Limit      int    `json:"limit"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON payload")
+		return
+	}
+	if _, err := uuid.Parse(payload.LocationID); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid location_id")
+		return
+	}
+	if payload.Limit <= 0 {
+		payload.Limit = defaultReencryptLimit
+	}
+	payload.Limit = min(payload.Limit, maxReencryptLimit)
+
+	// Each batch is audited as it commits, so a run that stopped part way
+	// still has what it resealed on record
+	ctx := a.auditWrites(r.Context(), authCtx, auditReencrypt, nil)
+	report, err := a.encryption.ReencryptMessages(ctx, payload.LocationID, payload.Limit)
+	if errors.Is(err, repository.ErrEncryptionDisabled) {
+		writeError(w, http.StatusConflict, "Encryption at rest is not enabled")
+		return
+	}
+	if err != nil {
+		writeError(w, errorStatus(err), "Re-encryption stopped: "+report.Error)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// POST /admin/chat/encryption/rewrap
+// Rewraps every data key still wrapped by a previous master key with the
+// current one. Once it reports none left, the previous key can be removed.
+func (a *API) RewrapEncryptionKeys(w http.ResponseWriter, r *http.Request) {
+	authCtx := auth.GetAuthContext(r)
+	if !isAdmin(authCtx) {
+		writeError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	// Each key is audited as it is rewrapped
+	ctx := a.auditWrites(r.Context(), authCtx, auditKeyRewrap, nil)
+	n, err := a.encryption.RewrapEncryptionKeys(ctx)
+	if errors.Is(err, repository.ErrEncryptionDisabled) {
+		writeError(w, http.StatusConflict, "Encryption at rest is not enabled")
+		return
+	}
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to rewrap encryption keys")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"rewrapped": n})
+}
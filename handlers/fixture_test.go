@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"internal_chat_system/middleware/auth"
+	"internal_chat_system/models"
+	"internal_chat_system/presence"
+	"internal_chat_system/repository/memory"
+	"internal_chat_system/ws"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+var (
+	testLocation = uuid.NewString()
+	testDoctor   = uuid.NewString()
+	testPatient  = uuid.NewString()
+
+	doctor  = auth.AuthContext{UserID: testDoctor, UserType: "DOCTOR"}
+	patient = auth.AuthContext{UserID: testPatient, UserType: "PATIENT"}
+	admin   = auth.AuthContext{UserID: uuid.NewString(), UserType: "ADMIN"}
+)
+
+// fakePresence keeps availability in memory and reports everyone offline.
+type fakePresence struct {
+	availability map[presence.Participant]presence.Availability
+}
+
+func (f *fakePresence) Get(ctx context.Context, p presence.Participant) (models.PresenceStatus, error) {
+	statuses, err := f.GetMany(ctx, []presence.Participant{p})
+	return statuses[p], err
+}
+
+func (f *fakePresence) GetMany(ctx context.Context, participants []presence.Participant) (map[presence.Participant]models.PresenceStatus, error) {
+	statuses := make(map[presence.Participant]models.PresenceStatus, len(participants))
+	for _, p := range participants {
+		status := models.PresenceStatus{Status: presence.StatusOffline, Availability: presence.AvailabilityAvailable}
+		if a, ok := f.availability[p]; ok {
+			status.Availability, status.Message, status.Until = a.State, a.Message, a.Until
+		}
+		statuses[p] = status
+	}
+	return statuses, nil
+}
+
+func (f *fakePresence) SetAvailability(ctx context.Context, p presence.Participant, a presence.Availability) error {
+	f.availability[p] = a
+	return nil
+}
+
+// newTestAPI builds an API on an in-memory store with a running hub.
+func newTestAPI(t *testing.T) (*API, *memory.Store) {
+	t.Helper()
+	store := memory.New()
+	hub := ws.NewHub()
+	go hub.Run()
+
+	api := NewAPI(Deps{
+		Messages: store, Sessions: store, Reactions: store, Pins: store,
+		Devices: store, OfficeHours: store, Audit: store, Retention: store,
+		LegalHolds: store, Encryption: store,
+		Presence: &fakePresence{availability: make(map[presence.Participant]presence.Availability)},
+		Hub:      hub,
+	})
+	return api, store
+}
+
+// call runs handler as caller. body is encoded as JSON unless it is nil;
+// params are the route's URL parameters.
+func call(t *testing.T, handler http.HandlerFunc, method, target string, caller auth.AuthContext, body any, params ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("encode body: %v", err)
+		}
+	}
+	r := httptest.NewRequest(method, target, &buf)
+
+	rctx := chi.NewRouteContext()
+	for i := 0; i+1 < len(params); i += 2 {
+		rctx.URLParams.Add(params[i], params[i+1])
+	}
+	r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+	r = auth.SetAuthContext(r, caller)
+
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// decode reads a JSON response into v, failing the test on an unexpected status.
+func decode(t *testing.T, w *httptest.ResponseRecorder, status int, v any) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d: %s", w.Code, status, w.Body.String())
+	}
+	if v == nil {
+		return
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode response: %v: %s", err, w.Body.String())
+	}
+}
+
+func newMessage(content string) models.Message {
+	return models.Message{
+		LocationID:        testLocation,
+		SenderUserID:      testDoctor,
+		ReceiverContactID: testPatient,
+		Content:           content,
+	}
+}
+
+// send stores a message from the doctor and returns it as history shows it.
+func send(t *testing.T, api *API, content string) models.DBMessage {
+	t.Helper()
+	decode(t, call(t, api.SendMessage, http.MethodPost, "/chat/send", doctor, newMessage(content)), http.StatusCreated, nil)
+
+	page := history(t, api, doctor)
+	for i := len(page.Messages) - 1; i >= 0; i-- {
+		if page.Messages[i].Content == content {
+			return page.Messages[i]
+		}
+	}
+	t.Fatalf("sent message %q not in history", content)
+	return models.DBMessage{}
+}
+
+func history(t *testing.T, api *API, caller auth.AuthContext) models.HistoryPage {
+	t.Helper()
+	var page models.HistoryPage
+	target := "/chat/history?location_id=" + testLocation + "&user_id=" + testDoctor + "&contact_id=" + testPatient
+	decode(t, call(t, api.GetMessageHistory, http.MethodGet, target, caller, nil), http.StatusOK, &page)
+	return page
+}
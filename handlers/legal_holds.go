@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"internal_chat_system/middleware/auth"
+	"internal_chat_system/models"
+	"internal_chat_system/repository"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// POST /admin/chat/legal-holds
+// Places a hold on a session, on a patient within a location, or on a
+// whole location. Held messages can't be edited, deleted or purged.
+func (a *API) PlaceLegalHold(w http.ResponseWriter, r *http.Request) {
+	authCtx := auth.GetAuthContext(r)
+	if !isAdmin(authCtx) {
+		writeError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	var hold models.LegalHold
+	if err := json.NewDecoder(r.Body).Decode(&hold); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON payload")
+		return
+	}
+	if hold.Reason == "" {
+		writeError(w, http.StatusBadRequest, "reason is required")
+		return
+	}
+
+	switch hold.Scope {
+	case models.HoldScopeSession:
+		if _, err := uuid.Parse(hold.SessionID); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid session_id")
+			return
+		}
+		session, err := a.sessions.GetSessionByID(r.Context(), hold.SessionID)
+		if errors.Is(err, repository.ErrSessionNotFound) {
+			writeError(w, http.StatusNotFound, "Session not found")
+			return
+		}
+		if err != nil {
+			writeError(w, errorStatus(err), "Failed to fetch session")
+			return
+		}
+		if hold.LocationID != "" && hold.LocationID != session.LocationID.String() {
+			writeError(w, http.StatusBadRequest, "Session belongs to another location")
+			return
+		}
+		hold.LocationID = session.LocationID.String()
+		hold.ContactID = ""
+	case models.HoldScopeContact:
+		if _, err := uuid.Parse(hold.ContactID); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid contact_id")
+			return
+		}
+		hold.SessionID = ""
+	case models.HoldScopeLocation:
+		hold.SessionID, hold.ContactID = "", ""
+	default:
+		writeError(w, http.StatusBadRequest, "scope must be session, contact or location")
+		return
+	}
+	if _, err := uuid.Parse(hold.LocationID); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid location_id")
+		return
+	}
+	hold.PlacedBy = authCtx.UserID
+
+	ctx := a.auditWrites(r.Context(), authCtx, auditHoldPlace, nil)
+	if err := a.legalHolds.PlaceLegalHold(ctx, &hold); err != nil {
+		writeError(w, errorStatus(err), "Failed to place legal hold")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, hold)
+}
+
+// PUT /admin/chat/legal-holds/{id}/release
+// Ends a hold. The hold stays listed with who released it and why.
+func (a *API) ReleaseLegalHold(w http.ResponseWriter, r *http.Request) {
+	authCtx := auth.GetAuthContext(r)
+	if !isAdmin(authCtx) {
+		writeError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid legal hold ID")
+		return
+	}
+	var payload struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON payload")
+		return
+	}
+	if payload.Reason == "" {
+		writeError(w, http.StatusBadRequest, "reason is required")
+		return
+	}
+
+	ctx := a.auditWrites(r.Context(), authCtx, auditHoldRelease, nil)
+	hold, err := a.legalHolds.ReleaseLegalHold(ctx, id, authCtx.UserID, payload.Reason)
+	switch {
+	case errors.Is(err, repository.ErrLegalHoldNotFound):
+		writeError(w, http.StatusNotFound, "Legal hold not found")
+		return
+	case errors.Is(err, repository.ErrLegalHoldReleased):
+		writeError(w, http.StatusConflict, "Legal hold already released")
+		return
+	case err != nil:
+		writeError(w, errorStatus(err), "Failed to release legal hold")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, hold)
+}
+
+// GET /admin/chat/legal-holds?location_id=loc1&active=true
+func (a *API) ListLegalHolds(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(auth.GetAuthContext(r)) {
+		writeError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+	locationID := r.URL.Query().Get("location_id")
+	if _, err := uuid.Parse(locationID); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid location_id")
+		return
+	}
+	activeOnly := r.URL.Query().Get("active") == "true"
+
+	holds, err := a.legalHolds.ListLegalHolds(r.Context(), locationID, activeOnly)
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to fetch legal holds")
+		return
+	}
+	writeJSON(w, http.StatusOK, holds)
+}
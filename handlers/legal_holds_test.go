@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"internal_chat_system/models"
+)
+
+func TestLegalHolds(t *testing.T) {
+	api, _ := newTestAPI(t)
+	msg := send(t, api, "discharge summary attached")
+	id := msg.ID.String()
+
+	place := models.LegalHold{Scope: models.HoldScopeSession, SessionID: msg.SessionID.String(), Reason: "litigation"}
+	decode(t, call(t, api.PlaceLegalHold, http.MethodPost, "/admin/chat/legal-holds", doctor, place), http.StatusForbidden, nil)
+
+	var hold models.LegalHold
+	decode(t, call(t, api.PlaceLegalHold, http.MethodPost, "/admin/chat/legal-holds", admin, place), http.StatusCreated, &hold)
+	if hold.LocationID != testLocation || hold.PlacedBy != admin.UserID {
+		t.Errorf("placed hold = %+v", hold)
+	}
+
+	// Held messages can't be changed
+	w := call(t, api.EditMessage, http.MethodPut, "/chat/message/"+id, doctor, map[string]string{"content": "redacted"}, "id", id)
+	decode(t, w, http.StatusLocked, nil)
+	decode(t, call(t, api.DeleteChatMessage, http.MethodDelete, "/chat/message/"+id, doctor, nil, "id", id), http.StatusLocked, nil)
+	w = call(t, api.AdminDeleteMessages, http.MethodPut, "/admin/chat/messages/delete", admin, map[string][]string{"message_ids": {id}})
+	decode(t, w, http.StatusLocked, nil)
+	if got := history(t, api, doctor).Messages; len(got) != 1 || got[0].Content != msg.Content {
+		t.Errorf("held message changed: %+v", got)
+	}
+
+	release := map[string]string{"reason": "case closed"}
+	path := "/admin/chat/legal-holds/" + hold.ID + "/release"
+	var released models.LegalHold
+	decode(t, call(t, api.ReleaseLegalHold, http.MethodPut, path, admin, release, "id", hold.ID), http.StatusOK, &released)
+	if released.Active() || released.ReleasedBy != admin.UserID || released.ReleaseReason != "case closed" {
+		t.Errorf("released hold = %+v", released)
+	}
+	decode(t, call(t, api.ReleaseLegalHold, http.MethodPut, path, admin, release, "id", hold.ID), http.StatusConflict, nil)
+
+	var holds []models.LegalHold
+	decode(t, call(t, api.ListLegalHolds, http.MethodGet, "/admin/chat/legal-holds?location_id="+testLocation, admin, nil), http.StatusOK, &holds)
+	if len(holds) != 1 || holds[0].ID != hold.ID {
+		t.Errorf("holds = %+v", holds)
+	}
+
+	// Placing and releasing are audited with the hold, once each
+	var entries []models.AuditEntry
+	decode(t, call(t, api.ListAuditEntries, http.MethodGet, "/admin/chat/audit?target_type=legal_hold&location_id="+testLocation, admin, nil), http.StatusOK, &entries)
+	if len(entries) != 2 || entries[0].Action != auditHoldRelease || entries[1].Action != auditHoldPlace ||
+		entries[0].TargetID != hold.ID || entries[1].ActorID != admin.UserID {
+		t.Errorf("legal hold audit entries = %+v", entries)
+	}
+
+	decode(t, call(t, api.DeleteChatMessage, http.MethodDelete, "/chat/message/"+id, doctor, nil, "id", id), http.StatusOK, nil)
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"internal_chat_system/middleware/auth"
+	"internal_chat_system/models"
+	"internal_chat_system/officehours"
+	"internal_chat_system/outbox"
+	"internal_chat_system/presence"
+	"internal_chat_system/redis"
+	"internal_chat_system/repository"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// autoReplyFallbackTTL limits auto-replies to one per session per day when a
+// schedule has no opening in sight.
+const autoReplyFallbackTTL = 24 * time.Hour
+
+// sendOfficeHoursAutoReply answers a patient's message with the doctor's
+// out-of-hours reply. Each session gets at most one reply per closed period.
+// A reply that can't be saved gives its claim back and returns the error, so
+// the outbox relay retries it.
+func (a *API) sendOfficeHoursAutoReply(ctx context.Context, msg models.Message) error {
+	// Only patient → doctor messages get auto-replies
+	if msg.ReceiverUserID == "" || msg.IsSystem {
+		return nil
+	}
+
+	schedule, err := a.officeHours.GetEffectiveOfficeHours(ctx, msg.LocationID, msg.ReceiverUserID)
+	if err != nil {
+		return err
+	}
+	if schedule == nil || !schedule.AutoReplyEnabled || officehours.IsOpen(*schedule, msg.SentAt) {
+		return nil
+	}
+
+	ttl := autoReplyFallbackTTL
+	if next, ok := officehours.NextOpen(*schedule, msg.SentAt); ok {
+		ttl = time.Until(next)
+	}
+	if ttl <= 0 || !redis.ClaimAutoReply(ctx, msg.SessionID, ttl) {
+		return nil
+	}
+
+	reply := models.Message{
+		ID:                uuid.New().String(),
+		LocationID:        msg.LocationID,
+		SenderUserID:      msg.SenderUserID,
+		ReceiverContactID: msg.ReceiverContactID,
+		SessionID:         msg.SessionID,
+		Content:           officehours.AutoReply(*schedule),
+		MessageType:       "system",
+		IsSystem:          true,
+	}
+	if err := a.messages.SaveMessage(ctx, &reply); err != nil {
+		log.Printf("❌ Failed to save office hours auto-reply: %v", err)
+		redis.ReleaseAutoReply(ctx, msg.SessionID)
+		return err
+	}
+	log.Printf("🌙 Sent out-of-hours auto-reply in session %s", msg.SessionID)
+	outbox.Wake()
+	return nil
+}
+
+// applyOfficeHours marks a doctor's presence as outside office hours when
+// their schedule is closed, so patients know not to expect a quick answer.
+func (a *API) applyOfficeHours(ctx context.Context, locationID, userID string, status *models.PresenceStatus) {
+	schedule, err := a.officeHours.GetEffectiveOfficeHours(ctx, locationID, userID)
+	if err != nil || schedule == nil {
+		return
+	}
+	markOutsideOfficeHours(*schedule, time.Now(), status)
+}
+
+// applyOfficeHoursMany does the same for every doctor in statuses, with one
+// schedule lookup per location rather than one per doctor.
+func (a *API) applyOfficeHoursMany(ctx context.Context, statuses map[presence.Participant]models.PresenceStatus) {
+	users := make(map[string][]string)
+	for p := range statuses {
+		if p.Kind == presence.KindUser {
+			users[p.LocationID] = append(users[p.LocationID], p.ID)
+		}
+	}
+
+	now := time.Now()
+	for locationID, userIDs := range users {
+		schedules, err := a.officeHours.GetEffectiveOfficeHoursForUsers(ctx, locationID, userIDs)
+		if err != nil {
+			log.Printf("⚠️ Failed to apply office hours to presence: %v", err)
+			continue
+		}
+		for userID, schedule := range schedules {
+			p := presence.User(locationID, userID)
+			status := statuses[p]
+			markOutsideOfficeHours(*schedule, now, &status)
+			statuses[p] = status
+		}
+	}
+}
+
+func markOutsideOfficeHours(schedule models.OfficeHours, now time.Time, status *models.PresenceStatus) {
+	if officehours.IsOpen(schedule, now) {
+		return
+	}
+	status.OutsideOfficeHours = true
+	if next, ok := officehours.NextOpen(schedule, now); ok {
+		next = next.UTC()
+		status.NextAvailableAt = &next
+	}
+}
+
+// GET /chat/office-hours?location_id=...&user_id=...
+// Returns the schedule in effect for the user, falling back to the location's.
+func (a *API) GetOfficeHours(w http.ResponseWriter, r *http.Request) {
+	locationID := r.URL.Query().Get("location_id")
+	userID := r.URL.Query().Get("user_id")
+	if locationID == "" {
+		writeError(w, http.StatusBadRequest, "Missing location_id")
+		return
+	}
+
+	schedule, err := a.officeHours.GetEffectiveOfficeHours(r.Context(), locationID, userID)
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to fetch office hours")
+		return
+	}
+	if schedule == nil {
+		writeError(w, http.StatusNotFound, "No office hours configured")
+		return
+	}
+
+	now := time.Now()
+	resp := struct {
+		*models.OfficeHours
+		OpenNow  bool       `json:"open_now"`
+		NextOpen *time.Time `json:"next_open,omitempty"`
+	}{OfficeHours: schedule, OpenNow: officehours.IsOpen(*schedule, now)}
+	if next, ok := officehours.NextOpen(*schedule, now); ok && !resp.OpenNow {
+		resp.NextOpen = &next
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// PUT /chat/office-hours
+// Doctors manage their own schedule; admins manage any doctor's and the
+// location-wide default (empty user_id).
+func (a *API) UpsertOfficeHours(w http.ResponseWriter, r *http.Request) {
+	authCtx := auth.GetAuthContext(r)
+
+	var schedule models.OfficeHours
+	if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if !canManageOfficeHours(authCtx, schedule.UserID) {
+		writeError(w, http.StatusForbidden, "Unauthorized")
+		return
+	}
+	if schedule.LocationID == "" {
+		writeError(w, http.StatusBadRequest, "Missing location_id")
+		return
+	}
+	if schedule.Timezone == "" {
+		schedule.Timezone = "UTC"
+	}
+	if err := officehours.Validate(schedule); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := a.officeHours.UpsertOfficeHours(r.Context(), &schedule); err != nil {
+		writeError(w, errorStatus(err), "Failed to save office hours")
+		return
+	}
+	writeJSON(w, http.StatusOK, schedule)
+}
+
+// POST /chat/office-hours/holidays
+func (a *API) AddOfficeHoliday(w http.ResponseWriter, r *http.Request) {
+	authCtx := auth.GetAuthContext(r)
+
+	var holiday models.OfficeHoliday
+	if err := json.NewDecoder(r.Body).Decode(&holiday); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if !canManageOfficeHours(authCtx, holiday.UserID) {
+		writeError(w, http.StatusForbidden, "Unauthorized")
+		return
+	}
+	if holiday.LocationID == "" {
+		writeError(w, http.StatusBadRequest, "Missing location_id")
+		return
+	}
+	if _, err := time.Parse(time.DateOnly, holiday.Date); err != nil {
+		writeError(w, http.StatusBadRequest, "date must be formatted YYYY-MM-DD")
+		return
+	}
+
+	if err := a.officeHours.AddOfficeHoliday(r.Context(), &holiday); err != nil {
+		writeError(w, errorStatus(err), "Failed to save holiday")
+		return
+	}
+	writeJSON(w, http.StatusCreated, holiday)
+}
+
+// DELETE /chat/office-hours/holidays/{id}?location_id=...
+func (a *API) DeleteOfficeHoliday(w http.ResponseWriter, r *http.Request) {
+	authCtx := auth.GetAuthContext(r)
+	if authCtx.UserType != "ADMIN" && authCtx.UserType != "SUPERADMIN" && authCtx.UserType != "DOCTOR" {
+		writeError(w, http.StatusForbidden, "Unauthorized")
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	locationID := r.URL.Query().Get("location_id")
+	if _, err := uuid.Parse(id); err != nil || locationID == "" {
+		writeError(w, http.StatusBadRequest, "Invalid holiday ID or missing location_id")
+		return
+	}
+
+	// Doctors may only remove their own holidays, not a colleague's or the
+	// location's
+	holiday, err := a.officeHours.GetOfficeHoliday(r.Context(), id, locationID)
+	if errors.Is(err, repository.ErrHolidayNotFound) {
+		writeError(w, http.StatusNotFound, "Holiday not found")
+		return
+	}
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to fetch holiday")
+		return
+	}
+	if !canManageOfficeHours(authCtx, holiday.UserID) {
+		writeError(w, http.StatusForbidden, "Unauthorized")
+		return
+	}
+
+	if err := a.officeHours.DeleteOfficeHoliday(r.Context(), id, locationID); err != nil {
+		writeError(w, errorStatus(err), "Failed to delete holiday")
+		return
+	}
+	writeSuccess(w, http.StatusOK, "Holiday deleted")
+}
+
+func canManageOfficeHours(authCtx auth.AuthContext, userID string) bool {
+	switch authCtx.UserType {
+	case "ADMIN", "SUPERADMIN":
+		return true
+	case "DOCTOR":
+		return userID != "" && userID == authCtx.UserID
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"internal_chat_system/middleware/auth"
+	"internal_chat_system/models"
+	"internal_chat_system/repository"
+
+	"github.com/google/uuid"
+)
+
+const maxRetentionReports = 100
+
+func isAdmin(authCtx auth.AuthContext) bool {
+	return authCtx.UserType == "ADMIN" || authCtx.UserType == "SUPERADMIN"
+}
+
+// GET /admin/chat/retention?location_id=loc1
+func (a *API) GetRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(auth.GetAuthContext(r)) {
+		writeError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+	locationID := r.URL.Query().Get("location_id")
+	if locationID == "" {
+		writeError(w, http.StatusBadRequest, "Missing location_id")
+		return
+	}
+
+	policy, err := a.retention.GetRetentionPolicy(r.Context(), locationID)
+	if errors.Is(err, repository.ErrNoRetentionPolicy) {
+		writeError(w, http.StatusNotFound, "No retention policy; messages are kept forever")
+		return
+	}
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to fetch retention policy")
+		return
+	}
+	writeJSON(w, http.StatusOK, policy)
+}
+
+// PUT /admin/chat/retention
+// Sets a location's retention policy. Omitted ages keep messages forever.
+func (a *API) UpsertRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	authCtx := auth.GetAuthContext(r)
+	if !isAdmin(authCtx) {
+		writeError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	var policy models.RetentionPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON payload")
+		return
+	}
+	if _, err := uuid.Parse(policy.LocationID); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid location_id")
+		return
+	}
+	if policy.Action == "" {
+		policy.Action = models.RetentionDelete
+	}
+	if policy.Action != models.RetentionDelete && policy.Action != models.RetentionAnonymize {
+		writeError(w, http.StatusBadRequest, "action must be delete or anonymize")
+		return
+	}
+	if policy.MaxAgeDays != nil && *policy.MaxAgeDays <= 0 {
+		writeError(w, http.StatusBadRequest, "max_age_days must be positive")
+		return
+	}
+	if policy.DeletedMaxAgeDays != nil && *policy.DeletedMaxAgeDays < 0 {
+		writeError(w, http.StatusBadRequest, "deleted_max_age_days must not be negative")
+		return
+	}
+	policy.UpdatedBy = authCtx.UserID
+
+	ctx := a.auditWrites(r.Context(), authCtx, auditRetention, nil)
+	if err := a.retention.UpsertRetentionPolicy(ctx, &policy); err != nil {
+		writeError(w, errorStatus(err), "Failed to save retention policy")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, policy)
+}
+
+// GET /admin/chat/retention/reports?location_id=loc1&limit=20
+// Lists what the purge job removed in a location, newest run first.
+func (a *API) ListRetentionReports(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(auth.GetAuthContext(r)) {
+		writeError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+	locationID := r.URL.Query().Get("location_id")
+	if locationID == "" {
+		writeError(w, http.StatusBadRequest, "Missing location_id")
+		return
+	}
+	limit := 20
+	if val := r.URL.Query().Get("limit"); val != "" {
+		if l, err := strconv.Atoi(val); err == nil && l > 0 {
+			limit = min(l, maxRetentionReports)
+		}
+	}
+
+	reports, err := a.retention.ListRetentionReports(r.Context(), locationID, limit)
+	if err != nil {
+		writeError(w, errorStatus(err), "Failed to fetch retention reports")
+		return
+	}
+	writeJSON(w, http.StatusOK, reports)
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"internal_chat_system/models"
+	"internal_chat_system/retention"
+
+	"github.com/google/uuid"
+)
+
+func TestRetentionPolicy(t *testing.T) {
+	api, store := newTestAPI(t)
+	expired := send(t, api, "old news")
+
+	held := newMessage("keep this")
+	held.ReceiverContactID = uuid.NewString()
+	decode(t, call(t, api.SendMessage, http.MethodPost, "/chat/send", doctor, held), http.StatusCreated, nil)
+	heldSession, err := store.GetOrCreateSession(context.Background(), held.ReceiverContactID, testDoctor, testLocation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hold := models.LegalHold{Scope: models.HoldScopeSession, SessionID: heldSession, Reason: "audit"}
+	decode(t, call(t, api.PlaceLegalHold, http.MethodPost, "/admin/chat/legal-holds", admin, hold), http.StatusCreated, nil)
+
+	days := 30
+	policy := models.RetentionPolicy{LocationID: testLocation, MaxAgeDays: &days}
+	decode(t, call(t, api.UpsertRetentionPolicy, http.MethodPut, "/admin/chat/retention", doctor, policy), http.StatusForbidden, nil)
+	bad := policy
+	bad.Action = "archive"
+	decode(t, call(t, api.UpsertRetentionPolicy, http.MethodPut, "/admin/chat/retention", admin, bad), http.StatusBadRequest, nil)
+
+	var saved models.RetentionPolicy
+	decode(t, call(t, api.UpsertRetentionPolicy, http.MethodPut, "/admin/chat/retention", admin, policy), http.StatusOK, &saved)
+	if saved.Action != models.RetentionDelete || saved.UpdatedBy != admin.UserID {
+		t.Errorf("saved policy = %+v", saved)
+	}
+	decode(t, call(t, api.GetRetentionPolicy, http.MethodGet, "/admin/chat/retention?location_id="+testLocation, admin, nil), http.StatusOK, &saved)
+
+	// Nothing is old enough yet
+	purger := &retention.Purger{Repo: store}
+	purger.RunOnce(context.Background(), time.Now())
+	if n := len(history(t, api, doctor).Messages); n != 1 {
+		t.Fatalf("history has %d messages before they expire, want 1", n)
+	}
+
+	purger.RunOnce(context.Background(), time.Now().AddDate(0, 0, days+1))
+	for _, m := range history(t, api, doctor).Messages {
+		if m.ID == expired.ID {
+			t.Errorf("expired message %s survived the purge", m.ID)
+		}
+	}
+
+	var reports []models.RetentionReport
+	decode(t, call(t, api.ListRetentionReports, http.MethodGet, "/admin/chat/retention/reports?location_id="+testLocation, admin, nil), http.StatusOK, &reports)
+	if len(reports) != 2 || reports[0].MessagesDeleted != 1 {
+		t.Errorf("reports = %+v", reports)
+	}
+
+	// The held conversation is untouched
+	var page models.HistoryPage
+	target := "/chat/history?location_id=" + testLocation + "&user_id=" + testDoctor + "&contact_id=" + held.ReceiverContactID
+	decode(t, call(t, api.GetMessageHistory, http.MethodGet, target, doctor, nil), http.StatusOK, &page)
+	if len(page.Messages) != 1 || page.Messages[0].Content != "keep this" {
+		t.Errorf("held conversation after purge = %+v", page.Messages)
+	}
+}
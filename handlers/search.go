@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"internal_chat_system/middleware/auth"
+	"internal_chat_system/models"
+
+	"github.com/google/uuid"
+)
+
+// GET /chat/search?q=...&location_id=...
+// Ranked full-text search within one conversation. Doctors pass contact_id,
+// patients pass user_id. Optional filters: session_id, sender_id, from, to
+// (RFC 3339), has_attachment, pinned, lang, limit and offset.
+func (a *API) SearchMessages(w http.ResponseWriter, r *http.Request) {
+	authCtx := auth.GetAuthContext(r)
+	q := r.URL.Query()
+
+	filter, err := parseSearchFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	switch authCtx.UserType {
+	case "DOCTOR":
+		filter.UserID, filter.ContactID = authCtx.UserID, q.Get("contact_id")
+	case "PATIENT":
+		filter.UserID, filter.ContactID = q.Get("user_id"), authCtx.UserID
+	default:
+		writeError(w, http.StatusForbidden, "Unauthorized")
+		return
+	}
+	if filter.UserID == "" || filter.ContactID == "" {
+		writeError(w, http.StatusBadRequest, "Missing conversation: contact_id for doctors, user_id for patients")
+		return
+	}
+
+	results, err := a.messages.SearchMessages(r.Context(), filter)
+	if err != nil {
+		log.Printf("❌ Failed to search messages: %v", err)
+		writeError(w, errorStatus(err), "Search failed")
+		return
+	}
+	if err := a.auditSearch(r, filter, len(results)); err != nil {
+		writeError(w, errorStatus(err), "Search failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, results)
+}
+
+// GET /chat/search/all?q=...&location_id=...
+// Searches every conversation the caller is part of in the location and
+// groups the matches by session. Accepts the same filters as /chat/search.
+func (a *API) SearchAllConversations(w http.ResponseWriter, r *http.Request) {
+	authCtx := auth.GetAuthContext(r)
+
+	filter, err := parseSearchFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	switch authCtx.UserType {
+	case "DOCTOR":
+		filter.UserID = authCtx.UserID
+	case "PATIENT":
+		filter.ContactID = authCtx.UserID
+	default:
+		writeError(w, http.StatusForbidden, "Unauthorized")
+		return
+	}
+
+	results, err := a.messages.SearchMessages(r.Context(), filter)
+	if err != nil {
+		log.Printf("❌ Failed to search conversations of %s: %v", authCtx.UserID, err)
+		writeError(w, errorStatus(err), "Search failed")
+		return
+	}
+	if err := a.auditSearch(r, filter, len(results)); err != nil {
+		writeError(w, errorStatus(err), "Search failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, groupBySession(results))
+}
+
+// GET /admin/chat/search?q=...&location_id=...
+// Searches every conversation in the location. Each search is written to
+// the audit log before any results are returned.
+func (a *API) AdminSearchMessages(w http.ResponseWriter, r *http.Request) {
+	authCtx := auth.GetAuthContext(r)
+	if authCtx.UserType != "ADMIN" && authCtx.UserType != "SUPERADMIN" {
+		writeError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	filter, err := parseSearchFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q := r.URL.Query()
+	filter.UserID, filter.ContactID = q.Get("user_id"), q.Get("contact_id")
+
+	results, err := a.messages.SearchMessages(r.Context(), filter)
+	if err != nil {
+		log.Printf("❌ Admin search failed in location %s: %v", filter.LocationID, err)
+		writeError(w, errorStatus(err), "Search failed")
+		return
+	}
+
+	if err := a.auditSearch(r, filter, len(results)); err != nil {
+		// Never hand out results we could not account for
+		writeError(w, errorStatus(err), "Search failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, groupBySession(results))
+}
+
+// auditSearch records a search with its filters and how many messages it
+// found.
+func (a *API) auditSearch(r *http.Request, f models.SearchFilter, results int) error {
+	return a.recordAudit(r.Context(), auth.GetAuthContext(r), models.AuditEntry{
+		LocationID: f.LocationID,
+		Action:     auditSearch,
+		Details: auditDetails(map[string]any{
+			"query":   f.Query,
+			"filters": r.URL.Query(),
+			"results": results,
+		}),
+	})
+}
+
+// groupBySession buckets ranked results by session, keeping rank order
+// both across groups and within each group. Each group names the session's
+// doctor and patient, whichever of them sent its best match.
+func groupBySession(results []models.SearchResult) []models.SessionSearchResults {
+	groups := []models.SessionSearchResults{}
+	index := make(map[uuid.UUID]int)
+	for _, res := range results {
+		i, ok := index[res.Message.SessionID]
+		if !ok {
+			i = len(groups)
+			index[res.Message.SessionID] = i
+			groups = append(groups, models.SessionSearchResults{
+				SessionID: res.Message.SessionID.String(),
+				UserID:    res.SessionUserID,
+				ContactID: res.SessionContactID,
+				TopRank:   res.Rank,
+			})
+		}
+		groups[i].Results = append(groups[i].Results, res)
+	}
+	return groups
+}
+
+// parseSearchFilter reads the query text, location and optional filters
+// shared by every search endpoint. Scope (who may see what) is left to the caller.
+func parseSearchFilter(r *http.Request) (models.SearchFilter, error) {
+	q := r.URL.Query()
+	filter := models.SearchFilter{
+		Query:      strings.TrimSpace(q.Get("q")),
+		LocationID: q.Get("location_id"),
+		Language:   q.Get("lang"),
+		SessionID:  q.Get("session_id"),
+		SenderID:   q.Get("sender_id"),
+		Limit:      20,
+	}
+	if filter.Query == "" || filter.LocationID == "" {
+		return filter, errors.New("Missing required parameters: q, location_id")
+	}
+	if filter.Language != "" && !isTextSearchConfig(filter.Language) {
+		return filter, errors.New("Invalid lang")
+	}
+
+	for name, dst := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, fmt.Errorf("Invalid %s: use RFC 3339", name)
+			}
+			*dst = &t
+		}
+	}
+	for name, dst := range map[string]**bool{"has_attachment": &filter.HasAttachment, "pinned": &filter.Pinned} {
+		if v := q.Get(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return filter, fmt.Errorf("Invalid %s: use true or false", name)
+			}
+			*dst = &b
+		}
+	}
+
+	if parsedLimit, err := strconv.Atoi(q.Get("limit")); err == nil && parsedLimit > 0 {
+		filter.Limit = parsedLimit
+	}
+	if parsedOffset, err := strconv.Atoi(q.Get("offset")); err == nil && parsedOffset >= 0 {
+		filter.Offset = parsedOffset
+	}
+	return filter, nil
+}
+
+// isTextSearchConfig accepts plain configuration names like "english" or
+// "simple"; anything else would be an invalid regconfig anyway.
+func isTextSearchConfig(name string) bool {
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && c != '_' {
+			return false
+		}
+	}
+	return len(name) <= 63
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"internal_chat_system/middleware/auth"
+	"internal_chat_system/models"
+
+	"github.com/google/uuid"
+)
+
+func TestSearchMessages(t *testing.T) {
+	api, _ := newTestAPI(t)
+	send(t, api, "your blood test results are in")
+	send(t, api, "please book a follow-up")
+
+	// Another patient of the same doctor
+	other := newMessage("blood pressure looks fine")
+	other.ReceiverContactID = uuid.NewString()
+	decode(t, call(t, api.SendMessage, http.MethodPost, "/chat/send", doctor, other), http.StatusCreated, nil)
+
+	search := func(caller auth.AuthContext, q url.Values) []models.SearchResult {
+		t.Helper()
+		var results []models.SearchResult
+		decode(t, call(t, api.SearchMessages, http.MethodGet, "/chat/search?"+q.Encode(), caller, nil), http.StatusOK, &results)
+		return results
+	}
+
+	results := search(patient, url.Values{"q": {"blood"}, "location_id": {testLocation}, "user_id": {testDoctor}})
+	if len(results) != 1 || results[0].Message.Content != "your blood test results are in" {
+		t.Errorf("patient search = %+v", results)
+	}
+	if results := search(doctor, url.Values{"q": {"blood"}, "location_id": {testLocation}, "contact_id": {testPatient}}); len(results) != 1 {
+		t.Errorf("doctor search found %d messages, want 1", len(results))
+	}
+
+	var groups []models.SessionSearchResults
+	q := url.Values{"q": {"blood"}, "location_id": {testLocation}}
+	decode(t, call(t, api.SearchAllConversations, http.MethodGet, "/chat/search/all?"+q.Encode(), doctor, nil), http.StatusOK, &groups)
+	if len(groups) != 2 {
+		t.Errorf("search across conversations found %d sessions, want 2", len(groups))
+	}
+
+	decode(t, call(t, api.SearchMessages, http.MethodGet, "/chat/search?location_id="+testLocation, doctor, nil), http.StatusBadRequest, nil)
+	decode(t, call(t, api.AdminSearchMessages, http.MethodGet, "/admin/chat/search?"+q.Encode(), doctor, nil), http.StatusForbidden, nil)
+
+	// Admin searches are audited before results go out
+	decode(t, call(t, api.AdminSearchMessages, http.MethodGet, "/admin/chat/search?"+q.Encode(), admin, nil), http.StatusOK, &groups)
+	var entries []models.AuditEntry
+	decode(t, call(t, api.ListAuditEntries, http.MethodGet, "/admin/chat/audit?action=message.search&location_id="+testLocation, admin, nil), http.StatusOK, &entries)
+	found := false
+	for _, e := range entries {
+		found = found || e.ActorID == admin.UserID
+	}
+	if !found {
+		t.Errorf("admin search not in audit log: %+v", entries)
+	}
+}
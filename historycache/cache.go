@@ -0,0 +1,377 @@
+// Package historycache keeps the newest page of hot conversations in Redis.
+// Store wraps the message, reaction and pin stores: it answers newest-page
+// history requests from Redis and refreshes or invalidates the cached page
+// on every write that can change it.
+//
+// Each session has a generation counter that writers bump after their change
+// commits. A cached page records the generation it was read at and only
+// counts as a hit while the counter still matches, so a page filled
+// concurrently with a write is never served after it.
+//
+// With encryption at rest on, pages are sealed with their location's data
+// key before they reach Redis and opened on the way out, so Redis never
+// holds message content in the clear.
+package historycache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"internal_chat_system/models"
+	"internal_chat_system/repository"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// defaultPageSize matches the stores' default history page.
+	defaultPageSize = 50
+
+	// sessionAliasTTL bounds how long a conversation's session id is
+	// remembered. The mapping never changes, so it only needs to expire to
+	// free memory.
+	sessionAliasTTL = 24 * time.Hour
+)
+
+// opTimeout bounds each Redis round trip. A slow Redis turns into a miss
+// rather than a slow page.
+var opTimeout = 500 * time.Millisecond
+
+// Config controls caching for a location.
+type Config struct {
+	Enabled bool
+	Size    int           // messages kept per session; larger pages bypass the cache
+	TTL     time.Duration // how long an unread page stays cached
+}
+
+var DefaultConfig = Config{Enabled: true, Size: defaultPageSize, TTL: 10 * time.Minute}
+
+// Store is a write-through history cache in front of the message stores.
+// Methods it doesn't override go straight to the wrapped stores.
+type Store struct {
+	repository.MessageStore
+	repository.ReactionStore
+	repository.PinStore
+
+	rdb       *redis.Client
+	defaults  Config
+	locations map[string]Config
+}
+
+// New wraps the stores with a cache in rdb. locations overrides defaults
+// for individual locations, e.g. to disable caching or keep more messages.
+func New(rdb *redis.Client, messages repository.MessageStore, reactions repository.ReactionStore, pins repository.PinStore, defaults Config, locations map[string]Config) *Store {
+	return &Store{
+		MessageStore:  messages,
+		ReactionStore: reactions,
+		PinStore:      pins,
+		rdb:           rdb,
+		defaults:      defaults,
+		locations:     locations,
+	}
+}
+
+var (
+	_ repository.MessageStore  = (*Store)(nil)
+	_ repository.ReactionStore = (*Store)(nil)
+	_ repository.PinStore      = (*Store)(nil)
+)
+
+func (s *Store) config(locationID string) Config {
+	if c, ok := s.locations[locationID]; ok {
+		return c
+	}
+	return s.defaults
+}
+
+// sessionKey maps a conversation to its session id.
+func sessionKey(locationID, userID, contactID string) string {
+	return fmt.Sprintf("history:session:%s:%s:%s", locationID, userID, contactID)
+}
+
+// genKey holds the session's generation counter.
+func genKey(sessionID string) string {
+	return "history:gen:" + sessionID
+}
+
+// pageKey holds the session's cached newest page.
+func pageKey(sessionID string) string {
+	return "history:page:" + sessionID
+}
+
+type cachedPage struct {
+	Gen  int64              `json:"gen"`
+	Page models.HistoryPage `json:"page"`
+}
+
+// GetConversation serves newest-page requests that fit in the location's
+// cache size from Redis. Cursor pages, and every request when Redis fails,
+// go to the wrapped store.
+func (s *Store) GetConversation(ctx context.Context, locationID, contactID, userID string, page models.PageRequest) (models.HistoryPage, error) {
+	cfg := s.config(locationID)
+	limit := page.Limit
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	newest := page.Before == "" && page.After == "" && page.BeforeSeq == 0 && page.AfterSeq == 0
+	if !cfg.Enabled || !newest || limit > cfg.Size {
+		return s.MessageStore.GetConversation(ctx, locationID, contactID, userID, page)
+	}
+
+	sessionID, gen, cached, err := s.lookup(ctx, locationID, userID, contactID)
+	if err != nil {
+		log.Printf("⚠️ History cache lookup failed: %v", err)
+		recordError(locationID)
+		return s.MessageStore.GetConversation(ctx, locationID, contactID, userID, page)
+	}
+	if cached != nil {
+		recordHit(locationID)
+		return trim(*cached, limit), nil
+	}
+	recordMiss(locationID)
+
+	// Fill from the primary: a lagging replica could hide a write that
+	// already bumped the generation this page will be stored under
+	full, err := s.MessageStore.GetConversation(repository.WithPrimary(ctx), locationID, contactID, userID,
+		models.PageRequest{Limit: cfg.Size})
+	if err != nil {
+		return models.HistoryPage{}, err
+	}
+
+	switch {
+	case sessionID != "":
+		s.store(ctx, locationID, sessionID, gen, full, cfg.TTL)
+	case len(full.Messages) > 0:
+		// First read of this conversation: remember its session so the next
+		// read can check the generation before trusting a cached page
+		s.remember(ctx, locationID, userID, contactID, full.Messages[0].SessionID.String())
+	}
+	return trim(full, limit), nil
+}
+
+// lookup resolves the conversation's session and, if a page is cached at
+// the current generation, returns it.
+func (s *Store) lookup(ctx context.Context, locationID, userID, contactID string) (string, int64, *models.HistoryPage, error) {
+	ctx, cancel := context.WithTimeout(ctx, opTimeout)
+	defer cancel()
+
+	sessionID, err := s.rdb.Get(ctx, sessionKey(locationID, userID, contactID)).Result()
+	if err == redis.Nil {
+		return "", 0, nil, nil
+	}
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	vals, err := s.rdb.MGet(ctx, genKey(sessionID), pageKey(sessionID)).Result()
+	if err != nil {
+		return "", 0, nil, err
+	}
+	var gen int64
+	if v, ok := vals[0].(string); ok {
+		fmt.Sscan(v, &gen)
+	}
+	raw, ok := vals[1].(string)
+	if !ok {
+		return sessionID, gen, nil, nil
+	}
+	data, err := repository.OpenCached(ctx, pageKey(sessionID), raw)
+	if err != nil {
+		log.Printf("⚠️ History cache failed to open page of session %s: %v", sessionID, err)
+		return sessionID, gen, nil, nil
+	}
+	var c cachedPage
+	if err := json.Unmarshal([]byte(data), &c); err != nil || c.Gen != gen {
+		return sessionID, gen, nil, nil
+	}
+	return sessionID, gen, &c.Page, nil
+}
+
+func (s *Store) remember(ctx context.Context, locationID, userID, contactID, sessionID string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), opTimeout)
+	defer cancel()
+
+	if err := s.rdb.Set(ctx, sessionKey(locationID, userID, contactID), sessionID, sessionAliasTTL).Err(); err != nil {
+		log.Printf("⚠️ History cache failed to remember session %s: %v", sessionID, err)
+	}
+}
+
+// store caches page as of gen, sealed with the location's key and bound to
+// the session. The generation key outlives the page, so a page can never
+// expire after its counter and match a restarted count.
+func (s *Store) store(ctx context.Context, locationID, sessionID string, gen int64, page models.HistoryPage, ttl time.Duration) {
+	raw, err := json.Marshal(cachedPage{Gen: gen, Page: page})
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), opTimeout)
+	defer cancel()
+
+	data, err := repository.SealCached(ctx, locationID, pageKey(sessionID), string(raw))
+	if err != nil {
+		log.Printf("⚠️ History cache failed to seal page of session %s: %v", sessionID, err)
+		return
+	}
+
+	_, err = s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, pageKey(sessionID), data, ttl)
+		pipe.Expire(ctx, genKey(sessionID), 2*ttl)
+		return nil
+	})
+	if err != nil {
+		log.Printf("⚠️ History cache failed to store session %s: %v", sessionID, err)
+	}
+}
+
+// bump moves the session to a new generation, making its cached page stale,
+// and returns the new generation. It runs after the write has committed.
+func (s *Store) bump(ctx context.Context, locationID, sessionID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), opTimeout)
+	defer cancel()
+
+	var gen *redis.IntCmd
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		gen = pipe.Incr(ctx, genKey(sessionID))
+		pipe.Expire(ctx, genKey(sessionID), 2*s.config(locationID).TTL)
+		return nil
+	})
+	if err != nil {
+		log.Printf("❌ History cache failed to invalidate session %s: %v", sessionID, err)
+		recordError(locationID)
+		return 0, err
+	}
+	recordInvalidation(locationID)
+	return gen.Val(), nil
+}
+
+// invalidate bumps the session of every event. Zero events (no-op changes)
+// are skipped.
+func (s *Store) invalidate(ctx context.Context, events ...models.SessionEvent) {
+	seen := make(map[string]bool)
+	for _, e := range events {
+		if e.SessionID == "" || seen[e.SessionID] {
+			continue
+		}
+		seen[e.SessionID] = true
+		s.bump(ctx, e.LocationID, e.SessionID)
+	}
+}
+
+// trim cuts a newest page down to its newest limit messages.
+func trim(page models.HistoryPage, limit int) models.HistoryPage {
+	if len(page.Messages) <= limit {
+		return page
+	}
+	page.Messages = page.Messages[len(page.Messages)-limit:]
+	page.HasOlder = true
+	first := page.Messages[0]
+	page.PrevCursor = repository.EncodeMessageCursor(first.SentAt, first.ID)
+	return page
+}
+
+// SaveMessage writes through: a session with a cached page gets it
+// refreshed with the new message, so the next read is still a hit.
+func (s *Store) SaveMessage(ctx context.Context, msg *models.Message) error {
+	if err := s.MessageStore.SaveMessage(ctx, msg); err != nil {
+		return err
+	}
+	if msg.SessionID == "" {
+		return nil
+	}
+	gen, err := s.bump(ctx, msg.LocationID, msg.SessionID)
+	if err != nil {
+		return nil
+	}
+
+	cfg := s.config(msg.LocationID)
+	if !cfg.Enabled {
+		return nil
+	}
+	rctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), opTimeout)
+	hot, err := s.rdb.Exists(rctx, pageKey(msg.SessionID)).Result()
+	cancel()
+	if err != nil || hot == 0 {
+		return nil
+	}
+
+	// Every message names the doctor as sender_user_id and the patient as
+	// receiver_contact_id, whichever way it was sent
+	userID, contactID := msg.SenderUserID, msg.ReceiverContactID
+	page, err := s.MessageStore.GetConversation(repository.WithPrimary(ctx), msg.LocationID, contactID, userID,
+		models.PageRequest{Limit: cfg.Size})
+	if err != nil {
+		log.Printf("⚠️ History cache failed to refresh session %s: %v", msg.SessionID, err)
+		return nil
+	}
+	s.store(ctx, msg.LocationID, msg.SessionID, gen, page, cfg.TTL)
+	return nil
+}
+
+func (s *Store) UpdateMessageContent(ctx context.Context, msgID, editorID, newContent string) (time.Time, models.SessionEvent, error) {
+	editedAt, event, err := s.MessageStore.UpdateMessageContent(ctx, msgID, editorID, newContent)
+	if err == nil {
+		s.invalidate(ctx, event)
+	}
+	return editedAt, event, err
+}
+
+func (s *Store) DeleteMessage(ctx context.Context, id uuid.UUID) (models.SessionEvent, error) {
+	event, err := s.MessageStore.DeleteMessage(ctx, id)
+	if err == nil {
+		s.invalidate(ctx, event)
+	}
+	return event, err
+}
+
+func (s *Store) AdminDeleteMessages(ctx context.Context, ids []uuid.UUID) ([]models.SessionEvent, error) {
+	events, err := s.MessageStore.AdminDeleteMessages(ctx, ids)
+	if err == nil {
+		s.invalidate(ctx, events...)
+	}
+	return events, err
+}
+
+// MarkMessagesRead invalidates too: cached pages carry is_read and read_at.
+func (s *Store) MarkMessagesRead(ctx context.Context, ids []string) ([]models.SessionEvent, error) {
+	events, err := s.MessageStore.MarkMessagesRead(ctx, ids)
+	if err == nil {
+		s.invalidate(ctx, events...)
+	}
+	return events, err
+}
+
+func (s *Store) AddReaction(ctx context.Context, msgID, userID, emoji string) (models.SessionEvent, error) {
+	event, err := s.ReactionStore.AddReaction(ctx, msgID, userID, emoji)
+	if err == nil {
+		s.invalidate(ctx, event)
+	}
+	return event, err
+}
+
+func (s *Store) RemoveReaction(ctx context.Context, msgID, userID, emoji string) (models.SessionEvent, error) {
+	event, err := s.ReactionStore.RemoveReaction(ctx, msgID, userID, emoji)
+	if err == nil {
+		s.invalidate(ctx, event)
+	}
+	return event, err
+}
+
+func (s *Store) TogglePinMessage(ctx context.Context, msgID string, pin bool) (models.SessionEvent, error) {
+	event, err := s.PinStore.TogglePinMessage(ctx, msgID, pin)
+	if err == nil {
+		s.invalidate(ctx, event)
+	}
+	return event, err
+}
+
+// InvalidateSessions makes the cached pages of sessions stale, for writes
+// that bypass the Store such as retention purges.
+func (s *Store) InvalidateSessions(ctx context.Context, locationID string, sessionIDs []string) {
+	for _, id := range sessionIDs {
+		s.bump(ctx, locationID, id)
+	}
+}
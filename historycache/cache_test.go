@@ -0,0 +1,65 @@
+package historycache
+
+import (
+	"testing"
+	"time"
+
+	"internal_chat_system/models"
+	"internal_chat_system/repository"
+
+	"github.com/google/uuid"
+)
+
+func TestTrim(t *testing.T) {
+	start := time.Date(2025, 1, 6, 9, 0, 0, 0, time.UTC)
+	page := models.HistoryPage{}
+	for i := range 5 {
+		page.Messages = append(page.Messages, models.DBMessage{ID: uuid.New(), SentAt: start.Add(time.Duration(i) * time.Minute)})
+	}
+
+	tests := []struct {
+		name      string
+		limit     int
+		wantLen   int
+		wantOlder bool
+	}{
+		{"fits", 5, 5, false},
+		{"larger limit", 10, 5, false},
+		{"cut to the newest", 2, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := trim(page, tt.limit)
+			if len(got.Messages) != tt.wantLen || got.HasOlder != tt.wantOlder {
+				t.Fatalf("trim = %d messages, has older %v", len(got.Messages), got.HasOlder)
+			}
+			last := got.Messages[len(got.Messages)-1]
+			if last.ID != page.Messages[4].ID {
+				t.Error("trim dropped the newest message")
+			}
+			if tt.wantOlder {
+				first := got.Messages[0]
+				if got.PrevCursor != repository.EncodeMessageCursor(first.SentAt, first.ID) {
+					t.Error("prev cursor doesn't point at the new first message")
+				}
+			}
+		})
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	location := uuid.NewString()
+	recordHit(location)
+	recordHit(location)
+	recordHit(location)
+	recordMiss(location)
+	recordInvalidation(location)
+
+	got := Snapshot().Locations[location]
+	if got.Hits != 3 || got.Misses != 1 || got.Invalidations != 1 || got.HitRatio != 0.75 {
+		t.Errorf("location stats = %+v", got)
+	}
+	if empty := hitRatio(LocationStats{Errors: 2}); empty != 0 {
+		t.Errorf("hit ratio without lookups = %v, want 0", empty)
+	}
+}
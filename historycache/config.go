@@ -0,0 +1,81 @@
+package historycache
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// fileConfig is a Config as written in a config file. Missing fields keep
+// the value they override.
+type fileConfig struct {
+	Enabled *bool   `json:"enabled"`
+	Size    *int    `json:"size"`
+	TTL     *string `json:"ttl"` // a time.ParseDuration string, e.g. "10m"
+}
+
+func (f fileConfig) apply(c Config) (Config, error) {
+	if f.Enabled != nil {
+		c.Enabled = *f.Enabled
+	}
+	if f.Size != nil {
+		if *f.Size <= 0 {
+			return c, fmt.Errorf("size must be positive, got %d", *f.Size)
+		}
+		c.Size = *f.Size
+	}
+	if f.TTL != nil {
+		ttl, err := time.ParseDuration(*f.TTL)
+		if err != nil {
+			return c, err
+		}
+		if ttl <= 0 {
+			return c, fmt.Errorf("ttl must be positive, got %s", ttl)
+		}
+		c.TTL = ttl
+	}
+	return c, nil
+}
+
+// LoadConfig reads the cache defaults and per-location overrides from a
+// JSON file:
+//
+//	{
+//	  "default": {"enabled": true, "size": 50, "ttl": "10m"},
+//	  "locations": {"<location_id>": {"enabled": false}}
+//	}
+//
+// Anything left out falls back to DefaultConfig, and each location to the
+// file's defaults. An empty path returns DefaultConfig and no overrides.
+func LoadConfig(path string) (Config, map[string]Config, error) {
+	locations := map[string]Config{}
+	if path == "" {
+		return DefaultConfig, locations, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return DefaultConfig, nil, err
+	}
+	var file struct {
+		Default   fileConfig            `json:"default"`
+		Locations map[string]fileConfig `json:"locations"`
+	}
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return DefaultConfig, nil, fmt.Errorf("history cache config %s: %w", path, err)
+	}
+
+	defaults, err := file.Default.apply(DefaultConfig)
+	if err != nil {
+		return DefaultConfig, nil, fmt.Errorf("history cache config %s: default: %w", path, err)
+	}
+	for id, f := range file.Locations {
+		c, err := f.apply(defaults)
+		if err != nil {
+			return DefaultConfig, nil, fmt.Errorf("history cache config %s: location %s: %w", path, id, err)
+		}
+		locations[id] = c
+	}
+	return defaults, locations, nil
+}
@@ -0,0 +1,74 @@
+package historycache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "history-cache.json")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	defaults, locations, err := LoadConfig(writeConfig(t, `{
+		"default": {"size": 20, "ttl": "5m"},
+		"locations": {
+			"quiet": {"enabled": false},
+			"busy": {"size": 100}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Config{Enabled: true, Size: 20, TTL: 5 * time.Minute}
+	if defaults != want {
+		t.Errorf("defaults = %+v, want %+v", defaults, want)
+	}
+	tests := []struct {
+		location string
+		want     Config
+	}{
+		{"quiet", Config{Enabled: false, Size: 20, TTL: 5 * time.Minute}},
+		{"busy", Config{Enabled: true, Size: 100, TTL: 5 * time.Minute}},
+	}
+	for _, tt := range tests {
+		if got := locations[tt.location]; got != tt.want {
+			t.Errorf("location %s = %+v, want %+v", tt.location, got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	defaults, locations, err := LoadConfig("")
+	if err != nil || defaults != DefaultConfig || len(locations) != 0 {
+		t.Errorf("LoadConfig(\"\") = %+v, %v, %v", defaults, locations, err)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name, body, wantErr string
+	}{
+		{"not json", `{`, "history cache config"},
+		{"zero size", `{"default": {"size": 0}}`, "size must be positive"},
+		{"bad ttl", `{"default": {"ttl": "soon"}}`, "invalid duration"},
+		{"negative ttl", `{"locations": {"x": {"ttl": "-1m"}}}`, "location x: ttl must be positive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := LoadConfig(writeConfig(t, tt.body))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadConfig error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
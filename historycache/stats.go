@@ -0,0 +1,81 @@
+package historycache
+
+import (
+	"sync"
+	"time"
+)
+
+// LocationStats counts cache outcomes for one location since startup.
+type LocationStats struct {
+	Hits          int64   `json:"hits"`
+	Misses        int64   `json:"misses"`
+	Errors        int64   `json:"errors"`        // Redis failures; the request fell back to the database
+	Invalidations int64   `json:"invalidations"` // writes that made a cached page stale
+	HitRatio      float64 `json:"hit_ratio"`
+}
+
+// Stats is what GET /admin/chat/cache/stats reports for this instance.
+type Stats struct {
+	Since     time.Time                `json:"since"`
+	Total     LocationStats            `json:"total"`
+	Locations map[string]LocationStats `json:"locations"`
+}
+
+var stats = struct {
+	sync.Mutex
+	since     time.Time
+	locations map[string]*LocationStats
+}{since: time.Now(), locations: make(map[string]*LocationStats)}
+
+func count(locationID string, inc func(*LocationStats)) {
+	stats.Lock()
+	defer stats.Unlock()
+	s := stats.locations[locationID]
+	if s == nil {
+		s = &LocationStats{}
+		stats.locations[locationID] = s
+	}
+	inc(s)
+}
+
+func recordHit(locationID string) {
+	count(locationID, func(s *LocationStats) { s.Hits++ })
+}
+
+func recordMiss(locationID string) {
+	count(locationID, func(s *LocationStats) { s.Misses++ })
+}
+
+func recordError(locationID string) {
+	count(locationID, func(s *LocationStats) { s.Errors++ })
+}
+
+func recordInvalidation(locationID string) {
+	count(locationID, func(s *LocationStats) { s.Invalidations++ })
+}
+
+// Snapshot returns the counters of this instance, per location and in total.
+func Snapshot() Stats {
+	stats.Lock()
+	defer stats.Unlock()
+
+	out := Stats{Since: stats.since, Locations: make(map[string]LocationStats, len(stats.locations))}
+	for id, s := range stats.locations {
+		l := *s
+		l.HitRatio = hitRatio(l)
+		out.Locations[id] = l
+		out.Total.Hits += l.Hits
+		out.Total.Misses += l.Misses
+		out.Total.Errors += l.Errors
+		out.Total.Invalidations += l.Invalidations
+	}
+	out.Total.HitRatio = hitRatio(out.Total)
+	return out
+}
+
+func hitRatio(s LocationStats) float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
@@ -2,14 +2,18 @@ package s3
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"mime/multipart"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
 var (
@@ -48,3 +52,80 @@ func UploadFile(file multipart.File, fileHeader *multipart.FileHeader, folder st
 	url := fmt.Sprintf("https://%s.s3.amazonaws.com/%s", bucket, key)
 	return url, nil
 }
+
+// Storage keeps private objects in the bucket, such as archived message
+// partitions. It satisfies repository.ObjectStorage.
+type Storage struct{}
+
+// PutObject streams body in a multipart upload, holding one part in memory
+// at a time.
+func (Storage) PutObject(ctx context.Context, key string, body io.Reader, contentType string) error {
+	uploader := s3manager.NewUploaderWithClient(s3Client)
+	_, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String(contentType),
+		ACL:         aws.String("private"),
+	})
+	if err != nil {
+		log.Printf("❌ S3 upload of %s failed: %v", key, err)
+	}
+	return err
+}
+
+func (Storage) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s3Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (Storage) DeleteObject(ctx context.Context, key string) error {
+	_, err := s3Client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		log.Printf("❌ S3 delete of %s failed: %v", key, err)
+	}
+	return err
+}
+
+// fileKey is the object key of a URL returned by UploadFile.
+func fileKey(fileURL string) (string, error) {
+	key, ok := strings.CutPrefix(fileURL, fmt.Sprintf("https://%s.s3.amazonaws.com/", bucket))
+	if !ok {
+		return "", fmt.Errorf("not a file in bucket %s: %s", bucket, fileURL)
+	}
+	return key, nil
+}
+
+// GetFile reads an attachment stored by UploadFile, given the URL
+// UploadFile returned.
+func (s Storage) GetFile(ctx context.Context, fileURL string) ([]byte, error) {
+	key, err := fileKey(fileURL)
+	if err != nil {
+		return nil, err
+	}
+	body, err := s.GetObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// DeleteFile removes an attachment stored by UploadFile, given the URL
+// UploadFile returned.
+func (s Storage) DeleteFile(ctx context.Context, fileURL string) error {
+	key, err := fileKey(fileURL)
+	if err != nil {
+		return err
+	}
+	return s.DeleteObject(ctx, key)
+}
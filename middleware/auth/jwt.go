@@ -3,6 +3,8 @@ package auth
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 
@@ -11,6 +13,9 @@ import (
 
 var jwtSecret = []byte("your-secret-key")
 
+// ErrMissingToken means the request carried no bearer token.
+var ErrMissingToken = errors.New("missing token")
+
 func JWTMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tokenStr := extractToken(r)
@@ -19,23 +24,78 @@ func JWTMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecret, nil
-		})
-		if err != nil || !token.Valid {
+		token, authCtx, err := parseToken(tokenStr)
+		if err != nil {
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
 			return
 		}
 
 		ctx := context.WithValue(r.Context(), "user", token.Claims)
+		ctx = context.WithValue(ctx, authContextKey, authCtx)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// OptionalJWTMiddleware verifies a token when the request carries one and
+// rejects it if invalid, but lets requests without a token through with no
+// auth context, as routes that predate JWT enforcement expect.
+func OptionalJWTMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if extractToken(r) == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		JWTMiddleware(next).ServeHTTP(w, r)
+	})
+}
+
+// Authenticate verifies the request's token and returns who it belongs to,
+// for handlers that can't rely on the middleware, e.g. WebSocket upgrades.
+func Authenticate(r *http.Request) (AuthContext, error) {
+	tokenStr := extractToken(r)
+	if tokenStr == "" {
+		return AuthContext{}, ErrMissingToken
+	}
+	_, authCtx, err := parseToken(tokenStr)
+	return authCtx, err
+}
+
+// parseToken verifies an HMAC-signed token and reads the caller from its
+// user_id (or sub) and user_type claims.
+func parseToken(tokenStr string) (*jwt.Token, AuthContext, error) {
+	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return jwtSecret, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, AuthContext{}, errors.New("invalid token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, AuthContext{}, errors.New("invalid token claims")
+	}
+	authCtx := AuthContext{}
+	authCtx.UserID, _ = claims["user_id"].(string)
+	if authCtx.UserID == "" {
+		authCtx.UserID, _ = claims["sub"].(string)
+	}
+	authCtx.UserType, _ = claims["user_type"].(string)
+	if authCtx.UserID == "" || authCtx.UserType == "" {
+		return nil, AuthContext{}, errors.New("token names no user")
+	}
+	return token, authCtx, nil
+}
+
+// extractToken reads the bearer token from the Authorization header, or
+// from the access_token query parameter for clients that can't set headers,
+// such as browser WebSockets.
 func extractToken(r *http.Request) string {
 	bearer := r.Header.Get("Authorization")
 	if bearer == "" {
-		return ""
+		return r.URL.Query().Get("access_token")
 	}
 	parts := strings.Split(bearer, " ")
 	if len(parts) != 2 || parts[0] != "Bearer" {
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS device_tokens;
+DROP TABLE IF EXISTS message_reactions;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS chat_sessions;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS contacts;
@@ -0,0 +1,89 @@
+-- Core chat tables. contacts and users belong to the EHR platform; only the
+-- columns the chat queries read are declared here. Every statement is
+-- IF NOT EXISTS so a database built by hand from the old bd_schams.txt is
+-- adopted as-is.
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Patients
+CREATE TABLE IF NOT EXISTS contacts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    location_id UUID NOT NULL,
+    full_name TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    deleted_at TIMESTAMP
+);
+
+-- Doctors and staff
+CREATE TABLE IF NOT EXISTS users (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    full_name TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS chat_sessions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    contact_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    location_id UUID NOT NULL,
+    started_at TIMESTAMP DEFAULT now(),
+    last_message_at TIMESTAMP,
+    UNIQUE(contact_id, user_id, location_id)
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    location_id UUID NOT NULL,
+
+    sender_user_id UUID,
+    receiver_user_id UUID,
+    sender_contact_id UUID,
+    receiver_contact_id UUID,
+
+    content TEXT NOT NULL,
+
+    sent_at TIMESTAMP NOT NULL DEFAULT now(),
+    read_at TIMESTAMP,
+    is_read BOOLEAN DEFAULT FALSE
+);
+
+ALTER TABLE messages
+ADD COLUMN IF NOT EXISTS session_id UUID REFERENCES chat_sessions(id),
+ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMP,
+ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP,
+ADD COLUMN IF NOT EXISTS file_url TEXT,
+ADD COLUMN IF NOT EXISTS file_name TEXT,
+ADD COLUMN IF NOT EXISTS file_type TEXT,
+ADD COLUMN IF NOT EXISTS reply_to_id UUID REFERENCES messages(id) ON DELETE SET NULL,
+ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP,
+ADD COLUMN IF NOT EXISTS is_pinned BOOLEAN DEFAULT FALSE;
+
+-- Index for retrieving conversations quickly
+CREATE INDEX IF NOT EXISTS idx_chat_conversation ON messages (
+    location_id,
+    sender_user_id,
+    receiver_user_id,
+    sender_contact_id,
+    receiver_contact_id
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_chat_session ON chat_sessions (contact_id, user_id, location_id);
+CREATE INDEX IF NOT EXISTS idx_messages_content_trgm ON messages USING gin (content gin_trgm_ops);
+
+CREATE TABLE IF NOT EXISTS message_reactions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL,
+    emoji TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT now(),
+    UNIQUE(message_id, user_id, emoji) -- prevent spamming same emoji
+);
+
+CREATE TABLE IF NOT EXISTS device_tokens (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL UNIQUE,
+    token TEXT NOT NULL,
+    platform TEXT,
+    created_at TIMESTAMP DEFAULT now(),
+    updated_at TIMESTAMP DEFAULT now()
+);
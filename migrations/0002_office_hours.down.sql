@@ -0,0 +1,3 @@
+ALTER TABLE messages DROP COLUMN IF EXISTS is_system;
+DROP TABLE IF EXISTS office_holidays;
+DROP TABLE IF EXISTS office_hours;
@@ -0,0 +1,30 @@
+-- Office hours: one schedule per doctor, plus an optional location-wide
+-- default (user_id NULL). weekly_hours is {"mon":[{"start":"09:00","end":"17:00"}], ...}
+CREATE TABLE IF NOT EXISTS office_hours (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    location_id UUID NOT NULL,
+    user_id UUID,
+    timezone TEXT NOT NULL DEFAULT 'UTC',
+    weekly_hours JSONB NOT NULL DEFAULT '{}',
+    auto_reply_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    auto_reply_message TEXT,
+    updated_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_office_hours ON office_hours (
+    location_id,
+    COALESCE(user_id, '00000000-0000-0000-0000-000000000000'::uuid)
+);
+
+CREATE TABLE IF NOT EXISTS office_holidays (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    location_id UUID NOT NULL,
+    user_id UUID,
+    holiday_date DATE NOT NULL,
+    description TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_office_holidays_lookup ON office_holidays (location_id, holiday_date);
+
+-- Auto-replies are stored as system messages
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS is_system BOOLEAN NOT NULL DEFAULT FALSE;
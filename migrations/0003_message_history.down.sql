@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_messages_reply_to;
+DROP TABLE IF EXISTS message_revisions;
+DROP INDEX IF EXISTS idx_messages_conversation_page;
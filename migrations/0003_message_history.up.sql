@@ -0,0 +1,24 @@
+-- Keyset pagination of conversation history on (sent_at, id)
+CREATE INDEX IF NOT EXISTS idx_messages_conversation_page ON messages (
+    location_id,
+    sender_user_id,
+    receiver_contact_id,
+    sent_at DESC,
+    id DESC
+) WHERE deleted_at IS NULL;
+
+-- Prior versions of edited messages. content was shown from valid_from until
+-- replaced_at; the current version stays in messages.
+CREATE TABLE IF NOT EXISTS message_revisions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    revision INT NOT NULL,
+    content TEXT NOT NULL,
+    edited_by UUID NOT NULL,
+    valid_from TIMESTAMP NOT NULL,
+    replaced_at TIMESTAMP NOT NULL DEFAULT now(),
+    UNIQUE(message_id, revision)
+);
+
+-- Reply counts and thread walks look messages up by parent
+CREATE INDEX IF NOT EXISTS idx_messages_reply_to ON messages (reply_to_id) WHERE reply_to_id IS NOT NULL;
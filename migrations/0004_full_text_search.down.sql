@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_messages_search;
+DROP TRIGGER IF EXISTS trg_messages_search_vector ON messages;
+DROP FUNCTION IF EXISTS messages_search_vector_update();
+ALTER TABLE messages
+DROP COLUMN IF EXISTS search_vector,
+DROP COLUMN IF EXISTS search_config;
+DROP TABLE IF EXISTS location_search_settings;
@@ -0,0 +1,37 @@
+-- Full-text search. Each location can pick its text search configuration;
+-- the one in effect when a message is stored is kept in search_config.
+CREATE TABLE IF NOT EXISTS location_search_settings (
+    location_id UUID PRIMARY KEY,
+    language REGCONFIG NOT NULL DEFAULT 'english'
+);
+
+ALTER TABLE messages
+ADD COLUMN IF NOT EXISTS search_config REGCONFIG NOT NULL DEFAULT 'english',
+ADD COLUMN IF NOT EXISTS search_vector TSVECTOR;
+
+CREATE OR REPLACE FUNCTION messages_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        NEW.search_config := COALESCE(
+            (SELECT language FROM location_search_settings WHERE location_id = NEW.location_id),
+            NEW.search_config
+        );
+    END IF;
+    NEW.search_vector :=
+        setweight(to_tsvector(NEW.search_config, COALESCE(NEW.content, '')), 'A') ||
+        setweight(to_tsvector(NEW.search_config, COALESCE(NEW.file_name, '')), 'B');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_messages_search_vector ON messages;
+CREATE TRIGGER trg_messages_search_vector
+BEFORE INSERT OR UPDATE OF content, file_name ON messages
+FOR EACH ROW EXECUTE FUNCTION messages_search_vector_update();
+
+UPDATE messages SET search_vector =
+    setweight(to_tsvector(search_config, COALESCE(content, '')), 'A') ||
+    setweight(to_tsvector(search_config, COALESCE(file_name, '')), 'B')
+WHERE search_vector IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_messages_search ON messages USING gin (search_vector);
@@ -0,0 +1,12 @@
+-- Privileged actions (e.g. admin searches across a location)
+CREATE TABLE IF NOT EXISTS audit_log (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    location_id UUID NOT NULL,
+    actor_id UUID NOT NULL,
+    actor_type TEXT NOT NULL,
+    action TEXT NOT NULL,
+    details JSONB,
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_location ON audit_log (location_id, created_at DESC);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS uniq_messages_session_seq;
+DROP TABLE IF EXISTS session_events;
+ALTER TABLE messages DROP COLUMN IF EXISTS seq;
+ALTER TABLE chat_sessions DROP COLUMN IF EXISTS last_seq;
@@ -0,0 +1,34 @@
+-- Per-session sequence numbers. last_seq is bumped (row-locked) in the same
+-- transaction as every message insert or mutation; session_events keeps the
+-- numbered log for replay and gap filling.
+ALTER TABLE chat_sessions ADD COLUMN IF NOT EXISTS last_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS seq BIGINT;
+
+CREATE TABLE IF NOT EXISTS session_events (
+    session_id UUID NOT NULL REFERENCES chat_sessions(id) ON DELETE CASCADE,
+    seq BIGINT NOT NULL,
+    event_type TEXT NOT NULL,
+    message_id UUID,
+    data JSONB,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY (session_id, seq)
+);
+
+-- Number existing messages in the order they were sent. Databases that
+-- already numbered their messages are left alone.
+WITH numbered AS (
+    SELECT id, session_id, ROW_NUMBER() OVER (PARTITION BY session_id ORDER BY sent_at, id) AS seq
+    FROM messages WHERE session_id IS NOT NULL
+)
+UPDATE messages m SET seq = n.seq FROM numbered n
+WHERE m.id = n.id
+AND NOT EXISTS (SELECT 1 FROM messages WHERE seq IS NOT NULL);
+
+INSERT INTO session_events (session_id, seq, event_type, message_id, created_at)
+SELECT session_id, seq, 'message_created', id, sent_at FROM messages WHERE seq IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+UPDATE chat_sessions cs SET last_seq = GREATEST(cs.last_seq, COALESCE(
+    (SELECT MAX(seq) FROM messages WHERE session_id = cs.id), 0));
+
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_messages_session_seq ON messages (session_id, seq);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS uniq_messages_client_id;
+ALTER TABLE messages DROP COLUMN IF EXISTS client_message_id;
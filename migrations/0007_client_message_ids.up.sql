@@ -0,0 +1,7 @@
+-- Idempotent sends: a client-chosen key, unique per sender (the contact for
+-- patient messages, otherwise the user)
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS client_message_id TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_messages_client_id
+ON messages (COALESCE(sender_contact_id, sender_user_id), client_message_id)
+WHERE client_message_id IS NOT NULL;
@@ -0,0 +1,17 @@
+-- Transactional outbox: side effects of a write are queued in the same
+-- transaction and dispatched by the relay (at least once)
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGSERIAL PRIMARY KEY,
+    event_type TEXT NOT NULL,
+    aggregate_id TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    available_at TIMESTAMP NOT NULL DEFAULT now(),
+    dispatched_at TIMESTAMP,
+    failed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox (id)
+WHERE dispatched_at IS NULL AND failed_at IS NULL;
@@ -0,0 +1,12 @@
+DROP INDEX IF EXISTS idx_chat_sessions_location_inbox;
+DROP INDEX IF EXISTS idx_chat_sessions_contact_inbox;
+DROP INDEX IF EXISTS idx_chat_sessions_user_inbox;
+DROP INDEX IF EXISTS idx_messages_session_unread;
+ALTER TABLE chat_sessions
+DROP COLUMN last_message_id,
+DROP COLUMN last_message_preview,
+DROP COLUMN last_sender_id,
+DROP COLUMN last_sender_type,
+DROP COLUMN user_unread_count,
+DROP COLUMN contact_unread_count,
+DROP COLUMN last_activity_at;
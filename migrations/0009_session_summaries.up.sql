@@ -0,0 +1,64 @@
+-- Session summaries for inbox listings. They are kept up to date in the
+-- transaction of every message write, read and delete, so listing sessions
+-- doesn't look at messages at all. The unread counts are per participant:
+-- user_unread_count is what the doctor hasn't read, contact_unread_count
+-- what the patient hasn't.
+ALTER TABLE chat_sessions
+ADD COLUMN last_message_id UUID,
+ADD COLUMN last_message_preview TEXT,
+ADD COLUMN last_sender_id UUID,
+ADD COLUMN last_sender_type TEXT,
+ADD COLUMN user_unread_count INT NOT NULL DEFAULT 0,
+ADD COLUMN contact_unread_count INT NOT NULL DEFAULT 0,
+ADD COLUMN last_activity_at TIMESTAMP;
+
+-- Recounting a session's unread messages only visits unread rows
+CREATE INDEX idx_messages_session_unread ON messages (session_id)
+WHERE is_read = false AND deleted_at IS NULL;
+
+UPDATE chat_sessions cs SET
+    last_message_id = m.id,
+    last_message_preview = m.preview,
+    last_sender_id = m.sender_id,
+    last_sender_type = m.sender_type
+FROM (
+    SELECT DISTINCT ON (session_id)
+        session_id, id,
+        LEFT(COALESCE(NULLIF(content, ''), file_name, ''), 200) AS preview,
+        COALESCE(sender_contact_id, sender_user_id) AS sender_id,
+        CASE WHEN sender_contact_id IS NOT NULL THEN 'contact' ELSE 'user' END AS sender_type
+    FROM messages
+    WHERE session_id IS NOT NULL AND deleted_at IS NULL
+    ORDER BY session_id, sent_at DESC, id DESC
+) m
+WHERE m.session_id = cs.id;
+
+UPDATE chat_sessions cs SET
+    user_unread_count = COALESCE(u.for_user, 0),
+    contact_unread_count = COALESCE(u.for_contact, 0)
+FROM (
+    SELECT session_id,
+        COUNT(*) FILTER (WHERE receiver_user_id IS NOT NULL) AS for_user,
+        COUNT(*) FILTER (WHERE receiver_user_id IS NULL) AS for_contact
+    FROM messages
+    WHERE session_id IS NOT NULL AND is_read = false AND deleted_at IS NULL
+    GROUP BY session_id
+) u
+WHERE u.session_id = cs.id;
+
+UPDATE chat_sessions SET last_activity_at = COALESCE(
+    (SELECT MAX(created_at) FROM session_events WHERE session_id = chat_sessions.id),
+    last_message_at,
+    started_at
+);
+
+-- Inbox listings: one index per side, ordered the way they are listed
+CREATE INDEX idx_chat_sessions_user_inbox ON chat_sessions (
+    user_id, last_message_at DESC NULLS LAST, started_at DESC
+);
+CREATE INDEX idx_chat_sessions_contact_inbox ON chat_sessions (
+    contact_id, last_message_at DESC NULLS LAST, started_at DESC
+);
+CREATE INDEX idx_chat_sessions_location_inbox ON chat_sessions (
+    location_id, last_message_at DESC NULLS LAST, started_at DESC
+);
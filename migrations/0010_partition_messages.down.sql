@@ -0,0 +1,54 @@
+-- Back to a single heap. Archived months only exist in object storage and
+-- message_archives is the sole record of them, so rolling back refuses to
+-- run while there are any rather than drop the index to them.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM message_archives) THEN
+        RAISE EXCEPTION 'messages have been archived to object storage; restore them into messages and clear message_archives before rolling back';
+    END IF;
+END $$;
+
+ALTER TABLE messages RENAME TO messages_partitioned;
+DROP TRIGGER IF EXISTS trg_messages_search_vector ON messages_partitioned;
+
+CREATE TABLE messages (LIKE messages_partitioned INCLUDING DEFAULTS INCLUDING STORAGE);
+INSERT INTO messages SELECT * FROM messages_partitioned;
+DROP TABLE messages_partitioned;
+
+ALTER TABLE messages ADD PRIMARY KEY (id);
+ALTER TABLE messages ADD FOREIGN KEY (reply_to_id) REFERENCES messages(id) ON DELETE SET NULL NOT VALID;
+ALTER TABLE message_reactions ADD FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE NOT VALID;
+ALTER TABLE message_revisions ADD FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE NOT VALID;
+
+CREATE INDEX idx_chat_conversation ON messages (
+    location_id,
+    sender_user_id,
+    receiver_user_id,
+    sender_contact_id,
+    receiver_contact_id
+);
+CREATE INDEX idx_messages_content_trgm ON messages USING gin (content gin_trgm_ops);
+CREATE INDEX idx_messages_conversation_page ON messages (
+    location_id,
+    sender_user_id,
+    receiver_contact_id,
+    sent_at DESC,
+    id DESC
+) WHERE deleted_at IS NULL;
+CREATE INDEX idx_messages_reply_to ON messages (reply_to_id) WHERE reply_to_id IS NOT NULL;
+CREATE INDEX idx_messages_search ON messages USING gin (search_vector);
+CREATE UNIQUE INDEX uniq_messages_session_seq ON messages (session_id, seq);
+CREATE INDEX idx_messages_session_unread ON messages (session_id)
+WHERE is_read = false AND deleted_at IS NULL;
+CREATE UNIQUE INDEX uniq_messages_client_id
+ON messages (COALESCE(sender_contact_id, sender_user_id), client_message_id)
+WHERE client_message_id IS NOT NULL;
+
+CREATE TRIGGER trg_messages_search_vector
+BEFORE INSERT OR UPDATE OF content, file_name ON messages
+FOR EACH ROW EXECUTE FUNCTION messages_search_vector_update();
+
+DROP TABLE message_client_ids;
+DROP TABLE message_archive_conversations;
+DROP TABLE message_archives;
+DROP FUNCTION create_messages_partition(DATE);
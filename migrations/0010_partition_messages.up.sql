@@ -0,0 +1,108 @@
+-- Monthly range partitioning of messages on sent_at. Partitions are named
+-- messages_pYYYY_MM and created ahead of time by create_messages_partition,
+-- which the server also calls on startup and from the archive job. Old
+-- partitions are exported to object storage and detached; see
+-- message_archives below.
+--
+-- The table is rebuilt: on a large database plan for the copy to take a while.
+
+CREATE OR REPLACE FUNCTION create_messages_partition(month DATE) RETURNS TEXT AS $$
+DECLARE
+    start_at DATE := date_trunc('month', month)::date;
+    name TEXT := 'messages_p' || to_char(start_at, 'YYYY_MM');
+BEGIN
+    EXECUTE format(
+        'CREATE TABLE IF NOT EXISTS %I PARTITION OF messages FOR VALUES FROM (%L) TO (%L)',
+        name, start_at, (start_at + INTERVAL '1 month')::date
+    );
+    RETURN name;
+END
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE messages RENAME TO messages_legacy;
+DROP TRIGGER IF EXISTS trg_messages_search_vector ON messages_legacy;
+
+CREATE TABLE messages (LIKE messages_legacy INCLUDING DEFAULTS INCLUDING STORAGE)
+PARTITION BY RANGE (sent_at);
+
+-- One partition per month of existing history, plus three months ahead
+SELECT create_messages_partition(m::date)
+FROM generate_series(
+    date_trunc('month', COALESCE((SELECT MIN(sent_at) FROM messages_legacy), now())),
+    date_trunc('month', now()) + INTERVAL '3 months',
+    INTERVAL '1 month'
+) AS m;
+
+INSERT INTO messages SELECT * FROM messages_legacy;
+
+-- Sends are idempotent per sender and client_message_id. The unique index
+-- can't include sent_at, so the key lives in its own table.
+CREATE TABLE message_client_ids (
+    sender_id UUID NOT NULL,
+    client_message_id TEXT NOT NULL,
+    message_id UUID NOT NULL,
+    PRIMARY KEY (sender_id, client_message_id)
+);
+
+INSERT INTO message_client_ids (sender_id, client_message_id, message_id)
+SELECT COALESCE(sender_contact_id, sender_user_id), client_message_id, id
+FROM messages_legacy WHERE client_message_id IS NOT NULL;
+
+-- Dropping the old table also drops the foreign keys that pointed at it
+-- (reply_to_id, message_reactions, message_revisions): they can't reference
+-- a partitioned table by id alone. Reactions and revisions outlive archived
+-- messages, so archived history still shows them.
+DROP TABLE messages_legacy CASCADE;
+
+-- Unique constraints on a partitioned table must include the partition key
+ALTER TABLE messages ADD PRIMARY KEY (id, sent_at);
+
+CREATE INDEX idx_messages_id ON messages (id);
+CREATE INDEX idx_chat_conversation ON messages (
+    location_id,
+    sender_user_id,
+    receiver_user_id,
+    sender_contact_id,
+    receiver_contact_id
+);
+CREATE INDEX idx_messages_content_trgm ON messages USING gin (content gin_trgm_ops);
+CREATE INDEX idx_messages_conversation_page ON messages (
+    location_id,
+    sender_user_id,
+    receiver_contact_id,
+    sent_at DESC,
+    id DESC
+) WHERE deleted_at IS NULL;
+CREATE INDEX idx_messages_reply_to ON messages (reply_to_id) WHERE reply_to_id IS NOT NULL;
+CREATE INDEX idx_messages_search ON messages USING gin (search_vector);
+-- seq stays unique per session through the row lock on chat_sessions
+CREATE INDEX idx_messages_session_seq ON messages (session_id, seq);
+CREATE INDEX idx_messages_session_unread ON messages (session_id)
+WHERE is_read = false AND deleted_at IS NULL;
+
+CREATE TRIGGER trg_messages_search_vector
+BEFORE INSERT OR UPDATE OF content, file_name ON messages
+FOR EACH ROW EXECUTE FUNCTION messages_search_vector_update();
+
+-- Partitions exported to object storage, one gzipped JSONL object each
+CREATE TABLE message_archives (
+    partition_name TEXT PRIMARY KEY,
+    range_start TIMESTAMP NOT NULL,
+    range_end TIMESTAMP NOT NULL,
+    object_key TEXT NOT NULL,
+    message_count BIGINT NOT NULL,
+    archived_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+-- Which conversations each archive holds, so scrolling back only fetches
+-- the archives a conversation actually appears in
+CREATE TABLE message_archive_conversations (
+    partition_name TEXT NOT NULL REFERENCES message_archives(partition_name) ON DELETE CASCADE,
+    location_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    contact_id UUID NOT NULL,
+    message_count BIGINT NOT NULL,
+    min_seq BIGINT NOT NULL DEFAULT 0,
+    max_seq BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (location_id, user_id, contact_id, partition_name)
+);
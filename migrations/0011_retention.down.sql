@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS retention_reports;
+DROP INDEX IF EXISTS idx_outbox_aggregate;
+DROP INDEX IF EXISTS idx_messages_location_sent;
+DROP INDEX IF EXISTS idx_messages_location_deleted;
+ALTER TABLE messages DROP COLUMN IF EXISTS anonymized_at;
+DROP TABLE IF EXISTS retention_policies;
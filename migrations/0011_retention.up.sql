@@ -0,0 +1,45 @@
+-- Per-location retention. Locations without a policy keep everything.
+-- Messages sent more than max_age_days ago are deleted or anonymized,
+-- depending on action; soft-deleted messages are hard-deleted
+-- deleted_max_age_days after their deletion. NULL keeps them forever.
+CREATE TABLE IF NOT EXISTS retention_policies (
+    location_id UUID PRIMARY KEY,
+    action TEXT NOT NULL DEFAULT 'delete' CHECK (action IN ('delete', 'anonymize')),
+    max_age_days INT CHECK (max_age_days > 0),
+    deleted_max_age_days INT CHECK (deleted_max_age_days >= 0),
+    updated_by UUID,
+    updated_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+-- Anonymized messages keep their place in the conversation (id, seq,
+-- timestamps, participants) but lose content and attachments
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS anonymized_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_messages_location_deleted ON messages (location_id, deleted_at)
+WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_messages_location_sent ON messages (location_id, sent_at)
+WHERE anonymized_at IS NULL;
+
+-- Purges drop the queued deliveries and pushes of the messages they remove
+CREATE INDEX IF NOT EXISTS idx_outbox_aggregate ON outbox (aggregate_id);
+
+-- One row per location per purge run
+CREATE TABLE IF NOT EXISTS retention_reports (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    location_id UUID NOT NULL,
+    action TEXT NOT NULL,
+    cutoff TIMESTAMP,
+    deleted_cutoff TIMESTAMP,
+    messages_deleted BIGINT NOT NULL DEFAULT 0,
+    messages_anonymized BIGINT NOT NULL DEFAULT 0,
+    reactions_removed BIGINT NOT NULL DEFAULT 0,
+    revisions_removed BIGINT NOT NULL DEFAULT 0,
+    attachments_removed BIGINT NOT NULL DEFAULT 0,
+    failed_attachments TEXT[] NOT NULL DEFAULT '{}',
+    sessions_affected BIGINT NOT NULL DEFAULT 0,
+    error TEXT,
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_retention_reports_location ON retention_reports (location_id, started_at DESC);
@@ -0,0 +1,2 @@
+DROP FUNCTION IF EXISTS message_on_hold(UUID, UUID, UUID);
+DROP TABLE IF EXISTS legal_holds;
@@ -0,0 +1,35 @@
+-- Legal holds preserve conversations for litigation or investigations.
+-- While a hold is active, messages it covers can't be edited, deleted or
+-- purged. Released holds stay for the record.
+CREATE TABLE IF NOT EXISTS legal_holds (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    scope TEXT NOT NULL CHECK (scope IN ('session', 'contact', 'location')),
+    location_id UUID NOT NULL,
+    session_id UUID REFERENCES chat_sessions(id),
+    contact_id UUID,
+    reason TEXT NOT NULL,
+    placed_by UUID NOT NULL,
+    placed_at TIMESTAMP NOT NULL DEFAULT now(),
+    released_by UUID,
+    released_at TIMESTAMP,
+    release_reason TEXT,
+    CHECK ((scope = 'session') = (session_id IS NOT NULL)),
+    CHECK ((scope = 'contact') = (contact_id IS NOT NULL)),
+    CHECK ((released_at IS NULL) = (released_by IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_legal_holds_active ON legal_holds (location_id)
+WHERE released_at IS NULL;
+
+-- Whether a message of this location, patient and session is under an
+-- active hold. Every message names the patient as receiver_contact_id.
+CREATE OR REPLACE FUNCTION message_on_hold(loc UUID, contact UUID, session UUID) RETURNS BOOLEAN AS $$
+    SELECT EXISTS (
+        SELECT 1 FROM legal_holds h
+        WHERE h.location_id = loc AND h.released_at IS NULL AND (
+            h.scope = 'location' OR
+            (h.scope = 'contact' AND h.contact_id = contact) OR
+            (h.scope = 'session' AND h.session_id = session)
+        )
+    )
+$$ LANGUAGE sql STABLE;
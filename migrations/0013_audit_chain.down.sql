@@ -0,0 +1,21 @@
+DROP TRIGGER IF EXISTS audit_chain_heads_no_truncate ON audit_chain_heads;
+DROP TRIGGER IF EXISTS audit_chain_heads_no_delete ON audit_chain_heads;
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+DROP TRIGGER IF EXISTS audit_log_chain ON audit_log;
+DROP FUNCTION IF EXISTS audit_log_append_only();
+DROP FUNCTION IF EXISTS audit_log_chain();
+DROP FUNCTION IF EXISTS audit_entry_hash(TEXT, BIGINT, UUID, UUID, TEXT, TEXT, TEXT, TEXT, TEXT, JSONB, TIMESTAMP);
+
+DROP INDEX IF EXISTS idx_audit_log_target;
+DROP INDEX IF EXISTS idx_audit_log_actor;
+DROP INDEX IF EXISTS idx_audit_log_chain;
+DROP TABLE IF EXISTS audit_chain_heads;
+
+ALTER TABLE audit_log
+    DROP COLUMN IF EXISTS hash,
+    DROP COLUMN IF EXISTS prev_hash,
+    DROP COLUMN IF EXISTS ip,
+    DROP COLUMN IF EXISTS target_id,
+    DROP COLUMN IF EXISTS target_type,
+    DROP COLUMN IF EXISTS seq;
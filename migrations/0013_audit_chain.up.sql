@@ -0,0 +1,115 @@
+-- The audit log covers every read and change of chat data, and becomes
+-- append-only and tamper-evident: the entries of each location form a hash
+-- chain, each entry's hash covering its own fields and the hash of the
+-- entry before it. Editing, removing or reordering an entry breaks the
+-- chain from that entry on.
+ALTER TABLE audit_log
+    ADD COLUMN IF NOT EXISTS seq BIGINT,
+    ADD COLUMN IF NOT EXISTS target_type TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS target_id TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS ip TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS prev_hash TEXT,
+    ADD COLUMN IF NOT EXISTS hash TEXT;
+
+-- The last entry of each location's chain. Inserts lock their location's
+-- row, so entries of a location are chained one at a time.
+CREATE TABLE IF NOT EXISTS audit_chain_heads (
+    location_id UUID PRIMARY KEY,
+    seq BIGINT NOT NULL,
+    hash TEXT NOT NULL
+);
+
+-- SHA-256 over a JSON array of the fields, so no two entries encode alike.
+-- The verifier recomputes it with this same function.
+CREATE OR REPLACE FUNCTION audit_entry_hash(
+    prev_hash TEXT, seq BIGINT, location_id UUID, actor_id UUID, actor_type TEXT, action TEXT,
+    target_type TEXT, target_id TEXT, ip TEXT, details JSONB, created_at TIMESTAMP
+) RETURNS TEXT AS $$
+    SELECT encode(sha256(convert_to(jsonb_build_array(
+        prev_hash, seq, location_id::text, actor_id::text, actor_type, action,
+        target_type, target_id, ip, details,
+        to_char(created_at, 'YYYY-MM-DD"T"HH24:MI:SS.US')
+    )::text, 'UTF8')), 'hex')
+$$ LANGUAGE sql STABLE;
+
+-- Chain the entries written before this migration, oldest first
+DO $$
+DECLARE
+    e RECORD;
+    loc UUID;
+    head_seq BIGINT;
+    head_hash TEXT;
+    entry_hash TEXT;
+BEGIN
+    FOR e IN SELECT * FROM audit_log WHERE hash IS NULL ORDER BY location_id, created_at, id LOOP
+        IF loc IS DISTINCT FROM e.location_id THEN
+            loc := e.location_id;
+            head_seq := 0;
+            head_hash := '';
+        END IF;
+        head_seq := head_seq + 1;
+        entry_hash := audit_entry_hash(head_hash, head_seq, e.location_id, e.actor_id, e.actor_type,
+            e.action, e.target_type, e.target_id, e.ip, e.details, e.created_at);
+        UPDATE audit_log SET seq = head_seq, prev_hash = head_hash, hash = entry_hash WHERE id = e.id;
+        head_hash := entry_hash;
+
+        INSERT INTO audit_chain_heads (location_id, seq, hash) VALUES (loc, head_seq, head_hash)
+        ON CONFLICT (location_id) DO UPDATE SET seq = EXCLUDED.seq, hash = EXCLUDED.hash;
+    END LOOP;
+END $$;
+
+ALTER TABLE audit_log
+    ALTER COLUMN seq SET NOT NULL,
+    ALTER COLUMN prev_hash SET NOT NULL,
+    ALTER COLUMN hash SET NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_audit_log_chain ON audit_log (location_id, seq);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log (actor_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log (location_id, target_id)
+WHERE target_id <> '';
+
+-- Numbers, links and hashes each new entry. Whatever the client sent for
+-- these columns, and for created_at, is overwritten.
+CREATE OR REPLACE FUNCTION audit_log_chain() RETURNS trigger AS $$
+DECLARE
+    head audit_chain_heads%ROWTYPE;
+BEGIN
+    INSERT INTO audit_chain_heads (location_id, seq, hash) VALUES (NEW.location_id, 0, '')
+    ON CONFLICT (location_id) DO NOTHING;
+    SELECT * INTO head FROM audit_chain_heads WHERE location_id = NEW.location_id FOR UPDATE;
+
+    NEW.created_at := now();
+    NEW.seq := head.seq + 1;
+    NEW.prev_hash := head.hash;
+    NEW.hash := audit_entry_hash(NEW.prev_hash, NEW.seq, NEW.location_id, NEW.actor_id, NEW.actor_type,
+        NEW.action, NEW.target_type, NEW.target_id, NEW.ip, NEW.details, NEW.created_at);
+
+    UPDATE audit_chain_heads SET seq = NEW.seq, hash = NEW.hash WHERE location_id = NEW.location_id;
+    RETURN NEW;
+END $$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION '% is append-only', TG_TABLE_NAME;
+END $$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_chain ON audit_log;
+CREATE TRIGGER audit_log_chain BEFORE INSERT ON audit_log
+FOR EACH ROW EXECUTE FUNCTION audit_log_chain();
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
+
+-- Heads only ever move forward, through audit_log_chain
+DROP TRIGGER IF EXISTS audit_chain_heads_no_delete ON audit_chain_heads;
+CREATE TRIGGER audit_chain_heads_no_delete BEFORE DELETE ON audit_chain_heads
+FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+DROP TRIGGER IF EXISTS audit_chain_heads_no_truncate ON audit_chain_heads;
+CREATE TRIGGER audit_chain_heads_no_truncate BEFORE TRUNCATE ON audit_chain_heads
+FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
@@ -0,0 +1,25 @@
+-- Sealed rows stay sealed: re-encrypting them to plaintext is not
+-- supported, so only roll back before anything was encrypted.
+CREATE OR REPLACE FUNCTION messages_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        NEW.search_config := COALESCE(
+            (SELECT language FROM location_search_settings WHERE location_id = NEW.location_id),
+            NEW.search_config
+        );
+    END IF;
+    NEW.search_vector :=
+        setweight(to_tsvector(NEW.search_config, COALESCE(NEW.content, '')), 'A') ||
+        setweight(to_tsvector(NEW.search_config, COALESCE(NEW.file_name, '')), 'B');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE INDEX IF NOT EXISTS idx_messages_content_trgm ON messages USING gin (content gin_trgm_ops);
+
+DROP INDEX IF EXISTS idx_messages_content_tokens;
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS content_tokens,
+    DROP COLUMN IF EXISTS key_id;
+
+DROP TABLE IF EXISTS encryption_keys;
@@ -0,0 +1,49 @@
+-- Message content, file fields and revisions can be sealed with per-location
+-- data keys (see package encryption). Data keys are stored wrapped by the
+-- master key named in master_key_id; each location has one active key, and
+-- retired keys stay so older rows and archives can still be opened.
+CREATE TABLE IF NOT EXISTS encryption_keys (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    location_id UUID NOT NULL,
+    version INT NOT NULL,
+    wrapped_key BYTEA NOT NULL,
+    master_key_id TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    retired_at TIMESTAMP,
+    UNIQUE (location_id, version)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_encryption_keys_active ON encryption_keys (location_id)
+WHERE retired_at IS NULL;
+
+-- key_id names the key a message's fields and content_tokens were made
+-- with; NULL for plaintext rows. content_tokens is the blind index: keyed
+-- hashes of the words of the content and file name.
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS key_id UUID,
+    ADD COLUMN IF NOT EXISTS content_tokens TEXT[];
+
+CREATE INDEX IF NOT EXISTS idx_messages_content_tokens ON messages USING gin (content_tokens);
+
+-- The trigram index held a readable copy of every message and nothing
+-- queries it
+DROP INDEX IF EXISTS idx_messages_content_trgm;
+
+-- Sealed values are ciphertext to the database: only plaintext rows get a
+-- search vector, sealed ones are found through content_tokens
+CREATE OR REPLACE FUNCTION messages_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        NEW.search_config := COALESCE(
+            (SELECT language FROM location_search_settings WHERE location_id = NEW.location_id),
+            NEW.search_config
+        );
+    END IF;
+    NEW.search_vector :=
+        setweight(to_tsvector(NEW.search_config,
+            CASE WHEN NEW.content LIKE 'enc:1:%' THEN '' ELSE COALESCE(NEW.content, '') END), 'A') ||
+        setweight(to_tsvector(NEW.search_config,
+            CASE WHEN NEW.file_name LIKE 'enc:1:%' THEN '' ELSE COALESCE(NEW.file_name, '') END), 'B');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
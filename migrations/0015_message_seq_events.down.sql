@@ -0,0 +1,2 @@
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS fk_messages_session_event;
+DROP INDEX IF EXISTS uniq_session_events_message;
@@ -0,0 +1,12 @@
+-- messages can't carry a unique (session_id, seq) index since 0010: unique
+-- indexes on a partitioned table must include sent_at. Instead each message
+-- must point at the message_created event that numbered it, and events are
+-- unique per (session_id, seq), so no two messages can share a seq.
+--
+-- Adding the key checks every partition: on a large database plan for it
+-- to take a while.
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_session_events_message
+ON session_events (session_id, seq, message_id);
+
+ALTER TABLE messages ADD CONSTRAINT fk_messages_session_event
+FOREIGN KEY (session_id, seq, id) REFERENCES session_events (session_id, seq, message_id);
@@ -0,0 +1,3 @@
+ALTER TABLE message_archive_conversations
+    DROP COLUMN IF EXISTS purged_deleted_before,
+    DROP COLUMN IF EXISTS purged_sent_before;
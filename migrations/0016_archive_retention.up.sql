@@ -0,0 +1,7 @@
+-- Retention reaches archived months too: each purge rewrites the archives
+-- holding a location's expired messages. These record the cutoffs already
+-- applied to a conversation's part of an archive, so it isn't fetched
+-- again until a later cutoff can change it. Changing a policy clears them.
+ALTER TABLE message_archive_conversations
+    ADD COLUMN IF NOT EXISTS purged_sent_before TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS purged_deleted_before TIMESTAMP;
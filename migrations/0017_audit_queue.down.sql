@@ -0,0 +1,22 @@
+-- Entries still queued are chained first, so none are lost
+SELECT audit_chain_pending(2147483647);
+DROP FUNCTION IF EXISTS audit_chain_pending(INT);
+DROP TABLE IF EXISTS audit_queue;
+
+CREATE OR REPLACE FUNCTION audit_log_chain() RETURNS trigger AS $$
+DECLARE
+    head audit_chain_heads%ROWTYPE;
+BEGIN
+    INSERT INTO audit_chain_heads (location_id, seq, hash) VALUES (NEW.location_id, 0, '')
+    ON CONFLICT (location_id) DO NOTHING;
+    SELECT * INTO head FROM audit_chain_heads WHERE location_id = NEW.location_id FOR UPDATE;
+
+    NEW.created_at := now();
+    NEW.seq := head.seq + 1;
+    NEW.prev_hash := head.hash;
+    NEW.hash := audit_entry_hash(NEW.prev_hash, NEW.seq, NEW.location_id, NEW.actor_id, NEW.actor_type,
+        NEW.action, NEW.target_type, NEW.target_id, NEW.ip, NEW.details, NEW.created_at);
+
+    UPDATE audit_chain_heads SET seq = NEW.seq, hash = NEW.hash WHERE location_id = NEW.location_id;
+    RETURN NEW;
+END $$ LANGUAGE plpgsql;
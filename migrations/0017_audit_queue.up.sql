@@ -0,0 +1,61 @@
+-- Audit entries are queued and chained in batches. Writing to audit_log
+-- directly locks the location's chain head until the transaction ends,
+-- which serialized every audited read in a location, and held the lock for
+-- the whole transaction when a change was audited along with it. Queued
+-- entries take no locks: reads insert theirs on their own, changes in the
+-- same transaction as the change. audit_chain_pending moves them into the
+-- chain in queue order, one head lock per location per batch.
+CREATE TABLE IF NOT EXISTS audit_queue (
+    id BIGSERIAL PRIMARY KEY,
+    location_id UUID NOT NULL,
+    actor_id UUID NOT NULL,
+    actor_type TEXT NOT NULL,
+    action TEXT NOT NULL,
+    target_type TEXT NOT NULL DEFAULT '',
+    target_id TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    details JSONB,
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+-- Entries moved by audit_chain_pending keep the time they were queued;
+-- any other insert is stamped with now() as before.
+CREATE OR REPLACE FUNCTION audit_log_chain() RETURNS trigger AS $$
+DECLARE
+    head audit_chain_heads%ROWTYPE;
+BEGIN
+    INSERT INTO audit_chain_heads (location_id, seq, hash) VALUES (NEW.location_id, 0, '')
+    ON CONFLICT (location_id) DO NOTHING;
+    SELECT * INTO head FROM audit_chain_heads WHERE location_id = NEW.location_id FOR UPDATE;
+
+    IF current_setting('audit.chaining_queue', true) IS DISTINCT FROM 'on' THEN
+        NEW.created_at := now();
+    END IF;
+    NEW.seq := head.seq + 1;
+    NEW.prev_hash := head.hash;
+    NEW.hash := audit_entry_hash(NEW.prev_hash, NEW.seq, NEW.location_id, NEW.actor_id, NEW.actor_type,
+        NEW.action, NEW.target_type, NEW.target_id, NEW.ip, NEW.details, NEW.created_at);
+
+    UPDATE audit_chain_heads SET seq = NEW.seq, hash = NEW.hash WHERE location_id = NEW.location_id;
+    RETURN NEW;
+END $$ LANGUAGE plpgsql;
+
+-- Chains up to max_rows queued entries, oldest first, and returns how many.
+-- Concurrent callers take disjoint batches.
+CREATE OR REPLACE FUNCTION audit_chain_pending(max_rows INT) RETURNS INT AS $$
+DECLARE
+    moved INT;
+BEGIN
+    PERFORM set_config('audit.chaining_queue', 'on', true);
+    WITH batch AS (
+        DELETE FROM audit_queue
+        WHERE id IN (SELECT id FROM audit_queue ORDER BY id LIMIT max_rows FOR UPDATE SKIP LOCKED)
+        RETURNING *
+    )
+    INSERT INTO audit_log (location_id, actor_id, actor_type, action, target_type, target_id, ip, details, created_at)
+    SELECT location_id, actor_id, actor_type, action, target_type, target_id, ip, details, created_at
+    FROM batch ORDER BY id;
+    GET DIAGNOSTICS moved = ROW_COUNT;
+    PERFORM set_config('audit.chaining_queue', 'off', true);
+    RETURN moved;
+END $$ LANGUAGE plpgsql;
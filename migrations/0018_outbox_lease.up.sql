@@ -0,0 +1,4 @@
+-- The relay claims events with a short lease instead of holding row locks
+-- while it delivers them. Events whose lease ran out, e.g. because the
+-- relay died mid-batch, are claimed again.
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_session_events_session_txid;
+DROP INDEX IF EXISTS uniq_session_events_event_id;
+ALTER TABLE session_events DROP COLUMN IF EXISTS txid;
+ALTER TABLE session_events DROP COLUMN IF EXISTS event_id;
@@ -0,0 +1,10 @@
+-- A global position for every session event, so a sync token can hold one
+-- high-water mark instead of a seq per session. event_id orders events;
+-- txid is the writing transaction, which tells sync when every event below
+-- a mark has committed (ids are handed out before commit, so they can
+-- become visible out of order).
+ALTER TABLE session_events ADD COLUMN IF NOT EXISTS event_id BIGSERIAL;
+ALTER TABLE session_events ADD COLUMN IF NOT EXISTS txid BIGINT NOT NULL DEFAULT pg_current_xact_id()::text::bigint;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_session_events_event_id ON session_events (event_id);
+CREATE INDEX IF NOT EXISTS idx_session_events_session_txid ON session_events (session_id, txid);
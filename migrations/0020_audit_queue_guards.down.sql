@@ -0,0 +1,46 @@
+DROP TRIGGER IF EXISTS audit_queue_no_truncate ON audit_queue;
+DROP TRIGGER IF EXISTS audit_queue_chain_only ON audit_queue;
+DROP TRIGGER IF EXISTS audit_queue_append_only ON audit_queue;
+DROP TRIGGER IF EXISTS audit_queue_stamp ON audit_queue;
+DROP FUNCTION IF EXISTS audit_queue_chain_only();
+DROP FUNCTION IF EXISTS audit_queue_stamp();
+
+CREATE OR REPLACE FUNCTION audit_log_chain() RETURNS trigger AS $$
+DECLARE
+    head audit_chain_heads%ROWTYPE;
+BEGIN
+    INSERT INTO audit_chain_heads (location_id, seq, hash) VALUES (NEW.location_id, 0, '')
+    ON CONFLICT (location_id) DO NOTHING;
+    SELECT * INTO head FROM audit_chain_heads WHERE location_id = NEW.location_id FOR UPDATE;
+
+    IF current_setting('audit.chaining_queue', true) IS DISTINCT FROM 'on' THEN
+        NEW.created_at := now();
+    END IF;
+    NEW.seq := head.seq + 1;
+    NEW.prev_hash := head.hash;
+    NEW.hash := audit_entry_hash(NEW.prev_hash, NEW.seq, NEW.location_id, NEW.actor_id, NEW.actor_type,
+        NEW.action, NEW.target_type, NEW.target_id, NEW.ip, NEW.details, NEW.created_at);
+
+    UPDATE audit_chain_heads SET seq = NEW.seq, hash = NEW.hash WHERE location_id = NEW.location_id;
+    RETURN NEW;
+END $$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION audit_chain_pending(max_rows INT) RETURNS INT AS $$
+DECLARE
+    moved INT;
+BEGIN
+    PERFORM set_config('audit.chaining_queue', 'on', true);
+    WITH batch AS (
+        DELETE FROM audit_queue
+        WHERE id IN (SELECT id FROM audit_queue ORDER BY id LIMIT max_rows FOR UPDATE SKIP LOCKED)
+        RETURNING *
+    )
+    INSERT INTO audit_log (location_id, actor_id, actor_type, action, target_type, target_id, ip, details, created_at)
+    SELECT location_id, actor_id, actor_type, action, target_type, target_id, ip, details, created_at
+    FROM batch ORDER BY id;
+    GET DIAGNOSTICS moved = ROW_COUNT;
+    PERFORM set_config('audit.chaining_queue', 'off', true);
+    RETURN moved;
+END $$ LANGUAGE plpgsql;
+
+ALTER TABLE audit_log DROP COLUMN IF EXISTS queue_id;
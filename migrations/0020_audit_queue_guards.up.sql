@@ -0,0 +1,92 @@
+-- audit_queue gets the guards audit_log has: queued entries can't be
+-- changed, and only leave the queue by being chained. Chaining no longer
+-- trusts a session setting to keep an entry's created_at, which any session
+-- could set to backdate entries written straight to audit_log. Instead a
+-- chained entry names its queue row in queue_id, and audit_log_chain takes
+-- the row out of the queue and copies every field from it; an insert
+-- without queue_id is stamped with now() as before.
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS queue_id BIGINT;
+
+CREATE OR REPLACE FUNCTION audit_log_chain() RETURNS trigger AS $$
+DECLARE
+    head audit_chain_heads%ROWTYPE;
+    queued audit_queue%ROWTYPE;
+BEGIN
+    IF NEW.queue_id IS NULL THEN
+        NEW.created_at := now();
+    ELSE
+        DELETE FROM audit_queue WHERE id = NEW.queue_id RETURNING * INTO queued;
+        IF NOT FOUND THEN
+            RAISE EXCEPTION 'audit_queue entry % is not queued', NEW.queue_id;
+        END IF;
+        NEW.location_id := queued.location_id;
+        NEW.actor_id := queued.actor_id;
+        NEW.actor_type := queued.actor_type;
+        NEW.action := queued.action;
+        NEW.target_type := queued.target_type;
+        NEW.target_id := queued.target_id;
+        NEW.ip := queued.ip;
+        NEW.details := queued.details;
+        NEW.created_at := queued.created_at;
+    END IF;
+
+    INSERT INTO audit_chain_heads (location_id, seq, hash) VALUES (NEW.location_id, 0, '')
+    ON CONFLICT (location_id) DO NOTHING;
+    SELECT * INTO head FROM audit_chain_heads WHERE location_id = NEW.location_id FOR UPDATE;
+
+    NEW.seq := head.seq + 1;
+    NEW.prev_hash := head.hash;
+    NEW.hash := audit_entry_hash(NEW.prev_hash, NEW.seq, NEW.location_id, NEW.actor_id, NEW.actor_type,
+        NEW.action, NEW.target_type, NEW.target_id, NEW.ip, NEW.details, NEW.created_at);
+
+    UPDATE audit_chain_heads SET seq = NEW.seq, hash = NEW.hash WHERE location_id = NEW.location_id;
+    RETURN NEW;
+END $$ LANGUAGE plpgsql;
+
+-- Chains up to max_rows queued entries, oldest first, and returns how many.
+-- Concurrent callers take disjoint batches.
+CREATE OR REPLACE FUNCTION audit_chain_pending(max_rows INT) RETURNS INT AS $$
+DECLARE
+    moved INT;
+BEGIN
+    INSERT INTO audit_log (location_id, actor_id, actor_type, action, queue_id)
+    SELECT location_id, actor_id, actor_type, action, id
+    FROM audit_queue
+    WHERE id IN (SELECT id FROM audit_queue ORDER BY id LIMIT max_rows FOR UPDATE SKIP LOCKED)
+    ORDER BY id;
+    GET DIAGNOSTICS moved = ROW_COUNT;
+    RETURN moved;
+END $$ LANGUAGE plpgsql;
+
+-- Queued entries are stamped when queued, whatever the client sent
+CREATE OR REPLACE FUNCTION audit_queue_stamp() RETURNS trigger AS $$
+BEGIN
+    NEW.created_at := now();
+    RETURN NEW;
+END $$ LANGUAGE plpgsql;
+
+-- A queued entry is only deleted by audit_log_chain, which runs as a
+-- trigger itself; a delete issued by a client fires this at depth 1
+CREATE OR REPLACE FUNCTION audit_queue_chain_only() RETURNS trigger AS $$
+BEGIN
+    IF pg_trigger_depth() < 2 THEN
+        RAISE EXCEPTION 'audit_queue entries only leave the queue through audit_chain_pending';
+    END IF;
+    RETURN OLD;
+END $$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_queue_stamp ON audit_queue;
+CREATE TRIGGER audit_queue_stamp BEFORE INSERT ON audit_queue
+FOR EACH ROW EXECUTE FUNCTION audit_queue_stamp();
+
+DROP TRIGGER IF EXISTS audit_queue_append_only ON audit_queue;
+CREATE TRIGGER audit_queue_append_only BEFORE UPDATE ON audit_queue
+FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+DROP TRIGGER IF EXISTS audit_queue_chain_only ON audit_queue;
+CREATE TRIGGER audit_queue_chain_only BEFORE DELETE ON audit_queue
+FOR EACH ROW EXECUTE FUNCTION audit_queue_chain_only();
+
+DROP TRIGGER IF EXISTS audit_queue_no_truncate ON audit_queue;
+CREATE TRIGGER audit_queue_no_truncate BEFORE TRUNCATE ON audit_queue
+FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
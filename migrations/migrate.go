@@ -0,0 +1,289 @@
+// Package migrations holds the versioned database schema, embedded in the
+// binary. Each version is a pair of files, NNNN_name.up.sql and
+// NNNN_name.down.sql, applied in its own transaction and recorded in
+// schema_migrations.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// ErrBehind means the database hasn't been migrated to the version this
+// binary was built against.
+var ErrBehind = errors.New("database schema is behind")
+
+// lockID serializes migrations across instances starting at the same time.
+const lockID = 72_402_815
+
+const (
+	queryCreateMigrationsTable = `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT now()
+		)
+	`
+	queryCurrentVersion = `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`
+	queryListApplied    = `SELECT version, name, applied_at FROM schema_migrations ORDER BY version`
+	queryRecordVersion  = `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`
+	queryForgetVersion  = `DELETE FROM schema_migrations WHERE version = $1`
+)
+
+// Migration is one schema version.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status describes one version for the migrate status command.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// All returns the embedded migrations, oldest first.
+func All() ([]Migration, error) {
+	return load(files)
+}
+
+// load reads the migrations in the root of fsys.
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		name := e.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		prefix, rest, ok := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: name must start with a version number", name)
+		}
+		body, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: strings.TrimSuffix(strings.TrimSuffix(rest, ".up.sql"), ".down.sql")}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	var all []Migration
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		all = append(all, *m)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+	return all, nil
+}
+
+// Latest is the version this binary expects.
+func Latest() int {
+	all, err := All()
+	if err != nil || len(all) == 0 {
+		return 0
+	}
+	return all[len(all)-1].Version
+}
+
+// Current returns the version the database is at, 0 if nothing is applied.
+func Current(ctx context.Context, db *sql.DB) (int, error) {
+	if _, err := db.ExecContext(ctx, queryCreateMigrationsTable); err != nil {
+		return 0, err
+	}
+	var version int
+	err := db.QueryRowContext(ctx, queryCurrentVersion).Scan(&version)
+	return version, err
+}
+
+// Check fails with ErrBehind if the database is older than the binary. A
+// newer database is allowed so instances can be rolled back one release.
+func Check(ctx context.Context, db *sql.DB) error {
+	current, err := Current(ctx, db)
+	if err != nil {
+		return err
+	}
+	if latest := Latest(); current < latest {
+		return fmt.Errorf("%w: at version %d, binary needs %d; run the migrate command", ErrBehind, current, latest)
+	}
+	return nil
+}
+
+// Up applies every pending migration and returns the ones it applied.
+func Up(ctx context.Context, db *sql.DB) ([]Migration, error) {
+	all, err := All()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	err = withLock(ctx, db, func(conn *sql.Conn) error {
+		current, err := currentOn(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range all {
+			if m.Version <= current {
+				continue
+			}
+			if err := run(ctx, conn, m.Up, queryRecordVersion, m.Version, m.Name); err != nil {
+				return fmt.Errorf("migration %04d_%s up: %w", m.Version, m.Name, err)
+			}
+			log.Printf("⬆️ Applied migration %04d_%s", m.Version, m.Name)
+			applied = append(applied, m)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the newest steps applied migrations and returns them, newest
+// first.
+func Down(ctx context.Context, db *sql.DB, steps int) ([]Migration, error) {
+	all, err := All()
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+	err = withLock(ctx, db, func(conn *sql.Conn) error {
+		current, err := currentOn(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(all) - 1; i >= 0 && len(reverted) < steps; i-- {
+			m := all[i]
+			if m.Version > current {
+				continue
+			}
+			if err := run(ctx, conn, m.Down, queryForgetVersion, m.Version); err != nil {
+				return fmt.Errorf("migration %04d_%s down: %w", m.Version, m.Name, err)
+			}
+			log.Printf("⬇️ Reverted migration %04d_%s", m.Version, m.Name)
+			reverted = append(reverted, m)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// List reports every embedded migration and when it was applied.
+func List(ctx context.Context, db *sql.DB) ([]Status, error) {
+	all, err := All()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, queryCreateMigrationsTable); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, queryListApplied)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	appliedAt := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var name string
+		var at time.Time
+		if err := rows.Scan(&version, &name, &at); err != nil {
+			return nil, err
+		}
+		appliedAt[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(all))
+	for i, m := range all {
+		statuses[i].Migration = m
+		if at, ok := appliedAt[m.Version]; ok {
+			statuses[i].AppliedAt = &at
+		}
+	}
+	return statuses, nil
+}
+
+// withLock runs fn on one connection holding the migration advisory lock.
+func withLock(ctx context.Context, db *sql.DB, fn func(*sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, queryCreateMigrationsTable); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID); err != nil {
+		return err
+	}
+	// Unlock even if ctx is done, or the session keeps the lock
+	defer conn.ExecContext(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, lockID)
+
+	return fn(conn)
+}
+
+func currentOn(ctx context.Context, conn *sql.Conn) (int, error) {
+	var version int
+	err := conn.QueryRowContext(ctx, queryCurrentVersion).Scan(&version)
+	return version, err
+}
+
+// run executes a migration script and its bookkeeping statement in one
+// transaction.
+func run(ctx context.Context, conn *sql.Conn, script, bookkeeping string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, bookkeeping, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,88 @@
+package migrations
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAllEmbedded(t *testing.T) {
+	all, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	// Versions are numbered without gaps, so Check and Down can count on them
+	for i, m := range all {
+		if m.Version != i+1 {
+			t.Errorf("migration %d is version %d, want %d", i, m.Version, i+1)
+		}
+		if strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			t.Errorf("migration %04d_%s has an empty up or down script", m.Version, m.Name)
+		}
+	}
+	if Latest() != all[len(all)-1].Version {
+		t.Errorf("Latest = %d, want %d", Latest(), all[len(all)-1].Version)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	file := func(body string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(body)} }
+	tests := []struct {
+		name      string
+		fsys      fstest.MapFS
+		wantNames []string
+		wantErr   string
+	}{
+		{
+			name: "pairs sorted by version",
+			fsys: fstest.MapFS{
+				"0002_add_index.up.sql":   file("CREATE INDEX"),
+				"0002_add_index.down.sql": file("DROP INDEX"),
+				"0001_init.up.sql":        file("CREATE TABLE"),
+				"0001_init.down.sql":      file("DROP TABLE"),
+				"README.md":               file("not a migration"),
+			},
+			wantNames: []string{"init", "add_index"},
+		},
+		{
+			name:    "missing down",
+			fsys:    fstest.MapFS{"0001_init.up.sql": file("CREATE TABLE")},
+			wantErr: "needs both an up and a down file",
+		},
+		{
+			name:    "no version",
+			fsys:    fstest.MapFS{"init.up.sql": file("CREATE TABLE")},
+			wantErr: "must start with a version number",
+		},
+		{
+			name:    "version zero",
+			fsys:    fstest.MapFS{"0000_init.up.sql": file("CREATE TABLE")},
+			wantErr: "must start with a version number",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			all, err := load(tt.fsys)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("load error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(all) != len(tt.wantNames) {
+				t.Fatalf("loaded %d migrations, want %d", len(all), len(tt.wantNames))
+			}
+			for i, m := range all {
+				if m.Name != tt.wantNames[i] || m.Version != i+1 {
+					t.Errorf("migration %d = %04d_%s, want %04d_%s", i, m.Version, m.Name, i+1, tt.wantNames[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// MessageArchive is a month of messages exported to object storage and
+// detached from the messages table.
+type MessageArchive struct {
+	PartitionName string    `json:"partition_name"`
+	RangeStart    time.Time `json:"range_start"`
+	RangeEnd      time.Time `json:"range_end"`
+	ObjectKey     string    `json:"object_key"`
+	MessageCount  int64     `json:"message_count"`
+	ArchivedAt    time.Time `json:"archived_at"`
+}
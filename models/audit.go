@@ -0,0 +1,52 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditEntry records who read or changed chat data, or took an admin
+// action. Entries are append-only; those of a location form a hash chain in
+// Seq order, each Hash covering the entry's fields and PrevHash.
+type AuditEntry struct {
+	ID         string          `json:"id"`
+	LocationID string          `json:"location_id"`
+	Seq        int64           `json:"seq"`
+	ActorID    string          `json:"actor_id"`
+	ActorType  string          `json:"actor_type"`
+	Action     string          `json:"action"`                // e.g. "message.search"
+	TargetType string          `json:"target_type,omitempty"` // e.g. "message", "session"
+	TargetID   string          `json:"target_id,omitempty"`
+	IP         string          `json:"ip,omitempty"`
+	Details    json.RawMessage `json:"details,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	PrevHash   string          `json:"prev_hash"`
+	Hash       string          `json:"hash"`
+}
+
+// AuditFilter narrows an audit log query to one location. Zero fields
+// match everything; entries come newest first.
+type AuditFilter struct {
+	LocationID string
+	ActorID    string
+	Action     string
+	TargetType string
+	TargetID   string
+	From       *time.Time
+	To         *time.Time
+	BeforeSeq  int64 // page back from the oldest entry of the previous page
+	Limit      int
+}
+
+// AuditChainStatus is the result of checking a location's hash chain from
+// its first entry to its head.
+type AuditChainStatus struct {
+	LocationID string    `json:"location_id"`
+	Entries    int64     `json:"entries"`
+	HeadSeq    int64     `json:"head_seq"`
+	HeadHash   string    `json:"head_hash"`
+	Valid      bool      `json:"valid"`
+	BrokenAt   int64     `json:"broken_at,omitempty"` // seq of the first entry that doesn't check out
+	Problem    string    `json:"problem,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// EncryptionKey is a location's data key as stored: wrapped by the master
+// key it names. A location has one active key, used for everything sealed
+// from then on; rotating retires it, but retired keys are kept so older
+// rows and archives can still be opened.
+type EncryptionKey struct {
+	ID          string     `json:"id"`
+	LocationID  string     `json:"location_id"`
+	Version     int        `json:"version"`
+	WrappedKey  []byte     `json:"-"`
+	MasterKeyID string     `json:"master_key_id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RetiredAt   *time.Time `json:"retired_at,omitempty"`
+}
+
+// EncryptionStatus shows which keys a location's messages are sealed under.
+type EncryptionStatus struct {
+	LocationID string           `json:"location_id"`
+	Enabled    bool             `json:"enabled"`
+	ActiveKey  string           `json:"active_key_id,omitempty"`
+	Keys       []EncryptionKey  `json:"keys"`
+	Messages   map[string]int64 `json:"messages"`  // by key id
+	Plaintext  int64            `json:"plaintext"` // stored before encryption was enabled
+	Pending    int64            `json:"pending"`   // messages not under the active key
+}
+
+// ReencryptReport is the outcome of one re-encryption run over a location.
+type ReencryptReport struct {
+	LocationID string    `json:"location_id"`
+	KeyID      string    `json:"key_id"`
+	Messages   int64     `json:"messages"`
+	Revisions  int64     `json:"revisions"`
+	Events     int64     `json:"events"`
+	OutboxRows int64     `json:"outbox_rows"`
+	Remaining  int64     `json:"remaining"` // still under another key or plaintext
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Error      string    `json:"error,omitempty"`
+}
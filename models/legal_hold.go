@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// Legal hold scopes.
+const (
+	HoldScopeSession  = "session"
+	HoldScopeContact  = "contact"
+	HoldScopeLocation = "location"
+)
+
+// LegalHold preserves the messages of a session, of a patient within a
+// location, or of a whole location while it is active.
+type LegalHold struct {
+	ID            string     `json:"id"`
+	Scope         string     `json:"scope"`
+	LocationID    string     `json:"location_id"`
+	SessionID     string     `json:"session_id,omitempty"`
+	ContactID     string     `json:"contact_id,omitempty"`
+	Reason        string     `json:"reason"`
+	PlacedBy      string     `json:"placed_by"`
+	PlacedAt      time.Time  `json:"placed_at"`
+	ReleasedBy    string     `json:"released_by,omitempty"`
+	ReleasedAt    *time.Time `json:"released_at,omitempty"`
+	ReleaseReason string     `json:"release_reason,omitempty"`
+}
+
+// Active reports whether the hold hasn't been released.
+func (h LegalHold) Active() bool {
+	return h.ReleasedAt == nil
+}
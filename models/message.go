@@ -16,6 +16,8 @@ type Message struct {
 	ReceiverContactID string            `json:"receiver_contact_id,omitempty"`
 	Content           string            `json:"content"`
 	SessionID         string            `json:"session_id,omitempty"`
+	Seq               int64             `json:"seq"`                         // position in the session, assigned on save
+	ClientMessageID   string            `json:"client_message_id,omitempty"` // sender-chosen idempotency key
 	SentAt            time.Time         `json:"sent_at"`
 	ReadAt            *time.Time        `json:"read_at,omitempty"`
 	DeliveredAt       *time.Time        `json:"delivered_at,omitempty"`
@@ -27,8 +29,10 @@ type Message struct {
 	EditedAt          *time.Time        `json:"edited_at,omitempty"`
 	IsPinned          bool              `json:"is_pinned"`
 	MessageType       string            `json:"message_type"`
+	IsSystem          bool              `json:"-"` // auto-replies and other server-generated messages
 	Reactions         []MessageReaction `json:"reactions"`
-	ReplyTo           *DBMessage        `json:"reply_to,omitempty"`
+	ReplyTo           *QuotedMessage    `json:"reply_to,omitempty"`
+	ReplyCount        int               `json:"reply_count"`
 }
 
 type DBMessage struct {
@@ -39,6 +43,8 @@ type DBMessage struct {
 	SenderContactID   uuid.UUID         `json:"sender_contact_id,omitempty"`
 	ReceiverContactID uuid.UUID         `json:"receiver_contact_id,omitempty"`
 	SessionID         uuid.UUID         `json:"session_id,omitempty"`
+	Seq               int64             `json:"seq"`
+	ClientMessageID   string            `json:"client_message_id,omitempty"`
 	Content           string            `json:"content"`
 	SentAt            time.Time         `json:"sent_at"`
 	ReadAt            *time.Time        `json:"read_at,omitempty"`
@@ -51,8 +57,10 @@ type DBMessage struct {
 	EditedAt          *time.Time        `json:"edited_at,omitempty"`
 	IsPinned          bool              `json:"is_pinned"`
 	MessageType       string            `json:"message_type"`
+	IsSystem          bool              `json:"-"` // auto-replies and other server-generated messages
 	Reactions         []MessageReaction `json:"reactions"`
-	ReplyTo           *DBMessage        `json:"reply_to,omitempty"`
+	ReplyTo           *QuotedMessage    `json:"reply_to,omitempty"`
+	ReplyCount        int               `json:"reply_count"`
 }
 
 type PinnedMessage struct {
@@ -63,3 +71,37 @@ type PinnedMessage struct {
 	FileType string    `json:"file_type,omitempty"`
 	SentAt   time.Time `json:"sent_at"`
 }
+
+// MessageRevision is a prior version of an edited message. Content was shown
+// from ValidFrom until it was replaced at ReplacedAt.
+type MessageRevision struct {
+	ID         string    `json:"id"`
+	MessageID  string    `json:"message_id"`
+	Revision   int       `json:"revision"`
+	Content    string    `json:"content"`
+	EditedBy   string    `json:"edited_by"`
+	ValidFrom  time.Time `json:"valid_from"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// QuotedMessage is the compact parent shown above a reply. Deleted parents
+// keep their place in the thread but lose their content.
+type QuotedMessage struct {
+	ID              string    `json:"id"`
+	SenderUserID    string    `json:"sender_user_id,omitempty"`
+	SenderContactID string    `json:"sender_contact_id,omitempty"`
+	Content         string    `json:"content,omitempty"`
+	MessageType     string    `json:"message_type"`
+	FileName        string    `json:"file_name,omitempty"`
+	SentAt          time.Time `json:"sent_at"`
+	Deleted         bool      `json:"deleted,omitempty"`
+}
+
+// ThreadPage is a root message followed by one page of its replies in the
+// order they were sent.
+type ThreadPage struct {
+	Root       DBMessage   `json:"root"`
+	Replies    []DBMessage `json:"replies"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	HasMore    bool        `json:"has_more"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// OfficeHoursInterval is an opening window in the schedule's local time,
+// formatted "15:04". An End before Start runs past midnight.
+type OfficeHoursInterval struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// OfficeHours is the weekly schedule of a doctor, or of a whole location when
+// UserID is empty. A doctor's own schedule takes precedence over the location's.
+type OfficeHours struct {
+	ID               string                           `json:"id"`
+	LocationID       string                           `json:"location_id"`
+	UserID           string                           `json:"user_id,omitempty"`
+	Timezone         string                           `json:"timezone"`
+	Weekly           map[string][]OfficeHoursInterval `json:"weekly"` // "mon" … "sun"
+	AutoReplyEnabled bool                             `json:"auto_reply_enabled"`
+	AutoReplyMessage string                           `json:"auto_reply_message,omitempty"`
+	Holidays         []OfficeHoliday                  `json:"holidays"`
+	UpdatedAt        time.Time                        `json:"updated_at"`
+}
+
+// OfficeHoliday closes a schedule for a whole local calendar day.
+type OfficeHoliday struct {
+	ID          string `json:"id"`
+	LocationID  string `json:"location_id"`
+	UserID      string `json:"user_id,omitempty"`
+	Date        string `json:"date"` // "2006-01-02"
+	Description string `json:"description,omitempty"`
+}
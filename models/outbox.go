@@ -0,0 +1,25 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Outbox event types. Each side effect of a send is its own row, so a failed
+// push is retried without delivering the message again.
+const (
+	OutboxMessageDeliver   = "message.deliver"    // live broadcast or offline queue
+	OutboxMessagePush      = "message.push"       // FCM push and push worker event
+	OutboxMessageAutoReply = "message.auto_reply" // out-of-hours auto-reply
+)
+
+// OutboxEvent is a side effect recorded in the same transaction as the change
+// that caused it and dispatched afterwards by the outbox relay.
+type OutboxEvent struct {
+	ID          int64           `json:"id"`
+	EventType   string          `json:"event_type"`
+	AggregateID string          `json:"aggregate_id"` // e.g. the message ID
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
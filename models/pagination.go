@@ -0,0 +1,24 @@
+package models
+
+// PageRequest asks for one page of a conversation. Before and After are
+// opaque cursors from a previous page; with neither set the newest page is
+// returned. BeforeSeq and AfterSeq page by sequence number instead, e.g. to
+// fill a gap after the last seq a client has seen.
+type PageRequest struct {
+	Before    string
+	After     string
+	BeforeSeq int64
+	AfterSeq  int64
+	Limit     int
+}
+
+// HistoryPage is one page of a conversation, oldest message first.
+// PrevCursor fetches older messages (pass as before=), NextCursor fetches
+// newer ones (pass as after=).
+type HistoryPage struct {
+	Messages   []DBMessage `json:"messages"`
+	PrevCursor string      `json:"prev_cursor,omitempty"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	HasOlder   bool        `json:"has_older"`
+	HasNewer   bool        `json:"has_newer"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// PresenceStatus is the resolved presence of a doctor or patient in a location.
+type PresenceStatus struct {
+	Status       string     `json:"status"` // "online", "away" or "offline"
+	LastSeen     *time.Time `json:"last_seen,omitempty"`
+	Availability string     `json:"availability"` // "available", "busy", "in_surgery", "on_call", "do_not_disturb"
+	Message      string     `json:"message,omitempty"`
+	Until        *time.Time `json:"until,omitempty"`
+
+	// Set for doctors whose office hours are closed right now
+	OutsideOfficeHours bool       `json:"outside_office_hours,omitempty"`
+	NextAvailableAt    *time.Time `json:"next_available_at,omitempty"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// Retention actions for messages past a policy's max age.
+const (
+	RetentionDelete    = "delete"
+	RetentionAnonymize = "anonymize"
+)
+
+// RetentionPolicy decides how long a location keeps messages. Nil ages keep
+// messages forever.
+type RetentionPolicy struct {
+	LocationID        string    `json:"location_id"`
+	Action            string    `json:"action"`               // RetentionDelete or RetentionAnonymize
+	MaxAgeDays        *int      `json:"max_age_days"`         // messages sent longer ago get Action
+	DeletedMaxAgeDays *int      `json:"deleted_max_age_days"` // soft-deleted messages are hard-deleted after
+	UpdatedBy         string    `json:"updated_by,omitempty"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// RetentionReport is what one purge run did in one location.
+type RetentionReport struct {
+	ID                 string     `json:"id"`
+	LocationID         string     `json:"location_id"`
+	Action             string     `json:"action"`
+	Cutoff             *time.Time `json:"cutoff,omitempty"`         // messages sent before this were purged
+	DeletedCutoff      *time.Time `json:"deleted_cutoff,omitempty"` // soft-deleted before this were hard-deleted
+	MessagesDeleted    int64      `json:"messages_deleted"`
+	MessagesAnonymized int64      `json:"messages_anonymized"`
+	ReactionsRemoved   int64      `json:"reactions_removed"`
+	RevisionsRemoved   int64      `json:"revisions_removed"`
+	AttachmentsRemoved int64      `json:"attachments_removed"`
+	FailedAttachments  []string   `json:"failed_attachments"`
+	SessionsAffected   int64      `json:"sessions_affected"`
+	Error              string     `json:"error,omitempty"`
+	StartedAt          time.Time  `json:"started_at"`
+	FinishedAt         time.Time  `json:"finished_at"`
+
+	// Filled in by the purge for the job; not stored
+	FileURLs   []string `json:"-"`
+	SessionIDs []string `json:"-"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// SearchFilter narrows a full-text message search. Zero values mean "any".
+type SearchFilter struct {
+	Query      string
+	LocationID string
+	Language   string // PostgreSQL text search configuration, e.g. "english"
+
+	// Conversation scope: the doctor/patient pair, one side of it for every
+	// conversation that doctor or patient is in, or neither for the location
+	UserID    string
+	ContactID string
+	SessionID string
+
+	SenderID      string // user or contact ID of the author
+	From          *time.Time
+	To            *time.Time
+	HasAttachment *bool
+	Pinned        *bool
+
+	Limit  int
+	Offset int
+}
+
+// SearchResult is a matching message with its relevance and a snippet in
+// which matches are wrapped in <mark> tags. The snippet is HTML-escaped.
+type SearchResult struct {
+	Message DBMessage `json:"message"`
+	Rank    float64   `json:"rank"`
+	Snippet string    `json:"snippet"`
+
+	// The doctor and patient of the match's session, for grouping
+	SessionUserID    string `json:"-"`
+	SessionContactID string `json:"-"`
+}
+
+// SessionSearchResults groups the matches of a cross-conversation search by
+// chat session. Groups are ordered by their best match.
+type SessionSearchResults struct {
+	SessionID string         `json:"session_id"`
+	UserID    string         `json:"user_id"`
+	ContactID string         `json:"contact_id"`
+	TopRank   float64        `json:"top_rank"`
+	Results   []SearchResult `json:"results"`
+}
@@ -33,6 +33,18 @@ type ChatSessionResponse struct {
 	LocationID    uuid.UUID  `json:"location_id"`
 	StartedAt     time.Time  `json:"started_at"`
 	LastMessageAt *time.Time `json:"last_message_at,omitempty"`
-	LastMessage   string     `json:"last_message,omitempty"`
-	UnreadCount   int        `json:"unread_count,omitempty"`
+	LastMessage   string     `json:"last_message,omitempty"` // preview, truncated
+	UnreadCount   int        `json:"unread_count,omitempty"` // unread by the caller
+	LastSeq       int64      `json:"last_seq"`
+
+	LastMessageID  *uuid.UUID `json:"last_message_id,omitempty"`
+	LastSenderID   *uuid.UUID `json:"last_sender_id,omitempty"`
+	LastSenderType string     `json:"last_sender_type,omitempty"` // "user" or "contact"
+	LastActivityAt *time.Time `json:"last_activity_at,omitempty"`
+
+	// Per-participant unread counts, filled in for admin listings
+	UserUnreadCount    int `json:"user_unread_count,omitempty"`
+	ContactUnreadCount int `json:"contact_unread_count,omitempty"`
+
+	CounterpartPresence *PresenceStatus `json:"counterpart_presence,omitempty"`
 }
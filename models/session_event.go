@@ -0,0 +1,50 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Session event types. Every change to a session's messages is numbered
+// with the session's next sequence number.
+const (
+	EventMessageCreated  = "message_created"
+	EventMessageEdited   = "message_edited"
+	EventMessageDeleted  = "message_deleted"
+	EventReactionAdded   = "reaction_added"
+	EventReactionRemoved = "reaction_removed"
+	EventMessagePinned   = "message_pinned"
+	EventMessageUnpinned = "message_unpinned"
+	EventMessagesRead    = "messages_read"
+
+	// A retention policy blanked the message's content and attachment
+	EventMessageAnonymized = "message_anonymized"
+)
+
+// SessionEvent is one numbered change in a session. Seq increases by exactly
+// one per event, so a client that sees a jump knows it missed something and
+// can replay from the last seq it has.
+type SessionEvent struct {
+	Type      string          `json:"type"`
+	SessionID string          `json:"session_id"`
+	Seq       int64           `json:"seq"`
+	MessageID string          `json:"message_id,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+
+	// Route the live broadcast to the session's doctor and patient
+	LocationID string `json:"-"`
+	UserID     string `json:"-"`
+	ContactID  string `json:"-"`
+
+	// Set on message_created events returned by replay, unless the message
+	// has since been deleted
+	Message *DBMessage `json:"message,omitempty"`
+}
+
+// SessionEventPage is a slice of a session's event log in seq order.
+type SessionEventPage struct {
+	Events  []SessionEvent `json:"events"`
+	LastSeq int64          `json:"last_seq"` // latest seq in the session, not just this page
+	HasMore bool           `json:"has_more"`
+}
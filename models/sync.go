@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// SyncResponse is every change in the caller's sessions since a sync token.
+// Apply it and keep SyncToken for the next call; with HasMore set, call again
+// straight away.
+type SyncResponse struct {
+	Messages          []DBMessage      `json:"messages"` // new or changed, as they are now
+	DeletedMessageIDs []string         `json:"deleted_message_ids"`
+	Reactions         []ReactionChange `json:"reactions"`
+	Sessions          []SyncSession    `json:"sessions"` // sessions with any change, and their last seq
+	SyncToken         string           `json:"sync_token"`
+	HasMore           bool             `json:"has_more"`
+}
+
+// ReactionChange is one reaction added to or removed from a message.
+type ReactionChange struct {
+	MessageID string    `json:"message_id"`
+	UserID    string    `json:"user_id"`
+	Emoji     string    `json:"emoji"`
+	Removed   bool      `json:"removed,omitempty"`
+	Seq       int64     `json:"seq"`
+	At        time.Time `json:"at"`
+}
+
+// SyncSession is the state of a session as of the returned sync token.
+type SyncSession struct {
+	ID            string     `json:"id"`
+	ContactID     string     `json:"contact_id"`
+	UserID        string     `json:"user_id"`
+	LocationID    string     `json:"location_id"`
+	StartedAt     time.Time  `json:"started_at"`
+	LastMessageAt *time.Time `json:"last_message_at,omitempty"`
+	LastSeq       int64      `json:"last_seq"`
+}
@@ -0,0 +1,161 @@
+// Package officehours evaluates weekly office-hour schedules with time zones
+// and holiday closures.
+package officehours
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"internal_chat_system/models"
+)
+
+// DefaultAutoReply is sent when a schedule enables auto-replies without
+// providing its own text.
+const DefaultAutoReply = "Thanks for your message. We're currently outside office hours and will get back to you as soon as we're available."
+
+// lookahead bounds how far NextOpen searches for the next opening.
+const lookahead = 14
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+var ErrInvalidSchedule = errors.New("invalid office hours")
+
+// Validate checks time zone, weekday keys and interval formats.
+func Validate(s models.OfficeHours) error {
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("%w: unknown timezone %q", ErrInvalidSchedule, s.Timezone)
+	}
+	for day, intervals := range s.Weekly {
+		if !isWeekday(day) {
+			return fmt.Errorf("%w: unknown weekday %q", ErrInvalidSchedule, day)
+		}
+		for _, in := range intervals {
+			start, err1 := parseClock(in.Start)
+			end, err2 := parseClock(in.End)
+			if err1 != nil || err2 != nil || start == end {
+				return fmt.Errorf("%w: bad interval %s-%s on %s", ErrInvalidSchedule, in.Start, in.End, day)
+			}
+		}
+	}
+	for _, h := range s.Holidays {
+		if _, err := time.Parse(time.DateOnly, h.Date); err != nil {
+			return fmt.Errorf("%w: bad holiday date %q", ErrInvalidSchedule, h.Date)
+		}
+	}
+	return nil
+}
+
+// IsOpen reports whether t falls inside the schedule's office hours.
+func IsOpen(s models.OfficeHours, t time.Time) bool {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		// A broken schedule must never silence doctors with auto-replies.
+		return true
+	}
+	local := t.In(loc)
+	now := minutesOfDay(local)
+
+	if !isHoliday(s, local) {
+		for _, in := range s.Weekly[weekdays[local.Weekday()]] {
+			start, end := mustClock(in.Start), mustClock(in.End)
+			if start < end && now >= start && now < end {
+				return true
+			}
+			if end < start && now >= start {
+				return true
+			}
+		}
+	}
+
+	// Overnight intervals from the previous day spill into today
+	yesterday := local.AddDate(0, 0, -1)
+	if !isHoliday(s, yesterday) {
+		for _, in := range s.Weekly[weekdays[yesterday.Weekday()]] {
+			start, end := mustClock(in.Start), mustClock(in.End)
+			if end < start && now < end {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NextOpen returns the next time the schedule opens at or after t. The second
+// result is false if nothing opens within the lookahead window.
+func NextOpen(s models.OfficeHours, t time.Time) (time.Time, bool) {
+	if IsOpen(s, t) {
+		return t, true
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return t, true
+	}
+	local := t.In(loc)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+
+	for d := 0; d <= lookahead; d++ {
+		day := midnight.AddDate(0, 0, d)
+		if isHoliday(s, day) {
+			continue
+		}
+		var next time.Time
+		for _, in := range s.Weekly[weekdays[day.Weekday()]] {
+			start := mustClock(in.Start)
+			opens := day.Add(time.Duration(start) * time.Minute)
+			if opens.After(local) && (next.IsZero() || opens.Before(next)) {
+				next = opens
+			}
+		}
+		if !next.IsZero() {
+			return next, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// AutoReply returns the text to send for a message received while closed.
+func AutoReply(s models.OfficeHours) string {
+	if strings.TrimSpace(s.AutoReplyMessage) != "" {
+		return s.AutoReplyMessage
+	}
+	return DefaultAutoReply
+}
+
+func isHoliday(s models.OfficeHours, local time.Time) bool {
+	date := local.Format(time.DateOnly)
+	for _, h := range s.Holidays {
+		if h.Date == date {
+			return true
+		}
+	}
+	return false
+}
+
+func isWeekday(day string) bool {
+	for _, d := range weekdays {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+func minutesOfDay(t time.Time) int {
+	return t.Hour()*60 + t.Minute()
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return minutesOfDay(t), nil
+}
+
+// mustClock is for schedules that already passed Validate.
+func mustClock(s string) int {
+	m, _ := parseClock(s)
+	return m
+}
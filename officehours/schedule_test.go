@@ -0,0 +1,147 @@
+package officehours
+
+import (
+	"errors"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"internal_chat_system/models"
+)
+
+// clinic opens 09:00-17:00 on weekdays in New York, runs an overnight
+// shift from Friday 22:00 to Saturday 06:00 and closes for New Year's Day.
+var clinic = models.OfficeHours{
+	Timezone: "America/New_York",
+	Weekly: map[string][]models.OfficeHoursInterval{
+		"mon": {{Start: "09:00", End: "17:00"}},
+		"tue": {{Start: "09:00", End: "17:00"}},
+		"wed": {{Start: "09:00", End: "17:00"}},
+		"thu": {{Start: "09:00", End: "17:00"}},
+		"fri": {{Start: "09:00", End: "17:00"}, {Start: "22:00", End: "06:00"}},
+	},
+	Holidays: []models.OfficeHoliday{{Date: "2025-01-01"}},
+}
+
+func at(t *testing.T, value string) time.Time {
+	t.Helper()
+	loc, err := time.LoadLocation(clinic.Timezone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := time.ParseInLocation("2006-01-02 15:04", value, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestIsOpen(t *testing.T) {
+	tests := []struct {
+		name string
+		at   string
+		want bool
+	}{
+		{"weekday morning", "2025-01-06 09:00", true},
+		{"weekday before opening", "2025-01-06 08:59", false},
+		{"closing time is closed", "2025-01-06 17:00", false},
+		{"sunday", "2025-01-05 12:00", false},
+		{"holiday", "2025-01-01 10:00", false},
+		{"overnight shift starts", "2025-01-10 23:30", true},
+		{"overnight shift spills into saturday", "2025-01-11 05:59", true},
+		{"overnight shift ends", "2025-01-11 06:00", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsOpen(clinic, at(t, tt.at)); got != tt.want {
+				t.Errorf("IsOpen(%s) = %v, want %v", tt.at, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsOpenUsesScheduleTimezone(t *testing.T) {
+	// 14:30 UTC is 09:30 in New York in January
+	if !IsOpen(clinic, time.Date(2025, 1, 6, 14, 30, 0, 0, time.UTC)) {
+		t.Error("schedule evaluated outside its own time zone")
+	}
+}
+
+func TestIsOpenBrokenTimezone(t *testing.T) {
+	broken := clinic
+	broken.Timezone = "Nowhere/Special"
+	if !IsOpen(broken, time.Now()) {
+		t.Error("a schedule with an unknown time zone must count as open")
+	}
+}
+
+func TestNextOpen(t *testing.T) {
+	tests := []struct {
+		name string
+		at   string
+		want string
+	}{
+		{"already open", "2025-01-06 10:00", "2025-01-06 10:00"},
+		{"before opening", "2025-01-06 07:15", "2025-01-06 09:00"},
+		{"after closing", "2025-01-06 18:00", "2025-01-07 09:00"},
+		{"friday evening waits for the night shift", "2025-01-10 18:00", "2025-01-10 22:00"},
+		{"weekend", "2025-01-11 12:00", "2025-01-13 09:00"},
+		{"skips the holiday", "2024-12-31 18:00", "2025-01-02 09:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := NextOpen(clinic, at(t, tt.at))
+			if !ok || !got.Equal(at(t, tt.want)) {
+				t.Errorf("NextOpen(%s) = %s, %v, want %s", tt.at, got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextOpenNeverOpens(t *testing.T) {
+	closed := models.OfficeHours{Timezone: "UTC"}
+	if next, ok := NextOpen(closed, time.Now()); ok {
+		t.Errorf("NextOpen of an empty schedule = %s, want none", next)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule models.OfficeHours
+		valid    bool
+	}{
+		{"clinic", clinic, true},
+		{"unknown timezone", models.OfficeHours{Timezone: "Mars/Olympus"}, false},
+		{"unknown weekday", models.OfficeHours{Timezone: "UTC", Weekly: map[string][]models.OfficeHoursInterval{
+			"funday": {{Start: "09:00", End: "17:00"}},
+		}}, false},
+		{"bad clock", models.OfficeHours{Timezone: "UTC", Weekly: map[string][]models.OfficeHoursInterval{
+			"mon": {{Start: "9am", End: "17:00"}},
+		}}, false},
+		{"empty interval", models.OfficeHours{Timezone: "UTC", Weekly: map[string][]models.OfficeHoursInterval{
+			"mon": {{Start: "09:00", End: "09:00"}},
+		}}, false},
+		{"bad holiday", models.OfficeHours{Timezone: "UTC", Holidays: []models.OfficeHoliday{{Date: "01/01/2025"}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.schedule)
+			if tt.valid && err != nil {
+				t.Errorf("Validate = %v, want nil", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidSchedule) {
+				t.Errorf("Validate = %v, want ErrInvalidSchedule", err)
+			}
+		})
+	}
+}
+
+func TestAutoReply(t *testing.T) {
+	if got := AutoReply(models.OfficeHours{AutoReplyMessage: "  "}); got != DefaultAutoReply {
+		t.Errorf("blank message sends %q, want the default", got)
+	}
+	if got := AutoReply(models.OfficeHours{AutoReplyMessage: "Back Monday"}); got != "Back Monday" {
+		t.Errorf("AutoReply = %q", got)
+	}
+}
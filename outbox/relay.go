@@ -0,0 +1,76 @@
+// Package outbox relays side effects that were recorded in the outbox table
+// alongside the database change that caused them.
+package outbox
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"internal_chat_system/models"
+	"internal_chat_system/repository"
+)
+
+const (
+	defaultInterval  = time.Second
+	defaultBatchSize = 50
+)
+
+// wake lets writers on this instance skip the wait for the next poll.
+var wake = make(chan struct{}, 1)
+
+// Wake asks the relay to check the outbox now. It never blocks.
+func Wake() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// Relay polls the outbox and hands each pending event to Handle. Events are
+// delivered at least once: a crash between handling and marking an event
+// dispatched means it is handled again once its lease runs out. Handle runs
+// outside any database transaction.
+type Relay struct {
+	Repo      repository.OutboxStore
+	Handle    func(context.Context, models.OutboxEvent) error
+	Interval  time.Duration
+	BatchSize int
+}
+
+// Run dispatches events until ctx is done.
+func (r *Relay) Run(ctx context.Context) {
+	interval, batch := r.Interval, r.BatchSize
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	if batch <= 0 {
+		batch = defaultBatchSize
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Println("📮 Outbox relay started")
+	for {
+		// Drain full batches before waiting again
+		for {
+			n, err := r.Repo.Dispatch(ctx, batch, r.Handle)
+			if err != nil {
+				log.Printf("❌ Outbox dispatch failed: %v", err)
+				break
+			}
+			if n < batch {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("📪 Outbox relay stopped")
+			return
+		case <-ticker.C:
+		case <-wake:
+		}
+	}
+}
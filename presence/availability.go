@@ -0,0 +1,105 @@
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"internal_chat_system/models"
+)
+
+const (
+	AvailabilityAvailable    = "available"
+	AvailabilityBusy         = "busy"
+	AvailabilityInSurgery    = "in_surgery"
+	AvailabilityOnCall       = "on_call"
+	AvailabilityDoNotDisturb = "do_not_disturb"
+)
+
+var validAvailability = map[string]bool{
+	AvailabilityAvailable:    true,
+	AvailabilityBusy:         true,
+	AvailabilityInSurgery:    true,
+	AvailabilityOnCall:       true,
+	AvailabilityDoNotDisturb: true,
+}
+
+var ErrInvalidAvailability = errors.New("invalid availability")
+
+// Availability is a status chosen by the participant, independent of whether
+// they are connected. It lapses automatically once Until has passed.
+type Availability struct {
+	State   string     `json:"state"`
+	Message string     `json:"message,omitempty"`
+	Until   *time.Time `json:"until,omitempty"`
+}
+
+// availabilityKey holds the participant's chosen Availability as JSON and
+// expires when the availability does.
+func availabilityKey(p Participant) string {
+	return fmt.Sprintf("presence:availability:%s:%s:%s", p.LocationID, p.Kind, p.ID)
+}
+
+// SetAvailability stores the participant's availability and notifies watchers.
+// Setting AvailabilityAvailable without a message clears any previous state.
+func SetAvailability(ctx context.Context, p Participant, a Availability) error {
+	if !validAvailability[a.State] {
+		return ErrInvalidAvailability
+	}
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if a.State == AvailabilityAvailable && a.Message == "" {
+		if err := rdb.Del(ctx, availabilityKey(p)).Err(); err != nil {
+			return err
+		}
+		publish(ctx, p)
+		return nil
+	}
+
+	var ttl time.Duration
+	if a.Until != nil {
+		ttl = time.Until(*a.Until)
+		if ttl <= 0 {
+			return fmt.Errorf("%w: until is in the past", ErrInvalidAvailability)
+		}
+	}
+
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	if err := rdb.Set(ctx, availabilityKey(p), data, ttl).Err(); err != nil {
+		return err
+	}
+	publish(ctx, p)
+	return nil
+}
+
+// applyAvailability copies a stored availability into the status, defaulting
+// to available when nothing is set.
+func applyAvailability(status *models.PresenceStatus, raw string) {
+	status.Availability = AvailabilityAvailable
+	if raw == "" {
+		return
+	}
+	var a Availability
+	if err := json.Unmarshal([]byte(raw), &a); err != nil || !validAvailability[a.State] {
+		return
+	}
+	status.Availability = a.State
+	status.Message = a.Message
+	status.Until = a.Until
+}
+
+// ShouldNotify decides whether a push notification should interrupt the
+// participant. Online participants see messages live, and do-not-disturb or
+// in-surgery suppress pushes entirely.
+func ShouldNotify(status models.PresenceStatus) bool {
+	if status.Availability == AvailabilityDoNotDisturb || status.Availability == AvailabilityInSurgery {
+		return false
+	}
+	return status.Status != StatusOnline
+}
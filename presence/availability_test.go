@@ -0,0 +1,63 @@
+package presence
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"internal_chat_system/models"
+)
+
+func TestApplyAvailability(t *testing.T) {
+	until := time.Date(2025, 3, 1, 14, 0, 0, 0, time.UTC)
+	stored := func(a Availability) string {
+		raw, _ := json.Marshal(a)
+		return string(raw)
+	}
+
+	tests := []struct {
+		name        string
+		raw         string
+		wantState   string
+		wantMessage string
+		wantUntil   bool
+	}{
+		{"nothing set", "", AvailabilityAvailable, "", false},
+		{"in surgery until two", stored(Availability{State: AvailabilityInSurgery, Message: "back at 2", Until: &until}), AvailabilityInSurgery, "back at 2", true},
+		{"on call", stored(Availability{State: AvailabilityOnCall}), AvailabilityOnCall, "", false},
+		{"unknown state", stored(Availability{State: "napping", Message: "zzz"}), AvailabilityAvailable, "", false},
+		{"corrupt value", "{not json", AvailabilityAvailable, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var status models.PresenceStatus
+			applyAvailability(&status, tt.raw)
+			if status.Availability != tt.wantState || status.Message != tt.wantMessage || (status.Until != nil) != tt.wantUntil {
+				t.Errorf("applyAvailability(%q) = %+v", tt.raw, status)
+			}
+		})
+	}
+}
+
+func TestShouldNotify(t *testing.T) {
+	tests := []struct {
+		status       string
+		availability string
+		want         bool
+	}{
+		{StatusOffline, AvailabilityAvailable, true},
+		{StatusAway, AvailabilityBusy, true},
+		{StatusOffline, AvailabilityOnCall, true},
+		{StatusOnline, AvailabilityAvailable, false},
+		{StatusOffline, AvailabilityDoNotDisturb, false},
+		{StatusOffline, AvailabilityInSurgery, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.status+"/"+tt.availability, func(t *testing.T) {
+			got := ShouldNotify(models.PresenceStatus{Status: tt.status, Availability: tt.availability})
+			if got != tt.want {
+				t.Errorf("ShouldNotify = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+
+	"internal_chat_system/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// eventsChannel carries presence transitions between server instances.
+const eventsChannel = "presence:events"
+
+// Event is a presence transition pushed to subscribed WebSocket clients.
+type Event struct {
+	Type       string `json:"type"` // always "presence"
+	LocationID string `json:"location_id"`
+	Kind       string `json:"kind"`
+	ID         string `json:"id"`
+	models.PresenceStatus
+}
+
+// Participant returns the participant the event is about.
+func (e Event) Participant() Participant {
+	return Participant{LocationID: e.LocationID, Kind: e.Kind, ID: e.ID}
+}
+
+// NewEvent builds the event announcing a participant's current status.
+func NewEvent(p Participant, status models.PresenceStatus) Event {
+	return Event{
+		Type:           "presence",
+		LocationID:     p.LocationID,
+		Kind:           p.Kind,
+		ID:             p.ID,
+		PresenceStatus: status,
+	}
+}
+
+// publish announces the participant's freshly resolved status to every instance.
+// It runs after the change it announces, so it outlives a cancelled caller.
+func publish(ctx context.Context, p Participant) {
+	ctx, cancel := withTimeout(context.WithoutCancel(ctx))
+	defer cancel()
+
+	status, err := Get(ctx, p)
+	if err != nil {
+		log.Printf("⚠️ Failed to resolve presence for event: %v", err)
+		return
+	}
+	data, err := json.Marshal(NewEvent(p, status))
+	if err != nil {
+		return
+	}
+	if err := rdb.Publish(ctx, eventsChannel, data).Err(); err != nil {
+		log.Printf("⚠️ Failed to publish presence event: %v", err)
+	}
+}
+
+// Watch delivers every presence transition in the cluster to onEvent. Besides
+// explicit connect/disconnect events it listens for expiry of online and
+// availability keys, so participants whose server instance died without a
+// clean disconnect are still reported offline, and timed statuses such as
+// "in surgery until 14:00" lapse visibly. Watch blocks until ctx is cancelled.
+func Watch(ctx context.Context, onEvent func(Event)) {
+	// Expiry notifications are off by default; managed Redis may refuse
+	// CONFIG, in which case it has to be enabled on the server side.
+	if err := rdb.ConfigSet(ctx, "notify-keyspace-events", "Ex").Err(); err != nil {
+		log.Printf("⚠️ Could not enable keyspace expiry notifications: %v", err)
+	}
+
+	pubsub := rdb.PSubscribe(ctx, eventsChannel, "__keyevent@*__:expired")
+	defer pubsub.Close()
+	log.Printf("📡 Watching presence events on %s", eventsChannel)
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			if event, ok := parseEvent(ctx, msg); ok {
+				onEvent(event)
+			}
+		}
+	}
+}
+
+func parseEvent(ctx context.Context, msg *redis.Message) (Event, bool) {
+	if msg.Channel == eventsChannel {
+		var event Event
+		if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+			log.Printf("⚠️ Invalid presence event: %v", err)
+			return Event{}, false
+		}
+		return event, true
+	}
+
+	// Expired key payloads are key names: presence:<kind of key>:<location>:<kind>:<id>
+	var rest string
+	var ok bool
+	for _, prefix := range []string{"presence:online:", "presence:availability:"} {
+		if rest, ok = strings.CutPrefix(msg.Payload, prefix); ok {
+			break
+		}
+	}
+	if !ok {
+		return Event{}, false
+	}
+	parts := strings.SplitN(rest, ":", 3)
+	if len(parts) != 3 {
+		return Event{}, false
+	}
+	p := Participant{LocationID: parts[0], Kind: parts[1], ID: parts[2]}
+	status, err := Get(ctx, p)
+	if err != nil {
+		log.Printf("⚠️ Failed to resolve presence after expiry: %v", err)
+		return Event{}, false
+	}
+	return NewEvent(p, status), true
+}
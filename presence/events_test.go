@@ -0,0 +1,50 @@
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"internal_chat_system/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestParseEvent(t *testing.T) {
+	doctor := User("loc-1", "doc-1")
+	published, err := json.Marshal(NewEvent(doctor, models.PresenceStatus{Status: StatusOnline, Availability: AvailabilityBusy}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		msg     redis.Message
+		wantOK  bool
+		wantFor Participant
+	}{
+		{"published transition", redis.Message{Channel: eventsChannel, Payload: string(published)}, true, doctor},
+		{"broken payload", redis.Message{Channel: eventsChannel, Payload: "{"}, false, Participant{}},
+		{"unrelated expired key", redis.Message{Channel: "__keyevent@0__:expired", Payload: "history:cache:abc"}, false, Participant{}},
+		{"malformed presence key", redis.Message{Channel: "__keyevent@0__:expired", Payload: "presence:online:loc-1"}, false, Participant{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, ok := parseEvent(context.Background(), &tt.msg)
+			if ok != tt.wantOK {
+				t.Fatalf("parseEvent ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && event.Participant() != tt.wantFor {
+				t.Errorf("event is about %+v, want %+v", event.Participant(), tt.wantFor)
+			}
+		})
+	}
+}
+
+func TestNewEvent(t *testing.T) {
+	patient := Contact("loc-1", "pat-1")
+	event := NewEvent(patient, models.PresenceStatus{Status: StatusAway})
+	if event.Type != "presence" || event.Kind != KindContact || event.Status != StatusAway {
+		t.Errorf("NewEvent = %+v", event)
+	}
+}
@@ -3,52 +3,283 @@ package presence
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"time"
 
+	"internal_chat_system/models"
+
 	"github.com/redis/go-redis/v9"
 )
 
 var rdb *redis.Client // 👈 define redis client here
 
+// DefaultTimeout is how long a single presence call may take unless
+// SetTimeout changes it.
+const DefaultTimeout = 2 * time.Second
+
+// opTimeout bounds each presence call on top of the caller's context.
+var opTimeout = DefaultTimeout
+
+const (
+	KindUser    = "user"
+	KindContact = "contact"
+
+	StatusOnline  = "online"
+	StatusAway    = "away"
+	StatusOffline = "offline"
+
+	// onlineTTL bounds how long a participant stays online without a
+	// heartbeat, so crashed instances don't leave ghosts behind.
+	onlineTTL = 60 * time.Second
+	// lastSeenTTL keeps last-seen timestamps around long enough to be useful
+	// without growing Redis forever.
+	lastSeenTTL = 30 * 24 * time.Hour
+)
+
+// Participant identifies a doctor (user) or patient (contact) within a location.
+// Presence is always scoped to a location: the same person can be online in
+// one clinic and offline in another.
+type Participant struct {
+	LocationID string
+	Kind       string // KindUser or KindContact
+	ID         string
+}
+
 // Init initializes the Redis client used for presence tracking
 func Init(redisClient *redis.Client) {
 	rdb = redisClient
 }
 
-func MarkUserOnline(userID, contactID, locationID string) {
-	ctx := context.Background()
+// SetTimeout changes how long a single presence call may take. Zero keeps
+// the current value.
+func SetTimeout(d time.Duration) {
+	if d > 0 {
+		opTimeout = d
+	}
+}
+
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, opTimeout)
+}
+
+func User(locationID, userID string) Participant {
+	return Participant{LocationID: locationID, Kind: KindUser, ID: userID}
+}
+
+func Contact(locationID, contactID string) Participant {
+	return Participant{LocationID: locationID, Kind: KindContact, ID: contactID}
+}
+
+// onlineKey holds the participant's live connections: a sorted set of
+// connection IDs, each scored with the unix time it lapses at unless a
+// heartbeat refreshes it. The key itself expires with its newest member, so
+// it exists exactly while some connection is live.
+func onlineKey(p Participant) string {
+	return fmt.Sprintf("presence:online:%s:%s:%s", p.LocationID, p.Kind, p.ID)
+}
+
+// lapsesAt scores a connection refreshed now.
+func lapsesAt(now time.Time) float64 {
+	return float64(now.Add(onlineTTL).Unix())
+}
+
+// lapsed bounds the scores of connections whose instance stopped sending
+// heartbeats, e.g. because it crashed.
+func lapsed(now time.Time) string {
+	return strconv.FormatInt(now.Unix(), 10)
+}
+
+// awayKey is present while a connected participant's clients report no
+// user activity. It shares onlineTTL so it never outlives the connection.
+func awayKey(p Participant) string {
+	return fmt.Sprintf("presence:away:%s:%s:%s", p.LocationID, p.Kind, p.ID)
+}
+
+// lastSeenKey holds the unix timestamp of the participant's last activity.
+func lastSeenKey(p Participant) string {
+	return fmt.Sprintf("presence:last_seen:%s:%s:%s", p.LocationID, p.Kind, p.ID)
+}
+
+// Connect registers a new connection for the participant. connID must be
+// unique to the connection and passed again to Heartbeat and Disconnect.
+func Connect(ctx context.Context, p Participant, connID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
 
-	if userID != "" {
-		key := fmt.Sprintf("online:user:%s", userID)
-		rdb.Set(ctx, key, "1", time.Minute)
-		rdb.Set(ctx, fmt.Sprintf("last_seen:user:%s", userID), time.Now().Unix(), 0)
+	now := time.Now()
+	var conns *redis.IntCmd
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, onlineKey(p), "-inf", lapsed(now))
+		pipe.ZAdd(ctx, onlineKey(p), redis.Z{Score: lapsesAt(now), Member: connID})
+		pipe.Expire(ctx, onlineKey(p), onlineTTL)
+		conns = pipe.ZCard(ctx, onlineKey(p))
+		pipe.Del(ctx, awayKey(p))
+		pipe.Set(ctx, lastSeenKey(p), now.Unix(), lastSeenTTL)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
+	if conns.Val() == 1 {
+		publish(ctx, p)
+	}
+	return nil
+}
+
+// Heartbeat keeps the connection online. If it already lapsed (e.g. a
+// missed ping), it is added back without touching the participant's other
+// connections. Idle heartbeats move the participant to away; an active one
+// brings them back.
+func Heartbeat(ctx context.Context, p Participant, connID string, idle bool) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	now := time.Now()
+	var added, conns *redis.IntCmd
+	var awayChanged *redis.Cmd
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, onlineKey(p), "-inf", lapsed(now))
+		added = pipe.ZAdd(ctx, onlineKey(p), redis.Z{Score: lapsesAt(now), Member: connID})
+		pipe.Expire(ctx, onlineKey(p), onlineTTL)
+		conns = pipe.ZCard(ctx, onlineKey(p))
+		if idle {
+			awayChanged = pipe.Do(ctx, "SET", awayKey(p), 1, "EX", int(onlineTTL.Seconds()), "GET")
+		} else {
+			awayChanged = pipe.Do(ctx, "GETDEL", awayKey(p))
+			pipe.Set(ctx, lastSeenKey(p), now.Unix(), lastSeenTTL)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	// The participant only went offline if this was their sole connection
+	revived := added.Val() == 1 && conns.Val() == 1
+	// SET ... GET returns nil when the participant wasn't away yet, and
+	// GETDEL returns nil when they weren't away to begin with.
+	wasAway := awayChanged.Err() != redis.Nil
+	if revived || idle != wasAway {
+		publish(ctx, p)
+	}
+	return nil
+}
+
+// Disconnect releases one connection. The participant goes offline once
+// their last live connection is gone.
+func Disconnect(ctx context.Context, p Participant, connID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
 
-	if contactID != "" {
-		key := fmt.Sprintf("online:contact:%s", contactID)
-		rdb.Set(ctx, key, "1", time.Minute)
-		rdb.Set(ctx, fmt.Sprintf("last_seen:contact:%s", contactID), time.Now().Unix(), 0)
+	now := time.Now()
+	var removed, pruned, conns *redis.IntCmd
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		removed = pipe.ZRem(ctx, onlineKey(p), connID)
+		pruned = pipe.ZRemRangeByScore(ctx, onlineKey(p), "-inf", lapsed(now))
+		conns = pipe.ZCard(ctx, onlineKey(p))
+		pipe.Set(ctx, lastSeenKey(p), now.Unix(), lastSeenTTL)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if conns.Val() > 0 {
+		return nil
+	}
+	if err := rdb.Del(ctx, onlineKey(p), awayKey(p)).Err(); err != nil {
+		return err
 	}
+	// Nothing removed means the key had already expired and the expiry
+	// listener has announced the participant as offline.
+	if removed.Val() > 0 || pruned.Val() > 0 {
+		publish(ctx, p)
+	}
+	return nil
 }
 
-func MarkUserOffline(userID, contactID, locationID string) {
-	ctx := context.Background()
+// IsOnline reports whether the participant has at least one live connection.
+func IsOnline(ctx context.Context, p Participant) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
 
-	if userID != "" {
-		rdb.Del(ctx, fmt.Sprintf("online:user:%s", userID))
+	n, err := rdb.Exists(ctx, onlineKey(p)).Result()
+	if err != nil {
+		return false, err
 	}
-	if contactID != "" {
-		rdb.Del(ctx, fmt.Sprintf("online:contact:%s", contactID))
+	return n > 0, nil
+}
+
+// Get resolves the participant's online state, last-seen time and availability.
+func Get(ctx context.Context, p Participant) (models.PresenceStatus, error) {
+	statuses, err := GetMany(ctx, []Participant{p})
+	if err != nil {
+		return models.PresenceStatus{}, err
 	}
+	return statuses[p], nil
+}
+
+// GetMany resolves many participants in a single pipelined round trip, e.g.
+// for every row of a session list.
+func GetMany(ctx context.Context, participants []Participant) (map[Participant]models.PresenceStatus, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	type lookup struct {
+		online, away           *redis.IntCmd
+		lastSeen, availability *redis.StringCmd
+	}
+	lookups := make(map[Participant]lookup, len(participants))
+
+	pipe := rdb.Pipeline()
+	for _, p := range participants {
+		if _, ok := lookups[p]; ok {
+			continue
+		}
+		lookups[p] = lookup{
+			online:       pipe.Exists(ctx, onlineKey(p)),
+			away:         pipe.Exists(ctx, awayKey(p)),
+			lastSeen:     pipe.Get(ctx, lastSeenKey(p)),
+			availability: pipe.Get(ctx, availabilityKey(p)),
+		}
+	}
+	if len(lookups) == 0 {
+		return map[Participant]models.PresenceStatus{}, nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	statuses := make(map[Participant]models.PresenceStatus, len(lookups))
+	for p, l := range lookups {
+		status := models.PresenceStatus{Status: StatusOffline}
+		if l.online.Val() > 0 {
+			status.Status = StatusOnline
+			if l.away.Val() > 0 {
+				status.Status = StatusAway
+			}
+		}
+		if ts, err := strconv.ParseInt(l.lastSeen.Val(), 10, 64); err == nil {
+			t := time.Unix(ts, 0).UTC()
+			status.LastSeen = &t
+		}
+		applyAvailability(&status, l.availability.Val())
+		statuses[p] = status
+	}
+	return statuses, nil
+}
+
+// Redis serves presence from the client set by Init. It satisfies
+// handlers.PresenceStore.
+type Redis struct{}
+
+func (Redis) Get(ctx context.Context, p Participant) (models.PresenceStatus, error) {
+	return Get(ctx, p)
 }
 
-func IsUserOnline(userID string) bool {
-	ctx := context.Background()
-	val, err := rdb.Get(ctx, fmt.Sprintf("online:user:%s", userID)).Result()
-	return err == nil && val == "1"
+func (Redis) GetMany(ctx context.Context, participants []Participant) (map[Participant]models.PresenceStatus, error) {
+	return GetMany(ctx, participants)
 }
 
-func GetLastSeen(userID string) (int64, error) {
-	ctx := context.Background()
-	return rdb.Get(ctx, fmt.Sprintf("last_seen:user:%s", userID)).Int64()
+func (Redis) SetAvailability(ctx context.Context, p Participant, a Availability) error {
+	return SetAvailability(ctx, p, a)
 }
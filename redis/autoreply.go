@@ -0,0 +1,36 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ClaimAutoReply reserves the single out-of-hours auto-reply a session gets
+// until ttl elapses, normally until office hours reopen. It returns false if
+// another message already triggered the reply.
+func ClaimAutoReply(ctx context.Context, sessionID string, ttl time.Duration) bool {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	key := fmt.Sprintf("autoreply:session:%s", sessionID)
+	ok, err := rdb.SetNX(ctx, key, time.Now().Unix(), ttl).Result()
+	if err != nil {
+		log.Printf("⚠️ Failed to claim auto-reply for session %s: %v", sessionID, err)
+		return false
+	}
+	return ok
+}
+
+// ReleaseAutoReply gives back a claim whose reply could not be sent, so a
+// retry can claim it again.
+func ReleaseAutoReply(ctx context.Context, sessionID string) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	key := fmt.Sprintf("autoreply:session:%s", sessionID)
+	if err := rdb.Del(ctx, key).Err(); err != nil {
+		log.Printf("⚠️ Failed to release auto-reply claim for session %s: %v", sessionID, err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"time"
 
 	"internal_chat_system/models"
 	"internal_chat_system/ws"
@@ -13,9 +14,27 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
-var ctx = context.Background()
 var rdb *redis.Client
 
+// DefaultTimeout is how long a single Redis command may take unless
+// SetTimeout changes it.
+const DefaultTimeout = 2 * time.Second
+
+// opTimeout bounds each Redis command on top of the caller's context.
+var opTimeout = DefaultTimeout
+
+// SetTimeout changes how long a single Redis command may take. Zero keeps
+// the current value.
+func SetTimeout(d time.Duration) {
+	if d > 0 {
+		opTimeout = d
+	}
+}
+
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, opTimeout)
+}
+
 func Init(addr, password string, db int) {
 	rdb = redis.NewClient(&redis.Options{
 		Addr:     addr,
@@ -24,7 +43,16 @@ func Init(addr, password string, db int) {
 	})
 }
 
-func Publish(locationID string, msg models.Message) {
+// Client exposes the shared Redis client to packages that can't import this
+// one without an import cycle (e.g. presence, which ws depends on).
+func Client() *redis.Client {
+	return rdb
+}
+
+func Publish(ctx context.Context, locationID string, msg models.Message) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	channel := "chat:" + locationID
 	data, _ := json.Marshal(msg)
 	if err := rdb.Publish(ctx, channel, data).Err(); err != nil {
@@ -32,10 +60,16 @@ func Publish(locationID string, msg models.Message) {
 	}
 }
 
-func Subscribe(locationID string, hub *ws.Hub) {
+// Subscribe forwards a location's messages to the hub until ctx is done.
+func Subscribe(ctx context.Context, locationID string, hub *ws.Hub) {
 	channel := "chat:" + locationID
 	pubsub := rdb.Subscribe(ctx, channel)
 
+	go func() {
+		<-ctx.Done()
+		pubsub.Close()
+	}()
+
 	go func() {
 		ch := pubsub.Channel()
 		for msg := range ch {
@@ -50,9 +84,27 @@ func Subscribe(locationID string, hub *ws.Hub) {
 	}()
 }
 
-func QueueOfflineMessage(recipientType, locationID, recipientID string, msg []byte) error {
-	key := fmt.Sprintf("offline_queue:%s:%s:%s", recipientType, locationID, recipientID)
-	if err := rdb.RPush(ctx, key, msg).Err(); err != nil {
+// OfflineQueueTTL is how long a recipient's offline queue is kept after the
+// last message was queued. Someone away for longer catches up through
+// history and sync instead.
+const OfflineQueueTTL = 7 * 24 * time.Hour
+
+func offlineQueueKey(recipientType, locationID, recipientID string) string {
+	return fmt.Sprintf("offline_queue:%s:%s:%s", recipientType, locationID, recipientID)
+}
+
+// QueueOfflineMessage queues a message for a recipient who isn't connected.
+// Only its id is queued, so Redis never holds message content; the message
+// is read back from the store when the recipient connects.
+func QueueOfflineMessage(ctx context.Context, recipientType, locationID, recipientID, messageID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	key := offlineQueueKey(recipientType, locationID, recipientID)
+	pipe := rdb.TxPipeline()
+	pipe.RPush(ctx, key, messageID)
+	pipe.Expire(ctx, key, OfflineQueueTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
 		log.Printf("❌ Failed to queue message in Redis: %v", err)
 		return err
 	}
@@ -60,10 +112,15 @@ func QueueOfflineMessage(recipientType, locationID, recipientID string, msg []by
 	return nil
 }
 
-func FlushQueuedMessages(recipientType, locationID, recipientID string, onDeliver func([]string)) ([][]byte, error) {
+// FlushQueuedMessages empties a recipient's offline queue and returns the
+// ids of the queued messages, oldest first.
+func FlushQueuedMessages(ctx context.Context, recipientType, locationID, recipientID string) ([]string, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	log.Printf("📦 Checking offline messages for %s:%s in location %s", recipientType, recipientID, locationID)
-	key := fmt.Sprintf("offline_queue:%s:%s:%s", recipientType, locationID, recipientID)
-	msgs, err := rdb.LRange(ctx, key, 0, -1).Result()
+	key := offlineQueueKey(recipientType, locationID, recipientID)
+	entries, err := rdb.LRange(ctx, key, 0, -1).Result()
 	if err != nil {
 		log.Printf("❌ Failed to fetch offline messages: %v", err)
 		return nil, err
@@ -73,24 +130,22 @@ func FlushQueuedMessages(recipientType, locationID, recipientID string, onDelive
 		log.Printf("⚠️ Failed to delete offline queue after flush: %v", err)
 	}
 
-	var result [][]byte
-	var deliveredIDs []string
-	for _, msg := range msgs {
-		result = append(result, []byte(msg))
-		var parsed struct {
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		// Entries queued before ids were queued hold the whole message
+		var queued struct {
 			ID string `json:"id"`
 		}
-		if err := json.Unmarshal([]byte(msg), &parsed); err == nil && parsed.ID != "" {
-			deliveredIDs = append(deliveredIDs, parsed.ID)
+		if json.Unmarshal([]byte(entry), &queued) == nil {
+			entry = queued.ID
+		}
+		if entry != "" {
+			ids = append(ids, entry)
 		}
 	}
 
-	log.Printf("📤 Flushed %d offline messages for key %s", len(result), key)
-	if onDeliver != nil && len(deliveredIDs) > 0 {
-		onDeliver(deliveredIDs)
-	}
-	log.Printf("✅ Delivered %d offline message(s) to %s:%s", len(result), recipientType, recipientID)
-	return result, nil
+	log.Printf("📤 Flushed %d offline messages for key %s", len(ids), key)
+	return ids, nil
 }
 
 func IsClientConnected(locationID, recipientID string, clients map[string]map[*ws.Client]bool) bool {
@@ -104,15 +159,21 @@ func IsClientConnected(locationID, recipientID string, clients map[string]map[*w
 	return false
 }
 
+// PushEvent tells push workers a message arrived. It carries no content:
+// Redis pub/sub is not a place for it, and workers that show a preview
+// read the message by id.
 type PushEvent struct {
 	MessageID    string `json:"message_id"`
 	LocationID   string `json:"location_id"`
-	ReceiverID   string `json:"receiver_id"`   // can be user or contact
-	ReceiverType string `json:"receiver_type"` // user or contact
-	Content      string `json:"content"`
+	ReceiverID   string `json:"receiver_id"`      // can be user or contact
+	ReceiverType string `json:"receiver_type"`    // user or contact
+	Silent       bool   `json:"silent,omitempty"` // receiver is in do-not-disturb; update badges only
 }
 
-func PublishPushEvent(event PushEvent) error {
+func PublishPushEvent(ctx context.Context, event PushEvent) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	data, err := json.Marshal(event)
 	if err != nil {
 		return err
@@ -0,0 +1,31 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+func stickyKey(caller string) string {
+	return fmt.Sprintf("replica:sticky:%s", caller)
+}
+
+// StickyWrites keeps callers' read-your-writes windows in Redis, so every
+// instance sends a caller who just wrote to the primary. It implements
+// repository.WriteMarks.
+type StickyWrites struct{}
+
+// MarkWrite starts or extends the caller's window.
+func (StickyWrites) MarkWrite(ctx context.Context, caller string, window time.Duration) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	return rdb.Set(ctx, stickyKey(caller), 1, window).Err()
+}
+
+// WroteRecently reports whether the caller's window is still open.
+func (StickyWrites) WroteRecently(ctx context.Context, caller string) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	n, err := rdb.Exists(ctx, stickyKey(caller)).Result()
+	return n > 0, err
+}
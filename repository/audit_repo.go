@@ -0,0 +1,283 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"internal_chat_system/models"
+)
+
+// maxAuditEntries bounds one page of an audit log query.
+const maxAuditEntries = 500
+
+type AuditRepo struct {
+	DB *sql.DB
+}
+
+func NewAuditRepo(db *sql.DB) *AuditRepo {
+	return &AuditRepo{DB: db}
+}
+
+const (
+	// Entries wait in audit_queue until audit_chain_pending chains them;
+	// seq, prev_hash and hash are set by the audit_log_chain trigger then
+	queryQueueAuditEntry = `
+		INSERT INTO audit_queue (location_id, actor_id, actor_type, action, target_type, target_id, ip, details)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING created_at
+	`
+
+	queryChainPendingAudit = `SELECT audit_chain_pending($1)`
+
+	auditEntryColumns = `id, location_id, seq, actor_id, actor_type, action, target_type, target_id, ip,
+		details, created_at, prev_hash, hash`
+
+	queryListAuditEntriesBase = `
+		SELECT ` + auditEntryColumns + `
+		FROM audit_log
+		WHERE location_id = $1
+	`
+
+	// Each entry with the hash it should have, recomputed from its fields
+	queryAuditChain = `
+		SELECT seq, prev_hash, hash,
+			audit_entry_hash(prev_hash, seq, location_id, actor_id, actor_type, action,
+				target_type, target_id, ip, details, created_at)
+		FROM audit_log
+		WHERE location_id = $1
+		ORDER BY seq
+	`
+
+	queryAuditChainHead = `SELECT seq, hash FROM audit_chain_heads WHERE location_id = $1`
+)
+
+// Record queues an entry and sets its time. It joins its location's chain,
+// and gets its ID, seq and hashes, when ChainPending next runs. Queueing
+// takes no locks, so audited reads don't wait on each other. Reads are
+// audited too, so unlike other writes this doesn't send the caller's next
+// reads to the primary.
+func (r *AuditRepo) Record(ctx context.Context, e *models.AuditEntry) (err error) {
+	ctx, end := beginOp(ctx, timeouts.Write)
+	defer end(&err)
+
+	return queueAudit(ctx, r.DB, e)
+}
+
+// rowQuerier is a *sql.DB or *sql.Tx.
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// queueAudit queues e through db, which may be the transaction of the
+// change it records.
+func queueAudit(ctx context.Context, db rowQuerier, e *models.AuditEntry) error {
+	var details any
+	if len(e.Details) > 0 {
+		details = []byte(e.Details)
+	}
+	err := db.QueryRowContext(ctx, queryQueueAuditEntry,
+		e.LocationID, e.ActorID, e.ActorType, e.Action, e.TargetType, e.TargetID, e.IP, details,
+	).Scan(&e.CreatedAt)
+	if err != nil {
+		log.Printf("❌ Failed to write audit entry %s by %s: %v", e.Action, e.ActorID, err)
+	}
+	return err
+}
+
+// ChainPending chains up to limit queued entries, oldest first, and
+// returns how many it chained.
+func (r *AuditRepo) ChainPending(ctx context.Context, limit int) (n int, err error) {
+	ctx, end := beginOp(ctx, timeouts.Write)
+	defer end(&err)
+
+	err = r.DB.QueryRowContext(ctx, queryChainPendingAudit, limit).Scan(&n)
+	return n, err
+}
+
+type auditContextKey struct{}
+
+// WithAudit has every session event that a store write on ctx makes
+// audited in the write's own transaction, so a change never commits
+// without its entry. e is the template: each entry is filed under the
+// event's location, targets its message and adds its session and seq to
+// e's details.
+func WithAudit(ctx context.Context, e models.AuditEntry) context.Context {
+	return context.WithValue(ctx, auditContextKey{}, e)
+}
+
+// AuditForEvent returns the entry to record for ev, if ctx came from
+// WithAudit.
+func AuditForEvent(ctx context.Context, ev models.SessionEvent) (models.AuditEntry, bool) {
+	e, ok := ctx.Value(auditContextKey{}).(models.AuditEntry)
+	if !ok || ev.Seq == 0 {
+		return e, false
+	}
+	details := map[string]any{}
+	if len(e.Details) > 0 {
+		_ = json.Unmarshal(e.Details, &details)
+	}
+	details["session_id"], details["seq"] = ev.SessionID, ev.Seq
+	e.Details, _ = json.Marshal(details)
+	e.LocationID, e.TargetType, e.TargetID = ev.LocationID, "message", ev.MessageID
+	return e, true
+}
+
+// AuditForChange returns the entry to record for an admin change that
+// isn't a session event, if ctx came from WithAudit: filed under
+// locationID, targeting targetType and targetID, with details in place of
+// the template's.
+func AuditForChange(ctx context.Context, locationID, targetType, targetID string, details any) (models.AuditEntry, bool) {
+	e, ok := ctx.Value(auditContextKey{}).(models.AuditEntry)
+	if !ok {
+		return e, false
+	}
+	e.LocationID, e.TargetType, e.TargetID = locationID, targetType, targetID
+	e.Details, _ = json.Marshal(details)
+	return e, true
+}
+
+// auditChange queues the AuditForChange entry, if any, in tx.
+func auditChange(ctx context.Context, tx rowQuerier, locationID, targetType, targetID string, details any) error {
+	e, ok := AuditForChange(ctx, locationID, targetType, targetID, details)
+	if !ok {
+		return nil
+	}
+	return queueAudit(ctx, tx, &e)
+}
+
+// ListAuditEntries returns a location's entries matching f, newest first.
+func (r *AuditRepo) ListAuditEntries(ctx context.Context, f models.AuditFilter) (_ []models.AuditEntry, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
+	args := []any{f.LocationID}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	var conditions []string
+	if f.ActorID != "" {
+		conditions = append(conditions, "actor_id = "+arg(f.ActorID))
+	}
+	if f.Action != "" {
+		conditions = append(conditions, "action = "+arg(f.Action))
+	}
+	if f.TargetType != "" {
+		conditions = append(conditions, "target_type = "+arg(f.TargetType))
+	}
+	if f.TargetID != "" {
+		conditions = append(conditions, "target_id = "+arg(f.TargetID))
+	}
+	if f.From != nil {
+		conditions = append(conditions, "created_at >= "+arg(*f.From))
+	}
+	if f.To != nil {
+		conditions = append(conditions, "created_at < "+arg(*f.To))
+	}
+	if f.BeforeSeq > 0 {
+		conditions = append(conditions, "seq < "+arg(f.BeforeSeq))
+	}
+
+	limit := f.Limit
+	if limit <= 0 || limit > maxAuditEntries {
+		limit = maxAuditEntries
+	}
+
+	var sb strings.Builder
+	sb.WriteString(queryListAuditEntriesBase)
+	for _, c := range conditions {
+		sb.WriteString("\tAND " + c + "\n")
+	}
+	sb.WriteString("\tORDER BY seq DESC\n\tLIMIT " + arg(limit) + "\n")
+
+	rows, err := readDB(ctx, r.DB).QueryContext(ctx, sb.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		var e models.AuditEntry
+		var details []byte
+		if err := rows.Scan(&e.ID, &e.LocationID, &e.Seq, &e.ActorID, &e.ActorType, &e.Action,
+			&e.TargetType, &e.TargetID, &e.IP, &details, &e.CreatedAt, &e.PrevHash, &e.Hash); err != nil {
+			return nil, err
+		}
+		e.Details = details
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// VerifyAuditChain walks a location's chain from its first entry and
+// reports the first entry that was altered, removed or reordered, or a head
+// that doesn't match the last entry. It always reads the primary.
+func (r *AuditRepo) VerifyAuditChain(ctx context.Context, locationID string) (_ models.AuditChainStatus, err error) {
+	ctx, end := beginOp(ctx, timeouts.Audit)
+	defer end(&err)
+
+	status := models.AuditChainStatus{LocationID: locationID, CheckedAt: time.Now()}
+	rows, err := r.DB.QueryContext(ctx, queryAuditChain, locationID)
+	if err != nil {
+		return status, err
+	}
+	defer rows.Close()
+
+	var lastSeq int64
+	lastHash := ""
+	for rows.Next() {
+		var seq int64
+		var prevHash, hash, expected string
+		// The database recomputes the hash, so the check doesn't depend on
+		// how Go would format the fields
+		if err := rows.Scan(&seq, &prevHash, &hash, &expected); err != nil {
+			return status, err
+		}
+		status.Entries++
+		if status.Problem == "" {
+			status.Problem = AuditLinkProblem(lastSeq, lastHash, seq, prevHash, hash, expected)
+			if status.Problem != "" {
+				status.BrokenAt = seq
+			}
+		}
+		lastSeq, lastHash = seq, hash
+	}
+	if err := rows.Err(); err != nil {
+		return status, err
+	}
+
+	err = r.DB.QueryRowContext(ctx, queryAuditChainHead, locationID).Scan(&status.HeadSeq, &status.HeadHash)
+	if err != nil && err != sql.ErrNoRows {
+		return status, err
+	}
+	if status.Problem == "" && (status.HeadSeq != lastSeq || status.HeadHash != lastHash) {
+		status.Problem = "entries after the last one were removed"
+		status.BrokenAt = lastSeq + 1
+	}
+	status.Valid = status.Problem == ""
+	if !status.Valid {
+		log.Printf("🚨 Audit chain of location %s is broken at seq %d: %s", locationID, status.BrokenAt, status.Problem)
+	}
+	return status, nil
+}
+
+// AuditLinkProblem checks one entry of a chain against the one before it,
+// given the hash recomputed from its fields, and describes what's wrong.
+// The first entry follows seq 0 and an empty hash.
+func AuditLinkProblem(lastSeq int64, lastHash string, seq int64, prevHash, hash, expected string) string {
+	switch {
+	case seq != lastSeq+1:
+		return fmt.Sprintf("entries %d to %d are missing", lastSeq+1, seq-1)
+	case prevHash != lastHash:
+		return "previous hash doesn't match the entry before"
+	case hash != expected:
+		return "entry was altered"
+	}
+	return ""
+}
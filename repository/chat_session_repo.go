@@ -1,7 +1,10 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"internal_chat_system/models"
 	"log"
 	"time"
@@ -19,14 +22,65 @@ func NewChatSessionRepo(db *sql.DB) *ChatSessionRepo {
 }
 
 const (
+	// Sessions are listed from their summaries alone; see
+	// refreshSessionSummary. The caller's unread count is the doctor's when
+	// they are the session's user, otherwise the patient's.
+	baseSessionQuery = `
+		SELECT
+			cs.id,
+			cs.contact_id,
+			COALESCE(c.full_name, '') AS contact_name,
+			cs.user_id,
+			COALESCE(u.full_name, '') AS user_name,
+			cs.location_id,
+			cs.started_at,
+			cs.last_message_at,
+			COALESCE(cs.last_message_preview, '') AS last_message,
+			cs.last_seq,
+			CASE WHEN cs.user_id = NULLIF($2, '')::uuid THEN cs.user_unread_count ELSE cs.contact_unread_count END AS unread_count,
+			cs.last_message_id,
+			cs.last_sender_id,
+			COALESCE(cs.last_sender_type, ''),
+			cs.last_activity_at
+		FROM chat_sessions cs
+		LEFT JOIN contacts c ON cs.contact_id = c.id
+		LEFT JOIN users u ON cs.user_id = u.id
+		WHERE (cs.contact_id = NULLIF($1, '')::uuid OR cs.user_id = NULLIF($2, '')::uuid)
+		AND ($3 = '' OR cs.location_id = NULLIF($3, '')::uuid)
+		AND c.deleted_at IS NULL
+		ORDER BY cs.last_message_at DESC NULLS LAST, cs.started_at DESC
+	`
+
 	queryGetSession = `
 		SELECT id FROM chat_sessions
 		WHERE contact_id = $1 AND user_id = $2 AND location_id = $3
 	`
 
+	queryGetSessionByID = `
+		SELECT id, contact_id, user_id, location_id, started_at, last_message_at
+		FROM chat_sessions WHERE id = $1
+	`
+
+	querySessionExists = `
+		SELECT EXISTS (
+			SELECT 1 FROM chat_sessions
+			WHERE contact_id = $1 AND user_id = $2 AND location_id = $3
+		)
+	`
+
+	// Counterparts of a doctor are contacts, and of a patient users
+	queryUserCounterparts = `
+		SELECT contact_id::text FROM chat_sessions
+		WHERE user_id = $1 AND location_id = $2 AND contact_id = ANY($3)
+	`
+	queryContactCounterparts = `
+		SELECT user_id::text FROM chat_sessions
+		WHERE contact_id = $1 AND location_id = $2 AND user_id = ANY($3)
+	`
+
 	queryInsertSession = `
-		INSERT INTO chat_sessions (id, contact_id, user_id, location_id, started_at, last_message_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO chat_sessions (id, contact_id, user_id, location_id, started_at, last_message_at, last_activity_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $5)
 		RETURNING id
 	`
 
@@ -40,38 +94,37 @@ const (
 		SELECT cs.id, cs.contact_id, COALESCE(c.full_name, '') AS contact_name,
 		       cs.user_id, COALESCE(u.full_name, '') AS user_name,
 		       cs.location_id, cs.started_at, cs.last_message_at,
-		       COALESCE(m.content, '') AS last_message,
-		       (
-				   SELECT COUNT(*) FROM messages
-				   WHERE session_id = cs.id AND is_read = false
-		       ) AS unread_count
+		       COALESCE(cs.last_message_preview, '') AS last_message, cs.last_seq,
+		       cs.user_unread_count + cs.contact_unread_count AS unread_count,
+		       cs.last_message_id, cs.last_sender_id,
+		       COALESCE(cs.last_sender_type, ''), cs.last_activity_at,
+		       cs.user_unread_count, cs.contact_unread_count
 		FROM chat_sessions cs
 		LEFT JOIN contacts c ON cs.contact_id = c.id
 		LEFT JOIN users u ON cs.user_id = u.id
-		LEFT JOIN LATERAL (
-			SELECT content FROM messages
-			WHERE session_id = cs.id
-			ORDER BY sent_at DESC LIMIT 1
-		) m ON true
-		WHERE ($1 = '' OR cs.location_id = $1)
+		WHERE ($1 = '' OR cs.location_id = NULLIF($1, '')::uuid)
 		ORDER BY cs.last_message_at DESC NULLS LAST, cs.started_at DESC
 		LIMIT $2 OFFSET $3
 	`
 	queryAdminDeleteMessages = `
 		UPDATE messages
 		SET deleted_at = now()
-		WHERE id = ANY($1)
+		WHERE id = ANY($1) AND deleted_at IS NULL
+		RETURNING id, session_id
 	`
 )
 
-func (r *ChatSessionRepo) GetOrCreateSession(contactID, userID, locationID string) (string, error) {
+func (r *ChatSessionRepo) GetOrCreateSession(ctx context.Context, contactID, userID, locationID string) (_ string, err error) {
+	ctx, end := beginWrite(ctx)
+	defer end(&err)
+
 	var sessionID string
-	err := r.DB.QueryRow(queryGetSession, contactID, userID, locationID).Scan(&sessionID)
+	err = r.DB.QueryRowContext(ctx, queryGetSession, contactID, userID, locationID).Scan(&sessionID)
 	if err == sql.ErrNoRows {
 		log.Println("📁 No session found — creating new one")
 		id := uuid.New().String()
 		t := time.Now()
-		err := r.DB.QueryRow(queryInsertSession, id, contactID, userID, locationID, t, t).Scan(&sessionID)
+		err := r.DB.QueryRowContext(ctx, queryInsertSession, id, contactID, userID, locationID, t, t).Scan(&sessionID)
 		if err != nil {
 			log.Println("❌ Failed to create new session:", err)
 			return "", err
@@ -83,7 +136,7 @@ func (r *ChatSessionRepo) GetOrCreateSession(contactID, userID, locationID strin
 	}
 
 	// Session exists: update last_message_at
-	_, err = r.DB.Exec(queryUpdateLastMessage, sessionID, time.Now())
+	_, err = r.DB.ExecContext(ctx, queryUpdateLastMessage, sessionID, time.Now())
 	if err != nil {
 		log.Println("⚠️ Failed to update last_message_at:", err)
 	}
@@ -91,15 +144,89 @@ func (r *ChatSessionRepo) GetOrCreateSession(contactID, userID, locationID strin
 	return sessionID, nil
 }
 
-func (r *MessageRepo) MarkMessagesDelivered(ids []uuid.UUID) error {
+var ErrSessionNotFound = errors.New("session not found")
+
+func (r *ChatSessionRepo) GetSessionByID(ctx context.Context, id string) (_ models.ChatSession, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
+	var s models.ChatSession
+	err = r.DB.QueryRowContext(ctx, queryGetSessionByID, id).Scan(
+		&s.ID, &s.ContactID, &s.UserID, &s.LocationID, &s.StartedAt, &s.LastMessageAt,
+	)
+	if err == sql.ErrNoRows {
+		return s, ErrSessionNotFound
+	}
+	if err != nil {
+		log.Println("❌ Failed to fetch session:", err)
+	}
+	return s, err
+}
+
+// SessionExists reports whether the contact and user share a chat session in the location.
+func (r *ChatSessionRepo) SessionExists(ctx context.Context, contactID, userID, locationID string) (_ bool, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
+	var exists bool
+	err = r.DB.QueryRowContext(ctx, querySessionExists, contactID, userID, locationID).Scan(&exists)
+	if err != nil {
+		log.Println("❌ Failed to check session:", err)
+	}
+	return exists, err
+}
+
+// SessionCounterparts reports which of ids share a session in the location
+// with the doctor (userID) or, if userID is empty, the patient (contactID).
+// IDs that aren't UUIDs share nothing.
+func (r *ChatSessionRepo) SessionCounterparts(ctx context.Context, userID, contactID, locationID string, ids []string) (_ map[string]bool, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
+	found := make(map[string]bool)
+	var candidates []uuid.UUID
+	for _, id := range ids {
+		if u, err := uuid.Parse(id); err == nil {
+			candidates = append(candidates, u)
+		}
+	}
+	if len(candidates) == 0 {
+		return found, nil
+	}
+
+	query, self := queryUserCounterparts, userID
+	if userID == "" {
+		query, self = queryContactCounterparts, contactID
+	}
+	rows, err := r.DB.QueryContext(ctx, query, self, locationID, pq.Array(candidates))
+	if err != nil {
+		log.Println("❌ Failed to check session counterparts:", err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		found[id] = true
+	}
+	return found, rows.Err()
+}
+
+func (r *MessageRepo) MarkMessagesDelivered(ctx context.Context, ids []uuid.UUID) (err error) {
+	ctx, end := beginWrite(ctx)
+	defer end(&err)
 
-	_, err := r.DB.Exec(queryMarkMessagesDelivered, pq.Array(ids))
+	_, err = r.DB.ExecContext(ctx, queryMarkMessagesDelivered, pq.Array(ids))
 	return err
 }
 
-func (r *MessageRepo) AdminListAllSessions(locationID string, limit, offset int) ([]models.ChatSessionResponse, error) {
+func (r *ChatSessionRepo) AdminListAllSessions(ctx context.Context, locationID string, limit, offset int) (_ []models.ChatSessionResponse, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
 
-	rows, err := r.DB.Query(queryAdminListAllSessions, locationID, limit, offset)
+	rows, err := readDB(ctx, r.DB).QueryContext(ctx, queryAdminListAllSessions, locationID, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -109,19 +236,99 @@ func (r *MessageRepo) AdminListAllSessions(locationID string, limit, offset int)
 	for rows.Next() {
 		var s models.ChatSessionResponse
 		if err := rows.Scan(&s.ID, &s.ContactID, &s.ContactName, &s.UserID, &s.UserName,
-			&s.LocationID, &s.StartedAt, &s.LastMessageAt, &s.LastMessage, &s.UnreadCount); err != nil {
+			&s.LocationID, &s.StartedAt, &s.LastMessageAt, &s.LastMessage, &s.LastSeq, &s.UnreadCount,
+			&s.LastMessageID, &s.LastSenderID, &s.LastSenderType, &s.LastActivityAt,
+			&s.UserUnreadCount, &s.ContactUnreadCount); err != nil {
 			return nil, err
 		}
 		sessions = append(sessions, s)
 	}
-	return sessions, nil
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, openSessionPreviews(ctx, sessions)
 }
 
-func (r *MessageRepo) AdminDeleteMessages(ids []uuid.UUID) error {
+// AdminDeleteMessages soft-deletes messages in bulk, recording a
+// message_deleted event for each one that was not already deleted. Nothing
+// is deleted if any of them is under legal hold.
+func (r *MessageRepo) AdminDeleteMessages(ctx context.Context, ids []uuid.UUID) (_ []models.SessionEvent, err error) {
+	ctx, end := beginWrite(ctx)
+	defer end(&err)
 
-	_, err := r.DB.Exec(queryAdminDeleteMessages, pq.Array(ids))
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkLegalHold(ctx, tx, ids); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, queryAdminDeleteMessages, pq.Array(ids))
 	if err != nil {
 		log.Printf("❌ AdminDeleteMessages failed: %v", err)
+		return nil, err
 	}
-	return err
+	type deleted struct{ id, sessionID *uuid.UUID }
+	var all []deleted
+	for rows.Next() {
+		var d deleted
+		if err := rows.Scan(&d.id, &d.sessionID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		all = append(all, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	events := []models.SessionEvent{}
+	for _, d := range all {
+		ev, err := appendSessionEvent(ctx, tx, d.sessionID, models.EventMessageDeleted, d.id, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := refreshSessionSummary(ctx, tx, d.sessionID); err != nil {
+			return nil, err
+		}
+		if ev.Seq > 0 {
+			events = append(events, ev)
+		}
+	}
+	return events, tx.Commit()
+}
+
+func (r *ChatSessionRepo) ListEnrichedChatSessionsWithFilter(ctx context.Context, userID, contactID, locationID string, limit, offset int) (_ []models.ChatSessionResponse, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
+	log.Printf("🔍 Listing sessions for user=%s contact=%s location=%s limit=%d offset=%d", userID, contactID, locationID, limit, offset)
+
+	query := fmt.Sprintf("%s LIMIT $4 OFFSET $5", baseSessionQuery)
+	rows, err := readDB(ctx, r.DB).QueryContext(ctx, query, contactID, userID, locationID, limit, offset)
+	if err != nil {
+		log.Println("❌ Query failed for ListEnrichedChatSessionsWithFilter:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []models.ChatSessionResponse{}
+	for rows.Next() {
+		var s models.ChatSessionResponse
+		err := rows.Scan(&s.ID, &s.ContactID, &s.ContactName, &s.UserID, &s.UserName, &s.LocationID, &s.StartedAt, &s.LastMessageAt, &s.LastMessage, &s.LastSeq, &s.UnreadCount,
+			&s.LastMessageID, &s.LastSenderID, &s.LastSenderType, &s.LastActivityAt)
+		if err != nil {
+			log.Println("❌ Failed to scan chat session row:", err)
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, openSessionPreviews(ctx, sessions)
 }
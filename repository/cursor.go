@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// cursorTimeLayout matches the microsecond precision of PostgreSQL TIMESTAMP
+// columns and carries no zone, so cursors compare exactly against sent_at.
+const cursorTimeLayout = "2006-01-02 15:04:05.999999"
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// messageCursor is a position in a conversation ordered by (sent_at, id). The
+// id breaks ties between messages stored in the same microsecond.
+type messageCursor struct {
+	SentAt time.Time
+	ID     uuid.UUID
+}
+
+func (c messageCursor) encode() string {
+	raw := c.SentAt.Format(cursorTimeLayout) + "|" + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeMessageCursor(s string) (messageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return messageCursor{}, ErrInvalidCursor
+	}
+	sentAt, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return messageCursor{}, ErrInvalidCursor
+	}
+	t, err := time.Parse(cursorTimeLayout, sentAt)
+	if err != nil {
+		return messageCursor{}, ErrInvalidCursor
+	}
+	u, err := uuid.Parse(id)
+	if err != nil {
+		return messageCursor{}, ErrInvalidCursor
+	}
+	return messageCursor{SentAt: t, ID: u}, nil
+}
+
+// EncodeMessageCursor returns the opaque history cursor for a message, for
+// MessageStore implementations outside this package.
+func EncodeMessageCursor(sentAt time.Time, id uuid.UUID) string {
+	return messageCursor{SentAt: sentAt, ID: id}.encode()
+}
+
+// DecodeMessageCursor parses a cursor made by EncodeMessageCursor. It returns
+// ErrInvalidCursor for anything else.
+func DecodeMessageCursor(s string) (time.Time, uuid.UUID, error) {
+	c, err := decodeMessageCursor(s)
+	return c.SentAt, c.ID, err
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMessageCursorRoundTrip(t *testing.T) {
+	id := uuid.New()
+	tests := []struct {
+		name   string
+		sentAt time.Time
+		want   time.Time
+	}{
+		{"microseconds survive", time.Date(2025, 1, 6, 9, 30, 0, 123456000, time.UTC), time.Date(2025, 1, 6, 9, 30, 0, 123456000, time.UTC)},
+		{"whole seconds", time.Date(2025, 1, 6, 9, 30, 0, 0, time.UTC), time.Date(2025, 1, 6, 9, 30, 0, 0, time.UTC)},
+		{"nanoseconds are dropped like the database does", time.Date(2025, 1, 6, 9, 30, 0, 123456789, time.UTC), time.Date(2025, 1, 6, 9, 30, 0, 123456000, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sentAt, gotID, err := DecodeMessageCursor(EncodeMessageCursor(tt.sentAt, id))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sentAt.Equal(tt.want) || gotID != id {
+				t.Errorf("decoded %s %s, want %s %s", sentAt, gotID, tt.want, id)
+			}
+		})
+	}
+}
+
+func TestDecodeMessageCursorInvalid(t *testing.T) {
+	encode := func(raw string) string { return base64.RawURLEncoding.EncodeToString([]byte(raw)) }
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "%%%"},
+		{"no separator", encode("2025-01-06 09:30:00")},
+		{"bad time", encode("yesterday|" + uuid.NewString())},
+		{"bad id", encode("2025-01-06 09:30:00|42")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := DecodeMessageCursor(tt.cursor); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("DecodeMessageCursor(%q) = %v, want ErrInvalidCursor", tt.cursor, err)
+			}
+		})
+	}
+}
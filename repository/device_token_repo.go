@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log"
+)
+
+type DeviceTokenRepo struct {
+	DB *sql.DB
+}
+
+func NewDeviceTokenRepo(db *sql.DB) *DeviceTokenRepo {
+	return &DeviceTokenRepo{DB: db}
+}
+
+func (r *DeviceTokenRepo) GetDeviceToken(ctx context.Context, userID string) (_ string, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
+	var token string
+	err = r.DB.QueryRowContext(ctx, "SELECT token FROM device_tokens WHERE user_id = $1", userID).Scan(&token)
+	if err != nil {
+		log.Printf("❌ GetDeviceToken error: %v", err)
+	}
+	return token, err
+}
+
+func (r *DeviceTokenRepo) UpsertDeviceToken(ctx context.Context, userID, token string) (err error) {
+	ctx, end := beginWrite(ctx)
+	defer end(&err)
+
+	query := `
+		INSERT INTO device_tokens (user_id, token, updated_at)
+		VALUES ($1, $2, now())
+		ON CONFLICT (user_id) DO UPDATE
+		SET token = EXCLUDED.token,
+		    updated_at = now()
+	`
+	_, err = r.DB.ExecContext(ctx, query, userID, token)
+	if err != nil {
+		log.Printf("❌ Failed to upsert device token: %v", err)
+	}
+	return err
+}
@@ -0,0 +1,788 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"internal_chat_system/encryption"
+	"internal_chat_system/models"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// ErrEncryptionDisabled means no keyring is configured: there are no keys
+// to rotate or re-encrypt with, and sealed rows can't be opened.
+var ErrEncryptionDisabled = errors.New("encryption at rest is not enabled")
+
+// keyring seals message content, file fields, revisions and the outbox
+// copies of messages. Nil stores them as plaintext.
+var keyring *encryption.Keyring
+
+// SetKeyring turns on encryption at rest. Messages written from then on
+// are sealed with their location's active data key; older plaintext rows
+// still read and are sealed by ReencryptMessages.
+func SetKeyring(k *encryption.Keyring) {
+	keyring = k
+}
+
+// reencryptBatchSize is how many messages one re-encryption transaction
+// reseals.
+const reencryptBatchSize = 200
+
+const (
+	encryptionKeyColumns = `id, location_id, version, wrapped_key, master_key_id, created_at, retired_at`
+
+	queryActiveEncryptionKey = `
+		SELECT ` + encryptionKeyColumns + ` FROM encryption_keys
+		WHERE location_id = $1 AND retired_at IS NULL
+	`
+
+	queryGetEncryptionKey = `SELECT ` + encryptionKeyColumns + ` FROM encryption_keys WHERE id = $1`
+
+	queryListEncryptionKeys = `
+		SELECT ` + encryptionKeyColumns + ` FROM encryption_keys
+		WHERE $1 = '' OR location_id = NULLIF($1, '')::uuid
+		ORDER BY location_id, version DESC
+	`
+
+	// Serializes key changes of a location, including creating its first
+	// key, which has no row to lock yet
+	queryLockLocationKeys = `SELECT pg_advisory_xact_lock(hashtext('encryption_keys:' || $1))`
+
+	queryRetireEncryptionKey = `
+		UPDATE encryption_keys SET retired_at = now()
+		WHERE location_id = $1 AND retired_at IS NULL
+	`
+
+	queryInsertEncryptionKey = `
+		INSERT INTO encryption_keys (location_id, version, wrapped_key, master_key_id)
+		VALUES ($1, (SELECT COALESCE(MAX(version), 0) + 1 FROM encryption_keys WHERE location_id = $1), $2, $3)
+		RETURNING id, version, created_at
+	`
+
+	queryRewrapEncryptionKey = `
+		UPDATE encryption_keys SET wrapped_key = $2, master_key_id = $3
+		WHERE id = $1
+		RETURNING location_id
+	`
+
+	queryCountMessagesByKey = `
+		SELECT COALESCE(key_id::text, ''), COUNT(*)
+		FROM messages WHERE location_id = $1
+		GROUP BY key_id
+	`
+
+	// Deleted and anonymized messages are resealed too: they still hold
+	// content, or will pick up the key's tokens
+	querySelectReencryptBatch = `
+		SELECT id, session_id, content, COALESCE(file_url, ''), COALESCE(file_name, ''), COALESCE(file_type, '')
+		FROM messages
+		WHERE location_id = $1 AND key_id IS DISTINCT FROM $2
+		ORDER BY sent_at
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED
+	`
+
+	queryReencryptMessage = `
+		UPDATE messages SET content = $2, file_url = NULLIF($3, ''), file_name = NULLIF($4, ''),
+			file_type = NULLIF($5, ''), key_id = $6, content_tokens = $7
+		WHERE id = $1
+	`
+
+	querySelectRevisionsForReencrypt = `SELECT id, message_id, content FROM message_revisions WHERE message_id = ANY($1)`
+	queryReencryptRevision           = `UPDATE message_revisions SET content = $2 WHERE id = $1`
+
+	querySelectEditEventsForReencrypt = `
+		SELECT session_id, seq, message_id, data FROM session_events
+		WHERE message_id = ANY($1) AND event_type = $2 AND data IS NOT NULL
+	`
+	queryReencryptEvent = `UPDATE session_events SET data = $3 WHERE session_id = $1 AND seq = $2`
+
+	querySelectOutboxForReencrypt = `
+		SELECT id, payload FROM outbox
+		WHERE aggregate_id = ANY($1) AND event_type = ANY($2)
+	`
+	queryReencryptOutbox = `UPDATE outbox SET payload = $2 WHERE id = $1`
+)
+
+// outboxMessageEvents are the outbox events whose payload is the message.
+var outboxMessageEvents = []string{models.OutboxMessageDeliver, models.OutboxMessagePush, models.OutboxMessageAutoReply}
+
+// storedFields are a message's fields as written: sealed under KeyID with
+// Tokens as their blind index, or plaintext with no key.
+type storedFields struct {
+	Content, FileURL, FileName, FileType string
+	KeyID                                *string
+	Tokens                               []string
+}
+
+// sealFields seals a message's fields for storage with k, or returns them
+// as they are for a nil k.
+func sealFields(k *encryption.DataKey, messageID, content, fileURL, fileName, fileType string) storedFields {
+	if k == nil {
+		return storedFields{Content: content, FileURL: fileURL, FileName: fileName, FileType: fileType}
+	}
+	return storedFields{
+		Content:  k.Seal(content, messageID),
+		FileURL:  k.Seal(fileURL, messageID),
+		FileName: k.Seal(fileName, messageID),
+		FileType: k.Seal(fileType, messageID),
+		KeyID:    &k.ID,
+		Tokens:   k.Tokens(content, fileName),
+	}
+}
+
+// activeKey returns the location's data key, or nil when encryption is off.
+func activeKey(ctx context.Context, locationID string) (*encryption.DataKey, error) {
+	if keyring == nil {
+		return nil, nil
+	}
+	k, err := keyring.Active(ctx, locationID)
+	if err != nil {
+		log.Printf("❌ Failed to get data key of location %s: %v", locationID, err)
+	}
+	return k, err
+}
+
+// openValue returns the plaintext of a value stored for the row aad.
+// Plaintext values are returned as they are.
+func openValue(ctx context.Context, s, aad string) (string, error) {
+	if !encryption.IsSealed(s) {
+		return s, nil
+	}
+	if keyring == nil {
+		return "", ErrEncryptionDisabled
+	}
+	plaintext, err := keyring.Open(ctx, s, aad)
+	if err != nil {
+		log.Printf("❌ Failed to open sealed value of %s: %v", aad, err)
+	}
+	return plaintext, err
+}
+
+// openFields opens each of fields in place.
+func openFields(ctx context.Context, aad string, fields ...*string) error {
+	for _, f := range fields {
+		v, err := openValue(ctx, *f, aad)
+		if err != nil {
+			return err
+		}
+		*f = v
+	}
+	return nil
+}
+
+func openMessage(ctx context.Context, m *models.DBMessage) error {
+	return openFields(ctx, m.ID.String(), &m.Content, &m.FileURL, &m.FileName, &m.FileType)
+}
+
+func openMessages(ctx context.Context, messages []models.DBMessage) error {
+	for i := range messages {
+		if err := openMessage(ctx, &messages[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// errCachedPlaintext means a cached value isn't sealed although encryption
+// is on, e.g. one written before the keyring was set.
+var errCachedPlaintext = errors.New("cached value is not sealed")
+
+// SealCached seals data a cache keeps outside the database, such as a
+// history page in Redis, with the location's active key, bound to aad.
+// With encryption off it is returned as is.
+func SealCached(ctx context.Context, locationID, aad, data string) (string, error) {
+	k, err := activeKey(ctx, locationID)
+	if err != nil || k == nil {
+		return data, err
+	}
+	return k.Seal(data, aad), nil
+}
+
+// OpenCached opens a value sealed by SealCached. While encryption is on,
+// plaintext values are refused rather than served.
+func OpenCached(ctx context.Context, aad, s string) (string, error) {
+	if keyring != nil && !encryption.IsSealed(s) {
+		return "", errCachedPlaintext
+	}
+	return openValue(ctx, s, aad)
+}
+
+// editedEventData is what a message_edited event says. The stored event
+// holds the sealed content; the one returned to the editor, the plaintext.
+func editedEventData(content string, editedAt time.Time) map[string]any {
+	return map[string]any{
+		"content":   content,
+		"edited_at": editedAt,
+	}
+}
+
+// openEventData opens the content a message_edited event carries.
+func openEventData(ctx context.Context, ev *models.SessionEvent) error {
+	if ev.Type != models.EventMessageEdited || len(ev.Data) == 0 {
+		return nil
+	}
+	data, changed, err := resealEventData(ev.Data, func(s string) (string, error) {
+		return openValue(ctx, s, ev.MessageID)
+	})
+	if err != nil || !changed {
+		return err
+	}
+	ev.Data = data
+	return nil
+}
+
+// resealEventData passes the content of edit event data through f.
+func resealEventData(data json.RawMessage, f func(string) (string, error)) (json.RawMessage, bool, error) {
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return data, false, nil
+	}
+	content, ok := fields["content"].(string)
+	if !ok {
+		return data, false, nil
+	}
+	v, err := f(content)
+	if err != nil || v == content {
+		return data, false, err
+	}
+	fields["content"] = v
+	b, err := json.Marshal(fields)
+	return b, err == nil, err
+}
+
+// openOutboxEvent opens the message an outbox event carries, so handlers
+// always see plaintext.
+func openOutboxEvent(ctx context.Context, ev *models.OutboxEvent) error {
+	if !isOutboxMessageEvent(ev.EventType) {
+		return nil
+	}
+	var msg models.Message
+	if err := json.Unmarshal(ev.Payload, &msg); err != nil {
+		return nil // not ours to judge; the handler reports it
+	}
+	if !encryption.IsSealed(msg.Content) && !encryption.IsSealed(msg.FileURL) &&
+		!encryption.IsSealed(msg.FileName) && !encryption.IsSealed(msg.FileType) {
+		return nil
+	}
+	if err := openFields(ctx, msg.ID, &msg.Content, &msg.FileURL, &msg.FileName, &msg.FileType); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	ev.Payload = payload
+	return nil
+}
+
+func isOutboxMessageEvent(eventType string) bool {
+	for _, t := range outboxMessageEvents {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// openSessionPreviews opens the last message previews of sessions. Sealed
+// previews are stored whole, so they are shortened here once readable.
+func openSessionPreviews(ctx context.Context, sessions []models.ChatSessionResponse) error {
+	for i := range sessions {
+		s := &sessions[i]
+		if s.LastMessageID == nil || !encryption.IsSealed(s.LastMessage) {
+			continue
+		}
+		preview, err := openValue(ctx, s.LastMessage, s.LastMessageID.String())
+		if err != nil {
+			return err
+		}
+		s.LastMessage = truncateRunes(preview, sessionPreviewLength)
+	}
+	return nil
+}
+
+type EncryptionRepo struct {
+	DB *sql.DB
+}
+
+func NewEncryptionRepo(db *sql.DB) *EncryptionRepo {
+	return &EncryptionRepo{DB: db}
+}
+
+func scanEncryptionKey(row rowScanner) (models.EncryptionKey, error) {
+	var k models.EncryptionKey
+	err := row.Scan(&k.ID, &k.LocationID, &k.Version, &k.WrappedKey, &k.MasterKeyID, &k.CreatedAt, &k.RetiredAt)
+	return k, err
+}
+
+// ActiveEncryptionKey returns the key a location seals with, or
+// encryption.ErrKeyNotFound before its first.
+func (r *EncryptionRepo) ActiveEncryptionKey(ctx context.Context, locationID string) (_ models.EncryptionKey, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
+	k, err := scanEncryptionKey(r.DB.QueryRowContext(ctx, queryActiveEncryptionKey, locationID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return k, encryption.ErrKeyNotFound
+	}
+	return k, err
+}
+
+func (r *EncryptionRepo) GetEncryptionKey(ctx context.Context, id string) (_ models.EncryptionKey, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
+	k, err := scanEncryptionKey(r.DB.QueryRowContext(ctx, queryGetEncryptionKey, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return k, encryption.ErrKeyNotFound
+	}
+	return k, err
+}
+
+func (r *EncryptionRepo) ListEncryptionKeys(ctx context.Context, locationID string) (_ []models.EncryptionKey, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
+	rows, err := r.DB.QueryContext(ctx, queryListEncryptionKeys, locationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.EncryptionKey{}
+	for rows.Next() {
+		k, err := scanEncryptionKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// CreateEncryptionKey stores k as its location's active key; see
+// encryption.KeyStore.
+func (r *EncryptionRepo) CreateEncryptionKey(ctx context.Context, k *models.EncryptionKey, rotate bool) (err error) {
+	ctx, end := beginWrite(ctx)
+	defer end(&err)
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, queryLockLocationKeys, k.LocationID); err != nil {
+		return err
+	}
+	if rotate {
+		if _, err := tx.ExecContext(ctx, queryRetireEncryptionKey, k.LocationID); err != nil {
+			return err
+		}
+	} else {
+		existing, err := scanEncryptionKey(tx.QueryRowContext(ctx, queryActiveEncryptionKey, k.LocationID))
+		if err == nil {
+			*k = existing
+			return nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+
+	err = tx.QueryRowContext(ctx, queryInsertEncryptionKey, k.LocationID, k.WrappedKey, k.MasterKeyID).
+		Scan(&k.ID, &k.Version, &k.CreatedAt)
+	if err != nil {
+		log.Printf("❌ Failed to store data key for location %s: %v", k.LocationID, err)
+		return err
+	}
+	// A location's first key is made on demand by whatever write needs it,
+	// so only rotations are admin changes
+	if rotate {
+		if err := auditChange(ctx, tx, k.LocationID, "encryption_key", k.ID, k); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RewrapEncryptionKey stores a data key wrapped by another master key. It
+// is audited per key if ctx came from WithAudit.
+func (r *EncryptionRepo) RewrapEncryptionKey(ctx context.Context, id string, wrapped []byte, masterKeyID string) (err error) {
+	ctx, end := beginWrite(ctx)
+	defer end(&err)
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var locationID string
+	if err := tx.QueryRowContext(ctx, queryRewrapEncryptionKey, id, wrapped, masterKeyID).Scan(&locationID); err != nil {
+		return err
+	}
+	if err := auditChange(ctx, tx, locationID, "encryption_key", id, map[string]any{"master_key_id": masterKeyID}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetEncryptionStatus lists a location's keys and how many of its messages
+// each one seals.
+func (r *EncryptionRepo) GetEncryptionStatus(ctx context.Context, locationID string) (_ models.EncryptionStatus, err error) {
+	status := models.EncryptionStatus{LocationID: locationID, Enabled: keyring != nil, Messages: map[string]int64{}}
+	if status.Keys, err = r.ListEncryptionKeys(ctx, locationID); err != nil {
+		return status, err
+	}
+	for _, k := range status.Keys {
+		if k.RetiredAt == nil {
+			status.ActiveKey = k.ID
+		}
+	}
+
+	ctx, end := beginOp(ctx, timeouts.Reencrypt)
+	defer end(&err)
+
+	rows, err := r.DB.QueryContext(ctx, queryCountMessagesByKey, locationID)
+	if err != nil {
+		return status, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var keyID string
+		var n int64
+		if err := rows.Scan(&keyID, &n); err != nil {
+			return status, err
+		}
+		if keyID == "" {
+			status.Plaintext = n
+		} else {
+			status.Messages[keyID] = n
+		}
+		if keyID != status.ActiveKey {
+			status.Pending += n
+		}
+	}
+	return status, rows.Err()
+}
+
+// RotateEncryptionKey retires a location's active key in favour of a new
+// one. Messages stay under the old key until ReencryptMessages.
+func (r *EncryptionRepo) RotateEncryptionKey(ctx context.Context, locationID string) (models.EncryptionKey, error) {
+	if keyring == nil {
+		return models.EncryptionKey{}, ErrEncryptionDisabled
+	}
+	k, err := keyring.Rotate(ctx, locationID)
+	if err != nil {
+		return models.EncryptionKey{}, err
+	}
+	return k.EncryptionKey, nil
+}
+
+// RewrapEncryptionKeys moves data keys wrapped by a previous master key to
+// the current one. It returns how many it moved.
+func (r *EncryptionRepo) RewrapEncryptionKeys(ctx context.Context) (int, error) {
+	if keyring == nil {
+		return 0, ErrEncryptionDisabled
+	}
+	return keyring.Rewrap(ctx)
+}
+
+// ReencryptMessages reseals up to limit of a location's messages that are
+// plaintext or under a retired key with its active key, along with their
+// revisions, edit events and outbox copies. It runs in batches, each its
+// own transaction, so it can be stopped and resumed at any point. The
+// report says what was resealed and how many messages are left. If ctx
+// came from WithAudit, each batch is audited with its counts in its own
+// transaction.
+func (r *EncryptionRepo) ReencryptMessages(ctx context.Context, locationID string, limit int) (models.ReencryptReport, error) {
+	report := models.ReencryptReport{LocationID: locationID, StartedAt: time.Now()}
+	if keyring == nil {
+		return report, ErrEncryptionDisabled
+	}
+
+	// The cached active key may predate a rotation on another instance
+	var key *encryption.DataKey
+	rec, err := r.ActiveEncryptionKey(ctx, locationID)
+	switch {
+	case err == nil:
+		key, err = keyring.Key(ctx, rec.ID)
+	case errors.Is(err, encryption.ErrKeyNotFound):
+		key, err = keyring.Active(ctx, locationID)
+	}
+	if err != nil {
+		report.Error = err.Error()
+		return report, err
+	}
+	report.KeyID = key.ID
+
+	for report.Messages < int64(limit) {
+		n, err := r.reencryptBatch(ctx, key, min(reencryptBatchSize, limit-int(report.Messages)), &report)
+		if err != nil {
+			log.Printf("❌ Re-encryption of location %s stopped after %d message(s): %v", locationID, report.Messages, err)
+			report.Error = err.Error()
+			break
+		}
+		if n == 0 {
+			break
+		}
+	}
+
+	if status, err := r.GetEncryptionStatus(ctx, locationID); err == nil {
+		report.Remaining = status.Pending
+	}
+	report.FinishedAt = time.Now()
+	log.Printf("🔐 Re-encrypted %d message(s) of location %s under key %s, %d left",
+		report.Messages, locationID, key.ID, report.Remaining)
+	if report.Error != "" {
+		return report, errors.New(report.Error)
+	}
+	return report, nil
+}
+
+func (r *EncryptionRepo) reencryptBatch(ctx context.Context, key *encryption.DataKey, size int, report *models.ReencryptReport) (_ int, err error) {
+	ctx, end := beginOp(ctx, timeouts.Reencrypt)
+	defer end(&err)
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, querySelectReencryptBatch, key.LocationID, key.ID, size)
+	if err != nil {
+		return 0, err
+	}
+	type row struct {
+		id                                   uuid.UUID
+		sessionID                            *uuid.UUID
+		content, fileURL, fileName, fileType string
+	}
+	var batch []row
+	for rows.Next() {
+		var m row
+		if err := rows.Scan(&m.id, &m.sessionID, &m.content, &m.fileURL, &m.fileName, &m.fileType); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(batch))
+	aggregateIDs := make([]string, 0, len(batch))
+	sessions := make(map[uuid.UUID]bool)
+	for _, m := range batch {
+		id := m.id.String()
+		if err := openFields(ctx, id, &m.content, &m.fileURL, &m.fileName, &m.fileType); err != nil {
+			return 0, err
+		}
+		f := sealFields(key, id, m.content, m.fileURL, m.fileName, m.fileType)
+		if _, err := tx.ExecContext(ctx, queryReencryptMessage, m.id,
+			f.Content, f.FileURL, f.FileName, f.FileType, f.KeyID, pq.Array(f.Tokens)); err != nil {
+			return 0, err
+		}
+		ids = append(ids, m.id)
+		aggregateIDs = append(aggregateIDs, id)
+		if m.sessionID != nil {
+			sessions[*m.sessionID] = true
+		}
+	}
+
+	reseal := func(s, aad string) (string, error) {
+		if s == "" || encryption.KeyIDOf(s) == key.ID {
+			return s, nil
+		}
+		plaintext, err := openValue(ctx, s, aad)
+		if err != nil {
+			return "", err
+		}
+		return key.Seal(plaintext, aad), nil
+	}
+
+	revisions, err := r.reencryptRevisions(ctx, tx, ids, reseal)
+	if err != nil {
+		return 0, err
+	}
+	events, err := r.reencryptEditEvents(ctx, tx, ids, reseal)
+	if err != nil {
+		return 0, err
+	}
+	outbox, err := r.reencryptOutbox(ctx, tx, aggregateIDs, reseal)
+	if err != nil {
+		return 0, err
+	}
+
+	// Previews are copies of the last message's fields
+	for id := range sessions {
+		if err := refreshSessionSummary(ctx, tx, &id); err != nil {
+			return 0, err
+		}
+	}
+	err = auditChange(ctx, tx, key.LocationID, "encryption_key", key.ID, map[string]any{
+		"messages": len(batch), "revisions": revisions, "events": events, "outbox_rows": outbox,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	report.Messages += int64(len(batch))
+	report.Revisions += revisions
+	report.Events += events
+	report.OutboxRows += outbox
+	return len(batch), nil
+}
+
+type resealFunc func(s, aad string) (string, error)
+
+func (r *EncryptionRepo) reencryptRevisions(ctx context.Context, tx *sql.Tx, ids []uuid.UUID, reseal resealFunc) (int64, error) {
+	rows, err := tx.QueryContext(ctx, querySelectRevisionsForReencrypt, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+	type revision struct{ id, messageID, content string }
+	var revisions []revision
+	for rows.Next() {
+		var rev revision
+		if err := rows.Scan(&rev.id, &rev.messageID, &rev.content); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		revisions = append(revisions, rev)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var n int64
+	for _, rev := range revisions {
+		content, err := reseal(rev.content, rev.messageID)
+		if err != nil {
+			return n, err
+		}
+		if content == rev.content {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, queryReencryptRevision, rev.id, content); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (r *EncryptionRepo) reencryptEditEvents(ctx context.Context, tx *sql.Tx, ids []uuid.UUID, reseal resealFunc) (int64, error) {
+	rows, err := tx.QueryContext(ctx, querySelectEditEventsForReencrypt, pq.Array(ids), models.EventMessageEdited)
+	if err != nil {
+		return 0, err
+	}
+	type event struct {
+		sessionID uuid.UUID
+		seq       int64
+		messageID string
+		data      []byte
+	}
+	var events []event
+	for rows.Next() {
+		var ev event
+		if err := rows.Scan(&ev.sessionID, &ev.seq, &ev.messageID, &ev.data); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		events = append(events, ev)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var n int64
+	for _, ev := range events {
+		data, changed, err := resealEventData(ev.data, func(s string) (string, error) {
+			return reseal(s, ev.messageID)
+		})
+		if err != nil {
+			return n, err
+		}
+		if !changed {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, queryReencryptEvent, ev.sessionID, ev.seq, []byte(data)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (r *EncryptionRepo) reencryptOutbox(ctx context.Context, tx *sql.Tx, aggregateIDs []string, reseal resealFunc) (int64, error) {
+	rows, err := tx.QueryContext(ctx, querySelectOutboxForReencrypt, pq.Array(aggregateIDs), pq.Array(outboxMessageEvents))
+	if err != nil {
+		return 0, err
+	}
+	type entry struct {
+		id      int64
+		payload []byte
+	}
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.id, &e.payload); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var n int64
+	for _, e := range entries {
+		var msg models.Message
+		if err := json.Unmarshal(e.payload, &msg); err != nil {
+			continue
+		}
+		before := msg
+		for _, f := range []*string{&msg.Content, &msg.FileURL, &msg.FileName, &msg.FileType} {
+			v, err := reseal(*f, msg.ID)
+			if err != nil {
+				return n, err
+			}
+			*f = v
+		}
+		if msg.Content == before.Content && msg.FileURL == before.FileURL &&
+			msg.FileName == before.FileName && msg.FileType == before.FileType {
+			continue
+		}
+		payload, err := json.Marshal(msg)
+		if err != nil {
+			return n, err
+		}
+		if _, err := tx.ExecContext(ctx, queryReencryptOutbox, e.id, payload); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,193 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"internal_chat_system/models"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrLegalHold means a change was refused because a message it touches
+	// is under an active legal hold.
+	ErrLegalHold = errors.New("message is under legal hold")
+
+	ErrLegalHoldNotFound = errors.New("legal hold not found")
+	ErrLegalHoldReleased = errors.New("legal hold already released")
+)
+
+const (
+	legalHoldColumns = `id, scope, location_id, COALESCE(session_id::text, ''), COALESCE(contact_id::text, ''),
+		reason, placed_by, placed_at, COALESCE(released_by::text, ''), released_at, COALESCE(release_reason, '')`
+
+	queryInsertLegalHold = `
+		INSERT INTO legal_holds (scope, location_id, session_id, contact_id, reason, placed_by)
+		VALUES ($1, $2, NULLIF($3, '')::uuid, NULLIF($4, '')::uuid, $5, $6)
+		RETURNING id, placed_at
+	`
+
+	queryReleaseLegalHold = `
+		UPDATE legal_holds SET released_by = $2, released_at = now(), release_reason = $3
+		WHERE id = $1 AND released_at IS NULL
+		RETURNING ` + legalHoldColumns
+
+	queryGetLegalHold = `SELECT ` + legalHoldColumns + ` FROM legal_holds WHERE id = $1`
+
+	// queryListLegalHolds is completed with an extra condition by
+	// ListLegalHolds.
+	queryListLegalHolds = `
+		SELECT ` + legalHoldColumns + `
+		FROM legal_holds
+		WHERE location_id = $1 %s
+		ORDER BY placed_at DESC
+	`
+
+	// Placing a hold takes its location's lock exclusively; edits, deletes
+	// and purges take it shared before checking for holds, so none of them
+	// acts on a hold check that a concurrent placement has made stale.
+	queryLockLocationHolds  = `SELECT pg_advisory_xact_lock(hashtext('legal_holds:' || $1::uuid::text))`
+	queryShareLocationHolds = `SELECT pg_advisory_xact_lock_shared(hashtext('legal_holds:' || $1::uuid::text))`
+
+	queryShareMessageHolds = `
+		SELECT pg_advisory_xact_lock_shared(hashtext('legal_holds:' || location_id::text))
+		FROM (SELECT DISTINCT location_id FROM messages WHERE id = ANY($1) ORDER BY location_id) l
+	`
+
+	queryAnyMessageOnHold = `
+		SELECT EXISTS (
+			SELECT 1 FROM messages
+			WHERE id = ANY($1) AND message_on_hold(location_id, receiver_contact_id, session_id)
+		)
+	`
+)
+
+type LegalHoldRepo struct {
+	DB *sql.DB
+}
+
+func NewLegalHoldRepo(db *sql.DB) *LegalHoldRepo {
+	return &LegalHoldRepo{DB: db}
+}
+
+func scanLegalHold(row rowScanner) (models.LegalHold, error) {
+	var h models.LegalHold
+	err := row.Scan(&h.ID, &h.Scope, &h.LocationID, &h.SessionID, &h.ContactID,
+		&h.Reason, &h.PlacedBy, &h.PlacedAt, &h.ReleasedBy, &h.ReleasedAt, &h.ReleaseReason)
+	return h, err
+}
+
+// PlaceLegalHold stores an active hold and sets its ID and PlacedAt. The
+// caller validates the scope and its target. The hold is audited if ctx
+// came from WithAudit.
+func (r *LegalHoldRepo) PlaceLegalHold(ctx context.Context, h *models.LegalHold) (err error) {
+	ctx, end := beginWrite(ctx)
+	defer end(&err)
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Waits for edits, deletes and purges that already checked this location
+	if _, err = tx.ExecContext(ctx, queryLockLocationHolds, h.LocationID); err == nil {
+		err = tx.QueryRowContext(ctx, queryInsertLegalHold,
+			h.Scope, h.LocationID, h.SessionID, h.ContactID, h.Reason, h.PlacedBy,
+		).Scan(&h.ID, &h.PlacedAt)
+	}
+	if err == nil {
+		err = auditChange(ctx, tx, h.LocationID, "legal_hold", h.ID, h)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("❌ Failed to place %s legal hold in location %s: %v", h.Scope, h.LocationID, err)
+		return err
+	}
+	log.Printf("⚖️ Legal hold %s placed on %s in location %s by %s", h.ID, h.Scope, h.LocationID, h.PlacedBy)
+	return nil
+}
+
+// ReleaseLegalHold ends an active hold. Released holds are kept. The
+// release is audited if ctx came from WithAudit.
+func (r *LegalHoldRepo) ReleaseLegalHold(ctx context.Context, id, actorID, reason string) (_ models.LegalHold, err error) {
+	ctx, end := beginWrite(ctx)
+	defer end(&err)
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return models.LegalHold{}, err
+	}
+	defer tx.Rollback()
+
+	h, err := scanLegalHold(tx.QueryRowContext(ctx, queryReleaseLegalHold, id, actorID, reason))
+	if errors.Is(err, sql.ErrNoRows) {
+		// Either it doesn't exist or someone released it first
+		if _, err := scanLegalHold(tx.QueryRowContext(ctx, queryGetLegalHold, id)); err == nil {
+			return models.LegalHold{}, ErrLegalHoldReleased
+		}
+		return models.LegalHold{}, ErrLegalHoldNotFound
+	}
+	if err == nil {
+		err = auditChange(ctx, tx, h.LocationID, "legal_hold", h.ID, h)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return models.LegalHold{}, err
+	}
+	log.Printf("⚖️ Legal hold %s released by %s", id, actorID)
+	return h, nil
+}
+
+// ListLegalHolds returns a location's holds, newest first, optionally only
+// the active ones.
+func (r *LegalHoldRepo) ListLegalHolds(ctx context.Context, locationID string, activeOnly bool) (_ []models.LegalHold, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
+	condition := ""
+	if activeOnly {
+		condition = "AND released_at IS NULL"
+	}
+	rows, err := r.DB.QueryContext(ctx, fmt.Sprintf(queryListLegalHolds, condition), locationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := []models.LegalHold{}
+	for rows.Next() {
+		h, err := scanLegalHold(rows)
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, h)
+	}
+	return holds, rows.Err()
+}
+
+// checkLegalHold fails with ErrLegalHold if any of the messages is under
+// an active hold. It holds their locations' hold locks until tx ends, so no
+// hold can be placed on them before the change commits.
+func checkLegalHold(ctx context.Context, tx *sql.Tx, ids []uuid.UUID) error {
+	if _, err := tx.ExecContext(ctx, queryShareMessageHolds, pq.Array(ids)); err != nil {
+		return err
+	}
+	var held bool
+	if err := tx.QueryRowContext(ctx, queryAnyMessageOnHold, pq.Array(ids)).Scan(&held); err != nil {
+		return err
+	}
+	if held {
+		return ErrLegalHold
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package memory
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"internal_chat_system/models"
+	"internal_chat_system/repository"
+
+	"github.com/google/uuid"
+)
+
+// maxAuditEntries matches the PostgreSQL audit repo.
+const maxAuditEntries = 500
+
+// Record appends e to its location's chain straight away; there is no
+// queue to chain in batches here.
+func (s *Store) Record(ctx context.Context, e *models.AuditEntry) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	if _, err := uuid.Parse(e.LocationID); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record(e)
+	return nil
+}
+
+// record chains e like the audit_log_chain trigger. Callers hold s.mu.
+func (s *Store) record(e *models.AuditEntry) {
+	e.ID = uuid.New().String()
+	e.CreatedAt = now()
+	e.Seq, e.PrevHash = 1, ""
+	for i := len(s.audit) - 1; i >= 0; i-- {
+		if last := s.audit[i]; last.LocationID == e.LocationID {
+			e.Seq, e.PrevHash = last.Seq+1, last.Hash
+			break
+		}
+	}
+	e.Hash = auditHash(*e)
+	s.audit = append(s.audit, *e)
+}
+
+// auditHash mirrors the audit_entry_hash SQL function: SHA-256 over a JSON
+// array of the fields.
+func auditHash(e models.AuditEntry) string {
+	var details any
+	if len(e.Details) > 0 {
+		details = e.Details
+	}
+	b, _ := json.Marshal([]any{
+		e.PrevHash, e.Seq, e.LocationID, e.ActorID, e.ActorType, e.Action,
+		e.TargetType, e.TargetID, e.IP, details,
+		e.CreatedAt.UTC().Format("2006-01-02T15:04:05.000000"),
+	})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Store) ListAuditEntries(ctx context.Context, f models.AuditFilter) ([]models.AuditEntry, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	limit := f.Limit
+	if limit <= 0 || limit > maxAuditEntries {
+		limit = maxAuditEntries
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := []models.AuditEntry{}
+	for i := len(s.audit) - 1; i >= 0 && len(entries) < limit; i-- {
+		e := s.audit[i]
+		switch {
+		case e.LocationID != f.LocationID,
+			f.ActorID != "" && e.ActorID != f.ActorID,
+			f.Action != "" && e.Action != f.Action,
+			f.TargetType != "" && e.TargetType != f.TargetType,
+			f.TargetID != "" && e.TargetID != f.TargetID,
+			f.From != nil && e.CreatedAt.Before(*f.From),
+			f.To != nil && !e.CreatedAt.Before(*f.To),
+			f.BeforeSeq > 0 && e.Seq >= f.BeforeSeq:
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (s *Store) VerifyAuditChain(ctx context.Context, locationID string) (models.AuditChainStatus, error) {
+	status := models.AuditChainStatus{LocationID: locationID, CheckedAt: now()}
+	if err := checkContext(ctx); err != nil {
+		return status, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.audit {
+		if e.LocationID != locationID {
+			continue
+		}
+		status.Entries++
+		if status.Problem == "" {
+			status.Problem = repository.AuditLinkProblem(status.HeadSeq, status.HeadHash, e.Seq, e.PrevHash, e.Hash, auditHash(e))
+			if status.Problem != "" {
+				status.BrokenAt = e.Seq
+			}
+		}
+		status.HeadSeq, status.HeadHash = e.Seq, e.Hash
+	}
+	status.Valid = status.Problem == ""
+	return status, nil
+}
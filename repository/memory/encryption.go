@@ -0,0 +1,54 @@
+package memory
+
+import (
+	"context"
+
+	"internal_chat_system/models"
+	"internal_chat_system/repository"
+)
+
+// Nothing in memory is at rest, so messages are never sealed: the status
+// shows every message as plaintext and key operations report encryption
+// as disabled.
+
+func (s *Store) GetEncryptionStatus(ctx context.Context, locationID string) (models.EncryptionStatus, error) {
+	if err := checkContext(ctx); err != nil {
+		return models.EncryptionStatus{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := models.EncryptionStatus{
+		LocationID: locationID,
+		Keys:       []models.EncryptionKey{},
+		Messages:   map[string]int64{},
+	}
+	for _, m := range s.messages {
+		if m.LocationID.String() == locationID {
+			status.Plaintext++
+		}
+	}
+	status.Pending = status.Plaintext
+	return status, nil
+}
+
+func (s *Store) RotateEncryptionKey(ctx context.Context, locationID string) (models.EncryptionKey, error) {
+	if err := checkContext(ctx); err != nil {
+		return models.EncryptionKey{}, err
+	}
+	return models.EncryptionKey{}, repository.ErrEncryptionDisabled
+}
+
+func (s *Store) ReencryptMessages(ctx context.Context, locationID string, limit int) (models.ReencryptReport, error) {
+	if err := checkContext(ctx); err != nil {
+		return models.ReencryptReport{}, err
+	}
+	return models.ReencryptReport{LocationID: locationID}, repository.ErrEncryptionDisabled
+}
+
+func (s *Store) RewrapEncryptionKeys(ctx context.Context) (int, error) {
+	if err := checkContext(ctx); err != nil {
+		return 0, err
+	}
+	return 0, repository.ErrEncryptionDisabled
+}
@@ -0,0 +1,187 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"internal_chat_system/models"
+	"internal_chat_system/repository"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultSyncEvents = 500
+	maxSyncEvents     = 1000
+)
+
+// GetSessionEvents replays a session's events after afterSeq, oldest first.
+func (s *Store) GetSessionEvents(ctx context.Context, sessionID string, afterSeq int64, limit int) (models.SessionEventPage, error) {
+	if err := checkContext(ctx); err != nil {
+		return models.SessionEventPage{}, err
+	}
+
+	limit = clampLimit(limit)
+	page := models.SessionEventPage{Events: []models.SessionEvent{}}
+
+	sid, err := uuid.Parse(sessionID)
+	if err != nil {
+		return page, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[sid]
+	if !ok {
+		return page, repository.ErrSessionNotFound
+	}
+	page.LastSeq = sess.lastSeq
+
+	for _, ev := range s.events[sid] {
+		if ev.Seq <= afterSeq {
+			continue
+		}
+		if len(page.Events) == limit {
+			page.HasMore = true
+			break
+		}
+		ev.LocationID, ev.UserID, ev.ContactID = "", "", ""
+		if ev.Type == models.EventMessageCreated {
+			if m, ok := s.live(ev.MessageID); ok {
+				msg := s.view(m)
+				msg.Reactions = nil
+				ev.Message = &msg
+			}
+		}
+		page.Events = append(page.Events, ev)
+	}
+	return page, nil
+}
+
+// Sync returns every change in the sessions of a doctor (userID) or patient
+// (contactID) since token, folded the same way as the database version.
+func (s *Store) Sync(ctx context.Context, userID, contactID, locationID, token string, limit int) (models.SyncResponse, error) {
+	if err := checkContext(ctx); err != nil {
+		return models.SyncResponse{}, err
+	}
+
+	resp := models.SyncResponse{
+		Messages:          []models.DBMessage{},
+		DeletedMessageIDs: []string{},
+		Reactions:         []models.ReactionChange{},
+		Sessions:          []models.SyncSession{},
+	}
+
+	mark, err := repository.DecodeSyncToken(token, locationID)
+	if err != nil {
+		return resp, err
+	}
+	if limit <= 0 {
+		limit = defaultSyncEvents
+	}
+	limit = min(limit, maxSyncEvents)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Events are written in log order under s.mu, so the log position is
+	// the whole mark
+	if mark.From > int64(len(s.eventLog)) {
+		return resp, repository.ErrInvalidSyncToken
+	}
+
+	lastSeqs := make(map[uuid.UUID]int64)
+	changed := make(map[string]bool)
+	deleted := make(map[string]bool)
+	var changedOrder []string
+	n := 0
+	pos := int(mark.From)
+	for ; pos < len(s.eventLog); pos++ {
+		ref := s.eventLog[pos]
+		sess, ok := s.sessions[ref.sessionID]
+		if !ok {
+			continue
+		}
+		mine := (userID != "" && sess.UserID.String() == userID) || (contactID != "" && sess.ContactID.String() == contactID)
+		if !mine || (locationID != "" && sess.LocationID.String() != locationID) {
+			continue
+		}
+		ev := s.events[ref.sessionID][ref.seq-1]
+		if n == limit {
+			resp.HasMore = true
+			break
+		}
+		n++
+		lastSeqs[ref.sessionID] = ev.Seq
+
+		switch ev.Type {
+		case models.EventMessageDeleted:
+			deleted[ev.MessageID] = true
+		case models.EventReactionAdded, models.EventReactionRemoved:
+			var rc struct {
+				UserID string `json:"user_id"`
+				Emoji  string `json:"emoji"`
+			}
+			_ = json.Unmarshal(ev.Data, &rc)
+			resp.Reactions = append(resp.Reactions, models.ReactionChange{
+				MessageID: ev.MessageID,
+				UserID:    rc.UserID,
+				Emoji:     rc.Emoji,
+				Removed:   ev.Type == models.EventReactionRemoved,
+				Seq:       ev.Seq,
+				At:        ev.CreatedAt,
+			})
+		case models.EventMessagesRead:
+			var read struct {
+				MessageIDs []string `json:"message_ids"`
+			}
+			_ = json.Unmarshal(ev.Data, &read)
+			for _, id := range read.MessageIDs {
+				if !changed[id] {
+					changed[id] = true
+					changedOrder = append(changedOrder, id)
+				}
+			}
+		default:
+			if ev.MessageID != "" && !changed[ev.MessageID] {
+				changed[ev.MessageID] = true
+				changedOrder = append(changedOrder, ev.MessageID)
+			}
+		}
+	}
+
+	for id := range deleted {
+		resp.DeletedMessageIDs = append(resp.DeletedMessageIDs, id)
+	}
+	for _, id := range changedOrder {
+		if m, ok := s.live(id); ok && !deleted[id] {
+			resp.Messages = append(resp.Messages, s.view(m))
+		}
+	}
+	sort.SliceStable(resp.Messages, func(i, j int) bool {
+		a, b := resp.Messages[i], resp.Messages[j]
+		if a.SessionID != b.SessionID {
+			return a.SessionID.String() < b.SessionID.String()
+		}
+		return a.Seq < b.Seq
+	})
+	s.attachThreadInfo(resp.Messages)
+
+	for id, seq := range lastSeqs {
+		sess := s.sessions[id]
+		resp.Sessions = append(resp.Sessions, models.SyncSession{
+			ID:            id.String(),
+			ContactID:     sess.ContactID.String(),
+			UserID:        sess.UserID.String(),
+			LocationID:    sess.LocationID.String(),
+			StartedAt:     sess.StartedAt,
+			LastMessageAt: sess.LastMessageAt,
+			LastSeq:       seq,
+		})
+	}
+	sort.Slice(resp.Sessions, func(i, j int) bool { return resp.Sessions[i].ID < resp.Sessions[j].ID })
+	resp.SyncToken = repository.EncodeSyncToken(repository.SyncMark{LocationID: locationID, From: int64(pos)})
+	return resp, nil
+}
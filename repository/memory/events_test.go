@@ -0,0 +1,103 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"internal_chat_system/repository"
+
+	"github.com/google/uuid"
+)
+
+func TestSyncPages(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+	loc, other, doc, pat := uuid.NewString(), uuid.NewString(), uuid.NewString(), uuid.NewString()
+	for _, m := range []struct{ loc, content string }{{loc, "one"}, {other, "elsewhere"}, {loc, "two"}, {loc, "three"}} {
+		if err := s.SaveMessage(ctx, patientMessage(m.loc, doc, pat, m.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Pages of two events; the other location's event is skipped
+	var got []string
+	token := ""
+	for page := 0; ; page++ {
+		resp, err := s.Sync(ctx, doc, "", loc, token, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range resp.Messages {
+			got = append(got, m.Content)
+		}
+		token = resp.SyncToken
+		if !resp.HasMore {
+			break
+		}
+		if page > 3 {
+			t.Fatal("sync never finished")
+		}
+	}
+	if len(got) != 3 || got[0] != "one" || got[2] != "three" {
+		t.Errorf("synced %v, want [one two three]", got)
+	}
+
+	// The token only grows by one mark, however many sessions changed
+	if err := s.SaveMessage(ctx, patientMessage(loc, doc, pat, "four")); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := s.Sync(ctx, doc, "", loc, token, 0)
+	if err != nil || len(resp.Messages) != 1 || resp.Messages[0].Content != "four" {
+		t.Fatalf("sync after token = %+v, %v", resp.Messages, err)
+	}
+	if len(resp.Sessions) != 1 || resp.Sessions[0].LastSeq != 4 {
+		t.Errorf("sessions = %+v, want one at seq 4", resp.Sessions)
+	}
+}
+
+func TestSyncTokenLocation(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+	loc, doc, pat := uuid.NewString(), uuid.NewString(), uuid.NewString()
+	if err := s.SaveMessage(ctx, patientMessage(loc, doc, pat, "hello")); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := s.Sync(ctx, "", pat, loc, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Sync(ctx, "", pat, "", resp.SyncToken, 0); !errors.Is(err, repository.ErrInvalidSyncToken) {
+		t.Errorf("token reused for another location filter: %v", err)
+	}
+}
+
+func TestDecodeSyncToken(t *testing.T) {
+	mark := repository.SyncMark{LocationID: "loc", From: 7, To: 9, After: 3}
+	got, err := repository.DecodeSyncToken(repository.EncodeSyncToken(mark), "loc")
+	if err != nil || got != mark {
+		t.Errorf("round trip = %+v, %v, want %+v", got, err, mark)
+	}
+
+	tests := []struct {
+		name, token string
+		wantErr     bool
+	}{
+		{"empty starts over", "", false},
+		{"version 1 starts over", "eyJ2IjoxLCJzIjp7fX0", false},
+		{"not base64", "???", true},
+		{"not json", "bm9wZQ", true},
+		{"unknown version", "eyJ2Ijo5fQ", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repository.DecodeSyncToken(tt.token, "loc")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeSyncToken(%q) error = %v", tt.token, err)
+			}
+			if !tt.wantErr && got != (repository.SyncMark{LocationID: "loc"}) {
+				t.Errorf("DecodeSyncToken(%q) = %+v, want the start", tt.token, got)
+			}
+		})
+	}
+}
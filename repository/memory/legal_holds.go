@@ -0,0 +1,95 @@
+package memory
+
+import (
+	"context"
+
+	"internal_chat_system/models"
+	"internal_chat_system/repository"
+
+	"github.com/google/uuid"
+)
+
+func (s *Store) PlaceLegalHold(ctx context.Context, h *models.LegalHold) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	if _, err := uuid.Parse(h.LocationID); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h.ID = uuid.New().String()
+	h.PlacedAt = now()
+	h.ReleasedBy, h.ReleasedAt, h.ReleaseReason = "", nil, ""
+	s.legalHolds = append(s.legalHolds, *h)
+	if e, ok := repository.AuditForChange(ctx, h.LocationID, "legal_hold", h.ID, h); ok {
+		s.record(&e)
+	}
+	return nil
+}
+
+func (s *Store) ReleaseLegalHold(ctx context.Context, id, actorID, reason string) (models.LegalHold, error) {
+	if err := checkContext(ctx); err != nil {
+		return models.LegalHold{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.legalHolds {
+		h := &s.legalHolds[i]
+		if h.ID != id {
+			continue
+		}
+		if !h.Active() {
+			return models.LegalHold{}, repository.ErrLegalHoldReleased
+		}
+		t := now()
+		h.ReleasedBy, h.ReleasedAt, h.ReleaseReason = actorID, &t, reason
+		if e, ok := repository.AuditForChange(ctx, h.LocationID, "legal_hold", h.ID, h); ok {
+			s.record(&e)
+		}
+		return *h, nil
+	}
+	return models.LegalHold{}, repository.ErrLegalHoldNotFound
+}
+
+// ListLegalHolds returns a location's holds, newest first.
+func (s *Store) ListLegalHolds(ctx context.Context, locationID string, activeOnly bool) ([]models.LegalHold, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	holds := []models.LegalHold{}
+	for i := len(s.legalHolds) - 1; i >= 0; i-- {
+		h := s.legalHolds[i]
+		if h.LocationID == locationID && (h.Active() || !activeOnly) {
+			holds = append(holds, h)
+		}
+	}
+	return holds, nil
+}
+
+// onHold mirrors the message_on_hold SQL function. Callers hold s.mu.
+func (s *Store) onHold(m *message) bool {
+	for _, h := range s.legalHolds {
+		if !h.Active() || h.LocationID != m.LocationID.String() {
+			continue
+		}
+		switch h.Scope {
+		case models.HoldScopeLocation:
+			return true
+		case models.HoldScopeContact:
+			if h.ContactID == m.ReceiverContactID.String() {
+				return true
+			}
+		case models.HoldScopeSession:
+			if h.SessionID == m.SessionID.String() {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,699 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"internal_chat_system/models"
+	"internal_chat_system/repository"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+
+	// quotePreviewLength caps how much of a parent's content is quoted in replies.
+	quotePreviewLength = 280
+)
+
+func clampLimit(limit int) int {
+	if limit <= 0 {
+		return defaultPageSize
+	}
+	return min(limit, maxPageSize)
+}
+
+// before orders messages by (sent_at, id), like the history cursors.
+func before(a, b *models.DBMessage) bool {
+	if !a.SentAt.Equal(b.SentAt) {
+		return a.SentAt.Before(b.SentAt)
+	}
+	return a.ID.String() < b.ID.String()
+}
+
+func optionalUUID(s string) (uuid.UUID, error) {
+	if s == "" {
+		return uuid.Nil, nil
+	}
+	return uuid.Parse(s)
+}
+
+// senderKey is the author of a message: the contact for patient messages,
+// otherwise the user.
+func senderKey(m *models.DBMessage) uuid.UUID {
+	if m.SenderContactID != uuid.Nil {
+		return m.SenderContactID
+	}
+	return m.SenderUserID
+}
+
+// SaveMessage stores a new message, creating or touching its session,
+// assigning its seq and queueing its side effects, all under one lock.
+func (s *Store) SaveMessage(ctx context.Context, msg *models.Message) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	m := models.DBMessage{
+		Content:         msg.Content,
+		ClientMessageID: msg.ClientMessageID,
+		FileURL:         msg.FileURL,
+		FileName:        msg.FileName,
+		FileType:        msg.FileType,
+		IsSystem:        msg.IsSystem,
+	}
+	var err error
+	if m.ID, err = uuid.Parse(msg.ID); err != nil {
+		return err
+	}
+	if m.LocationID, err = uuid.Parse(msg.LocationID); err != nil {
+		return err
+	}
+	if m.SenderUserID, err = uuid.Parse(msg.SenderUserID); err != nil {
+		return err
+	}
+	if m.ReceiverContactID, err = uuid.Parse(msg.ReceiverContactID); err != nil {
+		return err
+	}
+	if m.ReceiverUserID, err = optionalUUID(msg.ReceiverUserID); err != nil {
+		return err
+	}
+	if m.SenderContactID, err = optionalUUID(msg.SenderContactID); err != nil {
+		return err
+	}
+	if msg.ReplyToID != nil {
+		id, err := uuid.Parse(*msg.ReplyToID)
+		if err != nil {
+			return err
+		}
+		m.ReplyToID = &id
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.messages[m.ID]; ok {
+		return errors.New("duplicate message id")
+	}
+	if m.ClientMessageID != "" {
+		for _, other := range s.messages {
+			if other.ClientMessageID == m.ClientMessageID && senderKey(&other.DBMessage) == senderKey(&m) {
+				return repository.ErrDuplicateClientMessage
+			}
+		}
+	}
+
+	m.SentAt = now()
+	sess := s.upsertSession(m.ReceiverContactID, m.SenderUserID, m.LocationID, m.SentAt)
+	m.SessionID = sess.ID
+	m.Seq = s.appendEvent(ctx, sess.ID, models.EventMessageCreated, &m.ID, nil).Seq
+	s.messages[m.ID] = &message{DBMessage: m}
+
+	msg.SentAt = m.SentAt
+	msg.IsRead = false
+	msg.SessionID = sess.ID.String()
+	msg.Seq = m.Seq
+
+	s.enqueueOutbox(models.OutboxMessageDeliver, msg.ID, msg)
+	s.enqueueOutbox(models.OutboxMessagePush, msg.ID, msg)
+	if msg.ReceiverUserID != "" && !msg.IsSystem {
+		s.enqueueOutbox(models.OutboxMessageAutoReply, msg.ID, msg)
+	}
+	return nil
+}
+
+// view returns a copy of a stored message with its type and reactions set.
+// Callers hold s.mu.
+func (s *Store) view(m *message) models.DBMessage {
+	out := m.DBMessage
+	out.MessageType = "text"
+	if out.FileURL != "" {
+		out.MessageType = "file"
+	}
+	if out.IsSystem {
+		out.MessageType = "system"
+	}
+	out.Reactions = append([]models.MessageReaction(nil), s.reactions[m.ID]...)
+	return out
+}
+
+// live returns a message that exists and is not deleted. Callers hold s.mu.
+func (s *Store) live(id string) (*message, bool) {
+	u, err := uuid.Parse(id)
+	if err != nil {
+		return nil, false
+	}
+	m, ok := s.messages[u]
+	if !ok || m.deletedAt != nil {
+		return nil, false
+	}
+	return m, true
+}
+
+func (s *Store) GetMessageByID(ctx context.Context, id string) (models.DBMessage, error) {
+	if err := checkContext(ctx); err != nil {
+		return models.DBMessage{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.live(id)
+	if !ok {
+		return models.DBMessage{}, repository.ErrMessageNotFound
+	}
+	out := s.view(m)
+	out.Reactions = nil
+	return out, nil
+}
+
+// GetMessageByClientID includes deleted messages, so a late retry can't send
+// them again.
+func (s *Store) GetMessageByClientID(ctx context.Context, senderID, clientMessageID string) (models.DBMessage, error) {
+	if err := checkContext(ctx); err != nil {
+		return models.DBMessage{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range s.messages {
+		if m.ClientMessageID == clientMessageID && senderKey(&m.DBMessage).String() == senderID {
+			out := s.view(m)
+			out.Reactions = nil
+			return out, nil
+		}
+	}
+	return models.DBMessage{}, repository.ErrMessageNotFound
+}
+
+// GetConversation pages through a doctor/patient conversation with the same
+// cursor and seq semantics as the database.
+func (s *Store) GetConversation(ctx context.Context, locationID, contactID, userID string, page models.PageRequest) (models.HistoryPage, error) {
+	if err := checkContext(ctx); err != nil {
+		return models.HistoryPage{}, err
+	}
+
+	limit := clampLimit(page.Limit)
+
+	forward := page.After != "" && page.Before == ""
+	raw := page.Before
+	if forward {
+		raw = page.After
+	}
+	var cursor *models.DBMessage
+	if raw != "" {
+		at, id, err := repository.DecodeMessageCursor(raw)
+		if err != nil {
+			return models.HistoryPage{}, err
+		}
+		cursor = &models.DBMessage{SentAt: at, ID: id}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var all []*models.DBMessage
+	for _, m := range s.messages {
+		if m.deletedAt != nil || m.LocationID.String() != locationID {
+			continue
+		}
+		toPatient := m.SenderUserID.String() == userID && m.ReceiverContactID.String() == contactID
+		toDoctor := m.SenderContactID.String() == contactID && m.ReceiverUserID.String() == userID
+		if !toPatient && !toDoctor {
+			continue
+		}
+		all = append(all, &m.DBMessage)
+	}
+
+	keep := func(*models.DBMessage) bool { return true }
+	less := func(a, b *models.DBMessage) bool { return before(b, a) }
+	switch {
+	case page.AfterSeq > 0 && page.BeforeSeq == 0:
+		forward = true
+		keep = func(m *models.DBMessage) bool { return m.Seq > page.AfterSeq }
+		less = func(a, b *models.DBMessage) bool { return a.Seq < b.Seq }
+	case page.BeforeSeq > 0:
+		forward = false
+		keep = func(m *models.DBMessage) bool { return m.Seq < page.BeforeSeq }
+		less = func(a, b *models.DBMessage) bool { return a.Seq > b.Seq }
+	case forward:
+		keep = func(m *models.DBMessage) bool { return before(cursor, m) }
+		less = before
+	case cursor != nil:
+		keep = func(m *models.DBMessage) bool { return before(m, cursor) }
+	}
+
+	var selected []*models.DBMessage
+	for _, m := range all {
+		if keep(m) {
+			selected = append(selected, m)
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool { return less(selected[i], selected[j]) })
+
+	more := len(selected) > limit
+	if more {
+		selected = selected[:limit]
+	}
+	messages := make([]models.DBMessage, 0, len(selected))
+	for _, m := range selected {
+		messages = append(messages, s.view(s.messages[m.ID]))
+	}
+	if !forward {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+
+	result := models.HistoryPage{Messages: messages}
+	if forward {
+		result.HasNewer, result.HasOlder = more, true
+	} else {
+		result.HasOlder, result.HasNewer = more, cursor != nil || page.BeforeSeq > 0
+	}
+	if len(messages) > 0 {
+		first, last := messages[0], messages[len(messages)-1]
+		result.PrevCursor = repository.EncodeMessageCursor(first.SentAt, first.ID)
+		result.NextCursor = repository.EncodeMessageCursor(last.SentAt, last.ID)
+	}
+	s.attachThreadInfo(result.Messages)
+	return result, nil
+}
+
+// GetThread returns a message and one page of every reply beneath it.
+func (s *Store) GetThread(ctx context.Context, rootID string, after string, limit int) (models.ThreadPage, error) {
+	if err := checkContext(ctx); err != nil {
+		return models.ThreadPage{}, err
+	}
+
+	limit = clampLimit(limit)
+	var cursor *models.DBMessage
+	if after != "" {
+		at, id, err := repository.DecodeMessageCursor(after)
+		if err != nil {
+			return models.ThreadPage{}, err
+		}
+		cursor = &models.DBMessage{SentAt: at, ID: id}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	root, ok := s.live(rootID)
+	if !ok {
+		return models.ThreadPage{}, repository.ErrMessageNotFound
+	}
+
+	// Walk the whole reply tree, through deleted replies too
+	inThread := map[uuid.UUID]bool{root.ID: true}
+	for grew := true; grew; {
+		grew = false
+		for _, m := range s.messages {
+			if m.ReplyToID != nil && inThread[*m.ReplyToID] && !inThread[m.ID] {
+				inThread[m.ID] = true
+				grew = true
+			}
+		}
+	}
+
+	var replies []*models.DBMessage
+	for id := range inThread {
+		m := s.messages[id]
+		if id == root.ID || m.deletedAt != nil || (cursor != nil && !before(cursor, &m.DBMessage)) {
+			continue
+		}
+		replies = append(replies, &m.DBMessage)
+	}
+	sort.Slice(replies, func(i, j int) bool { return before(replies[i], replies[j]) })
+
+	page := models.ThreadPage{}
+	if len(replies) > limit {
+		replies = replies[:limit]
+		page.HasMore = true
+	}
+	if len(replies) > 0 {
+		last := replies[len(replies)-1]
+		page.NextCursor = repository.EncodeMessageCursor(last.SentAt, last.ID)
+	}
+
+	all := []models.DBMessage{s.view(root)}
+	for _, m := range replies {
+		all = append(all, s.view(s.messages[m.ID]))
+	}
+	s.attachThreadInfo(all)
+	page.Root, page.Replies = all[0], all[1:]
+	return page, nil
+}
+
+// attachThreadInfo fills in quoted parents and reply counts. Callers hold s.mu.
+func (s *Store) attachThreadInfo(messages []models.DBMessage) {
+	for i := range messages {
+		n := 0
+		for _, m := range s.messages {
+			if m.deletedAt == nil && m.ReplyToID != nil && *m.ReplyToID == messages[i].ID {
+				n++
+			}
+		}
+		messages[i].ReplyCount = n
+
+		if messages[i].ReplyToID == nil {
+			continue
+		}
+		parent, ok := s.messages[*messages[i].ReplyToID]
+		if !ok {
+			continue
+		}
+		p := s.view(parent)
+		q := &models.QuotedMessage{
+			ID:          p.ID.String(),
+			Content:     p.Content,
+			MessageType: p.MessageType,
+			FileName:    p.FileName,
+			SentAt:      p.SentAt,
+			Deleted:     parent.deletedAt != nil,
+		}
+		if p.SenderUserID != uuid.Nil {
+			q.SenderUserID = p.SenderUserID.String()
+		}
+		if p.SenderContactID != uuid.Nil {
+			q.SenderContactID = p.SenderContactID.String()
+		}
+		if q.Deleted {
+			q.Content, q.FileName = "", ""
+		}
+		if utf8.RuneCountInString(q.Content) > quotePreviewLength {
+			q.Content = string([]rune(q.Content)[:quotePreviewLength]) + "…"
+		}
+		messages[i].ReplyTo = q
+	}
+}
+
+// UpdateMessageContent replaces a message's content and keeps the replaced
+// version as a revision.
+func (s *Store) UpdateMessageContent(ctx context.Context, msgID, editorID, newContent string) (time.Time, models.SessionEvent, error) {
+	if err := checkContext(ctx); err != nil {
+		return time.Time{}, models.SessionEvent{}, err
+	}
+
+	if _, err := uuid.Parse(msgID); err != nil {
+		return time.Time{}, models.SessionEvent{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.live(msgID)
+	if !ok {
+		return time.Time{}, models.SessionEvent{}, repository.ErrMessageNotFound
+	}
+	if s.onHold(m) {
+		return time.Time{}, models.SessionEvent{}, repository.ErrLegalHold
+	}
+
+	validFrom := m.SentAt
+	if m.EditedAt != nil {
+		validFrom = *m.EditedAt
+	}
+	editedAt := now()
+	revs := s.revisions[m.ID]
+	s.revisions[m.ID] = append(revs, models.MessageRevision{
+		ID:         uuid.New().String(),
+		MessageID:  msgID,
+		Revision:   len(revs) + 1,
+		Content:    m.Content,
+		EditedBy:   editorID,
+		ValidFrom:  validFrom,
+		ReplacedAt: editedAt,
+	})
+	m.Content, m.EditedAt = newContent, &editedAt
+
+	ev := s.appendEvent(ctx, m.SessionID, models.EventMessageEdited, &m.ID, map[string]any{
+		"content":   newContent,
+		"edited_at": editedAt,
+	})
+	return editedAt, ev, nil
+}
+
+func (s *Store) GetMessageRevisions(ctx context.Context, msgID string) ([]models.MessageRevision, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.Parse(msgID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.MessageRevision{}, s.revisions[id]...), nil
+}
+
+// DeleteMessage soft-deletes a message. Deleting an already deleted message
+// returns the zero event.
+func (s *Store) DeleteMessage(ctx context.Context, id uuid.UUID) (models.SessionEvent, error) {
+	if err := checkContext(ctx); err != nil {
+		return models.SessionEvent{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, ok := s.messages[id]; ok && s.onHold(m) {
+		return models.SessionEvent{}, repository.ErrLegalHold
+	}
+	return s.deleteMessage(ctx, id), nil
+}
+
+func (s *Store) AdminDeleteMessages(ctx context.Context, ids []uuid.UUID) ([]models.SessionEvent, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		if m, ok := s.messages[id]; ok && s.onHold(m) {
+			return nil, repository.ErrLegalHold
+		}
+	}
+	events := []models.SessionEvent{}
+	for _, id := range ids {
+		if ev := s.deleteMessage(ctx, id); ev.Seq > 0 {
+			events = append(events, ev)
+		}
+	}
+	return events, nil
+}
+
+// deleteMessage soft-deletes one message. Callers hold s.mu.
+func (s *Store) deleteMessage(ctx context.Context, id uuid.UUID) models.SessionEvent {
+	m, ok := s.messages[id]
+	if !ok || m.deletedAt != nil {
+		return models.SessionEvent{}
+	}
+	t := now()
+	m.deletedAt = &t
+	return s.appendEvent(ctx, m.SessionID, models.EventMessageDeleted, &id, nil)
+}
+
+// MarkMessagesRead marks messages read and records one messages_read event
+// per session touched.
+func (s *Store) MarkMessagesRead(ctx context.Context, ids []string) ([]models.SessionEvent, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	var uuids []uuid.UUID
+	for _, id := range ids {
+		u, err := uuid.Parse(id)
+		if err != nil {
+			return nil, err
+		}
+		uuids = append(uuids, u)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	readAt := now()
+	var sessions []uuid.UUID
+	bySession := make(map[uuid.UUID][]string)
+	for _, id := range uuids {
+		m, ok := s.messages[id]
+		if !ok || m.IsRead {
+			continue
+		}
+		m.IsRead, m.ReadAt = true, &readAt
+		if _, seen := bySession[m.SessionID]; !seen {
+			sessions = append(sessions, m.SessionID)
+		}
+		bySession[m.SessionID] = append(bySession[m.SessionID], id.String())
+	}
+
+	events := []models.SessionEvent{}
+	for _, sid := range sessions {
+		events = append(events, s.appendEvent(ctx, sid, models.EventMessagesRead, nil, map[string]any{
+			"message_ids": bySession[sid],
+			"read_at":     readAt,
+		}))
+	}
+	return events, nil
+}
+
+func (s *Store) MarkMessagesDelivered(ctx context.Context, ids []uuid.UUID) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := now()
+	for _, id := range ids {
+		if m, ok := s.messages[id]; ok {
+			m.DeliveredAt = &t
+		}
+	}
+	return nil
+}
+
+func (s *Store) AddReaction(ctx context.Context, msgID, userID, emoji string) (models.SessionEvent, error) {
+	if err := checkContext(ctx); err != nil {
+		return models.SessionEvent{}, err
+	}
+
+	id, err := uuid.Parse(msgID)
+	if err != nil {
+		return models.SessionEvent{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.messages[id]
+	if !ok {
+		return models.SessionEvent{}, repository.ErrMessageNotFound
+	}
+	for _, r := range s.reactions[id] {
+		if r.UserID == userID && r.Emoji == emoji {
+			return models.SessionEvent{}, nil
+		}
+	}
+	s.reactions[id] = append(s.reactions[id], models.MessageReaction{
+		ID:        uuid.New().String(),
+		MessageID: msgID,
+		UserID:    userID,
+		Emoji:     emoji,
+		CreatedAt: now(),
+	})
+	return s.appendEvent(ctx, m.SessionID, models.EventReactionAdded, &id, map[string]string{
+		"user_id": userID,
+		"emoji":   emoji,
+	}), nil
+}
+
+func (s *Store) RemoveReaction(ctx context.Context, msgID, userID, emoji string) (models.SessionEvent, error) {
+	if err := checkContext(ctx); err != nil {
+		return models.SessionEvent{}, err
+	}
+
+	id, err := uuid.Parse(msgID)
+	if err != nil {
+		return models.SessionEvent{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rs := s.reactions[id]
+	for i, r := range rs {
+		if r.UserID == userID && r.Emoji == emoji {
+			s.reactions[id] = append(rs[:i:i], rs[i+1:]...)
+			return s.appendEvent(ctx, s.messages[id].SessionID, models.EventReactionRemoved, &id, map[string]string{
+				"user_id": userID,
+				"emoji":   emoji,
+			}), nil
+		}
+	}
+	return models.SessionEvent{}, nil
+}
+
+func (s *Store) GetReactions(ctx context.Context, msgID string) ([]models.MessageReaction, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.Parse(msgID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.MessageReaction(nil), s.reactions[id]...), nil
+}
+
+// TogglePinMessage pins or unpins a message. Setting the state it already
+// has, or pinning an unknown message, returns the zero event.
+func (s *Store) TogglePinMessage(ctx context.Context, msgID string, pin bool) (models.SessionEvent, error) {
+	if err := checkContext(ctx); err != nil {
+		return models.SessionEvent{}, err
+	}
+
+	id, err := uuid.Parse(msgID)
+	if err != nil {
+		return models.SessionEvent{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.messages[id]
+	if !ok || m.IsPinned == pin {
+		return models.SessionEvent{}, nil
+	}
+	m.IsPinned = pin
+
+	eventType := models.EventMessageUnpinned
+	if pin {
+		eventType = models.EventMessagePinned
+	}
+	return s.appendEvent(ctx, m.SessionID, eventType, &id, nil), nil
+}
+
+func (s *Store) GetPinnedMessages(ctx context.Context, sessionID string) ([]models.PinnedMessage, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	sid, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pinned []models.PinnedMessage
+	for _, m := range s.messages {
+		if m.SessionID == sid && m.IsPinned {
+			pinned = append(pinned, models.PinnedMessage{
+				ID:       m.ID.String(),
+				Content:  m.Content,
+				FileURL:  m.FileURL,
+				FileName: m.FileName,
+				FileType: m.FileType,
+				SentAt:   m.SentAt,
+			})
+		}
+	}
+	sort.Slice(pinned, func(i, j int) bool { return pinned[i].SentAt.After(pinned[j].SentAt) })
+	return pinned, nil
+}
@@ -0,0 +1,154 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"internal_chat_system/models"
+	"internal_chat_system/repository"
+
+	"github.com/google/uuid"
+)
+
+// conversation saves contents from a doctor to a patient and returns the
+// saved messages.
+func conversation(t *testing.T, s *Store, contents ...string) (loc, doc, pat string, sent []*models.Message) {
+	t.Helper()
+	loc, doc, pat = uuid.NewString(), uuid.NewString(), uuid.NewString()
+	for _, c := range contents {
+		msg := &models.Message{ID: uuid.NewString(), LocationID: loc, SenderUserID: doc, ReceiverContactID: pat, Content: c}
+		if err := s.SaveMessage(context.Background(), msg); err != nil {
+			t.Fatal(err)
+		}
+		sent = append(sent, msg)
+	}
+	return loc, doc, pat, sent
+}
+
+func TestSaveMessageNumbersSession(t *testing.T) {
+	s := New()
+	_, _, _, sent := conversation(t, s, "a", "b", "c")
+	for i, msg := range sent {
+		if msg.Seq != int64(i+1) || msg.SessionID != sent[0].SessionID {
+			t.Errorf("message %d saved as session %s seq %d", i, msg.SessionID, msg.Seq)
+		}
+	}
+}
+
+func TestSaveMessageIdempotent(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+	loc, doc, pat := uuid.NewString(), uuid.NewString(), uuid.NewString()
+	first := &models.Message{ID: uuid.NewString(), LocationID: loc, SenderUserID: doc, ReceiverContactID: pat, Content: "hi", ClientMessageID: "c-1"}
+	if err := s.SaveMessage(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		sender string
+		want   error
+	}{
+		{"same sender and client id", doc, repository.ErrDuplicateClientMessage},
+		{"another sender may reuse it", uuid.NewString(), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retry := *first
+			retry.ID, retry.SenderUserID = uuid.NewString(), tt.sender
+			if err := s.SaveMessage(ctx, &retry); !errors.Is(err, tt.want) {
+				t.Errorf("SaveMessage = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	got, err := s.GetMessageByClientID(ctx, doc, "c-1")
+	if err != nil || got.ID.String() != first.ID {
+		t.Errorf("GetMessageByClientID = %s, %v, want %s", got.ID, err, first.ID)
+	}
+}
+
+func TestGetConversationPages(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+	loc, doc, pat, _ := conversation(t, s, "1", "2", "3", "4", "5")
+	all, err := s.GetConversation(ctx, loc, pat, doc, models.PageRequest{})
+	if err != nil || len(all.Messages) != 5 {
+		t.Fatalf("full history = %d messages, %v", len(all.Messages), err)
+	}
+	ids := func(page models.HistoryPage) []uuid.UUID {
+		var out []uuid.UUID
+		for _, m := range page.Messages {
+			out = append(out, m.ID)
+		}
+		return out
+	}
+	want := ids(all)
+
+	tests := []struct {
+		name      string
+		req       models.PageRequest
+		from, to  int // the expected slice of the full history
+		older     bool
+		newer     bool
+		wantError bool
+	}{
+		{"latest", models.PageRequest{Limit: 2}, 3, 5, true, false, false},
+		{"before a cursor", models.PageRequest{Before: all.NextCursor, Limit: 2}, 2, 4, true, true, false},
+		{"after a cursor", models.PageRequest{After: all.PrevCursor, Limit: 2}, 1, 3, true, true, false},
+		{"after seq", models.PageRequest{AfterSeq: 3, Limit: 10}, 3, 5, true, false, false},
+		{"before seq", models.PageRequest{BeforeSeq: 2, Limit: 10}, 0, 1, false, true, false},
+		{"bad cursor", models.PageRequest{Before: "not-a-cursor"}, 0, 0, false, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := s.GetConversation(ctx, loc, pat, doc, tt.req)
+			if tt.wantError {
+				if err == nil {
+					t.Error("GetConversation accepted a bad cursor")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ids(page)
+			if len(got) != tt.to-tt.from {
+				t.Fatalf("got %d messages, want %d", len(got), tt.to-tt.from)
+			}
+			for i, id := range got {
+				if id != want[tt.from+i] {
+					t.Errorf("message %d = %s, want %s", i, id, want[tt.from+i])
+				}
+			}
+			if page.HasOlder != tt.older || page.HasNewer != tt.newer {
+				t.Errorf("has older/newer = %v/%v, want %v/%v", page.HasOlder, page.HasNewer, tt.older, tt.newer)
+			}
+		})
+	}
+}
+
+func TestDeleteMessage(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+	loc, doc, pat, sent := conversation(t, s, "keep", "drop")
+	id := uuid.MustParse(sent[1].ID)
+
+	ev, err := s.DeleteMessage(ctx, id)
+	if err != nil || ev.Type != models.EventMessageDeleted || ev.Seq != 3 {
+		t.Fatalf("DeleteMessage = %+v, %v", ev, err)
+	}
+	// Deleting twice records nothing
+	if ev, err := s.DeleteMessage(ctx, id); err != nil || ev.Seq != 0 {
+		t.Errorf("second DeleteMessage = %+v, %v, want the zero event", ev, err)
+	}
+
+	page, err := s.GetConversation(ctx, loc, pat, doc, models.PageRequest{})
+	if err != nil || len(page.Messages) != 1 || page.Messages[0].Content != "keep" {
+		t.Errorf("history after delete = %+v, %v", page.Messages, err)
+	}
+	if _, err := s.GetMessageByID(ctx, id.String()); !errors.Is(err, repository.ErrMessageNotFound) {
+		t.Errorf("GetMessageByID of a deleted message = %v, want ErrMessageNotFound", err)
+	}
+}
@@ -0,0 +1,91 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"internal_chat_system/models"
+
+	"github.com/google/uuid"
+)
+
+func patientMessage(locationID, doctorID, patientID, content string) *models.Message {
+	return &models.Message{
+		ID:                uuid.NewString(),
+		LocationID:        locationID,
+		SenderUserID:      doctorID,
+		ReceiverUserID:    doctorID,
+		SenderContactID:   patientID,
+		ReceiverContactID: patientID,
+		Content:           content,
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+	loc, doc, pat := uuid.NewString(), uuid.NewString(), uuid.NewString()
+	if err := s.SaveMessage(ctx, patientMessage(loc, doc, pat, "hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Handlers run without the store's lock held, so an auto-reply can be
+	// saved from inside one; a failed push is retried later
+	var handled []string
+	n, err := s.Dispatch(ctx, 10, func(ctx context.Context, ev models.OutboxEvent) error {
+		handled = append(handled, ev.EventType)
+		switch ev.EventType {
+		case models.OutboxMessageAutoReply:
+			reply := &models.Message{
+				ID: uuid.NewString(), LocationID: loc, SenderUserID: doc, ReceiverContactID: pat,
+				Content: "closed", IsSystem: true,
+			}
+			return s.SaveMessage(ctx, reply)
+		case models.OutboxMessagePush:
+			return errors.New("push gateway down")
+		}
+		return nil
+	})
+	if err != nil || n != 3 {
+		t.Fatalf("Dispatch = %d, %v, want 3 events", n, err)
+	}
+	want := []string{models.OutboxMessageDeliver, models.OutboxMessagePush, models.OutboxMessageAutoReply}
+	for i, ev := range want {
+		if i >= len(handled) || handled[i] != ev {
+			t.Fatalf("handled %v, want %v", handled, want)
+		}
+	}
+
+	// Next round: the reply's deliver and push; the failed push is backing off
+	var retried bool
+	n, err = s.Dispatch(ctx, 10, func(ctx context.Context, ev models.OutboxEvent) error {
+		if ev.Attempts > 0 {
+			retried = true
+		}
+		return nil
+	})
+	if err != nil || n != 2 || retried {
+		t.Errorf("second Dispatch = %d, %v, retried %v; want the reply's 2 events only", n, err, retried)
+	}
+	if pending := len(s.outbox); pending != 1 {
+		t.Errorf("%d events pending, want the failed push", pending)
+	}
+}
+
+func TestDispatchBatch(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+	loc, doc, pat := uuid.NewString(), uuid.NewString(), uuid.NewString()
+	for range 3 {
+		if err := s.SaveMessage(ctx, patientMessage(loc, doc, pat, "hi")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ok := func(context.Context, models.OutboxEvent) error { return nil }
+	for _, want := range []int{4, 4, 1, 0} {
+		if n, err := s.Dispatch(ctx, 4, ok); err != nil || n != want {
+			t.Fatalf("Dispatch = %d, %v, want %d", n, err, want)
+		}
+	}
+}
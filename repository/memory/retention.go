@@ -0,0 +1,179 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"internal_chat_system/models"
+	"internal_chat_system/repository"
+
+	"github.com/google/uuid"
+)
+
+func (s *Store) GetRetentionPolicy(ctx context.Context, locationID string) (models.RetentionPolicy, error) {
+	if err := checkContext(ctx); err != nil {
+		return models.RetentionPolicy{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.retentionPolicies[locationID]
+	if !ok {
+		return models.RetentionPolicy{}, repository.ErrNoRetentionPolicy
+	}
+	return p, nil
+}
+
+func (s *Store) UpsertRetentionPolicy(ctx context.Context, p *models.RetentionPolicy) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	if _, err := uuid.Parse(p.LocationID); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.UpdatedAt = now()
+	s.retentionPolicies[p.LocationID] = *p
+	if e, ok := repository.AuditForChange(ctx, p.LocationID, "retention_policy", p.LocationID, p); ok {
+		s.record(&e)
+	}
+	return nil
+}
+
+func (s *Store) ListRetentionPolicies(ctx context.Context) ([]models.RetentionPolicy, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	policies := []models.RetentionPolicy{}
+	for _, p := range s.retentionPolicies {
+		policies = append(policies, p)
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].LocationID < policies[j].LocationID })
+	return policies, nil
+}
+
+// PurgeExpiredMessages applies a policy in one pass under the lock, with
+// the same effects as the database purge.
+func (s *Store) PurgeExpiredMessages(ctx context.Context, p models.RetentionPolicy, at time.Time) (models.RetentionReport, error) {
+	report := models.RetentionReport{
+		LocationID:        p.LocationID,
+		Action:            p.Action,
+		StartedAt:         at,
+		FailedAttachments: []string{},
+	}
+	if err := checkContext(ctx); err != nil {
+		return report, err
+	}
+	var deletedCutoff, cutoff time.Time
+	if p.DeletedMaxAgeDays != nil {
+		deletedCutoff = at.AddDate(0, 0, -*p.DeletedMaxAgeDays)
+		report.DeletedCutoff = &deletedCutoff
+	}
+	if p.MaxAgeDays != nil {
+		cutoff = at.AddDate(0, 0, -*p.MaxAgeDays)
+		report.Cutoff = &cutoff
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions := make(map[uuid.UUID]bool)
+	purged := make(map[string]bool)
+	for id, m := range s.messages {
+		if m.LocationID.String() != p.LocationID || s.onHold(m) {
+			continue
+		}
+		action := ""
+		switch {
+		case report.DeletedCutoff != nil && m.deletedAt != nil && m.deletedAt.Before(deletedCutoff):
+			action = models.RetentionDelete
+		case report.Cutoff != nil && m.SentAt.Before(cutoff):
+			action = p.Action
+			if action == models.RetentionAnonymize && m.anonymizedAt != nil {
+				continue
+			}
+		default:
+			continue
+		}
+
+		report.ReactionsRemoved += int64(len(s.reactions[id]))
+		report.RevisionsRemoved += int64(len(s.revisions[id]))
+		delete(s.reactions, id)
+		delete(s.revisions, id)
+		if m.FileURL != "" {
+			report.FileURLs = append(report.FileURLs, m.FileURL)
+		}
+		sessions[m.SessionID] = true
+		purged[id.String()] = true
+
+		event := models.EventMessageDeleted
+		if action == models.RetentionAnonymize {
+			t := now()
+			m.Content, m.FileURL, m.FileName, m.FileType = "", "", "", ""
+			m.anonymizedAt = &t
+			report.MessagesAnonymized++
+			event = models.EventMessageAnonymized
+		} else {
+			delete(s.messages, id)
+			report.MessagesDeleted++
+		}
+		// Deleted messages were already announced
+		if m.deletedAt == nil {
+			s.appendEvent(ctx, m.SessionID, event, &id, nil)
+		}
+	}
+
+	for sid := range sessions {
+		events := s.events[sid]
+		for i := range events {
+			if purged[events[i].MessageID] {
+				events[i].Data = nil
+			}
+		}
+		report.SessionIDs = append(report.SessionIDs, sid.String())
+	}
+	report.SessionsAffected = int64(len(sessions))
+
+	pending := s.outbox[:0]
+	for _, e := range s.outbox {
+		if !purged[e.AggregateID] {
+			pending = append(pending, e)
+		}
+	}
+	s.outbox = pending
+	return report, nil
+}
+
+func (s *Store) SaveRetentionReport(ctx context.Context, r *models.RetentionReport) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.ID = uuid.New().String()
+	s.retentionReports = append(s.retentionReports, *r)
+	return nil
+}
+
+func (s *Store) ListRetentionReports(ctx context.Context, locationID string, limit int) ([]models.RetentionReport, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reports := []models.RetentionReport{}
+	for i := len(s.retentionReports) - 1; i >= 0 && len(reports) < limit; i-- {
+		if r := s.retentionReports[i]; r.LocationID == locationID {
+			reports = append(reports, r)
+		}
+	}
+	return reports, nil
+}
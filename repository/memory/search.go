@@ -0,0 +1,225 @@
+package memory
+
+import (
+	"context"
+	"html"
+	"sort"
+	"strings"
+
+	"internal_chat_system/models"
+)
+
+const maxSearchResults = 100
+
+// searchQuery is a websearch-style query reduced to what the in-memory store
+// can match: lowercase terms and "quoted phrases" that must all appear, and
+// -excluded terms that must not. "or" is ignored, so alternatives are ANDed.
+type searchQuery struct {
+	include []string
+	exclude []string
+}
+
+func parseSearchQuery(q string) searchQuery {
+	var sq searchQuery
+	q = strings.ToLower(q)
+	for q != "" {
+		q = strings.TrimLeft(q, " \t\n")
+		if q == "" {
+			break
+		}
+		negate := strings.HasPrefix(q, "-")
+		if negate {
+			q = q[1:]
+		}
+
+		var term string
+		if strings.HasPrefix(q, `"`) {
+			end := strings.Index(q[1:], `"`)
+			if end < 0 {
+				term, q = q[1:], ""
+			} else {
+				term, q = q[1:end+1], q[end+2:]
+			}
+		} else {
+			end := strings.IndexAny(q, " \t\n")
+			if end < 0 {
+				end = len(q)
+			}
+			term, q = q[:end], q[end:]
+		}
+		term = strings.TrimSpace(term)
+		if term == "" || term == "or" {
+			continue
+		}
+		if negate {
+			sq.exclude = append(sq.exclude, term)
+		} else {
+			sq.include = append(sq.include, term)
+		}
+	}
+	return sq
+}
+
+// rank scores text against the query, or returns false if it doesn't match.
+// Matches in the file name count for less than matches in the content.
+func (sq searchQuery) rank(content, fileName string) (float64, bool) {
+	if len(sq.include) == 0 {
+		return 0, false
+	}
+	content, fileName = strings.ToLower(content), strings.ToLower(fileName)
+	for _, t := range sq.exclude {
+		if strings.Contains(content, t) || strings.Contains(fileName, t) {
+			return 0, false
+		}
+	}
+	var rank float64
+	for _, t := range sq.include {
+		c, f := strings.Count(content, t), strings.Count(fileName, t)
+		if c+f == 0 {
+			return 0, false
+		}
+		rank += float64(c) + 0.4*float64(f)
+	}
+	return rank / float64(len(sq.include)), true
+}
+
+// snippet escapes content and wraps every match in <mark> tags.
+func (sq searchQuery) snippet(content string) string {
+	lower := strings.ToLower(content)
+	marked := make([]bool, len(content))
+	for _, t := range sq.include {
+		for i := 0; ; {
+			j := strings.Index(lower[i:], t)
+			if j < 0 {
+				break
+			}
+			for k := i + j; k < i+j+len(t) && k < len(marked); k++ {
+				marked[k] = true
+			}
+			i += j + len(t)
+		}
+	}
+
+	var sb strings.Builder
+	open := false
+	for i := 0; i < len(content); {
+		if marked[i] != open {
+			if open {
+				sb.WriteString("</mark>")
+			} else {
+				sb.WriteString("<mark>")
+			}
+			open = marked[i]
+		}
+		// Copy whole runes so multi-byte characters are never split
+		j := i + 1
+		for j < len(content) && !utf8Start(content[j]) {
+			j++
+		}
+		sb.WriteString(html.EscapeString(content[i:j]))
+		i = j
+	}
+	if open {
+		sb.WriteString("</mark>")
+	}
+	return sb.String()
+}
+
+func utf8Start(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// SearchMessages matches messages with the same filters as the database
+// search, but with plain substring matching instead of stemming; the
+// filter's Language is ignored.
+func (s *Store) SearchMessages(ctx context.Context, f models.SearchFilter) ([]models.SearchResult, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	sq := parseSearchQuery(f.Query)
+
+	limit := f.Limit
+	if limit <= 0 || limit > maxSearchResults {
+		limit = maxSearchResults
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := []models.SearchResult{}
+	for _, m := range s.messages {
+		if m.deletedAt != nil || m.LocationID.String() != f.LocationID || !matchesFilter(&m.DBMessage, f) {
+			continue
+		}
+		rank, ok := sq.rank(m.Content, m.FileName)
+		if !ok {
+			continue
+		}
+		msg := s.view(m)
+		msg.Reactions = nil
+		res := models.SearchResult{
+			Message: msg,
+			Rank:    rank,
+			Snippet: sq.snippet(m.Content),
+		}
+		if sess, ok := s.sessions[m.SessionID]; ok {
+			res.SessionUserID, res.SessionContactID = sess.UserID.String(), sess.ContactID.String()
+		}
+		results = append(results, res)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		return results[i].Message.SentAt.After(results[j].Message.SentAt)
+	})
+
+	if f.Offset >= len(results) {
+		return []models.SearchResult{}, nil
+	}
+	results = results[f.Offset:]
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+func matchesFilter(m *models.DBMessage, f models.SearchFilter) bool {
+	user, contact := f.UserID, f.ContactID
+	switch {
+	case user != "" && contact != "":
+		toPatient := m.SenderUserID.String() == user && m.ReceiverContactID.String() == contact
+		toDoctor := m.SenderContactID.String() == contact && m.ReceiverUserID.String() == user
+		if !toPatient && !toDoctor {
+			return false
+		}
+	case user != "":
+		if m.SenderUserID.String() != user && m.ReceiverUserID.String() != user {
+			return false
+		}
+	case contact != "":
+		if m.SenderContactID.String() != contact && m.ReceiverContactID.String() != contact {
+			return false
+		}
+	}
+	if f.SessionID != "" && m.SessionID.String() != f.SessionID {
+		return false
+	}
+	if f.SenderID != "" && senderKey(m).String() != f.SenderID {
+		return false
+	}
+	if f.From != nil && m.SentAt.Before(*f.From) {
+		return false
+	}
+	if f.To != nil && !m.SentAt.Before(*f.To) {
+		return false
+	}
+	if f.HasAttachment != nil && (m.FileURL != "") != *f.HasAttachment {
+		return false
+	}
+	if f.Pinned != nil && m.IsPinned != *f.Pinned {
+		return false
+	}
+	return true
+}
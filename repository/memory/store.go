@@ -0,0 +1,615 @@
+// Package memory is an in-memory implementation of the repository
+// interfaces. It keeps the semantics of the PostgreSQL repos (sessions,
+// seq numbering, outbox, idempotent sends) so handlers can be exercised
+// without a database. Everything is lost when the process exits.
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"internal_chat_system/models"
+	"internal_chat_system/repository"
+
+	"github.com/google/uuid"
+)
+
+// maxOutboxAttempts matches the PostgreSQL outbox.
+const maxOutboxAttempts = 10
+
+type pairKey struct {
+	contactID, userID, locationID uuid.UUID
+}
+
+type session struct {
+	models.ChatSession
+	lastSeq      int64
+	lastActivity time.Time
+}
+
+type message struct {
+	models.DBMessage
+	deletedAt    *time.Time
+	anonymizedAt *time.Time
+}
+
+type outboxEntry struct {
+	models.OutboxEvent
+	availableAt time.Time
+	claimed     bool
+	done        bool
+}
+
+// eventRef points at an event in Store.events.
+type eventRef struct {
+	sessionID uuid.UUID
+	seq       int64
+}
+
+type officeHoursKey struct {
+	locationID, userID string
+}
+
+// Store holds all data behind one mutex. The zero value is not usable; use New.
+type Store struct {
+	mu sync.Mutex
+
+	sessions      map[uuid.UUID]*session
+	sessionByPair map[pairKey]uuid.UUID
+	messages      map[uuid.UUID]*message
+	revisions     map[uuid.UUID][]models.MessageRevision
+	reactions     map[uuid.UUID][]models.MessageReaction
+	events        map[uuid.UUID][]models.SessionEvent
+	eventLog      []eventRef // every event in write order, for sync
+
+	outbox       []*outboxEntry
+	nextOutboxID int64
+
+	deviceTokens map[string]string
+	officeHours  map[officeHoursKey]*models.OfficeHours
+	holidays     []models.OfficeHoliday
+	audit        []models.AuditEntry
+
+	retentionPolicies map[string]models.RetentionPolicy
+	retentionReports  []models.RetentionReport
+	legalHolds        []models.LegalHold
+}
+
+func New() *Store {
+	return &Store{
+		sessions:      make(map[uuid.UUID]*session),
+		sessionByPair: make(map[pairKey]uuid.UUID),
+		messages:      make(map[uuid.UUID]*message),
+		revisions:     make(map[uuid.UUID][]models.MessageRevision),
+		reactions:     make(map[uuid.UUID][]models.MessageReaction),
+		events:        make(map[uuid.UUID][]models.SessionEvent),
+		deviceTokens:  make(map[string]string),
+		officeHours:   make(map[officeHoursKey]*models.OfficeHours),
+
+		retentionPolicies: make(map[string]models.RetentionPolicy),
+	}
+}
+
+var (
+	_ repository.MessageStore     = (*Store)(nil)
+	_ repository.SessionStore     = (*Store)(nil)
+	_ repository.ReactionStore    = (*Store)(nil)
+	_ repository.PinStore         = (*Store)(nil)
+	_ repository.DeviceTokenStore = (*Store)(nil)
+	_ repository.OfficeHoursStore = (*Store)(nil)
+	_ repository.AuditStore       = (*Store)(nil)
+	_ repository.OutboxStore      = (*Store)(nil)
+	_ repository.RetentionStore   = (*Store)(nil)
+	_ repository.LegalHoldStore   = (*Store)(nil)
+	_ repository.EncryptionStore  = (*Store)(nil)
+)
+
+// now is truncated to microseconds like PostgreSQL timestamps, so cursors
+// made from stored times compare the same way.
+func now() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
+
+// appendEvent numbers a change in its session. Callers hold s.mu. As with
+// the database, messages without a session get the zero event.
+func (s *Store) appendEvent(ctx context.Context, sessionID uuid.UUID, eventType string, messageID *uuid.UUID, data any) models.SessionEvent {
+	sess, ok := s.sessions[sessionID]
+	if !ok {
+		return models.SessionEvent{}
+	}
+	sess.lastSeq++
+
+	ev := models.SessionEvent{
+		Type:       eventType,
+		SessionID:  sessionID.String(),
+		Seq:        sess.lastSeq,
+		CreatedAt:  now(),
+		LocationID: sess.LocationID.String(),
+		UserID:     sess.UserID.String(),
+		ContactID:  sess.ContactID.String(),
+	}
+	sess.lastActivity = ev.CreatedAt
+	if messageID != nil {
+		ev.MessageID = messageID.String()
+	}
+	if data != nil {
+		ev.Data, _ = json.Marshal(data)
+	}
+	s.events[sessionID] = append(s.events[sessionID], ev)
+	s.eventLog = append(s.eventLog, eventRef{sessionID, ev.Seq})
+	if e, ok := repository.AuditForEvent(ctx, ev); ok {
+		s.record(&e)
+	}
+	return ev
+}
+
+// upsertSession finds or creates the session of a pair and sets its
+// last activity. Callers hold s.mu.
+func (s *Store) upsertSession(contactID, userID, locationID uuid.UUID, at time.Time) *session {
+	key := pairKey{contactID, userID, locationID}
+	if id, ok := s.sessionByPair[key]; ok {
+		sess := s.sessions[id]
+		sess.LastMessageAt = &at
+		return sess
+	}
+	sess := &session{ChatSession: models.ChatSession{
+		ID:            uuid.New(),
+		ContactID:     contactID,
+		UserID:        userID,
+		LocationID:    locationID,
+		StartedAt:     at,
+		LastMessageAt: &at,
+	}, lastActivity: at}
+	s.sessions[sess.ID] = sess
+	s.sessionByPair[key] = sess.ID
+	return sess
+}
+
+func (s *Store) enqueueOutbox(eventType, aggregateID string, payload any) {
+	data, _ := json.Marshal(payload)
+	s.nextOutboxID++
+	at := now()
+	s.outbox = append(s.outbox, &outboxEntry{
+		OutboxEvent: models.OutboxEvent{
+			ID:          s.nextOutboxID,
+			EventType:   eventType,
+			AggregateID: aggregateID,
+			Payload:     data,
+			CreatedAt:   at,
+		},
+		availableAt: at,
+	})
+}
+
+// Dispatch hands up to batch pending events to handle, retrying failures
+// with the same backoff as the database outbox. The lock is released while
+// handlers run, since they may write back to the store.
+func (s *Store) Dispatch(ctx context.Context, batch int, handle func(context.Context, models.OutboxEvent) error) (int, error) {
+	if err := checkContext(ctx); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	var claimed []*outboxEntry
+	t := time.Now()
+	for _, e := range s.outbox {
+		if len(claimed) == batch {
+			break
+		}
+		if e.done || e.claimed || e.availableAt.After(t) {
+			continue
+		}
+		e.claimed = true
+		claimed = append(claimed, e)
+	}
+	s.mu.Unlock()
+
+	for _, e := range claimed {
+		herr := handle(ctx, e.OutboxEvent)
+
+		s.mu.Lock()
+		e.claimed = false
+		if herr != nil {
+			backoff := time.Second << min(e.Attempts, 10)
+			log.Printf("⚠️ Outbox event %d (%s) failed, attempt %d: %v", e.ID, e.EventType, e.Attempts+1, herr)
+			e.Attempts++
+			e.availableAt = time.Now().Add(backoff)
+			e.done = e.Attempts >= maxOutboxAttempts
+		} else {
+			e.Attempts++
+			e.done = true
+		}
+		s.mu.Unlock()
+	}
+
+	// Drop finished entries so the queue does not grow forever
+	s.mu.Lock()
+	pending := s.outbox[:0]
+	for _, e := range s.outbox {
+		if !e.done {
+			pending = append(pending, e)
+		}
+	}
+	s.outbox = pending
+	s.mu.Unlock()
+
+	return len(claimed), nil
+}
+
+func (s *Store) GetOrCreateSession(ctx context.Context, contactID, userID, locationID string) (string, error) {
+	if err := checkContext(ctx); err != nil {
+		return "", err
+	}
+
+	c, err := uuid.Parse(contactID)
+	if err != nil {
+		return "", err
+	}
+	u, err := uuid.Parse(userID)
+	if err != nil {
+		return "", err
+	}
+	l, err := uuid.Parse(locationID)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.upsertSession(c, u, l, now()).ID.String(), nil
+}
+
+func (s *Store) GetSessionByID(ctx context.Context, id string) (models.ChatSession, error) {
+	if err := checkContext(ctx); err != nil {
+		return models.ChatSession{}, err
+	}
+
+	sid, err := uuid.Parse(id)
+	if err != nil {
+		return models.ChatSession{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[sid]
+	if !ok {
+		return models.ChatSession{}, repository.ErrSessionNotFound
+	}
+	return sess.ChatSession, nil
+}
+
+func (s *Store) SessionExists(ctx context.Context, contactID, userID, locationID string) (bool, error) {
+	if err := checkContext(ctx); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessionExists(contactID, userID, locationID)
+}
+
+// sessionExists looks up a session by its participants. Callers hold s.mu.
+func (s *Store) sessionExists(contactID, userID, locationID string) (bool, error) {
+	c, err := uuid.Parse(contactID)
+	if err != nil {
+		return false, err
+	}
+	u, err := uuid.Parse(userID)
+	if err != nil {
+		return false, err
+	}
+	l, err := uuid.Parse(locationID)
+	if err != nil {
+		return false, err
+	}
+	_, ok := s.sessionByPair[pairKey{c, u, l}]
+	return ok, nil
+}
+
+// SessionCounterparts reports which of ids share a session in the location
+// with the doctor (userID) or, if userID is empty, the patient (contactID).
+func (s *Store) SessionCounterparts(ctx context.Context, userID, contactID, locationID string, ids []string) (map[string]bool, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := make(map[string]bool)
+	for _, id := range ids {
+		c, u := contactID, id
+		if userID != "" {
+			c, u = id, userID
+		}
+		ok, err := s.sessionExists(c, u, locationID)
+		if err == nil && ok {
+			found[id] = true
+		}
+	}
+	return found, nil
+}
+
+// ListEnrichedChatSessionsWithFilter lists the sessions of a contact or user.
+// Names are left empty: the in-memory store has no contacts or users.
+func (s *Store) ListEnrichedChatSessionsWithFilter(ctx context.Context, userID, contactID, locationID string, limit, offset int) ([]models.ChatSessionResponse, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions := s.listSessions(func(sess *session) bool {
+		return sess.ContactID.String() == contactID || sess.UserID.String() == userID
+	}, locationID, limit, offset)
+	for i := range sessions {
+		r := &sessions[i]
+		r.UnreadCount = r.ContactUnreadCount
+		if r.UserID.String() == userID {
+			r.UnreadCount = r.UserUnreadCount
+		}
+		r.UserUnreadCount, r.ContactUnreadCount = 0, 0
+	}
+	return sessions, nil
+}
+
+func (s *Store) AdminListAllSessions(ctx context.Context, locationID string, limit, offset int) ([]models.ChatSessionResponse, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions := s.listSessions(func(*session) bool { return true }, locationID, limit, offset)
+	for i := range sessions {
+		sessions[i].UnreadCount = sessions[i].UserUnreadCount + sessions[i].ContactUnreadCount
+	}
+	return sessions, nil
+}
+
+// listSessions orders matching sessions by latest message and fills in the
+// same summary the database keeps: the last live message and the unread
+// counts of each participant.
+func (s *Store) listSessions(match func(*session) bool, locationID string, limit, offset int) []models.ChatSessionResponse {
+	var matched []*session
+	for _, sess := range s.sessions {
+		if match(sess) && (locationID == "" || sess.LocationID.String() == locationID) {
+			matched = append(matched, sess)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if (a.LastMessageAt == nil) != (b.LastMessageAt == nil) {
+			return a.LastMessageAt != nil
+		}
+		if a.LastMessageAt != nil && !a.LastMessageAt.Equal(*b.LastMessageAt) {
+			return a.LastMessageAt.After(*b.LastMessageAt)
+		}
+		return a.StartedAt.After(b.StartedAt)
+	})
+
+	out := []models.ChatSessionResponse{}
+	for i, sess := range matched {
+		if i < offset {
+			continue
+		}
+		if limit > 0 && len(out) == limit {
+			break
+		}
+		r := models.ChatSessionResponse{
+			ID:            sess.ID,
+			ContactID:     sess.ContactID,
+			UserID:        sess.UserID,
+			LocationID:    sess.LocationID,
+			StartedAt:     sess.StartedAt,
+			LastMessageAt: sess.LastMessageAt,
+			LastSeq:       sess.lastSeq,
+		}
+		activity := sess.lastActivity
+		r.LastActivityAt = &activity
+
+		var last *message
+		for _, m := range s.messages {
+			if m.SessionID != sess.ID || m.deletedAt != nil {
+				continue
+			}
+			if last == nil || m.Seq > last.Seq {
+				last = m
+			}
+			if !m.IsRead {
+				if m.ReceiverUserID != uuid.Nil {
+					r.UserUnreadCount++
+				} else {
+					r.ContactUnreadCount++
+				}
+			}
+		}
+		if last != nil {
+			id, sender := last.ID, senderKey(&last.DBMessage)
+			r.LastMessageID, r.LastSenderID = &id, &sender
+			r.LastSenderType = "user"
+			if last.SenderContactID != uuid.Nil {
+				r.LastSenderType = "contact"
+			}
+			r.LastMessage = last.Content
+			if r.LastMessage == "" {
+				r.LastMessage = last.FileName
+			}
+			r.LastMessage = preview(r.LastMessage)
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// preview truncates a session's last message like the database does.
+func preview(content string) string {
+	const length = 200
+	if utf8.RuneCountInString(content) <= length {
+		return content
+	}
+	return string([]rune(content)[:length]) + "…"
+}
+
+// GetDeviceToken returns sql.ErrNoRows for unknown users, like the database.
+func (s *Store) GetDeviceToken(ctx context.Context, userID string) (string, error) {
+	if err := checkContext(ctx); err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.deviceTokens[userID]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return token, nil
+}
+
+func (s *Store) UpsertDeviceToken(ctx context.Context, userID, token string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deviceTokens[userID] = token
+	return nil
+}
+
+// GetEffectiveOfficeHours prefers the user's own schedule over the location's
+// and includes holidays from yesterday on.
+func (s *Store) GetEffectiveOfficeHours(ctx context.Context, locationID, userID string) (*models.OfficeHours, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.effectiveOfficeHours(locationID, userID), nil
+}
+
+func (s *Store) GetEffectiveOfficeHoursForUsers(ctx context.Context, locationID string, userIDs []string) (map[string]*models.OfficeHours, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	schedules := make(map[string]*models.OfficeHours)
+	for _, id := range userIDs {
+		if oh := s.effectiveOfficeHours(locationID, id); oh != nil {
+			schedules[id] = oh
+		}
+	}
+	return schedules, nil
+}
+
+// effectiveOfficeHours returns a copy of the schedule in effect, or nil.
+// Callers hold s.mu.
+func (s *Store) effectiveOfficeHours(locationID, userID string) *models.OfficeHours {
+	oh, ok := s.officeHours[officeHoursKey{locationID, userID}]
+	if !ok || userID == "" {
+		oh, ok = s.officeHours[officeHoursKey{locationID, ""}]
+	}
+	if !ok {
+		return nil
+	}
+
+	out := *oh
+	out.Holidays = []models.OfficeHoliday{}
+	from := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+	for _, h := range s.holidays {
+		if h.LocationID == locationID && (h.UserID == "" || h.UserID == userID) && h.Date >= from {
+			out.Holidays = append(out.Holidays, h)
+		}
+	}
+	sort.Slice(out.Holidays, func(i, j int) bool { return out.Holidays[i].Date < out.Holidays[j].Date })
+	return &out
+}
+
+func (s *Store) UpsertOfficeHours(ctx context.Context, oh *models.OfficeHours) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := officeHoursKey{oh.LocationID, oh.UserID}
+	if existing, ok := s.officeHours[key]; ok {
+		oh.ID = existing.ID
+	} else {
+		oh.ID = uuid.New().String()
+	}
+	oh.UpdatedAt = now()
+	stored := *oh
+	stored.Holidays = nil
+	s.officeHours[key] = &stored
+	return nil
+}
+
+func (s *Store) AddOfficeHoliday(ctx context.Context, h *models.OfficeHoliday) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	if _, err := time.Parse("2006-01-02", h.Date); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h.ID = uuid.New().String()
+	s.holidays = append(s.holidays, *h)
+	return nil
+}
+
+func (s *Store) GetOfficeHoliday(ctx context.Context, id, locationID string) (models.OfficeHoliday, error) {
+	if err := checkContext(ctx); err != nil {
+		return models.OfficeHoliday{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, h := range s.holidays {
+		if h.ID == id && h.LocationID == locationID {
+			return h, nil
+		}
+	}
+	return models.OfficeHoliday{}, repository.ErrHolidayNotFound
+}
+
+func (s *Store) DeleteOfficeHoliday(ctx context.Context, id, locationID string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.holidays[:0]
+	for _, h := range s.holidays {
+		if h.ID != id || h.LocationID != locationID {
+			kept = append(kept, h)
+		}
+	}
+	s.holidays = kept
+	return nil
+}
+
+// checkContext reports a cancelled or expired ctx the way the PostgreSQL
+// repos do. Operations here never block, so checking on entry is enough.
+func checkContext(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return repository.ContextError(ctx, ctx.Err())
+	}
+	return nil
+}
@@ -0,0 +1,673 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"internal_chat_system/models"
+
+	"github.com/google/uuid"
+)
+
+// ObjectStorage stores archived message partitions. internal/s3 provides the
+// S3 implementation.
+type ObjectStorage interface {
+	// PutObject reads body to the end as it uploads, so archives are never
+	// held in memory whole.
+	PutObject(ctx context.Context, key string, body io.Reader, contentType string) error
+	// GetObject streams an object; the caller closes it.
+	GetObject(ctx context.Context, key string) (io.ReadCloser, error)
+	DeleteObject(ctx context.Context, key string) error
+}
+
+// archivePrefix is where archived partitions are written in object storage.
+const archivePrefix = "message-archive/"
+
+const (
+	queryCreateMessagesPartition = `SELECT create_messages_partition($1::date)`
+
+	queryListMessagePartitions = `
+		SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_class p ON p.oid = i.inhparent
+		WHERE p.relname = 'messages'
+		ORDER BY c.relname
+	`
+
+	// The partition queries are completed with the partition name. The
+	// export runs unlocked in one snapshot; the partition is only locked
+	// against writes to check that nothing changed since, then detached.
+	queryLockPartition   = `LOCK TABLE %s IN SHARE MODE`
+	queryExportPartition = `
+		SELECT ` + messageColumns + `, delivered_at, deleted_at, anonymized_at, xmin::text
+		FROM %s
+		ORDER BY sent_at, id
+	`
+	queryPartitionVersions = `
+		SELECT id, xmin::text
+		FROM %s
+		ORDER BY sent_at, id
+	`
+
+	queryInsertMessageArchive = `
+		INSERT INTO message_archives (partition_name, range_start, range_end, object_key, message_count)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING archived_at
+	`
+
+	queryInsertArchiveConversation = `
+		INSERT INTO message_archive_conversations
+			(partition_name, location_id, user_id, contact_id, message_count, min_seq, max_seq)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	queryDetachPartition = `ALTER TABLE messages DETACH PARTITION %s`
+	queryDropPartition   = `DROP TABLE %s`
+
+	queryListMessageArchives = `
+		SELECT partition_name, range_start, range_end, object_key, message_count, archived_at
+		FROM message_archives
+		ORDER BY range_start
+	`
+
+	querySelectConversationArchiveBounds = `
+		SELECT MAX(a.range_end), COALESCE(MAX(c.max_seq), 0)
+		FROM message_archive_conversations c
+		JOIN message_archives a ON a.partition_name = c.partition_name
+		WHERE c.location_id = $1 AND c.user_id = $2 AND c.contact_id = $3
+	`
+
+	querySelectConversationArchives = `
+		SELECT a.object_key, a.range_start, a.range_end, c.min_seq, c.max_seq
+		FROM message_archive_conversations c
+		JOIN message_archives a ON a.partition_name = c.partition_name
+		WHERE c.location_id = $1 AND c.user_id = $2 AND c.contact_id = $3
+		ORDER BY a.range_start
+	`
+)
+
+// partitionName matches the monthly partitions made by create_messages_partition.
+var partitionName = regexp.MustCompile(`^messages_p(\d{4})_(\d{2})$`)
+
+// partitionRange returns the month a partition holds, [start, end).
+func partitionRange(name string) (time.Time, time.Time, bool) {
+	m := partitionName.FindStringSubmatch(name)
+	if m == nil {
+		return time.Time{}, time.Time{}, false
+	}
+	start, err := time.Parse("2006-01", m[1]+"-"+m[2])
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	return start, start.AddDate(0, 1, 0), true
+}
+
+// archivedMessage is one line of an archive: the message as stored,
+// including the fields the API never shows.
+type archivedMessage struct {
+	models.DBMessage
+	IsSystem     bool       `json:"is_system"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"`
+	AnonymizedAt *time.Time `json:"anonymized_at,omitempty"`
+}
+
+type ArchiveRepo struct {
+	DB      *sql.DB
+	Storage ObjectStorage
+}
+
+func NewArchiveRepo(db *sql.DB, storage ObjectStorage) *ArchiveRepo {
+	return &ArchiveRepo{DB: db, Storage: storage}
+}
+
+// EnsureMessagePartitions creates the partitions for the month of from and
+// the months after it, so inserts never find a month without a partition.
+func (r *ArchiveRepo) EnsureMessagePartitions(ctx context.Context, from time.Time, monthsAhead int) (err error) {
+	ctx, end := beginWrite(ctx)
+	defer end(&err)
+
+	month := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i <= monthsAhead; i++ {
+		if _, err := r.DB.ExecContext(ctx, queryCreateMessagesPartition, month.AddDate(0, i, 0).Format("2006-01-02")); err != nil {
+			log.Printf("❌ Failed to create messages partition for %s: %v", month.AddDate(0, i, 0).Format("2006-01"), err)
+			return err
+		}
+	}
+	return nil
+}
+
+// ListMessagePartitions returns the names of the attached monthly
+// partitions, oldest first.
+func (r *ArchiveRepo) ListMessagePartitions(ctx context.Context) (_ []string, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
+	rows, err := r.DB.QueryContext(ctx, queryListMessagePartitions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if _, _, ok := partitionRange(name); ok {
+			names = append(names, name)
+		}
+	}
+	return names, rows.Err()
+}
+
+// ListMessageArchives returns every archived partition, oldest first.
+func (r *ArchiveRepo) ListMessageArchives(ctx context.Context) (_ []models.MessageArchive, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
+	rows, err := r.DB.QueryContext(ctx, queryListMessageArchives)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	archives := []models.MessageArchive{}
+	for rows.Next() {
+		var a models.MessageArchive
+		if err := rows.Scan(&a.PartitionName, &a.RangeStart, &a.RangeEnd, &a.ObjectKey, &a.MessageCount, &a.ArchivedAt); err != nil {
+			return nil, err
+		}
+		archives = append(archives, a)
+	}
+	return archives, rows.Err()
+}
+
+// conversationKey identifies a doctor/patient conversation. Every message
+// carries the doctor as sender_user_id and the patient as receiver_contact_id,
+// whichever way it was sent.
+type conversationKey struct {
+	LocationID, UserID, ContactID uuid.UUID
+}
+
+func parseConversationKey(locationID, userID, contactID string) (conversationKey, bool) {
+	var k conversationKey
+	var err1, err2, err3 error
+	k.LocationID, err1 = uuid.Parse(locationID)
+	k.UserID, err2 = uuid.Parse(userID)
+	k.ContactID, err3 = uuid.Parse(contactID)
+	return k, err1 == nil && err2 == nil && err3 == nil
+}
+
+type conversationStats struct {
+	count          int64
+	minSeq, maxSeq int64
+}
+
+// ErrPartitionChanged means a partition was written to while it was being
+// exported. Nothing is detached; archiving it again picks up the change.
+var ErrPartitionChanged = errors.New("partition changed during export")
+
+// partitionExport is what exporting a partition found.
+type partitionExport struct {
+	count         int64
+	conversations map[conversationKey]*conversationStats
+	versions      []byte // digest of every row id and version, see rowVersions
+}
+
+// rowVersions digests the id and xmin of each row in order. Any insert,
+// update or delete changes it, so comparing digests tells whether a
+// partition still holds exactly what was exported.
+type rowVersions struct{ h hash.Hash }
+
+func newRowVersions() rowVersions { return rowVersions{sha256.New()} }
+
+func (v rowVersions) add(id uuid.UUID, xmin string) {
+	v.h.Write(id[:])
+	v.h.Write([]byte(xmin))
+	v.h.Write([]byte{0})
+}
+
+func (v rowVersions) sum() []byte { return v.h.Sum(nil) }
+
+// ArchiveMessagePartition exports a monthly partition as gzipped JSONL to
+// object storage, records it in message_archives along with the
+// conversations it holds, and detaches and drops the partition.
+//
+// The export is streamed into the upload from a read-only snapshot, without
+// locks. Only then is the partition locked against writes, checked against
+// the export and detached, so writers wait for a single scan of ids rather
+// than the upload. The object is written before anything is committed: a
+// failure, or ErrPartitionChanged, leaves the partition attached and the
+// archive can simply be retried.
+func (r *ArchiveRepo) ArchiveMessagePartition(ctx context.Context, name string) (_ models.MessageArchive, err error) {
+	ctx, end := beginOp(ctx, timeouts.Archive)
+	defer end(&err)
+
+	start, stop, ok := partitionRange(name)
+	if !ok {
+		return models.MessageArchive{}, fmt.Errorf("not a messages partition: %q", name)
+	}
+	if r.Storage == nil {
+		return models.MessageArchive{}, errors.New("no object storage configured for archives")
+	}
+
+	archive := models.MessageArchive{
+		PartitionName: name,
+		RangeStart:    start,
+		RangeEnd:      stop,
+		ObjectKey:     archivePrefix + name + ".jsonl.gz",
+	}
+	exported, err := r.uploadPartition(ctx, name, archive.ObjectKey)
+	if err != nil {
+		return models.MessageArchive{}, err
+	}
+	archive.MessageCount = exported.count
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return models.MessageArchive{}, err
+	}
+	defer tx.Rollback()
+
+	// name matched partitionName, so it is safe to splice into the statements
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(queryLockPartition, name)); err != nil {
+		return models.MessageArchive{}, err
+	}
+	current, err := partitionVersions(ctx, tx, name)
+	if err != nil {
+		return models.MessageArchive{}, err
+	}
+	if !bytes.Equal(current, exported.versions) {
+		log.Printf("⚠️ Partition %s changed while it was exported, leaving it attached", name)
+		return models.MessageArchive{}, ErrPartitionChanged
+	}
+
+	err = tx.QueryRowContext(ctx, queryInsertMessageArchive,
+		archive.PartitionName, archive.RangeStart, archive.RangeEnd, archive.ObjectKey, archive.MessageCount,
+	).Scan(&archive.ArchivedAt)
+	if err != nil {
+		return models.MessageArchive{}, err
+	}
+	for key, st := range exported.conversations {
+		if _, err := tx.ExecContext(ctx, queryInsertArchiveConversation,
+			name, key.LocationID, key.UserID, key.ContactID, st.count, st.minSeq, st.maxSeq); err != nil {
+			return models.MessageArchive{}, err
+		}
+	}
+
+	// Detaching locks the parent table, so it comes last
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(queryDetachPartition, name)); err != nil {
+		log.Printf("❌ Failed to detach partition %s: %v", name, err)
+		return models.MessageArchive{}, err
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(queryDropPartition, name)); err != nil {
+		return models.MessageArchive{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return models.MessageArchive{}, err
+	}
+
+	archiveBounds.Lock()
+	for key := range exported.conversations {
+		delete(archiveBounds.entries, key)
+	}
+	archiveBounds.Unlock()
+
+	log.Printf("🗄️ Archived %d message(s) from %s to %s", archive.MessageCount, name, archive.ObjectKey)
+	return archive, nil
+}
+
+// uploadPartition streams a partition, as of one snapshot, through gzip
+// into object storage under key.
+func (r *ArchiveRepo) uploadPartition(ctx context.Context, name, key string) (partitionExport, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return partitionExport{}, err
+	}
+	defer tx.Rollback()
+
+	pr, pw := io.Pipe()
+	var e partitionExport
+	exported := make(chan error, 1)
+	go func() {
+		var err error
+		e, err = exportPartition(ctx, tx, name, pw)
+		pw.CloseWithError(err)
+		exported <- err
+	}()
+
+	uploadErr := r.Storage.PutObject(ctx, key, pr, "application/gzip")
+	// Unblocks the export if the upload stopped reading early
+	pr.CloseWithError(io.ErrClosedPipe)
+	exportErr := <-exported
+	if uploadErr != nil {
+		log.Printf("❌ Failed to upload archive of %s: %v", name, uploadErr)
+		return partitionExport{}, uploadErr
+	}
+	if exportErr != nil {
+		log.Printf("❌ Failed to read partition %s: %v", name, exportErr)
+		return partitionExport{}, exportErr
+	}
+	return e, nil
+}
+
+// exportPartition writes every row of a partition to w as gzipped JSONL.
+func exportPartition(ctx context.Context, tx *sql.Tx, name string, w io.Writer) (partitionExport, error) {
+	e := partitionExport{conversations: make(map[conversationKey]*conversationStats)}
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(queryExportPartition, name))
+	if err != nil {
+		return e, err
+	}
+	defer rows.Close()
+
+	gz := gzip.NewWriter(w)
+	enc := json.NewEncoder(gz)
+	versions := newRowVersions()
+	for rows.Next() {
+		var deliveredAt, deletedAt, anonymizedAt *time.Time
+		var xmin string
+		m, err := scanMessage(scanWithExtra{rows, []any{&deliveredAt, &deletedAt, &anonymizedAt, &xmin}})
+		if err != nil {
+			return e, err
+		}
+		m.DeliveredAt = deliveredAt
+		if err := enc.Encode(archivedMessage{DBMessage: m, IsSystem: m.IsSystem, DeletedAt: deletedAt, AnonymizedAt: anonymizedAt}); err != nil {
+			return e, err
+		}
+		versions.add(m.ID, xmin)
+		e.count++
+
+		key := conversationKey{m.LocationID, m.SenderUserID, m.ReceiverContactID}
+		st := e.conversations[key]
+		if st == nil {
+			st = &conversationStats{minSeq: m.Seq, maxSeq: m.Seq}
+			e.conversations[key] = st
+		}
+		st.count++
+		st.minSeq, st.maxSeq = min(st.minSeq, m.Seq), max(st.maxSeq, m.Seq)
+	}
+	if err := rows.Err(); err != nil {
+		return e, err
+	}
+	e.versions = versions.sum()
+	return e, gz.Close()
+}
+
+// partitionVersions digests a partition's rows the way exportPartition does.
+func partitionVersions(ctx context.Context, tx *sql.Tx, name string) ([]byte, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(queryPartitionVersions, name))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := newRowVersions()
+	for rows.Next() {
+		var id uuid.UUID
+		var xmin string
+		if err := rows.Scan(&id, &xmin); err != nil {
+			return nil, err
+		}
+		versions.add(id, xmin)
+	}
+	return versions.sum(), rows.Err()
+}
+
+// archivedConversationCacheSize bounds how many decoded conversation slices
+// of archives are kept, so scrolling back doesn't refetch the same object
+// for every page.
+const archivedConversationCacheSize = 64
+
+type archiveCacheKey struct {
+	objectKey string
+	conv      conversationKey
+}
+
+var archiveCache = struct {
+	sync.Mutex
+	entries map[archiveCacheKey][]models.DBMessage
+	order   []archiveCacheKey
+}{entries: make(map[archiveCacheKey][]models.DBMessage)}
+
+// loadArchivedConversation returns the live (not deleted) messages of one
+// conversation in an archive, oldest first.
+func loadArchivedConversation(ctx context.Context, storage ObjectStorage, objectKey string, conv conversationKey) ([]models.DBMessage, error) {
+	key := archiveCacheKey{objectKey, conv}
+	archiveCache.Lock()
+	cached, ok := archiveCache.entries[key]
+	archiveCache.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	body, err := storage.GetObject(ctx, objectKey)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	messages := []models.DBMessage{}
+	sc := bufio.NewScanner(gz)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var a archivedMessage
+		if err := json.Unmarshal(sc.Bytes(), &a); err != nil {
+			return nil, fmt.Errorf("archive %s: %w", objectKey, err)
+		}
+		m := a.DBMessage
+		if a.DeletedAt != nil || m.LocationID != conv.LocationID ||
+			m.SenderUserID != conv.UserID || m.ReceiverContactID != conv.ContactID {
+			continue
+		}
+		m.IsSystem = a.IsSystem
+		m.MessageType = "text"
+		if m.FileURL != "" {
+			m.MessageType = "file"
+		}
+		if m.IsSystem {
+			m.MessageType = "system"
+		}
+		messages = append(messages, m)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	archiveCache.Lock()
+	if _, ok := archiveCache.entries[key]; !ok {
+		archiveCache.entries[key] = messages
+		archiveCache.order = append(archiveCache.order, key)
+		if len(archiveCache.order) > archivedConversationCacheSize {
+			delete(archiveCache.entries, archiveCache.order[0])
+			archiveCache.order = archiveCache.order[1:]
+		}
+	}
+	archiveCache.Unlock()
+	return messages, nil
+}
+
+// archivedBounds limits which archived messages a history page may use.
+type archivedBounds struct {
+	cursor    *messageCursor
+	afterSeq  int64
+	beforeSeq int64
+}
+
+func (b archivedBounds) matches(m models.DBMessage, forward bool) bool {
+	switch {
+	case b.afterSeq > 0:
+		return m.Seq > b.afterSeq
+	case b.beforeSeq > 0:
+		return m.Seq < b.beforeSeq
+	case b.cursor == nil:
+		return true
+	}
+	after := m.SentAt.After(b.cursor.SentAt) ||
+		(m.SentAt.Equal(b.cursor.SentAt) && bytes.Compare(m.ID[:], b.cursor.ID[:]) > 0)
+	before := m.SentAt.Before(b.cursor.SentAt) ||
+		(m.SentAt.Equal(b.cursor.SentAt) && bytes.Compare(m.ID[:], b.cursor.ID[:]) < 0)
+	if forward {
+		return after
+	}
+	return before
+}
+
+// archiveBoundsTTL is how long the archive bounds of a conversation are
+// trusted. Only months-old partitions are archived, so a forward page racing
+// a new archive on another instance is rare, and it resolves once the bounds
+// are read again.
+const archiveBoundsTTL = time.Minute
+
+// archiveBoundsCacheSize bounds how many conversations' archive bounds are kept.
+const archiveBoundsCacheSize = 10_000
+
+type archivedThrough struct {
+	end     time.Time // end of the newest archive; zero if there is none
+	maxSeq  int64
+	fetched time.Time
+}
+
+var archiveBounds = struct {
+	sync.Mutex
+	entries map[conversationKey]archivedThrough
+	order   []conversationKey
+}{entries: make(map[conversationKey]archivedThrough)}
+
+// conversationArchivedThrough returns how far a conversation's archives
+// reach, so forward pages past them can skip the archive lookup.
+func (r *MessageRepo) conversationArchivedThrough(ctx context.Context, conv conversationKey) (_ archivedThrough, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
+	archiveBounds.Lock()
+	cached, ok := archiveBounds.entries[conv]
+	archiveBounds.Unlock()
+	if ok && time.Since(cached.fetched) < archiveBoundsTTL {
+		return cached, nil
+	}
+
+	var newest sql.NullTime
+	b := archivedThrough{fetched: time.Now()}
+	err = readDB(ctx, r.DB).QueryRowContext(ctx, querySelectConversationArchiveBounds,
+		conv.LocationID, conv.UserID, conv.ContactID).Scan(&newest, &b.maxSeq)
+	if err != nil {
+		log.Printf("❌ Failed to read archive bounds: %v", err)
+		return archivedThrough{}, err
+	}
+	b.end = newest.Time
+
+	archiveBounds.Lock()
+	if _, ok := archiveBounds.entries[conv]; !ok {
+		archiveBounds.order = append(archiveBounds.order, conv)
+		if len(archiveBounds.order) > archiveBoundsCacheSize {
+			delete(archiveBounds.entries, archiveBounds.order[0])
+			archiveBounds.order = archiveBounds.order[1:]
+		}
+	}
+	archiveBounds.entries[conv] = b
+	archiveBounds.Unlock()
+	return b, nil
+}
+
+// pastArchives reports whether a forward page starts after everything the
+// conversation has archived.
+func (b archivedThrough) pastArchives(bounds archivedBounds) bool {
+	switch {
+	case b.end.IsZero():
+		return true
+	case bounds.afterSeq > 0:
+		return bounds.afterSeq >= b.maxSeq
+	case bounds.cursor != nil:
+		return !bounds.cursor.SentAt.Before(b.end)
+	}
+	return false
+}
+
+// readArchivedConversation returns up to n archived messages of a
+// conversation within bounds: the newest ones, newest first, or with
+// forward the oldest ones, oldest first. Only archives the conversation
+// appears in, and whose range or seqs can satisfy bounds, are fetched.
+func (r *MessageRepo) readArchivedConversation(ctx context.Context, conv conversationKey, bounds archivedBounds, forward bool, n int) (_ []models.DBMessage, err error) {
+	ctx, end := beginOp(ctx, timeouts.Archive)
+	defer end(&err)
+
+	rows, err := r.DB.QueryContext(ctx, querySelectConversationArchives, conv.LocationID, conv.UserID, conv.ContactID)
+	if err != nil {
+		return nil, err
+	}
+	type candidate struct {
+		objectKey      string
+		start, end     time.Time
+		minSeq, maxSeq int64
+	}
+	var candidates []candidate
+	for rows.Next() {
+		var c candidate
+		if err := rows.Scan(&c.objectKey, &c.start, &c.end, &c.minSeq, &c.maxSeq); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		switch {
+		case bounds.afterSeq > 0 && c.maxSeq <= bounds.afterSeq,
+			bounds.beforeSeq > 0 && c.minSeq >= bounds.beforeSeq,
+			bounds.cursor != nil && forward && !c.end.After(bounds.cursor.SentAt),
+			bounds.cursor != nil && !forward && c.start.After(bounds.cursor.SentAt):
+			continue
+		}
+		candidates = append(candidates, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !forward {
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].start.After(candidates[j].start) })
+	}
+
+	var out []models.DBMessage
+	for _, c := range candidates {
+		messages, err := loadArchivedConversation(ctx, r.Storage, c.objectKey, conv)
+		if err != nil {
+			log.Printf("❌ Failed to load archive %s: %v", c.objectKey, err)
+			return nil, err
+		}
+		if forward {
+			for _, m := range messages {
+				if bounds.matches(m, true) {
+					out = append(out, m)
+				}
+			}
+		} else {
+			for i := len(messages) - 1; i >= 0; i-- {
+				if bounds.matches(messages[i], false) {
+					out = append(out, messages[i])
+				}
+			}
+		}
+		if len(out) >= n {
+			return out[:n], nil
+		}
+	}
+	return out, nil
+}
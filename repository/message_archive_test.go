@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestPastArchives(t *testing.T) {
+	end := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	archived := archivedThrough{end: end, maxSeq: 40}
+	cursorAt := func(at time.Time) archivedBounds {
+		return archivedBounds{cursor: &messageCursor{SentAt: at, ID: uuid.New()}}
+	}
+
+	tests := []struct {
+		name    string
+		through archivedThrough
+		bounds  archivedBounds
+		want    bool
+	}{
+		{"nothing archived", archivedThrough{}, cursorAt(end.AddDate(-1, 0, 0)), true},
+		{"seq past the archives", archived, archivedBounds{afterSeq: 40}, true},
+		{"seq inside the archives", archived, archivedBounds{afterSeq: 39}, false},
+		{"cursor at the archive end", archived, cursorAt(end), true},
+		{"cursor inside the archives", archived, cursorAt(end.Add(-time.Microsecond)), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.through.pastArchives(tt.bounds); got != tt.want {
+				t.Errorf("pastArchives = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,7 +1,10 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -12,84 +15,71 @@ import (
 	"github.com/lib/pq"
 )
 
+// messageColumns is the column list read by scanMessage.
+const messageColumns = `id, location_id, sender_user_id, receiver_user_id,
+			sender_contact_id, receiver_contact_id, session_id, content,
+			sent_at, read_at, is_read,
+			COALESCE(file_url, ''), COALESCE(file_name, ''), COALESCE(file_type, ''),
+			reply_to_id, edited_at, is_pinned, is_system, COALESCE(seq, 0),
+			COALESCE(client_message_id, '')`
+
 const (
 	queryInsertMessage = `
 		INSERT INTO messages (
 			id, location_id, sender_user_id, receiver_user_id,
-			sender_contact_id, receiver_contact_id, content, sent_at, is_read, session_id, file_url, file_name, file_type, reply_to_id
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+			sender_contact_id, receiver_contact_id, content, sent_at, is_read, session_id, file_url, file_name, file_type, reply_to_id,
+			is_system, seq, client_message_id, key_id, content_tokens
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, NULLIF($17, ''), $18, $19)
 	`
 
-	querySelectConversation = `
-		SELECT id, location_id, sender_user_id, receiver_user_id,
-			sender_contact_id, receiver_contact_id, content,
-			sent_at, read_at, is_read,
-			file_url, file_name, file_type, reply_to_id
+	// querySelectConversationPage is completed with a cursor condition,
+	// ordering and limit placeholder by GetConversation.
+	querySelectConversationPage = `
+		SELECT ` + messageColumns + `
 		FROM messages
 		WHERE location_id = $1 AND (
 			(sender_user_id = $2 AND receiver_contact_id = $3) OR
 			(sender_contact_id = $3 AND receiver_user_id = $2)
 		)
 		AND deleted_at IS NULL
-		ORDER BY sent_at ASC
+		AND %s
+		ORDER BY %s
+		LIMIT %s
+	`
+
+	queryUpsertSessionForMessage = `
+		INSERT INTO chat_sessions (id, contact_id, user_id, location_id, started_at, last_message_at, last_activity_at)
+		VALUES ($1, $2, $3, $4, $5, $5, $5)
+		ON CONFLICT (contact_id, user_id, location_id) DO UPDATE
+		SET last_message_at = EXCLUDED.last_message_at
+		RETURNING id
 	`
 
 	queryUpdateMarkMessagesRead = `
 		UPDATE messages SET is_read = true, read_at = now()
-		WHERE id = ANY($1)
+		WHERE id = ANY($1) AND is_read = false
+		RETURNING id, session_id, read_at
 	`
 
-	baseSessionQuery = `
-		SELECT
-			cs.id,
-			cs.contact_id,
-			COALESCE(c.full_name, '') AS contact_name,
-			cs.user_id,
-			COALESCE(u.full_name, '') AS user_name,
-			cs.location_id,
-			cs.started_at,
-			cs.last_message_at,
-			COALESCE(m.content, '') AS last_message,
-			(
-				SELECT COUNT(*) FROM messages
-				WHERE session_id = cs.id AND is_read = false AND receiver_user_id = $2
-			) AS unread_count
-		FROM chat_sessions cs
-		LEFT JOIN contacts c ON cs.contact_id = c.id
-		LEFT JOIN users u ON cs.user_id = u.id
-		LEFT JOIN LATERAL (
-			SELECT content FROM messages
-			WHERE session_id = cs.id
-			ORDER BY sent_at DESC LIMIT 1
-		) m ON true
-		WHERE (cs.contact_id = $1 OR cs.user_id = $2)
-		AND ($3 = '' OR cs.location_id = $3)
-		AND deleted_at IS NULL
-		ORDER BY cs.last_message_at DESC NULLS LAST, cs.started_at DESC
+	queryDeleteMessage = `
+		UPDATE messages SET deleted_at = now()
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING session_id
 	`
 
-	querySearchMessage = `
-		SELECT id, location_id, sender_user_id, receiver_user_id,
-		       sender_contact_id, receiver_contact_id, content,
-		       sent_at, read_at, delivered_at, is_read
-		FROM messages
-		WHERE content ILIKE '%' || $1 || '%'
-		AND location_id = $2
-		AND (
-			(sender_user_id = $3 AND receiver_contact_id = $4) OR
-			(sender_contact_id = $4 AND receiver_user_id = $3)
-		)
-		ORDER BY sent_at DESC
-		LIMIT $5
-	`
-
-	queryDeleteMessage = `UPDATE messages SET deleted_at = now() WHERE id = $1`
+	queryGetMessageSession = `SELECT session_id FROM messages WHERE id = $1`
 
 	queryGetReactions = `
 		SELECT id, message_id, user_id, emoji, created_at
 		FROM message_reactions
 		WHERE message_id = $1
 	`
+	queryGetReactionsForMessages = `
+		SELECT id, message_id, user_id, emoji, created_at
+		FROM message_reactions
+		WHERE message_id = ANY($1)
+		ORDER BY created_at, id
+	`
 	queryRemoveReaction = `
 		DELETE FROM message_reactions
 		WHERE message_id = $1 AND user_id = $2 AND emoji = $3
@@ -99,21 +89,58 @@ const (
 		VALUES ($1, $2, $3)
 		ON CONFLICT (message_id, user_id, emoji) DO NOTHING
 	`
+	// The sender is the contact for patient messages, otherwise the user.
+	// Deleted messages still match, so a late retry can't send them again.
+	querySelectMessageByClientID = `
+		SELECT ` + messageColumns + `
+		FROM messages
+		WHERE id = (
+			SELECT message_id FROM message_client_ids
+			WHERE sender_id = $1 AND client_message_id = $2
+		)
+	`
+
+	queryInsertClientMessageID = `
+		INSERT INTO message_client_ids (sender_id, client_message_id, message_id)
+		VALUES ($1, $2, $3)
+	`
+
 	querySelectMessageByID = `
-		SELECT id, location_id, sender_user_id, receiver_user_id,
-			sender_contact_id, receiver_contact_id, content,
-			sent_at, read_at, is_read, file_url, file_name, file_type
+		SELECT ` + messageColumns + `
 		FROM messages WHERE id = $1 AND deleted_at IS NULL
 	`
 
+	// Locks the message so concurrent edits get consecutive revision numbers
+	queryLockMessageForEdit = `
+		SELECT content, COALESCE(edited_at, sent_at), session_id, location_id,
+		       COALESCE(file_url, ''), COALESCE(file_name, ''), COALESCE(file_type, '')
+		FROM messages WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE
+	`
+
+	queryInsertMessageRevision = `
+		INSERT INTO message_revisions (message_id, revision, content, edited_by, valid_from, replaced_at)
+		VALUES ($1, (SELECT COALESCE(MAX(revision), 0) + 1 FROM message_revisions WHERE message_id = $1), $2, $3, $4, $5)
+	`
+
+	// The whole row is resealed, so its fields and tokens share one key
 	queryUpdateMessageContent = `
-	UPDATE messages SET content = $1, edited_at = now()
-	WHERE id = $2 AND sender_user_id = $3 AND deleted_at IS NULL
+	UPDATE messages SET content = $1, edited_at = $2, file_url = NULLIF($4, ''), file_name = NULLIF($5, ''),
+		file_type = NULLIF($6, ''), key_id = $7, content_tokens = $8
+	WHERE id = $3 AND deleted_at IS NULL
+	`
+
+	queryGetMessageRevisions = `
+		SELECT id, message_id, revision, content, edited_by, valid_from, replaced_at
+		FROM message_revisions
+		WHERE message_id = $1
+		ORDER BY revision ASC
 	`
 
 	queryTogglePinMessage = `
 	UPDATE messages SET is_pinned = $1
-	WHERE id = $2
+	WHERE id = $2 AND is_pinned IS DISTINCT FROM $1
+	RETURNING session_id
 	`
 	queryGetPinnedMessages = `
 		SELECT id, content, file_url, file_name, file_type, sent_at
@@ -123,15 +150,68 @@ const (
 	`
 )
 
+var (
+	ErrMessageNotFound = errors.New("message not found")
+
+	// ErrDuplicateClientMessage means the sender already stored a message
+	// with the same client_message_id.
+	ErrDuplicateClientMessage = errors.New("duplicate client message id")
+)
+
+// uniqClientMessageIndex enforces one message per sender and client_message_id.
+// It is the key of message_client_ids rather than an index on the
+// partitioned messages table, whose unique indexes must include sent_at.
+const uniqClientMessageIndex = "message_client_ids_pkey"
+
 type MessageRepo struct {
 	DB *sql.DB
+	// Storage holds archived partitions. GetConversation falls back to it
+	// when a page reaches past the messages still in the database; nil
+	// disables the fallback.
+	Storage ObjectStorage
 }
 
 func NewMessageRepo(db *sql.DB) *MessageRepo {
 	return &MessageRepo{DB: db}
 }
 
-func (r *MessageRepo) SaveMessage(msg *models.Message) error {
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanMessage reads a row selected with messageColumns.
+func scanMessage(row rowScanner) (models.DBMessage, error) {
+	var msg models.DBMessage
+	err := row.Scan(&msg.ID, &msg.LocationID, &msg.SenderUserID, &msg.ReceiverUserID,
+		&msg.SenderContactID, &msg.ReceiverContactID, &msg.SessionID, &msg.Content,
+		&msg.SentAt, &msg.ReadAt, &msg.IsRead,
+		&msg.FileURL, &msg.FileName, &msg.FileType,
+		&msg.ReplyToID, &msg.EditedAt, &msg.IsPinned, &msg.IsSystem, &msg.Seq,
+		&msg.ClientMessageID,
+	)
+	if err != nil {
+		return msg, err
+	}
+
+	msg.MessageType = "text"
+	if msg.FileURL != "" {
+		msg.MessageType = "file"
+	}
+	if msg.IsSystem {
+		msg.MessageType = "system"
+	}
+	return msg, nil
+}
+
+// SaveMessage stores a new message in one transaction: it creates or touches
+// the doctor/patient session, assigns the message's seq, inserts it and
+// queues its delivery, push and auto-reply in the outbox. msg.SessionID is
+// set from the session. It returns ErrDuplicateClientMessage if the sender
+// already used msg.ClientMessageID.
+func (r *MessageRepo) SaveMessage(ctx context.Context, msg *models.Message) (err error) {
+	ctx, end := beginWrite(ctx)
+	defer end(&err)
+
 	log.Printf("💾 Saving message from user %s to contact %s (session: %s)", msg.SenderUserID, msg.ReceiverContactID, msg.SessionID)
 
 	id, err := uuid.Parse(msg.ID)
@@ -181,83 +261,263 @@ func (r *MessageRepo) SaveMessage(msg *models.Message) error {
 		replyToID = &val
 	}
 
-	sessionID, err := uuid.Parse(msg.SessionID)
+	msg.SentAt = time.Now()
+	msg.IsRead = false
+
+	key, err := activeKey(ctx, msg.LocationID)
 	if err != nil {
-		log.Println("❌ Invalid UUID for SessionID:", err)
 		return err
 	}
+	stored := sealFields(key, msg.ID, msg.Content, msg.FileURL, msg.FileName, msg.FileType)
 
-	msg.SentAt = time.Now()
-	msg.IsRead = false
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The session is created or touched in the same transaction, so a
+	// session never shows activity for a message that was not stored
+	var sessionID uuid.UUID
+	err = tx.QueryRowContext(ctx, queryUpsertSessionForMessage, uuid.New(), receiverContactID, senderUserID, locationID, msg.SentAt).Scan(&sessionID)
+	if err != nil {
+		log.Println("❌ Failed to create or fetch session:", err)
+		return err
+	}
+	msg.SessionID = sessionID.String()
+
+	// The seq comes from the session row lock and is recorded with the
+	// message_created event first. messages has no unique (session_id, seq)
+	// index since partitioning; fk_messages_session_event ties the message
+	// to that event instead, which is unique per seq.
+	ev, err := appendSessionEvent(ctx, tx, &sessionID, models.EventMessageCreated, &id, nil)
+	if err != nil {
+		return err
+	}
+	msg.Seq = ev.Seq
 
-	_, err = r.DB.Exec(queryInsertMessage,
+	_, err = tx.ExecContext(ctx, queryInsertMessage,
 		id, locationID, senderUserID, receiverUserID,
-		senderContactID, receiverContactID, msg.Content,
-		msg.SentAt, msg.IsRead, sessionID, msg.FileURL, msg.FileName, msg.FileType, replyToID,
+		senderContactID, receiverContactID, stored.Content,
+		msg.SentAt, msg.IsRead, sessionID, stored.FileURL, stored.FileName, stored.FileType, replyToID,
+		msg.IsSystem, msg.Seq, msg.ClientMessageID, stored.KeyID, pq.Array(stored.Tokens),
 	)
+	if err == nil && msg.ClientMessageID != "" {
+		senderID := senderUserID
+		if senderContactID != nil {
+			senderID = *senderContactID
+		}
+		_, err = tx.ExecContext(ctx, queryInsertClientMessageID, senderID, msg.ClientMessageID, id)
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == uniqClientMessageIndex {
+		log.Printf("🔁 Duplicate client_message_id %s from sender %s", msg.ClientMessageID, msg.SenderUserID)
+		return ErrDuplicateClientMessage
+	}
 	if err != nil {
 		log.Println("❌ Failed to insert message:", err)
+		return err
+	}
+	if err := refreshSessionSummary(ctx, tx, &sessionID); err != nil {
+		return err
 	}
-	return err
+
+	// Side effects are dispatched by the outbox relay once this commits. The
+	// outbox keeps the message as stored; Dispatch opens it again.
+	queued := *msg
+	queued.Content, queued.FileURL, queued.FileName, queued.FileType = stored.Content, stored.FileURL, stored.FileName, stored.FileType
+	effects := []string{models.OutboxMessageDeliver, models.OutboxMessagePush}
+	if msg.ReceiverUserID != "" && !msg.IsSystem {
+		effects = append(effects, models.OutboxMessageAutoReply)
+	}
+	for _, eventType := range effects {
+		if err := enqueueOutbox(ctx, tx, eventType, msg.ID, &queued); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
-func (r *MessageRepo) GetConversation(locationID, contactID, userID string) ([]models.DBMessage, error) {
+// GetMessageByClientID finds the message a sender stored under a
+// client_message_id, including deleted ones.
+func (r *MessageRepo) GetMessageByClientID(ctx context.Context, senderID, clientMessageID string) (_ models.DBMessage, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
+	msg, err := scanMessage(r.DB.QueryRowContext(ctx, querySelectMessageByClientID, senderID, clientMessageID))
+	if err == sql.ErrNoRows {
+		return msg, ErrMessageNotFound
+	}
+	if err != nil {
+		log.Println("❌ Failed to fetch message by client id:", err)
+		return msg, err
+	}
+	return msg, openMessage(ctx, &msg)
+}
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// GetConversation returns one page of the conversation between a doctor and a
+// patient. Without cursors it returns the newest page; Before walks back in
+// time and After walks forward. Messages are always returned oldest first.
+func (r *MessageRepo) GetConversation(ctx context.Context, locationID, contactID, userID string, page models.PageRequest) (_ models.HistoryPage, err error) {
+	parent := ctx
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
 	log.Printf("📤 Fetching conversation for locationID=%s, contactID=%s, userID=%s", locationID, contactID, userID)
 
-	rows, err := r.DB.Query(querySelectConversation, locationID, userID, contactID)
+	limit := page.Limit
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	// Forward pages read ascending from the cursor; everything else reads
+	// descending from the cursor (or the newest message) and is flipped after.
+	forward := page.After != "" && page.Before == ""
+	raw := page.Before
+	if forward {
+		raw = page.After
+	}
+
+	var cursor *messageCursor
+	if raw != "" {
+		c, err := decodeMessageCursor(raw)
+		if err != nil {
+			return models.HistoryPage{}, err
+		}
+		cursor = &c
+	}
+
+	args := []any{locationID, userID, contactID}
+	condition, order := "TRUE", "sent_at DESC, id DESC"
+	switch {
+	case page.AfterSeq > 0 && page.BeforeSeq == 0:
+		forward = true
+		args = append(args, page.AfterSeq)
+		condition, order = "seq > $4", "seq ASC"
+	case page.BeforeSeq > 0:
+		forward = false
+		args = append(args, page.BeforeSeq)
+		condition, order = "seq < $4", "seq DESC"
+	case forward:
+		args = append(args, cursor.SentAt.Format(cursorTimeLayout), cursor.ID)
+		condition, order = "(sent_at, id) > ($4::timestamp, $5::uuid)", "sent_at ASC, id ASC"
+	case cursor != nil:
+		args = append(args, cursor.SentAt.Format(cursorTimeLayout), cursor.ID)
+		condition = "(sent_at, id) < ($4::timestamp, $5::uuid)"
+	}
+	// One extra row tells us whether another page exists in this direction
+	args = append(args, limit+1)
+	query := fmt.Sprintf(querySelectConversationPage, condition, order, fmt.Sprintf("$%d", len(args)))
+
+	rows, err := readDB(ctx, r.DB).QueryContext(ctx, query, args...)
 	if err != nil {
 		log.Println("❌ Failed to fetch messages:", err)
-		return nil, err
+		return models.HistoryPage{}, err
 	}
 	defer rows.Close()
 
-	var messages []models.DBMessage
-	messageMap := make(map[uuid.UUID]*models.DBMessage)
-
+	messages := []models.DBMessage{}
 	for rows.Next() {
-		var msg models.DBMessage
-		var replyToID *uuid.UUID
-
-		err := rows.Scan(&msg.ID, &msg.LocationID, &msg.SenderUserID, &msg.ReceiverUserID,
-			&msg.SenderContactID, &msg.ReceiverContactID, &msg.Content,
-			&msg.SentAt, &msg.ReadAt, &msg.IsRead,
-			&msg.FileURL, &msg.FileName, &msg.FileType,
-			&replyToID, &msg.EditedAt, &msg.IsPinned,
-		)
-
+		msg, err := scanMessage(rows)
 		if err != nil {
 			log.Println("❌ Failed to scan message:", err)
-			return nil, err
+			return models.HistoryPage{}, err
 		}
-
-		msg.MessageType = "text"
-		if msg.FileURL != "" {
-			msg.MessageType = "file"
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return models.HistoryPage{}, err
+	}
+	rows.Close()
+
+	// Archived messages are older than everything left in the table: they
+	// come before the database rows going forward, and after them going back
+	// once the table has run out.
+	if r.Storage != nil && (forward || len(messages) <= limit) {
+		conv, ok := parseConversationKey(locationID, userID, contactID)
+		if ok {
+			bounds := archivedBounds{cursor: cursor}
+			switch {
+			case page.BeforeSeq > 0:
+				bounds = archivedBounds{beforeSeq: page.BeforeSeq}
+			case page.AfterSeq > 0:
+				bounds = archivedBounds{afterSeq: page.AfterSeq}
+			}
+			skip := false
+			if forward {
+				through, err := r.conversationArchivedThrough(parent, conv)
+				if err != nil {
+					return models.HistoryPage{}, err
+				}
+				skip = through.pastArchives(bounds)
+			}
+			if !skip {
+				want := limit + 1
+				if !forward {
+					want -= len(messages)
+				}
+				archived, err := r.readArchivedConversation(parent, conv, bounds, forward, want)
+				if err != nil {
+					return models.HistoryPage{}, err
+				}
+				if forward {
+					messages = append(archived, messages...)
+				} else {
+					messages = append(messages, archived...)
+				}
+			}
 		}
+	}
 
-		msg.Reactions, _ = r.GetReactions(msg.ID.String())
-		if replyToID != nil {
-			msg.ReplyToID = replyToID
+	more := len(messages) > limit
+	if more {
+		messages = messages[:limit]
+	}
+	if err := openMessages(ctx, messages); err != nil {
+		return models.HistoryPage{}, err
+	}
+	if err := r.attachReactions(ctx, readDB(ctx, r.DB), messages); err != nil {
+		return models.HistoryPage{}, err
+	}
+	if !forward {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
 		}
+	}
 
-		messageMap[msg.ID] = &msg
-		messages = append(messages, msg)
+	result := models.HistoryPage{Messages: messages}
+	if forward {
+		result.HasNewer, result.HasOlder = more, true
+	} else {
+		result.HasOlder, result.HasNewer = more, cursor != nil || page.BeforeSeq > 0
+	}
+	if len(messages) > 0 {
+		first, last := messages[0], messages[len(messages)-1]
+		result.PrevCursor = messageCursor{SentAt: first.SentAt, ID: first.ID}.encode()
+		result.NextCursor = messageCursor{SentAt: last.SentAt, ID: last.ID}.encode()
 	}
 
-	// Populate reply_to inline
-	for i := range messages {
-		if messages[i].ReplyToID != nil {
-			if parent, ok := messageMap[*messages[i].ReplyToID]; ok {
-				msgCopy := *parent
-				messages[i].ReplyTo = &msgCopy
-			}
-		}
+	if err := r.attachThreadInfo(ctx, result.Messages); err != nil {
+		return models.HistoryPage{}, err
 	}
 
-	return messages, nil
+	return result, nil
 }
 
-func (r *MessageRepo) MarkMessagesRead(ids []string) error {
+// MarkMessagesRead marks messages read and records one messages_read event
+// per session touched. Messages that were already read are left alone.
+func (r *MessageRepo) MarkMessagesRead(ctx context.Context, ids []string) (_ []models.SessionEvent, err error) {
+	ctx, end := beginWrite(ctx)
+	defer end(&err)
+
 	log.Println("📌 Marking messages as read:", ids)
 
 	var uuids []uuid.UUID
@@ -265,101 +525,179 @@ func (r *MessageRepo) MarkMessagesRead(ids []string) error {
 		u, err := uuid.Parse(id)
 		if err != nil {
 			log.Println("❌ Error parsing UUID:", err)
-			return err
+			return nil, err
 		}
 		uuids = append(uuids, u)
 	}
 
-	_, err := r.DB.Exec(queryUpdateMarkMessagesRead, pq.Array(ids))
+	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println("❌ Failed to mark messages read:", err)
+		return nil, err
 	}
-	return err
-}
-
-func (r *MessageRepo) ListEnrichedChatSessionsWithFilter(userID, contactID, locationID string, limit, offset int) ([]models.ChatSessionResponse, error) {
-	log.Printf("🔍 Listing sessions for user=%s contact=%s location=%s limit=%d offset=%d", userID, contactID, locationID, limit, offset)
+	defer tx.Rollback()
 
-	query := fmt.Sprintf("%s LIMIT $4 OFFSET $5", baseSessionQuery)
-	rows, err := r.DB.Query(query, contactID, userID, locationID, limit, offset)
+	rows, err := tx.QueryContext(ctx, queryUpdateMarkMessagesRead, pq.Array(uuids))
 	if err != nil {
-		log.Println("❌ Query failed for ListEnrichedChatSessionsWithFilter:", err)
+		log.Println("❌ Failed to mark messages read:", err)
 		return nil, err
 	}
-	defer rows.Close()
-
-	sessions := []models.ChatSessionResponse{}
+	type readBatch struct {
+		sessionID *uuid.UUID
+		ids       []string
+		readAt    time.Time
+	}
+	var batches []*readBatch
+	bySession := make(map[uuid.UUID]*readBatch)
 	for rows.Next() {
-		var s models.ChatSessionResponse
-		err := rows.Scan(&s.ID, &s.ContactID, &s.ContactName, &s.UserID, &s.UserName, &s.LocationID, &s.StartedAt, &s.LastMessageAt, &s.LastMessage, &s.UnreadCount)
-		if err != nil {
-			log.Println("❌ Failed to scan chat session row:", err)
+		var id uuid.UUID
+		var sessionID *uuid.UUID
+		var readAt time.Time
+		if err := rows.Scan(&id, &sessionID, &readAt); err != nil {
+			rows.Close()
 			return nil, err
 		}
-		sessions = append(sessions, s)
+		if sessionID == nil {
+			continue
+		}
+		b, ok := bySession[*sessionID]
+		if !ok {
+			b = &readBatch{sessionID: sessionID, readAt: readAt}
+			bySession[*sessionID] = b
+			batches = append(batches, b)
+		}
+		b.ids = append(b.ids, id.String())
 	}
-
-	return sessions, nil
-}
-
-func (r *MessageRepo) SearchMessages(userID, contactID, locationID, query string, limit int) ([]models.DBMessage, error) {
-
-	rows, err := r.DB.Query(querySearchMessage, query, locationID, userID, contactID, limit)
-	if err != nil {
-		log.Println("❌ Search query failed:", err)
+	rows.Close()
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var results []models.DBMessage
-	for rows.Next() {
-		var msg models.DBMessage
-		err := rows.Scan(&msg.ID, &msg.LocationID, &msg.SenderUserID, &msg.ReceiverUserID,
-			&msg.SenderContactID, &msg.ReceiverContactID, &msg.Content, &msg.SentAt,
-			&msg.ReadAt, &msg.DeliveredAt, &msg.IsRead)
+	events := []models.SessionEvent{}
+	for _, b := range batches {
+		ev, err := appendSessionEvent(ctx, tx, b.sessionID, models.EventMessagesRead, nil, map[string]any{
+			"message_ids": b.ids,
+			"read_at":     b.readAt,
+		})
 		if err != nil {
-			log.Println("❌ Error scanning search row:", err)
 			return nil, err
 		}
-		results = append(results, msg)
+		if err := refreshSessionSummary(ctx, tx, b.sessionID); err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
 	}
-	return results, nil
+	return events, tx.Commit()
 }
 
-func (r *MessageRepo) DeleteMessage(id uuid.UUID) error {
+// DeleteMessage soft-deletes a message. Deleting an already deleted message
+// is a no-op and returns the zero event.
+func (r *MessageRepo) DeleteMessage(ctx context.Context, id uuid.UUID) (_ models.SessionEvent, err error) {
+	ctx, end := beginWrite(ctx)
+	defer end(&err)
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return models.SessionEvent{}, err
+	}
+	defer tx.Rollback()
 
-	_, err := r.DB.Exec(queryDeleteMessage, id)
-	return err
+	if err := checkLegalHold(ctx, tx, []uuid.UUID{id}); err != nil {
+		return models.SessionEvent{}, err
+	}
+
+	var sessionID *uuid.UUID
+	err = tx.QueryRowContext(ctx, queryDeleteMessage, id).Scan(&sessionID)
+	if err == sql.ErrNoRows {
+		return models.SessionEvent{}, nil
+	}
+	if err != nil {
+		log.Printf("❌ Failed to delete message %s: %v", id, err)
+		return models.SessionEvent{}, err
+	}
+
+	ev, err := appendSessionEvent(ctx, tx, sessionID, models.EventMessageDeleted, &id, nil)
+	if err != nil {
+		return ev, err
+	}
+	if err := refreshSessionSummary(ctx, tx, sessionID); err != nil {
+		return ev, err
+	}
+	return ev, tx.Commit()
 }
 
-func (r *MessageRepo) AddReaction(msgID, userID, emoji string) error {
+func (r *MessageRepo) AddReaction(ctx context.Context, msgID, userID, emoji string) (_ models.SessionEvent, err error) {
+	ctx, end := beginWrite(ctx)
+	defer end(&err)
+
 	log.Printf("➕ Adding reaction: %s by user %s to message %s", emoji, userID, msgID)
 
-	_, err := r.DB.Exec(queryAddReaction, msgID, userID, emoji)
+	ev, err := r.changeReaction(ctx, queryAddReaction, models.EventReactionAdded, msgID, userID, emoji)
 	if err != nil {
 		log.Printf("❌ Failed to add reaction: %v", err)
 	} else {
 		log.Printf("✅ Reaction added: %s by user %s", emoji, userID)
 	}
-	return err
+	return ev, err
 }
 
-func (r *MessageRepo) RemoveReaction(msgID, userID, emoji string) error {
+func (r *MessageRepo) RemoveReaction(ctx context.Context, msgID, userID, emoji string) (_ models.SessionEvent, err error) {
+	ctx, end := beginWrite(ctx)
+	defer end(&err)
+
 	log.Printf("❌ Removing reaction: %s by user %s from message %s", emoji, userID, msgID)
 
-	_, err := r.DB.Exec(queryRemoveReaction, msgID, userID, emoji)
+	ev, err := r.changeReaction(ctx, queryRemoveReaction, models.EventReactionRemoved, msgID, userID, emoji)
 	if err != nil {
 		log.Printf("❌ Failed to remove reaction: %v", err)
 	} else {
 		log.Printf("✅ Reaction removed: %s by user %s", emoji, userID)
 	}
-	return err
+	return ev, err
+}
+
+// changeReaction runs an add or remove query and, if it changed anything,
+// records the event in the message's session.
+func (r *MessageRepo) changeReaction(ctx context.Context, query, eventType, msgID, userID, emoji string) (models.SessionEvent, error) {
+	id, err := uuid.Parse(msgID)
+	if err != nil {
+		return models.SessionEvent{}, err
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return models.SessionEvent{}, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, query, msgID, userID, emoji)
+	if err != nil {
+		return models.SessionEvent{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.SessionEvent{}, nil
+	}
+
+	var sessionID *uuid.UUID
+	if err := tx.QueryRowContext(ctx, queryGetMessageSession, id).Scan(&sessionID); err != nil {
+		return models.SessionEvent{}, err
+	}
+	ev, err := appendSessionEvent(ctx, tx, sessionID, eventType, &id, map[string]string{
+		"user_id": userID,
+		"emoji":   emoji,
+	})
+	if err != nil {
+		return ev, err
+	}
+	return ev, tx.Commit()
 }
 
-func (r *MessageRepo) GetReactions(msgID string) ([]models.MessageReaction, error) {
+func (r *MessageRepo) GetReactions(ctx context.Context, msgID string) (_ []models.MessageReaction, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
 	log.Printf("🔍 Fetching reactions for message %s", msgID)
 
-	rows, err := r.DB.Query(queryGetReactions, msgID)
+	rows, err := r.DB.QueryContext(ctx, queryGetReactions, msgID)
 	if err != nil {
 		log.Printf("❌ Failed to fetch reactions: %v", err)
 		return nil, err
@@ -380,46 +718,200 @@ func (r *MessageRepo) GetReactions(msgID string) ([]models.MessageReaction, erro
 	return reactions, nil
 }
 
-func (r *MessageRepo) GetMessageByID(id string) (models.DBMessage, error) {
-	var msg models.DBMessage
-	err := r.DB.QueryRow(querySelectMessageByID, id).Scan(
-		&msg.ID, &msg.LocationID, &msg.SenderUserID, &msg.ReceiverUserID,
-		&msg.SenderContactID, &msg.ReceiverContactID, &msg.Content,
-		&msg.SentAt, &msg.ReadAt, &msg.IsRead,
-		&msg.FileURL, &msg.FileName, &msg.FileType,
-	)
+// attachReactions fills in the reactions of each message with one query
+// against db.
+func (r *MessageRepo) attachReactions(ctx context.Context, db *sql.DB, messages []models.DBMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	index := make(map[string]int, len(messages))
+	ids := make([]uuid.UUID, 0, len(messages))
+	for i, m := range messages {
+		index[m.ID.String()] = i
+		ids = append(ids, m.ID)
+	}
+
+	rows, err := db.QueryContext(ctx, queryGetReactionsForMessages, pq.Array(ids))
 	if err != nil {
-		log.Println("❌ Failed to fetch reply message:", err)
-		return msg, err
+		log.Printf("❌ Failed to fetch reactions: %v", err)
+		return err
 	}
-	msg.MessageType = "text"
-	if msg.FileURL != "" {
-		msg.MessageType = "file"
+	defer rows.Close()
+
+	for rows.Next() {
+		var re models.MessageReaction
+		if err := rows.Scan(&re.ID, &re.MessageID, &re.UserID, &re.Emoji, &re.CreatedAt); err != nil {
+			log.Printf("❌ Failed to scan reaction row: %v", err)
+			return err
+		}
+		if i, ok := index[re.MessageID]; ok {
+			messages[i].Reactions = append(messages[i].Reactions, re)
+		}
 	}
-	return msg, nil
+	return rows.Err()
 }
 
-func (r *MessageRepo) UpdateMessageContent(msgID, senderID, newContent string) error {
-	log.Printf("✏️ Editing message %s by user %s", msgID, senderID)
-	_, err := r.DB.Exec(queryUpdateMessageContent, newContent, msgID, senderID)
+func (r *MessageRepo) GetMessageByID(ctx context.Context, id string) (_ models.DBMessage, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
+	msg, err := scanMessage(r.DB.QueryRowContext(ctx, querySelectMessageByID, id))
+	if err == sql.ErrNoRows {
+		return msg, ErrMessageNotFound
+	}
+	if err != nil {
+		log.Println("❌ Failed to fetch message:", err)
+		return msg, err
+	}
+	return msg, openMessage(ctx, &msg)
+}
+
+// UpdateMessageContent replaces a message's content and keeps the replaced
+// version in message_revisions, so the original wording can always be shown.
+// Authorization and the edit window are the caller's responsibility.
+// The returned message_edited event carries the new content and edited_at.
+func (r *MessageRepo) UpdateMessageContent(ctx context.Context, msgID, editorID, newContent string) (_ time.Time, _ models.SessionEvent, err error) {
+	ctx, end := beginWrite(ctx)
+	defer end(&err)
+
+	log.Printf("✏️ Editing message %s by %s", msgID, editorID)
+
+	id, err := uuid.Parse(msgID)
 	if err != nil {
+		return time.Time{}, models.SessionEvent{}, err
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, models.SessionEvent{}, err
+	}
+	defer tx.Rollback()
+
+	var oldContent, locationID, fileURL, fileName, fileType string
+	var validFrom time.Time
+	var sessionID *uuid.UUID
+	err = tx.QueryRowContext(ctx, queryLockMessageForEdit, msgID).Scan(&oldContent, &validFrom, &sessionID,
+		&locationID, &fileURL, &fileName, &fileType)
+	if err == sql.ErrNoRows {
+		return time.Time{}, models.SessionEvent{}, ErrMessageNotFound
+	}
+	if err != nil {
+		log.Printf("❌ Failed to lock message for edit: %v", err)
+		return time.Time{}, models.SessionEvent{}, err
+	}
+	if err := checkLegalHold(ctx, tx, []uuid.UUID{id}); err != nil {
+		return time.Time{}, models.SessionEvent{}, err
+	}
+
+	if err := openFields(ctx, msgID, &fileURL, &fileName, &fileType); err != nil {
+		return time.Time{}, models.SessionEvent{}, err
+	}
+	key, err := activeKey(ctx, locationID)
+	if err != nil {
+		return time.Time{}, models.SessionEvent{}, err
+	}
+	stored := sealFields(key, msgID, newContent, fileURL, fileName, fileType)
+
+	// The replaced content moves to the revision as stored: both are
+	// sealed for the message's ID
+	editedAt := time.Now()
+	if _, err := tx.ExecContext(ctx, queryInsertMessageRevision, msgID, oldContent, editorID, validFrom, editedAt); err != nil {
+		log.Printf("❌ Failed to store message revision: %v", err)
+		return time.Time{}, models.SessionEvent{}, err
+	}
+	if _, err := tx.ExecContext(ctx, queryUpdateMessageContent, stored.Content, editedAt, msgID,
+		stored.FileURL, stored.FileName, stored.FileType, stored.KeyID, pq.Array(stored.Tokens)); err != nil {
 		log.Printf("❌ Failed to edit message: %v", err)
+		return time.Time{}, models.SessionEvent{}, err
+	}
+
+	ev, err := appendSessionEvent(ctx, tx, sessionID, models.EventMessageEdited, &id, editedEventData(stored.Content, editedAt))
+	if err != nil {
+		return time.Time{}, ev, err
+	}
+	if ev.Data, err = json.Marshal(editedEventData(newContent, editedAt)); err != nil {
+		return time.Time{}, ev, err
+	}
+	if err := refreshSessionSummary(ctx, tx, sessionID); err != nil {
+		return time.Time{}, ev, err
+	}
+	return editedAt, ev, tx.Commit()
+}
+
+// GetMessageRevisions lists the prior versions of a message, oldest first.
+func (r *MessageRepo) GetMessageRevisions(ctx context.Context, msgID string) (_ []models.MessageRevision, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
+	rows, err := r.DB.QueryContext(ctx, queryGetMessageRevisions, msgID)
+	if err != nil {
+		log.Printf("❌ Failed to fetch message revisions: %v", err)
+		return nil, err
 	}
-	return err
+	defer rows.Close()
+
+	revisions := []models.MessageRevision{}
+	for rows.Next() {
+		var rev models.MessageRevision
+		if err := rows.Scan(&rev.ID, &rev.MessageID, &rev.Revision, &rev.Content, &rev.EditedBy, &rev.ValidFrom, &rev.ReplacedAt); err != nil {
+			log.Printf("❌ Failed to scan message revision: %v", err)
+			return nil, err
+		}
+		if err := openFields(ctx, rev.MessageID, &rev.Content); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
 }
 
-func (r *MessageRepo) TogglePinMessage(msgID string, pin bool) error {
+// TogglePinMessage pins or unpins a message. Setting the state it already
+// has returns the zero event.
+func (r *MessageRepo) TogglePinMessage(ctx context.Context, msgID string, pin bool) (_ models.SessionEvent, err error) {
+	ctx, end := beginWrite(ctx)
+	defer end(&err)
+
 	log.Printf("📌 Pin status update for message %s to %v", msgID, pin)
-	_, err := r.DB.Exec(queryTogglePinMessage, pin, msgID)
+
+	id, err := uuid.Parse(msgID)
+	if err != nil {
+		return models.SessionEvent{}, err
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return models.SessionEvent{}, err
+	}
+	defer tx.Rollback()
+
+	var sessionID *uuid.UUID
+	err = tx.QueryRowContext(ctx, queryTogglePinMessage, pin, msgID).Scan(&sessionID)
+	if err == sql.ErrNoRows {
+		return models.SessionEvent{}, nil
+	}
 	if err != nil {
 		log.Printf("❌ Failed to update pin status: %v", err)
+		return models.SessionEvent{}, err
 	}
-	return err
+
+	eventType := models.EventMessageUnpinned
+	if pin {
+		eventType = models.EventMessagePinned
+	}
+	ev, err := appendSessionEvent(ctx, tx, sessionID, eventType, &id, nil)
+	if err != nil {
+		return ev, err
+	}
+	return ev, tx.Commit()
 }
 
-func (r *MessageRepo) GetPinnedMessages(sessionID string) ([]models.PinnedMessage, error) {
+func (r *MessageRepo) GetPinnedMessages(ctx context.Context, sessionID string) (_ []models.PinnedMessage, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
 	log.Printf("📍 Fetching pinned messages for session %s", sessionID)
-	rows, err := r.DB.Query(queryGetPinnedMessages, sessionID)
+	rows, err := r.DB.QueryContext(ctx, queryGetPinnedMessages, sessionID)
 	if err != nil {
 		log.Printf("❌ Failed to fetch pinned messages: %v", err)
 		return nil, err
@@ -434,32 +926,11 @@ func (r *MessageRepo) GetPinnedMessages(sessionID string) ([]models.PinnedMessag
 			log.Printf("❌ Failed to scan pinned message: %v", err)
 			return nil, err
 		}
+		if err := openFields(ctx, m.ID, &m.Content, &m.FileURL, &m.FileName, &m.FileType); err != nil {
+			return nil, err
+		}
 		pinned = append(pinned, m)
 	}
 
 	return pinned, nil
 }
-
-func (r *MessageRepo) GetDeviceToken(userID string) (string, error) {
-	var token string
-	err := r.DB.QueryRow("SELECT token FROM device_tokens WHERE user_id = $1", userID).Scan(&token)
-	if err != nil {
-		log.Printf("❌ GetDeviceToken error: %v", err)
-	}
-	return token, err
-}
-
-func (r *MessageRepo) UpsertDeviceToken(userID, token string) error {
-	query := `
-		INSERT INTO device_tokens (user_id, token, updated_at)
-		VALUES ($1, $2, now())
-		ON CONFLICT (user_id) DO UPDATE
-		SET token = EXCLUDED.token,
-		    updated_at = now()
-	`
-	_, err := r.DB.Exec(query, userID, token)
-	if err != nil {
-		log.Printf("❌ Failed to upsert device token: %v", err)
-	}
-	return err
-}
@@ -0,0 +1,305 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"unicode"
+
+	"internal_chat_system/encryption"
+	"internal_chat_system/models"
+
+	"github.com/lib/pq"
+)
+
+const maxSearchResults = 100
+
+// querySearchMessagesBase ranks messages against a websearch-style query
+// ("chest pain" -aspirin). The text search configuration comes from the
+// request, else the location's setting, else english. Content is escaped
+// before highlighting so snippets are safe to render as HTML. Sealed
+// messages have no search vector; SearchMessages matches them through
+// their blind index, and they rank 0 and get their snippet once opened.
+const querySearchMessagesBase = `
+	WITH q AS (
+		SELECT cfg, websearch_to_tsquery(cfg, $3) AS query
+		FROM (
+			SELECT COALESCE(
+				NULLIF($2, '')::regconfig,
+				(SELECT language FROM location_search_settings WHERE location_id = $1),
+				'english'::regconfig
+			) AS cfg
+		) c
+	)
+	SELECT ` + messageColumns + `,
+	       ts_rank_cd(m.search_vector, q.query) AS rank,
+	       CASE WHEN m.key_id IS NULL THEN ts_headline(q.cfg,
+	           replace(replace(replace(m.content, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+	           q.query,
+	           'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=20, MinWords=5'
+	       ) ELSE '' END AS snippet,
+	       COALESCE((SELECT s.user_id::text FROM chat_sessions s WHERE s.id = m.session_id), ''),
+	       COALESCE((SELECT s.contact_id::text FROM chat_sessions s WHERE s.id = m.session_id), '')
+	FROM messages m, q
+	WHERE m.location_id = $1
+	AND m.deleted_at IS NULL
+`
+
+// SearchMessages runs a ranked full-text search within a location, narrowed
+// by the filter's conversation scope and attribute filters.
+func (r *MessageRepo) SearchMessages(ctx context.Context, f models.SearchFilter) (_ []models.SearchResult, err error) {
+	ctx, end := beginOp(ctx, timeouts.Search)
+	defer end(&err)
+
+	log.Printf("🔍 Searching messages in location=%s", f.LocationID)
+
+	args := []any{f.LocationID, f.Language, f.Query}
+	var conditions []string
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	include, exclude := blindSearchTerms(f.Query)
+	blind, err := blindSearchCondition(ctx, f.LocationID, include, exclude, arg)
+	if err != nil {
+		return nil, err
+	}
+	if blind != "" {
+		conditions = append(conditions, "(m.search_vector @@ q.query OR "+blind+")")
+	} else {
+		conditions = append(conditions, "m.search_vector @@ q.query")
+	}
+
+	// With only one side of the pair set, search every conversation of that
+	// doctor or patient; with neither, the whole location (admins).
+	switch {
+	case f.UserID != "" && f.ContactID != "":
+		u, c := arg(f.UserID), arg(f.ContactID)
+		conditions = append(conditions, fmt.Sprintf(
+			"((m.sender_user_id = %s AND m.receiver_contact_id = %s) OR (m.sender_contact_id = %s AND m.receiver_user_id = %s))",
+			u, c, c, u))
+	case f.UserID != "":
+		u := arg(f.UserID)
+		conditions = append(conditions, fmt.Sprintf("(m.sender_user_id = %s OR m.receiver_user_id = %s)", u, u))
+	case f.ContactID != "":
+		c := arg(f.ContactID)
+		conditions = append(conditions, fmt.Sprintf("(m.sender_contact_id = %s OR m.receiver_contact_id = %s)", c, c))
+	}
+	if f.SessionID != "" {
+		conditions = append(conditions, "m.session_id = "+arg(f.SessionID))
+	}
+	if f.SenderID != "" {
+		s := arg(f.SenderID)
+		conditions = append(conditions, fmt.Sprintf(
+			"(m.sender_contact_id = %s OR (m.sender_contact_id IS NULL AND m.sender_user_id = %s))", s, s))
+	}
+	if f.From != nil {
+		conditions = append(conditions, "m.sent_at >= "+arg(*f.From))
+	}
+	if f.To != nil {
+		conditions = append(conditions, "m.sent_at < "+arg(*f.To))
+	}
+	if f.HasAttachment != nil {
+		if *f.HasAttachment {
+			conditions = append(conditions, "COALESCE(m.file_url, '') <> ''")
+		} else {
+			conditions = append(conditions, "COALESCE(m.file_url, '') = ''")
+		}
+	}
+	if f.Pinned != nil {
+		conditions = append(conditions, "m.is_pinned = "+arg(*f.Pinned))
+	}
+
+	limit := f.Limit
+	if limit <= 0 || limit > maxSearchResults {
+		limit = maxSearchResults
+	}
+
+	var sb strings.Builder
+	sb.WriteString(querySearchMessagesBase)
+	for _, c := range conditions {
+		sb.WriteString("\tAND " + c + "\n")
+	}
+	sb.WriteString(fmt.Sprintf("\tORDER BY rank DESC, m.sent_at DESC\n\tLIMIT %s OFFSET %s\n", arg(limit), arg(f.Offset)))
+
+	rows, err := readDB(ctx, r.DB).QueryContext(ctx, sb.String(), args...)
+	if err != nil {
+		log.Println("❌ Search query failed:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []models.SearchResult{}
+	for rows.Next() {
+		var res models.SearchResult
+		msg, err := scanMessage(scanWithExtra{rows, []any{&res.Rank, &res.Snippet, &res.SessionUserID, &res.SessionContactID}})
+		if err != nil {
+			log.Println("❌ Error scanning search row:", err)
+			return nil, err
+		}
+		res.Message = msg
+		results = append(results, res)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range results {
+		res := &results[i]
+		sealed := encryption.IsSealed(res.Message.Content)
+		if err := openMessage(ctx, &res.Message); err != nil {
+			return nil, err
+		}
+		if sealed {
+			res.Snippet = blindSnippet(res.Message.Content, include)
+		}
+	}
+	return results, nil
+}
+
+// blindSearchTerms splits a websearch-style query into the words sealed
+// messages must and must not contain. The blind index only knows whole
+// words: quotes group words but their order isn't checked, OR isn't
+// supported, and there is no stemming.
+func blindSearchTerms(query string) (include, exclude []string) {
+	var terms []string
+	var term strings.Builder
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			term.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			terms = append(terms, term.String())
+			term.Reset()
+		default:
+			term.WriteRune(r)
+		}
+	}
+	terms = append(terms, term.String())
+
+	for _, t := range terms {
+		negated := strings.HasPrefix(t, "-")
+		t = strings.Trim(strings.TrimPrefix(t, "-"), `"`)
+		if !negated && strings.EqualFold(t, "or") {
+			continue
+		}
+		if negated {
+			exclude = append(exclude, encryption.Words(t)...)
+		} else {
+			include = append(include, encryption.Words(t)...)
+		}
+	}
+	return include, exclude
+}
+
+// blindSearchCondition matches sealed messages of a location containing
+// every included word and none of the excluded ones. Each key hashes words
+// differently, so there is one alternative per key the location has had.
+// It returns "" when there is nothing to match on.
+func blindSearchCondition(ctx context.Context, locationID string, include, exclude []string, arg func(any) string) (string, error) {
+	if keyring == nil || len(include) == 0 {
+		return "", nil
+	}
+	keys, err := keyring.Keys(ctx, locationID)
+	if err != nil {
+		log.Printf("❌ Failed to load data keys of location %s for search: %v", locationID, err)
+		return "", err
+	}
+
+	var alternatives []string
+	for _, k := range keys {
+		c := fmt.Sprintf("(m.key_id = %s AND m.content_tokens @> %s", arg(k.ID), arg(pq.Array(k.WordTokens(include))))
+		if len(exclude) > 0 {
+			c += fmt.Sprintf(" AND NOT m.content_tokens && %s", arg(pq.Array(k.WordTokens(exclude))))
+		}
+		alternatives = append(alternatives, c+")")
+	}
+	return strings.Join(alternatives, " OR "), nil
+}
+
+const (
+	snippetWords = 20 // like ts_headline's MaxWords
+	snippetLead  = 5  // words kept before the first match
+)
+
+// blindSnippet highlights words in the opened content of a sealed match
+// the way ts_headline does for plaintext ones: escaped for HTML, matches in
+// <mark>, at most snippetWords words starting a little before the first
+// match.
+func blindSnippet(content string, words []string) string {
+	match := make(map[string]bool, len(words))
+	for _, w := range words {
+		match[w] = true
+	}
+
+	// Alternate runs of word and non-word characters
+	type run struct {
+		text       string
+		word, mark bool
+	}
+	var runs []run
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+	start := 0
+	for i, r := range content {
+		if i > start && isWord(r) != runs[len(runs)-1].word {
+			runs[len(runs)-1].text = content[start:i]
+			start = i
+		}
+		if i == start {
+			runs = append(runs, run{word: isWord(r)})
+		}
+	}
+	if len(runs) == 0 {
+		return ""
+	}
+	runs[len(runs)-1].text = content[start:]
+
+	first := -1
+	for i := range runs {
+		runs[i].mark = runs[i].word && match[strings.ToLower(runs[i].text)]
+		if runs[i].mark && first < 0 {
+			first = i
+		}
+	}
+	from := 0
+	if first >= 0 {
+		for i, lead := first, 0; i >= 0 && lead <= snippetLead; i-- {
+			if runs[i].word {
+				from, lead = i, lead+1
+			}
+		}
+	}
+
+	var sb strings.Builder
+	n := 0
+	for _, r := range runs[from:] {
+		if r.word {
+			if n == snippetWords {
+				break
+			}
+			n++
+		}
+		if r.mark {
+			sb.WriteString("<mark>" + html.EscapeString(r.text) + "</mark>")
+		} else {
+			sb.WriteString(html.EscapeString(r.text))
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// scanWithExtra appends extra destinations after the message columns.
+type scanWithExtra struct {
+	row   rowScanner
+	extra []any
+}
+
+func (s scanWithExtra) Scan(dest ...any) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
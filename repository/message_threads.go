@@ -0,0 +1,209 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"unicode/utf8"
+
+	"internal_chat_system/models"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// quotePreviewLength caps how much of a parent's content is quoted in replies.
+const quotePreviewLength = 280
+
+const (
+	// Parents are loaded even when deleted so replies can show a placeholder
+	queryGetQuotedMessages = `
+		SELECT id, COALESCE(sender_user_id::text, ''), COALESCE(sender_contact_id::text, ''),
+		       content, COALESCE(file_url, ''), COALESCE(file_name, ''), is_system,
+		       sent_at, deleted_at IS NOT NULL
+		FROM messages
+		WHERE id = ANY($1)
+	`
+
+	queryCountReplies = `
+		SELECT reply_to_id, COUNT(*)
+		FROM messages
+		WHERE reply_to_id = ANY($1) AND deleted_at IS NULL
+		GROUP BY reply_to_id
+	`
+
+	// Walks the whole reply tree below the root, then pages through it in
+	// the order replies were sent. $2/$3 are the cursor's sent_at and id.
+	querySelectThreadPage = `
+		WITH RECURSIVE thread AS (
+			SELECT id FROM messages WHERE reply_to_id = $1
+			UNION
+			SELECT m.id FROM messages m JOIN thread t ON m.reply_to_id = t.id
+		)
+		SELECT ` + messageColumns + `
+		FROM messages
+		WHERE id IN (SELECT id FROM thread)
+		AND deleted_at IS NULL
+		AND ($2::timestamp IS NULL OR (sent_at, id) > ($2::timestamp, $3::uuid))
+		ORDER BY sent_at ASC, id ASC
+		LIMIT $4
+	`
+)
+
+// GetThread returns a message and one page of every reply beneath it,
+// including replies to replies.
+func (r *MessageRepo) GetThread(ctx context.Context, rootID string, after string, limit int) (_ models.ThreadPage, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
+	root, err := r.GetMessageByID(ctx, rootID)
+	if err != nil {
+		return models.ThreadPage{}, err
+	}
+
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	var cursorAt, cursorID any
+	if after != "" {
+		c, err := decodeMessageCursor(after)
+		if err != nil {
+			return models.ThreadPage{}, err
+		}
+		cursorAt, cursorID = c.SentAt.Format(cursorTimeLayout), c.ID
+	}
+
+	rows, err := r.DB.QueryContext(ctx, querySelectThreadPage, rootID, cursorAt, cursorID, limit+1)
+	if err != nil {
+		log.Printf("❌ Failed to fetch thread for %s: %v", rootID, err)
+		return models.ThreadPage{}, err
+	}
+	defer rows.Close()
+
+	replies := []models.DBMessage{}
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			log.Println("❌ Failed to scan thread reply:", err)
+			return models.ThreadPage{}, err
+		}
+		msg.Reactions, _ = r.GetReactions(ctx, msg.ID.String())
+		replies = append(replies, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return models.ThreadPage{}, err
+	}
+
+	page := models.ThreadPage{Root: root}
+	if len(replies) > limit {
+		replies = replies[:limit]
+		page.HasMore = true
+	}
+	if err := openMessages(ctx, replies); err != nil {
+		return models.ThreadPage{}, err
+	}
+	if len(replies) > 0 {
+		last := replies[len(replies)-1]
+		page.NextCursor = messageCursor{SentAt: last.SentAt, ID: last.ID}.encode()
+	}
+
+	root.Reactions, _ = r.GetReactions(ctx, root.ID.String())
+	all := append([]models.DBMessage{root}, replies...)
+	if err := r.attachThreadInfo(ctx, all); err != nil {
+		return models.ThreadPage{}, err
+	}
+	page.Root, page.Replies = all[0], all[1:]
+	return page, nil
+}
+
+// attachThreadInfo fills in the quoted parent and reply count of each message
+// with two batched queries, whether or not the parent is on the same page.
+func (r *MessageRepo) attachThreadInfo(ctx context.Context, messages []models.DBMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(messages))
+	var parentIDs []uuid.UUID
+	for _, m := range messages {
+		ids = append(ids, m.ID)
+		if m.ReplyToID != nil {
+			parentIDs = append(parentIDs, *m.ReplyToID)
+		}
+	}
+
+	counts := make(map[uuid.UUID]int)
+	rows, err := r.DB.QueryContext(ctx, queryCountReplies, pq.Array(ids))
+	if err != nil {
+		log.Printf("❌ Failed to count replies: %v", err)
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uuid.UUID
+		var n int
+		if err := rows.Scan(&id, &n); err != nil {
+			return err
+		}
+		counts[id] = n
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	quoted := make(map[string]*models.QuotedMessage)
+	if len(parentIDs) > 0 {
+		prows, err := r.DB.QueryContext(ctx, queryGetQuotedMessages, pq.Array(parentIDs))
+		if err != nil {
+			log.Printf("❌ Failed to fetch quoted messages: %v", err)
+			return err
+		}
+		defer prows.Close()
+		for prows.Next() {
+			var q models.QuotedMessage
+			var fileURL string
+			var isSystem bool
+			if err := prows.Scan(&q.ID, &q.SenderUserID, &q.SenderContactID, &q.Content,
+				&fileURL, &q.FileName, &isSystem, &q.SentAt, &q.Deleted); err != nil {
+				return err
+			}
+			q.MessageType = "text"
+			if fileURL != "" {
+				q.MessageType = "file"
+			}
+			if isSystem {
+				q.MessageType = "system"
+			}
+			if q.Deleted {
+				q.Content, q.FileName = "", ""
+			}
+			if err := openFields(ctx, q.ID, &q.Content, &q.FileName); err != nil {
+				return err
+			}
+			q.Content = truncateRunes(q.Content, quotePreviewLength)
+			quoted[q.ID] = &q
+		}
+		if err := prows.Err(); err != nil {
+			return err
+		}
+	}
+
+	for i := range messages {
+		messages[i].ReplyCount = counts[messages[i].ID]
+		if messages[i].ReplyToID != nil {
+			messages[i].ReplyTo = quoted[messages[i].ReplyToID.String()]
+		}
+	}
+	return nil
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:n]) + "…"
+}
@@ -0,0 +1,230 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+
+	"internal_chat_system/models"
+
+	"github.com/lib/pq"
+)
+
+type OfficeHoursRepo struct {
+	DB *sql.DB
+}
+
+func NewOfficeHoursRepo(db *sql.DB) *OfficeHoursRepo {
+	return &OfficeHoursRepo{DB: db}
+}
+
+const (
+	// A doctor's own schedule wins over the location-wide one (user_id NULL)
+	queryGetEffectiveOfficeHours = `
+		SELECT id, location_id, COALESCE(user_id::text, ''), timezone, weekly_hours,
+		       auto_reply_enabled, COALESCE(auto_reply_message, ''), updated_at
+		FROM office_hours
+		WHERE location_id = $1 AND (user_id IS NULL OR user_id::text = $2)
+		ORDER BY user_id NULLS LAST
+		LIMIT 1
+	`
+
+	queryGetOfficeHolidays = `
+		SELECT id, location_id, COALESCE(user_id::text, ''), to_char(holiday_date, 'YYYY-MM-DD'), COALESCE(description, '')
+		FROM office_holidays
+		WHERE location_id = $1 AND (user_id IS NULL OR user_id::text = $2)
+		AND holiday_date >= CURRENT_DATE - 1
+		ORDER BY holiday_date
+	`
+
+	// The same lookup for many users at once, holidays included as JSON
+	queryGetEffectiveOfficeHoursForUsers = `
+		SELECT u.user_id, oh.id, oh.location_id, COALESCE(oh.user_id::text, ''), oh.timezone, oh.weekly_hours,
+		       oh.auto_reply_enabled, COALESCE(oh.auto_reply_message, ''), oh.updated_at,
+		       COALESCE((
+		           SELECT json_agg(json_build_object(
+		               'id', h.id, 'location_id', h.location_id, 'user_id', COALESCE(h.user_id::text, ''),
+		               'date', to_char(h.holiday_date, 'YYYY-MM-DD'), 'description', COALESCE(h.description, '')
+		           ) ORDER BY h.holiday_date)
+		           FROM office_holidays h
+		           WHERE h.location_id = $1 AND (h.user_id IS NULL OR h.user_id::text = u.user_id)
+		           AND h.holiday_date >= CURRENT_DATE - 1
+		       ), '[]')
+		FROM unnest($2::text[]) AS u(user_id)
+		CROSS JOIN LATERAL (
+		    SELECT * FROM office_hours o
+		    WHERE o.location_id = $1 AND (o.user_id IS NULL OR o.user_id::text = u.user_id)
+		    ORDER BY o.user_id NULLS LAST
+		    LIMIT 1
+		) oh
+	`
+
+	queryUpsertOfficeHours = `
+		INSERT INTO office_hours (location_id, user_id, timezone, weekly_hours, auto_reply_enabled, auto_reply_message, updated_at)
+		VALUES ($1, NULLIF($2, '')::uuid, $3, $4, $5, NULLIF($6, ''), now())
+		ON CONFLICT (location_id, COALESCE(user_id, '00000000-0000-0000-0000-000000000000'::uuid)) DO UPDATE
+		SET timezone = EXCLUDED.timezone,
+		    weekly_hours = EXCLUDED.weekly_hours,
+		    auto_reply_enabled = EXCLUDED.auto_reply_enabled,
+		    auto_reply_message = EXCLUDED.auto_reply_message,
+		    updated_at = now()
+		RETURNING id, updated_at
+	`
+
+	queryInsertOfficeHoliday = `
+		INSERT INTO office_holidays (location_id, user_id, holiday_date, description)
+		VALUES ($1, NULLIF($2, '')::uuid, $3, NULLIF($4, ''))
+		RETURNING id
+	`
+
+	queryGetOfficeHoliday = `
+		SELECT id, location_id, COALESCE(user_id::text, ''), to_char(holiday_date, 'YYYY-MM-DD'), COALESCE(description, '')
+		FROM office_holidays
+		WHERE id = $1 AND location_id = $2
+	`
+
+	queryDeleteOfficeHoliday = `DELETE FROM office_holidays WHERE id = $1 AND location_id = $2`
+)
+
+var ErrHolidayNotFound = errors.New("office holiday not found")
+
+// GetEffectiveOfficeHours returns the schedule that applies to the user in the
+// location, including upcoming holidays. It returns nil when neither the user
+// nor the location has configured office hours.
+func (r *OfficeHoursRepo) GetEffectiveOfficeHours(ctx context.Context, locationID, userID string) (_ *models.OfficeHours, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
+	var s models.OfficeHours
+	var weekly []byte
+	err = r.DB.QueryRowContext(ctx, queryGetEffectiveOfficeHours, locationID, userID).Scan(
+		&s.ID, &s.LocationID, &s.UserID, &s.Timezone, &weekly,
+		&s.AutoReplyEnabled, &s.AutoReplyMessage, &s.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("❌ Failed to fetch office hours: %v", err)
+		return nil, err
+	}
+	if err := json.Unmarshal(weekly, &s.Weekly); err != nil {
+		log.Printf("❌ Corrupt weekly_hours for office hours %s: %v", s.ID, err)
+		return nil, err
+	}
+
+	rows, err := r.DB.QueryContext(ctx, queryGetOfficeHolidays, locationID, userID)
+	if err != nil {
+		log.Printf("❌ Failed to fetch office holidays: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	s.Holidays = []models.OfficeHoliday{}
+	for rows.Next() {
+		var h models.OfficeHoliday
+		if err := rows.Scan(&h.ID, &h.LocationID, &h.UserID, &h.Date, &h.Description); err != nil {
+			log.Printf("❌ Failed to scan office holiday: %v", err)
+			return nil, err
+		}
+		s.Holidays = append(s.Holidays, h)
+	}
+	return &s, rows.Err()
+}
+
+// GetEffectiveOfficeHoursForUsers is GetEffectiveOfficeHours for many users
+// of one location in a single query, e.g. for a bulk presence lookup. Users
+// without a schedule are left out of the map.
+func (r *OfficeHoursRepo) GetEffectiveOfficeHoursForUsers(ctx context.Context, locationID string, userIDs []string) (_ map[string]*models.OfficeHours, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
+	schedules := make(map[string]*models.OfficeHours)
+	if len(userIDs) == 0 {
+		return schedules, nil
+	}
+	rows, err := r.DB.QueryContext(ctx, queryGetEffectiveOfficeHoursForUsers, locationID, pq.Array(userIDs))
+	if err != nil {
+		log.Printf("❌ Failed to fetch office hours: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID string
+		var s models.OfficeHours
+		var weekly, holidays []byte
+		if err := rows.Scan(&userID, &s.ID, &s.LocationID, &s.UserID, &s.Timezone, &weekly,
+			&s.AutoReplyEnabled, &s.AutoReplyMessage, &s.UpdatedAt, &holidays); err != nil {
+			log.Printf("❌ Failed to scan office hours: %v", err)
+			return nil, err
+		}
+		if err := json.Unmarshal(weekly, &s.Weekly); err != nil {
+			log.Printf("❌ Corrupt weekly_hours for office hours %s: %v", s.ID, err)
+			return nil, err
+		}
+		if err := json.Unmarshal(holidays, &s.Holidays); err != nil {
+			return nil, err
+		}
+		schedules[userID] = &s
+	}
+	return schedules, rows.Err()
+}
+
+func (r *OfficeHoursRepo) UpsertOfficeHours(ctx context.Context, s *models.OfficeHours) (err error) {
+	ctx, end := beginWrite(ctx)
+	defer end(&err)
+
+	weekly, err := json.Marshal(s.Weekly)
+	if err != nil {
+		return err
+	}
+	err = r.DB.QueryRowContext(ctx, queryUpsertOfficeHours,
+		s.LocationID, s.UserID, s.Timezone, weekly, s.AutoReplyEnabled, s.AutoReplyMessage,
+	).Scan(&s.ID, &s.UpdatedAt)
+	if err != nil {
+		log.Printf("❌ Failed to upsert office hours: %v", err)
+	}
+	return err
+}
+
+func (r *OfficeHoursRepo) AddOfficeHoliday(ctx context.Context, h *models.OfficeHoliday) (err error) {
+	ctx, end := beginWrite(ctx)
+	defer end(&err)
+
+	err = r.DB.QueryRowContext(ctx, queryInsertOfficeHoliday, h.LocationID, h.UserID, h.Date, h.Description).Scan(&h.ID)
+	if err != nil {
+		log.Printf("❌ Failed to add office holiday: %v", err)
+	}
+	return err
+}
+
+// GetOfficeHoliday returns ErrHolidayNotFound for unknown holidays or ones
+// of another location.
+func (r *OfficeHoursRepo) GetOfficeHoliday(ctx context.Context, id, locationID string) (_ models.OfficeHoliday, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
+	var h models.OfficeHoliday
+	err = r.DB.QueryRowContext(ctx, queryGetOfficeHoliday, id, locationID).Scan(&h.ID, &h.LocationID, &h.UserID, &h.Date, &h.Description)
+	if err == sql.ErrNoRows {
+		return h, ErrHolidayNotFound
+	}
+	if err != nil {
+		log.Printf("❌ Failed to fetch office holiday: %v", err)
+	}
+	return h, err
+}
+
+func (r *OfficeHoursRepo) DeleteOfficeHoliday(ctx context.Context, id, locationID string) (err error) {
+	ctx, end := beginWrite(ctx)
+	defer end(&err)
+
+	_, err = r.DB.ExecContext(ctx, queryDeleteOfficeHoliday, id, locationID)
+	if err != nil {
+		log.Printf("❌ Failed to delete office holiday: %v", err)
+	}
+	return err
+}
@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	"internal_chat_system/models"
+
+	"github.com/lib/pq"
+)
+
+// maxOutboxAttempts is how often an event is tried before it is parked as
+// failed for someone to look at.
+const maxOutboxAttempts = 10
+
+type OutboxRepo struct {
+	DB *sql.DB
+}
+
+func NewOutboxRepo(db *sql.DB) *OutboxRepo {
+	return &OutboxRepo{DB: db}
+}
+
+const (
+	queryInsertOutboxEvent = `
+		INSERT INTO outbox (event_type, aggregate_id, payload)
+		VALUES ($1, $2, $3)
+	`
+
+	// Claims by setting a lease and commits at once, so no lock is held
+	// while the events are handled. SKIP LOCKED lets relays on several
+	// instances claim side by side; the lease keeps them off each other's
+	// events afterwards.
+	queryClaimOutboxEvents = `
+		UPDATE outbox SET locked_until = now() + $2 * interval '1 millisecond'
+		WHERE id IN (
+			SELECT id FROM outbox
+			WHERE dispatched_at IS NULL AND failed_at IS NULL AND available_at <= now()
+			  AND (locked_until IS NULL OR locked_until <= now())
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, event_type, aggregate_id, payload, attempts, created_at
+	`
+
+	queryMarkOutboxDispatched = `
+		UPDATE outbox SET dispatched_at = now(), attempts = attempts + 1, locked_until = NULL
+		WHERE id = $1
+	`
+
+	queryMarkOutboxRetry = `
+		UPDATE outbox
+		SET attempts = attempts + 1, last_error = $2, available_at = now() + $3 * interval '1 second',
+		    failed_at = CASE WHEN attempts + 1 >= $4 THEN now() END, locked_until = NULL
+		WHERE id = $1
+	`
+
+	queryReleaseOutboxEvents = `UPDATE outbox SET locked_until = NULL WHERE id = ANY($1)`
+)
+
+// enqueueOutbox records a side effect within tx, so it exists if and only if
+// the change that caused it is committed.
+func enqueueOutbox(ctx context.Context, tx *sql.Tx, eventType, aggregateID string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, queryInsertOutboxEvent, eventType, aggregateID, data); err != nil {
+		log.Printf("❌ Failed to enqueue %s for %s: %v", eventType, aggregateID, err)
+		return err
+	}
+	return nil
+}
+
+// minOutboxLease is the shortest time claimed events are reserved for the
+// relay that claimed them. The lease is at least twice the per-event
+// timeout, so one slow event can't make the batch outlive it.
+const minOutboxLease = 2 * time.Minute
+
+// leaseMargin is how much of the lease must be left to start on another
+// event; events not started by then are released for the next claim.
+const leaseMargin = time.Second
+
+// Dispatch claims up to batch pending events under a lease and hands each
+// to handle outside any transaction, with its own timeouts.Outbox deadline
+// that never runs past the lease. Handled events are marked dispatched and
+// failed ones retried with exponential backoff until maxOutboxAttempts, each
+// in its own statement, so one slow event never undoes the others. It
+// returns how many events were claimed.
+func (r *OutboxRepo) Dispatch(ctx context.Context, batch int, handle func(context.Context, models.OutboxEvent) error) (int, error) {
+	lease := max(minOutboxLease, 2*timeouts.Outbox)
+	events, err := r.claim(ctx, batch, lease)
+	if err != nil {
+		return 0, err
+	}
+	leaseEnds := time.Now().Add(lease)
+
+	for i, ev := range events {
+		if time.Until(leaseEnds) < leaseMargin {
+			r.release(ctx, events[i:])
+			break
+		}
+		herr := r.handle(ctx, ev, leaseEnds, handle)
+		if herr != nil {
+			backoff := time.Second << min(ev.Attempts, 10)
+			log.Printf("⚠️ Outbox event %d (%s) failed, attempt %d: %v", ev.ID, ev.EventType, ev.Attempts+1, herr)
+			err = r.mark(ctx, queryMarkOutboxRetry, ev.ID, herr.Error(), int(backoff.Seconds()), maxOutboxAttempts)
+		} else {
+			err = r.mark(ctx, queryMarkOutboxDispatched, ev.ID)
+		}
+		if err != nil {
+			// The lease runs out and the event is tried again
+			log.Printf("❌ Failed to record outcome of outbox event %d: %v", ev.ID, err)
+		}
+	}
+	return len(events), nil
+}
+
+// claim leases up to batch pending events, oldest first.
+func (r *OutboxRepo) claim(ctx context.Context, batch int, lease time.Duration) (_ []models.OutboxEvent, err error) {
+	ctx, end := beginOp(ctx, timeouts.Write)
+	defer end(&err)
+
+	rows, err := r.DB.QueryContext(ctx, queryClaimOutboxEvents, batch, lease.Milliseconds())
+	if err != nil {
+		log.Printf("❌ Failed to claim outbox events: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.OutboxEvent
+	for rows.Next() {
+		var ev models.OutboxEvent
+		if err := rows.Scan(&ev.ID, &ev.EventType, &ev.AggregateID, &ev.Payload, &ev.Attempts, &ev.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// RETURNING doesn't keep the subquery's order
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	return events, nil
+}
+
+// handle opens and handles one event, bounded by timeouts.Outbox and the
+// lease.
+func (r *OutboxRepo) handle(ctx context.Context, ev models.OutboxEvent, leaseEnds time.Time, handle func(context.Context, models.OutboxEvent) error) (err error) {
+	ctx, cancel := context.WithDeadline(ctx, leaseEnds)
+	defer cancel()
+	ctx, end := beginOp(ctx, timeouts.Outbox)
+	defer end(&err)
+
+	if err := openOutboxEvent(ctx, &ev); err != nil {
+		return err
+	}
+	return handle(ctx, ev)
+}
+
+// mark records an event's outcome. It outlives a canceled relay context,
+// since the side effect has already happened.
+func (r *OutboxRepo) mark(ctx context.Context, query string, args ...any) (err error) {
+	ctx, end := beginOp(context.WithoutCancel(ctx), timeouts.Write)
+	defer end(&err)
+
+	_, err = r.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// release hands back events this relay ran out of lease for.
+func (r *OutboxRepo) release(ctx context.Context, events []models.OutboxEvent) {
+	ids := make([]int64, len(events))
+	for i, ev := range events {
+		ids[i] = ev.ID
+	}
+	if err := r.mark(ctx, queryReleaseOutboxEvents, pq.Array(ids)); err != nil {
+		log.Printf("⚠️ Failed to release %d outbox events: %v", len(ids), err)
+	}
+}
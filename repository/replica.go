@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultStickyWindow is how long a caller's reads stay on the primary
+// after they write, comfortably above normal replication lag.
+const DefaultStickyWindow = 3 * time.Second
+
+// Heavy read-only queries (history pages, search, session listings) can be
+// served by read replicas. Every other query, and every transaction, runs
+// on the repo's DB, the primary.
+var replicas struct {
+	pools  []*sql.DB
+	sticky time.Duration
+	next   atomic.Uint64
+}
+
+// SetReplicas routes read-only queries to pools, round robin. A caller's
+// reads go to the primary for sticky after they write, so they always see
+// their own changes. Zero sticky uses DefaultStickyWindow; no pools sends
+// everything to the primary.
+func SetReplicas(pools []*sql.DB, sticky time.Duration) {
+	if sticky <= 0 {
+		sticky = DefaultStickyWindow
+	}
+	replicas.pools = pools
+	replicas.sticky = sticky
+}
+
+type callerKey struct{}
+
+// WithCaller tags ctx with who is making the request, e.g. the
+// authenticated user, for the read-your-writes guard.
+func WithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+func callerFrom(ctx context.Context) string {
+	caller, _ := ctx.Value(callerKey{}).(string)
+	return caller
+}
+
+type primaryKey struct{}
+
+// WithPrimary sends every query made with ctx to the primary, for reads
+// that must not lag behind writes, such as filling a cache.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// WriteMarks remembers which callers wrote within their sticky window.
+// Instances behind a load balancer share one, e.g. in Redis, so a caller's
+// next request reads their own writes whichever instance it lands on.
+type WriteMarks interface {
+	MarkWrite(ctx context.Context, caller string, window time.Duration) error
+	WroteRecently(ctx context.Context, caller string) (bool, error)
+}
+
+var writeMarks WriteMarks = &localWriteMarks{at: make(map[string]time.Time)}
+
+// SetWriteMarks shares sticky windows through m. Without it they live in
+// this instance's memory only.
+func SetWriteMarks(m WriteMarks) {
+	if m != nil {
+		writeMarks = m
+	}
+}
+
+// localWriteMarks holds when each caller last wrote, in this instance's
+// memory: a caller whose next request lands on another instance may still
+// read from a replica.
+type localWriteMarks struct {
+	sync.Mutex
+	at    map[string]time.Time
+	swept time.Time
+}
+
+func (l *localWriteMarks) MarkWrite(ctx context.Context, caller string, window time.Duration) error {
+	now := time.Now()
+
+	l.Lock()
+	defer l.Unlock()
+	l.at[caller] = now.Add(window)
+	// Forget expired windows now and then so the map doesn't grow forever
+	if now.Sub(l.swept) > time.Minute {
+		for c, until := range l.at {
+			if now.After(until) {
+				delete(l.at, c)
+			}
+		}
+		l.swept = now
+	}
+	return nil
+}
+
+func (l *localWriteMarks) WroteRecently(ctx context.Context, caller string) (bool, error) {
+	l.Lock()
+	until, ok := l.at[caller]
+	l.Unlock()
+	return ok && time.Now().Before(until), nil
+}
+
+// noteWrite starts the caller's sticky window. It runs after the write
+// committed, so it outlives a cancelled caller.
+func noteWrite(ctx context.Context) {
+	caller := callerFrom(ctx)
+	if caller == "" || len(replicas.pools) == 0 {
+		return
+	}
+	if err := writeMarks.MarkWrite(context.WithoutCancel(ctx), caller, replicas.sticky); err != nil {
+		log.Printf("⚠️ Failed to mark write by %s: %v", caller, err)
+	}
+}
+
+// wroteRecently reports whether the caller is inside their sticky window.
+// When that can't be told, the caller is sent to the primary.
+func wroteRecently(ctx context.Context) bool {
+	caller := callerFrom(ctx)
+	if caller == "" {
+		return false
+	}
+	recent, err := writeMarks.WroteRecently(ctx, caller)
+	if err != nil {
+		log.Printf("⚠️ Failed to check recent writes by %s: %v", caller, err)
+		return true
+	}
+	return recent
+}
+
+// readDB picks the pool for a read-only query: a replica, unless there are
+// none or the caller wrote within the sticky window.
+func readDB(ctx context.Context, primary *sql.DB) *sql.DB {
+	pools := replicas.pools
+	if len(pools) == 0 || ctx.Value(primaryKey{}) != nil || wroteRecently(ctx) {
+		return primary
+	}
+	return pools[replicas.next.Add(1)%uint64(len(pools))]
+}
+
+// beginWrite is beginOp for operations that change data. On success it
+// starts the caller's sticky window.
+func beginWrite(ctx context.Context) (context.Context, func(*error)) {
+	opCtx, end := beginOp(ctx, timeouts.Write)
+	return opCtx, func(err *error) {
+		end(err)
+		if *err == nil {
+			noteWrite(ctx)
+		}
+	}
+}
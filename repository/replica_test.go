@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+// stubMarks answers WroteRecently with a fixed result.
+type stubMarks struct {
+	recent bool
+	err    error
+}
+
+func (s stubMarks) MarkWrite(context.Context, string, time.Duration) error { return s.err }
+func (s stubMarks) WroteRecently(context.Context, string) (bool, error)    { return s.recent, s.err }
+
+func TestReadDB(t *testing.T) {
+	primary, replica := &sql.DB{}, &sql.DB{}
+	savedPools, savedMarks := replicas.pools, writeMarks
+	t.Cleanup(func() { replicas.pools, writeMarks = savedPools, savedMarks })
+
+	caller := WithCaller(context.Background(), "doc-1")
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		pools []*sql.DB
+		marks WriteMarks
+		want  *sql.DB
+	}{
+		{"no replicas", caller, nil, stubMarks{}, primary},
+		{"quiet caller", caller, []*sql.DB{replica}, stubMarks{}, replica},
+		{"caller just wrote", caller, []*sql.DB{replica}, stubMarks{recent: true}, primary},
+		{"marks unavailable", caller, []*sql.DB{replica}, stubMarks{err: errors.New("redis down")}, primary},
+		{"anonymous", context.Background(), []*sql.DB{replica}, stubMarks{recent: true}, replica},
+		{"primary requested", WithPrimary(caller), []*sql.DB{replica}, stubMarks{}, primary},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replicas.pools, writeMarks = tt.pools, tt.marks
+			if got := readDB(tt.ctx, primary); got != tt.want {
+				t.Errorf("readDB picked the wrong pool")
+			}
+		})
+	}
+}
+
+func TestLocalWriteMarks(t *testing.T) {
+	ctx := context.Background()
+	marks := &localWriteMarks{at: make(map[string]time.Time)}
+	if err := marks.MarkWrite(ctx, "doc-1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := marks.MarkWrite(ctx, "doc-2", -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for caller, want := range map[string]bool{"doc-1": true, "doc-2": false, "doc-3": false} {
+		if got, _ := marks.WroteRecently(ctx, caller); got != want {
+			t.Errorf("WroteRecently(%s) = %v, want %v", caller, got, want)
+		}
+	}
+}
@@ -0,0 +1,386 @@
+package repository
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"internal_chat_system/models"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	// Conversations of a location in archives that a cutoff can still
+	// change. Holds cover whole conversations (location, patient or their
+	// one session), so held ones are skipped without opening the archive.
+	querySelectArchivesToPurge = `
+		SELECT a.partition_name, a.object_key, c.user_id, c.contact_id
+		FROM message_archive_conversations c
+		JOIN message_archives a ON a.partition_name = c.partition_name
+		WHERE c.location_id = $1
+		AND NOT message_on_hold(c.location_id, c.contact_id, (
+			SELECT s.id FROM chat_sessions s
+			WHERE s.location_id = c.location_id AND s.user_id = c.user_id AND s.contact_id = c.contact_id
+		))
+		AND (
+			($2::timestamp IS NOT NULL AND a.range_start < $2
+				AND (c.purged_sent_before IS NULL OR c.purged_sent_before < LEAST($2, a.range_end)))
+			OR ($3::timestamp IS NOT NULL AND a.range_start < $3
+				AND (c.purged_deleted_before IS NULL OR c.purged_deleted_before < LEAST($3, a.archived_at)))
+		)
+		ORDER BY a.range_start
+	`
+
+	// Whether a hold was placed on any of the conversations since
+	// querySelectArchivesToPurge picked them
+	queryAnyConversationOnHold = `
+		SELECT EXISTS (
+			SELECT 1 FROM unnest($2::uuid[], $3::uuid[]) AS c(user_id, contact_id)
+			WHERE message_on_hold($1, c.contact_id, (
+				SELECT s.id FROM chat_sessions s
+				WHERE s.location_id = $1 AND s.user_id = c.user_id AND s.contact_id = c.contact_id
+			))
+		)
+	`
+
+	// Only swaps the object if no other purge replaced it meanwhile
+	querySwapArchiveObject = `
+		UPDATE message_archives SET object_key = $3, message_count = $4
+		WHERE partition_name = $1 AND object_key = $2
+	`
+
+	queryUpdateArchiveConversation = `
+		UPDATE message_archive_conversations
+		SET message_count = $5, min_seq = $6, max_seq = $7,
+			purged_sent_before = COALESCE($8, purged_sent_before),
+			purged_deleted_before = COALESCE($9, purged_deleted_before)
+		WHERE partition_name = $1 AND location_id = $2 AND user_id = $3 AND contact_id = $4
+	`
+
+	queryDeleteArchiveConversation = `
+		DELETE FROM message_archive_conversations
+		WHERE partition_name = $1 AND location_id = $2 AND user_id = $3 AND contact_id = $4
+	`
+)
+
+// archivePurge is one archive holding conversations a purge has to rewrite.
+type archivePurge struct {
+	partition, objectKey string
+	conversations        map[conversationKey]bool
+}
+
+// archiveRewrite is what rewriting an archive removed and what it left.
+type archiveRewrite struct {
+	count         int64
+	conversations map[conversationKey]*conversationStats
+	deleted       []uuid.UUID
+	anonymized    []uuid.UUID
+	files         []string
+	sessions      map[uuid.UUID]bool
+
+	reactions, revisions int64 // removed with the purged messages
+}
+
+// purgeArchives applies a policy's cutoffs to the location's archived
+// months. Each archive holding expired messages is rewritten to a new
+// object without them (or with them anonymized), swapped in, and the old
+// object removed. Conversations under legal hold are left as they are.
+func (r *RetentionRepo) purgeArchives(ctx context.Context, p models.RetentionPolicy, cutoff, deletedCutoff *time.Time, report *models.RetentionReport, sessions map[string]bool) error {
+	archives, err := r.archivesToPurge(ctx, p.LocationID, cutoff, deletedCutoff)
+	if err != nil || len(archives) == 0 {
+		return err
+	}
+	if r.Storage == nil {
+		return errors.New("archived messages are due for purging but no object storage is configured")
+	}
+
+	location := uuid.MustParse(p.LocationID)
+	for _, a := range archives {
+		if err := r.purgeArchive(ctx, p.Action, location, a, cutoff, deletedCutoff, report, sessions); err != nil {
+			log.Printf("❌ Failed to purge archive %s for location %s: %v", a.partition, p.LocationID, err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RetentionRepo) archivesToPurge(ctx context.Context, locationID string, cutoff, deletedCutoff *time.Time) (_ []archivePurge, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
+	rows, err := r.DB.QueryContext(ctx, querySelectArchivesToPurge, locationID, cutoff, deletedCutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	location := uuid.MustParse(locationID)
+	var archives []archivePurge
+	for rows.Next() {
+		var partition, objectKey string
+		conv := conversationKey{LocationID: location}
+		if err := rows.Scan(&partition, &objectKey, &conv.UserID, &conv.ContactID); err != nil {
+			return nil, err
+		}
+		if n := len(archives); n == 0 || archives[n-1].partition != partition {
+			archives = append(archives, archivePurge{partition: partition, objectKey: objectKey, conversations: map[conversationKey]bool{}})
+		}
+		archives[len(archives)-1].conversations[conv] = true
+	}
+	return archives, rows.Err()
+}
+
+// purgeArchive rewrites one archive. The new object is uploaded before
+// anything is committed, and only swapped in if the archive still points at
+// the object it was read from; otherwise it is dropped and the next run
+// tries again.
+func (r *RetentionRepo) purgeArchive(ctx context.Context, action string, location uuid.UUID, a archivePurge, cutoff, deletedCutoff *time.Time, report *models.RetentionReport, sessions map[string]bool) (err error) {
+	ctx, end := beginOp(ctx, timeouts.Archive)
+	defer end(&err)
+
+	now := time.Now()
+	newKey := fmt.Sprintf("%s%s.%d.jsonl.gz", archivePrefix, a.partition, now.UnixNano())
+	purge := func(m *archivedMessage) string {
+		conv := conversationKey{m.LocationID, m.SenderUserID, m.ReceiverContactID}
+		if m.LocationID != location || !a.conversations[conv] {
+			return ""
+		}
+		if deletedCutoff != nil && m.DeletedAt != nil && m.DeletedAt.Before(*deletedCutoff) {
+			return models.RetentionDelete
+		}
+		if cutoff == nil || !m.SentAt.Before(*cutoff) {
+			return ""
+		}
+		if action == models.RetentionAnonymize {
+			if m.AnonymizedAt != nil {
+				return ""
+			}
+			m.Content, m.FileURL, m.FileName, m.FileType = "", "", "", ""
+			m.AnonymizedAt = &now
+		}
+		return action
+	}
+
+	body, err := r.Storage.GetObject(ctx, a.objectKey)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	pr, pw := io.Pipe()
+	var rw archiveRewrite
+	rewritten := make(chan error, 1)
+	go func() {
+		var err error
+		rw, err = rewriteArchive(ctx, body, pw, purge)
+		pw.CloseWithError(err)
+		rewritten <- err
+	}()
+	uploadErr := r.Storage.PutObject(ctx, newKey, pr, "application/gzip")
+	pr.CloseWithError(io.ErrClosedPipe)
+	rewriteErr := <-rewritten
+	if uploadErr != nil {
+		return uploadErr
+	}
+	if rewriteErr != nil {
+		r.dropObject(ctx, newKey)
+		return rewriteErr
+	}
+
+	changed := len(rw.deleted)+len(rw.anonymized) > 0
+	swapped, err := r.commitArchivePurge(ctx, location, a, &rw, newKey, changed, cutoff, deletedCutoff)
+	if err != nil || !swapped {
+		// The new object isn't referenced by anything
+		r.dropObject(ctx, newKey)
+		return err
+	}
+	if !changed {
+		r.dropObject(ctx, newKey)
+		return nil
+	}
+	r.dropObject(ctx, a.objectKey)
+
+	report.MessagesDeleted += int64(len(rw.deleted))
+	report.MessagesAnonymized += int64(len(rw.anonymized))
+	report.ReactionsRemoved += rw.reactions
+	report.RevisionsRemoved += rw.revisions
+	report.FileURLs = append(report.FileURLs, rw.files...)
+	for id := range rw.sessions {
+		sessions[id.String()] = true
+	}
+	log.Printf("🧹 Purged %d and anonymized %d archived message(s) of location %s in %s",
+		len(rw.deleted), len(rw.anonymized), location, a.partition)
+	return nil
+}
+
+// commitArchivePurge swaps the rewritten object in when changed, updates
+// the archived conversations and removes what belonged to the purged
+// messages, counting them in rw. swapped is false if another purge replaced
+// the object first or one of its conversations went under a legal hold.
+func (r *RetentionRepo) commitArchivePurge(ctx context.Context, location uuid.UUID, a archivePurge, rw *archiveRewrite, newKey string, changed bool, cutoff, deletedCutoff *time.Time) (swapped bool, err error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// The archive was picked without a lock, so holds are checked again
+	// once placing them has to wait for this transaction
+	if _, err := tx.ExecContext(ctx, queryShareLocationHolds, location); err != nil {
+		return false, err
+	}
+	var users, contacts []uuid.UUID
+	for conv := range a.conversations {
+		users = append(users, conv.UserID)
+		contacts = append(contacts, conv.ContactID)
+	}
+	var held bool
+	if err := tx.QueryRowContext(ctx, queryAnyConversationOnHold, location, pq.Array(users), pq.Array(contacts)).Scan(&held); err != nil {
+		return false, err
+	}
+	if held {
+		log.Printf("⚖️ A legal hold was placed on archive %s during the purge, leaving it for the next run", a.partition)
+		return false, nil
+	}
+
+	if changed {
+		res, err := tx.ExecContext(ctx, querySwapArchiveObject, a.partition, a.objectKey, newKey, rw.count)
+		if err != nil {
+			return false, err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			log.Printf("⚠️ Archive %s was replaced during the purge, leaving it for the next run", a.partition)
+			return false, err
+		}
+	}
+
+	for conv := range a.conversations {
+		st := rw.conversations[conv]
+		if st == nil {
+			if _, err := tx.ExecContext(ctx, queryDeleteArchiveConversation, a.partition, location, conv.UserID, conv.ContactID); err != nil {
+				return false, err
+			}
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, queryUpdateArchiveConversation,
+			a.partition, location, conv.UserID, conv.ContactID, st.count, st.minSeq, st.maxSeq, cutoff, deletedCutoff); err != nil {
+			return false, err
+		}
+	}
+
+	ids := append(append([]uuid.UUID{}, rw.deleted...), rw.anonymized...)
+	if len(ids) > 0 {
+		var aggregateIDs []string
+		for _, id := range ids {
+			aggregateIDs = append(aggregateIDs, id.String())
+		}
+		var sessionIDs []uuid.UUID
+		for id := range rw.sessions {
+			sessionIDs = append(sessionIDs, id)
+		}
+		exec := func(q string, args ...any) (int64, error) {
+			res, err := tx.ExecContext(ctx, q, args...)
+			if err != nil {
+				return 0, err
+			}
+			return res.RowsAffected()
+		}
+		if rw.reactions, err = exec(queryPurgeReactions, pq.Array(ids)); err != nil {
+			return false, err
+		}
+		if rw.revisions, err = exec(queryPurgeRevisions, pq.Array(ids)); err != nil {
+			return false, err
+		}
+		if _, err := exec(queryScrubSessionEvents, pq.Array(sessionIDs), pq.Array(ids)); err != nil {
+			return false, err
+		}
+		if _, err := exec(queryPurgeOutbox, pq.Array(aggregateIDs)); err != nil {
+			return false, err
+		}
+		if _, err := exec(queryPurgeClientIDs, pq.Array(rw.deleted)); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+// rewriteArchive copies an archive from src to dst, dropping the messages
+// purge returns RetentionDelete for and keeping the ones it anonymized in
+// place.
+func rewriteArchive(ctx context.Context, src io.Reader, dst io.Writer, purge func(*archivedMessage) string) (archiveRewrite, error) {
+	rw := archiveRewrite{
+		conversations: make(map[conversationKey]*conversationStats),
+		sessions:      make(map[uuid.UUID]bool),
+	}
+	in, err := gzip.NewReader(src)
+	if err != nil {
+		return rw, err
+	}
+	defer in.Close()
+	out := gzip.NewWriter(dst)
+	enc := json.NewEncoder(out)
+
+	sc := bufio.NewScanner(in)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var m archivedMessage
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			return rw, err
+		}
+		fileURL := m.FileURL
+
+		action := purge(&m)
+		if action != "" {
+			if m.SessionID != uuid.Nil {
+				rw.sessions[m.SessionID] = true
+			}
+			if fileURL != "" {
+				// Sealed URLs are opened now; afterwards nothing holds them
+				if err := openFields(ctx, m.ID.String(), &fileURL); err != nil {
+					return rw, err
+				}
+				rw.files = append(rw.files, fileURL)
+			}
+		}
+		if action == models.RetentionDelete {
+			rw.deleted = append(rw.deleted, m.ID)
+			continue
+		}
+		if action == models.RetentionAnonymize {
+			rw.anonymized = append(rw.anonymized, m.ID)
+		}
+
+		if err := enc.Encode(m); err != nil {
+			return rw, err
+		}
+		rw.count++
+		key := conversationKey{m.LocationID, m.SenderUserID, m.ReceiverContactID}
+		st := rw.conversations[key]
+		if st == nil {
+			st = &conversationStats{minSeq: m.Seq, maxSeq: m.Seq}
+			rw.conversations[key] = st
+		}
+		st.count++
+		st.minSeq, st.maxSeq = min(st.minSeq, m.Seq), max(st.maxSeq, m.Seq)
+	}
+	if err := sc.Err(); err != nil {
+		return rw, err
+	}
+	return rw, out.Close()
+}
+
+// dropObject removes an archive object nothing points at any more. A
+// failure only leaves an orphan behind, so it is logged.
+func (r *RetentionRepo) dropObject(ctx context.Context, key string) {
+	if err := r.Storage.DeleteObject(ctx, key); err != nil {
+		log.Printf("⚠️ Failed to remove archive object %s: %v", key, err)
+	}
+}
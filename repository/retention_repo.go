@@ -0,0 +1,451 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"internal_chat_system/models"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// purgeBatchSize bounds how many messages one purge transaction removes, so
+// a large backlog never holds locks for long.
+const purgeBatchSize = 500
+
+var ErrNoRetentionPolicy = errors.New("no retention policy for location")
+
+const (
+	retentionPolicyColumns = `location_id, action, max_age_days, deleted_max_age_days,
+		COALESCE(updated_by::text, ''), updated_at`
+
+	queryGetRetentionPolicy = `
+		SELECT ` + retentionPolicyColumns + `
+		FROM retention_policies WHERE location_id = $1
+	`
+
+	queryListRetentionPolicies = `
+		SELECT ` + retentionPolicyColumns + `
+		FROM retention_policies ORDER BY location_id
+	`
+
+	// A new policy may reach archived messages the old one left, so the
+	// cutoffs recorded for the location's archives are cleared
+	queryUpsertRetentionPolicy = `
+		WITH reset AS (
+			UPDATE message_archive_conversations
+			SET purged_sent_before = NULL, purged_deleted_before = NULL
+			WHERE location_id = $1
+		)
+		INSERT INTO retention_policies (location_id, action, max_age_days, deleted_max_age_days, updated_by)
+		VALUES ($1, $2, $3, $4, NULLIF($5, '')::uuid)
+		ON CONFLICT (location_id) DO UPDATE
+		SET action = EXCLUDED.action,
+			max_age_days = EXCLUDED.max_age_days,
+			deleted_max_age_days = EXCLUDED.deleted_max_age_days,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = now()
+		RETURNING updated_at
+	`
+
+	// Soft-deleted messages past the policy's window are always hard-deleted.
+	// Messages under legal hold are never picked, nor are their attachments.
+	querySelectPurgeDeleted = `
+		SELECT id, session_id, COALESCE(file_url, ''), deleted_at IS NOT NULL
+		FROM messages
+		WHERE location_id = $1 AND deleted_at IS NOT NULL AND deleted_at < $2
+		AND NOT message_on_hold(location_id, receiver_contact_id, session_id)
+		ORDER BY deleted_at
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED
+	`
+
+	querySelectPurgeExpired = `
+		SELECT id, session_id, COALESCE(file_url, ''), deleted_at IS NOT NULL
+		FROM messages
+		WHERE location_id = $1 AND sent_at < $2
+		AND NOT message_on_hold(location_id, receiver_contact_id, session_id)
+		ORDER BY sent_at
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED
+	`
+
+	// Anonymized messages stay behind, so they must not be picked again
+	querySelectAnonymizeExpired = `
+		SELECT id, session_id, COALESCE(file_url, ''), deleted_at IS NOT NULL
+		FROM messages
+		WHERE location_id = $1 AND sent_at < $2 AND anonymized_at IS NULL
+		AND NOT message_on_hold(location_id, receiver_contact_id, session_id)
+		ORDER BY sent_at
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED
+	`
+
+	queryPurgeReactions = `DELETE FROM message_reactions WHERE message_id = ANY($1)`
+	queryPurgeRevisions = `DELETE FROM message_revisions WHERE message_id = ANY($1)`
+	queryPurgeClientIDs = `DELETE FROM message_client_ids WHERE message_id = ANY($1)`
+	queryPurgeOutbox    = `DELETE FROM outbox WHERE aggregate_id = ANY($1)`
+
+	// Events keep their seq so replay has no gaps, but lose what they said
+	queryScrubSessionEvents = `
+		UPDATE session_events SET data = NULL
+		WHERE session_id = ANY($1) AND message_id = ANY($2) AND data IS NOT NULL
+	`
+
+	queryPurgeMessages = `DELETE FROM messages WHERE id = ANY($1)`
+
+	queryAnonymizeMessages = `
+		UPDATE messages
+		SET content = '', file_url = NULL, file_name = NULL, file_type = NULL, content_tokens = NULL,
+			anonymized_at = now()
+		WHERE id = ANY($1)
+	`
+
+	queryInsertRetentionReport = `
+		INSERT INTO retention_reports (
+			location_id, action, cutoff, deleted_cutoff,
+			messages_deleted, messages_anonymized, reactions_removed, revisions_removed,
+			attachments_removed, failed_attachments, sessions_affected, error,
+			started_at, finished_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NULLIF($12, ''), $13, $14)
+		RETURNING id
+	`
+
+	queryListRetentionReports = `
+		SELECT id, location_id, action, cutoff, deleted_cutoff,
+			messages_deleted, messages_anonymized, reactions_removed, revisions_removed,
+			attachments_removed, failed_attachments, sessions_affected, COALESCE(error, ''),
+			started_at, finished_at
+		FROM retention_reports
+		WHERE location_id = $1
+		ORDER BY started_at DESC
+		LIMIT $2
+	`
+)
+
+type RetentionRepo struct {
+	DB *sql.DB
+	// Storage holds archived months, which purges rewrite. Required once
+	// a location with a policy has archives.
+	Storage ObjectStorage
+}
+
+func NewRetentionRepo(db *sql.DB) *RetentionRepo {
+	return &RetentionRepo{DB: db}
+}
+
+func scanRetentionPolicy(row rowScanner) (models.RetentionPolicy, error) {
+	var p models.RetentionPolicy
+	var maxAge, deletedMaxAge sql.NullInt64
+	err := row.Scan(&p.LocationID, &p.Action, &maxAge, &deletedMaxAge, &p.UpdatedBy, &p.UpdatedAt)
+	if maxAge.Valid {
+		days := int(maxAge.Int64)
+		p.MaxAgeDays = &days
+	}
+	if deletedMaxAge.Valid {
+		days := int(deletedMaxAge.Int64)
+		p.DeletedMaxAgeDays = &days
+	}
+	return p, err
+}
+
+// GetRetentionPolicy returns ErrNoRetentionPolicy for locations that keep
+// everything.
+func (r *RetentionRepo) GetRetentionPolicy(ctx context.Context, locationID string) (_ models.RetentionPolicy, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
+	p, err := scanRetentionPolicy(r.DB.QueryRowContext(ctx, queryGetRetentionPolicy, locationID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.RetentionPolicy{}, ErrNoRetentionPolicy
+	}
+	return p, err
+}
+
+func (r *RetentionRepo) ListRetentionPolicies(ctx context.Context) (_ []models.RetentionPolicy, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
+	rows, err := r.DB.QueryContext(ctx, queryListRetentionPolicies)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []models.RetentionPolicy{}
+	for rows.Next() {
+		p, err := scanRetentionPolicy(rows)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+	return policies, rows.Err()
+}
+
+// UpsertRetentionPolicy creates or replaces a location's policy and sets
+// p.UpdatedAt. The change is audited with the policy if ctx came from
+// WithAudit.
+func (r *RetentionRepo) UpsertRetentionPolicy(ctx context.Context, p *models.RetentionPolicy) (err error) {
+	ctx, end := beginWrite(ctx)
+	defer end(&err)
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, queryUpsertRetentionPolicy,
+		p.LocationID, p.Action, p.MaxAgeDays, p.DeletedMaxAgeDays, p.UpdatedBy,
+	).Scan(&p.UpdatedAt)
+	if err == nil {
+		err = auditChange(ctx, tx, p.LocationID, "retention_policy", p.LocationID, p)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("❌ Failed to save retention policy of location %s: %v", p.LocationID, err)
+	}
+	return err
+}
+
+// PurgeExpiredMessages applies a policy as of now, in batches, and then to
+// the location's archived months (see purgeArchives). Reactions,
+// revisions, event payloads and queued deliveries of purged messages go
+// with them; session summaries are refreshed. Each message clients still
+// showed gets a message_deleted or message_anonymized event. The report counts what was
+// purged and lists the attachments and sessions touched, which the caller
+// removes from storage and caches. Batches that committed before an error
+// stay purged and are counted.
+func (r *RetentionRepo) PurgeExpiredMessages(ctx context.Context, p models.RetentionPolicy, now time.Time) (models.RetentionReport, error) {
+	report := models.RetentionReport{
+		LocationID:        p.LocationID,
+		Action:            p.Action,
+		StartedAt:         now,
+		FailedAttachments: []string{},
+	}
+	sessions := make(map[string]bool)
+
+	run := func(query, action string, cutoff time.Time) error {
+		for {
+			n, err := r.purgeBatch(ctx, query, action, p.LocationID, cutoff, &report, sessions)
+			if err != nil {
+				return err
+			}
+			if n < purgeBatchSize {
+				return nil
+			}
+		}
+	}
+
+	var err error
+	if p.DeletedMaxAgeDays != nil {
+		cutoff := now.AddDate(0, 0, -*p.DeletedMaxAgeDays)
+		report.DeletedCutoff = &cutoff
+		err = run(querySelectPurgeDeleted, models.RetentionDelete, cutoff)
+	}
+	if err == nil && p.MaxAgeDays != nil {
+		cutoff := now.AddDate(0, 0, -*p.MaxAgeDays)
+		report.Cutoff = &cutoff
+		query := querySelectPurgeExpired
+		if p.Action == models.RetentionAnonymize {
+			query = querySelectAnonymizeExpired
+		}
+		err = run(query, p.Action, cutoff)
+	}
+	if err == nil {
+		err = r.purgeArchives(ctx, p, report.Cutoff, report.DeletedCutoff, &report, sessions)
+	}
+
+	for id := range sessions {
+		report.SessionIDs = append(report.SessionIDs, id)
+	}
+	report.SessionsAffected = int64(len(sessions))
+	if err != nil {
+		log.Printf("❌ Retention purge failed in location %s: %v", p.LocationID, err)
+		report.Error = err.Error()
+	}
+	return report, err
+}
+
+// purgeBatch deletes or anonymizes up to purgeBatchSize messages picked by
+// query in one transaction and adds them to report.
+func (r *RetentionRepo) purgeBatch(ctx context.Context, query, action, locationID string, cutoff time.Time, report *models.RetentionReport, sessions map[string]bool) (_ int, err error) {
+	ctx, end := beginOp(ctx, timeouts.Purge)
+	defer end(&err)
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Holds placed from here on wait for this batch; the select sees the rest
+	if _, err := tx.ExecContext(ctx, queryShareLocationHolds, locationID); err != nil {
+		return 0, err
+	}
+	rows, err := tx.QueryContext(ctx, query, locationID, cutoff, purgeBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	// Messages clients still show; the rest were deleted and already announced
+	type visible struct {
+		id        uuid.UUID
+		sessionID *uuid.UUID
+	}
+	var ids []uuid.UUID
+	var shown []visible
+	var aggregateIDs, files []string
+	batchSessions := make(map[uuid.UUID]bool)
+	for rows.Next() {
+		var id uuid.UUID
+		var sessionID *uuid.UUID
+		var fileURL string
+		var deleted bool
+		if err := rows.Scan(&id, &sessionID, &fileURL, &deleted); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+		if !deleted {
+			shown = append(shown, visible{id, sessionID})
+		}
+		aggregateIDs = append(aggregateIDs, id.String())
+		if sessionID != nil {
+			batchSessions[*sessionID] = true
+		}
+		if fileURL != "" {
+			// Sealed URLs are opened now; after this batch there is no
+			// row left to open them with
+			if err := openFields(ctx, id.String(), &fileURL); err != nil {
+				rows.Close()
+				return 0, err
+			}
+			files = append(files, fileURL)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	var sessionIDs []uuid.UUID
+	for id := range batchSessions {
+		sessionIDs = append(sessionIDs, id)
+	}
+
+	exec := func(q string, args ...any) (int64, error) {
+		res, err := tx.ExecContext(ctx, q, args...)
+		if err != nil {
+			return 0, err
+		}
+		return res.RowsAffected()
+	}
+	reactions, err := exec(queryPurgeReactions, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+	revisions, err := exec(queryPurgeRevisions, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+	if _, err := exec(queryScrubSessionEvents, pq.Array(sessionIDs), pq.Array(ids)); err != nil {
+		return 0, err
+	}
+	if _, err := exec(queryPurgeOutbox, pq.Array(aggregateIDs)); err != nil {
+		return 0, err
+	}
+
+	var messages int64
+	if action == models.RetentionAnonymize {
+		messages, err = exec(queryAnonymizeMessages, pq.Array(ids))
+	} else {
+		if _, err = exec(queryPurgeClientIDs, pq.Array(ids)); err == nil {
+			messages, err = exec(queryPurgeMessages, pq.Array(ids))
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Synced clients learn that the message went or lost its content
+	event := models.EventMessageDeleted
+	if action == models.RetentionAnonymize {
+		event = models.EventMessageAnonymized
+	}
+	for _, m := range shown {
+		if _, err := appendSessionEvent(ctx, tx, m.sessionID, event, &m.id, nil); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, id := range sessionIDs {
+		if err := refreshSessionSummary(ctx, tx, &id); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	if action == models.RetentionAnonymize {
+		report.MessagesAnonymized += messages
+	} else {
+		report.MessagesDeleted += messages
+	}
+	report.ReactionsRemoved += reactions
+	report.RevisionsRemoved += revisions
+	report.FileURLs = append(report.FileURLs, files...)
+	for _, id := range sessionIDs {
+		sessions[id.String()] = true
+	}
+	return len(ids), nil
+}
+
+// SaveRetentionReport stores a finished run and sets report.ID.
+func (r *RetentionRepo) SaveRetentionReport(ctx context.Context, report *models.RetentionReport) (err error) {
+	ctx, end := beginWrite(ctx)
+	defer end(&err)
+
+	return r.DB.QueryRowContext(ctx, queryInsertRetentionReport,
+		report.LocationID, report.Action, report.Cutoff, report.DeletedCutoff,
+		report.MessagesDeleted, report.MessagesAnonymized, report.ReactionsRemoved, report.RevisionsRemoved,
+		report.AttachmentsRemoved, pq.Array(report.FailedAttachments), report.SessionsAffected, report.Error,
+		report.StartedAt, report.FinishedAt,
+	).Scan(&report.ID)
+}
+
+// ListRetentionReports returns a location's newest reports first.
+func (r *RetentionRepo) ListRetentionReports(ctx context.Context, locationID string, limit int) (_ []models.RetentionReport, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
+	rows, err := r.DB.QueryContext(ctx, queryListRetentionReports, locationID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []models.RetentionReport{}
+	for rows.Next() {
+		var rep models.RetentionReport
+		err := rows.Scan(&rep.ID, &rep.LocationID, &rep.Action, &rep.Cutoff, &rep.DeletedCutoff,
+			&rep.MessagesDeleted, &rep.MessagesAnonymized, &rep.ReactionsRemoved, &rep.RevisionsRemoved,
+			&rep.AttachmentsRemoved, pq.Array(&rep.FailedAttachments), &rep.SessionsAffected, &rep.Error,
+			&rep.StartedAt, &rep.FinishedAt)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, rep)
+	}
+	return reports, rows.Err()
+}
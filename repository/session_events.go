@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+
+	"internal_chat_system/models"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Bumping the counter row-locks the session, so concurrent writers from
+	// any instance get consecutive numbers in commit order. Every event
+	// counts as activity in the session.
+	queryNextSessionSeq = `
+		UPDATE chat_sessions SET last_seq = last_seq + 1, last_activity_at = now()
+		WHERE id = $1
+		RETURNING last_seq, location_id, user_id, contact_id
+	`
+
+	queryInsertSessionEvent = `
+		INSERT INTO session_events (session_id, seq, event_type, message_id, data)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING created_at
+	`
+
+	querySelectSessionEvents = `
+		SELECT session_id, seq, event_type, COALESCE(message_id::text, ''), data, created_at
+		FROM session_events
+		WHERE session_id = $1 AND seq > $2
+		ORDER BY seq ASC
+		LIMIT $3
+	`
+
+	queryGetSessionLastSeq = `SELECT last_seq FROM chat_sessions WHERE id = $1`
+)
+
+// appendSessionEvent numbers a change within tx, and audits it there if ctx
+// came from WithAudit. Messages saved before sessions existed have no
+// session and get no event; the zero event is returned for them.
+func appendSessionEvent(ctx context.Context, tx *sql.Tx, sessionID *uuid.UUID, eventType string, messageID *uuid.UUID, data any) (models.SessionEvent, error) {
+	if sessionID == nil {
+		return models.SessionEvent{}, nil
+	}
+
+	ev := models.SessionEvent{Type: eventType, SessionID: sessionID.String()}
+	if messageID != nil {
+		ev.MessageID = messageID.String()
+	}
+	var raw any
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return ev, err
+		}
+		ev.Data, raw = b, b
+	}
+
+	if err := tx.QueryRowContext(ctx, queryNextSessionSeq, *sessionID).Scan(&ev.Seq, &ev.LocationID, &ev.UserID, &ev.ContactID); err != nil {
+		log.Printf("❌ Failed to assign seq in session %s: %v", sessionID, err)
+		return ev, err
+	}
+	if err := tx.QueryRowContext(ctx, queryInsertSessionEvent, *sessionID, ev.Seq, eventType, messageID, raw).Scan(&ev.CreatedAt); err != nil {
+		log.Printf("❌ Failed to record %s event in session %s: %v", eventType, sessionID, err)
+		return ev, err
+	}
+	if e, ok := AuditForEvent(ctx, ev); ok {
+		if err := queueAudit(ctx, tx, &e); err != nil {
+			return ev, err
+		}
+	}
+	return ev, nil
+}
+
+// GetSessionEvents replays a session's events after afterSeq, oldest first.
+// message_created events carry the message as it is now.
+func (r *MessageRepo) GetSessionEvents(ctx context.Context, sessionID string, afterSeq int64, limit int) (_ models.SessionEventPage, err error) {
+	ctx, end := beginOp(ctx, timeouts.Read)
+	defer end(&err)
+
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	page := models.SessionEventPage{Events: []models.SessionEvent{}}
+	if err := r.DB.QueryRowContext(ctx, queryGetSessionLastSeq, sessionID).Scan(&page.LastSeq); err != nil {
+		log.Printf("❌ Failed to read last seq of session %s: %v", sessionID, err)
+		return page, err
+	}
+
+	rows, err := r.DB.QueryContext(ctx, querySelectSessionEvents, sessionID, afterSeq, limit+1)
+	if err != nil {
+		log.Printf("❌ Failed to replay session %s: %v", sessionID, err)
+		return page, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ev models.SessionEvent
+		var data []byte
+		if err := rows.Scan(&ev.SessionID, &ev.Seq, &ev.Type, &ev.MessageID, &data, &ev.CreatedAt); err != nil {
+			log.Printf("❌ Failed to scan session event: %v", err)
+			return page, err
+		}
+		if len(data) > 0 {
+			ev.Data = data
+		}
+		if err := openEventData(ctx, &ev); err != nil {
+			return page, err
+		}
+		page.Events = append(page.Events, ev)
+	}
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+
+	if len(page.Events) > limit {
+		page.Events = page.Events[:limit]
+		page.HasMore = true
+	}
+
+	for i, ev := range page.Events {
+		if ev.Type != models.EventMessageCreated {
+			continue
+		}
+		msg, err := r.GetMessageByID(ctx, ev.MessageID)
+		if errors.Is(err, ErrMessageNotFound) {
+			continue
+		}
+		if err != nil {
+			return page, err
+		}
+		page.Events[i].Message = &msg
+	}
+	return page, nil
+}
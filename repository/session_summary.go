@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log"
+
+	"github.com/google/uuid"
+)
+
+// sessionPreviewLength caps the last message preview kept on a session.
+const sessionPreviewLength = 200
+
+// queryRefreshSessionSummary recomputes a session's inbox summary from its
+// messages. The last message is found through (session_id, seq) and the
+// counts through the partial unread index, so this stays cheap however long
+// the conversation is. File messages without text preview as the file name.
+// Sealed previews are kept whole and shortened once opened.
+const queryRefreshSessionSummary = `
+	UPDATE chat_sessions SET
+		(last_message_id, last_message_preview, last_sender_id, last_sender_type) = (
+			SELECT id,
+				CASE WHEN char_length(p) > $2 AND p NOT LIKE 'enc:1:%' THEN LEFT(p, $2) || '…' ELSE p END,
+				COALESCE(sender_contact_id, sender_user_id),
+				CASE WHEN sender_contact_id IS NOT NULL THEN 'contact' ELSE 'user' END
+			FROM (
+				SELECT id, sender_contact_id, sender_user_id,
+					COALESCE(NULLIF(content, ''), file_name, '') AS p
+				FROM messages
+				WHERE session_id = $1 AND deleted_at IS NULL
+				ORDER BY seq DESC
+				LIMIT 1
+			) last
+		),
+		user_unread_count = (
+			SELECT COUNT(*) FROM messages
+			WHERE session_id = $1 AND is_read = false AND deleted_at IS NULL
+			AND receiver_user_id IS NOT NULL
+		),
+		contact_unread_count = (
+			SELECT COUNT(*) FROM messages
+			WHERE session_id = $1 AND is_read = false AND deleted_at IS NULL
+			AND receiver_user_id IS NULL
+		)
+	WHERE id = $1
+`
+
+// refreshSessionSummary brings the summary of a session up to date within
+// tx. Every write that changes a session's last message or unread counts
+// calls it before committing. Messages without a session are skipped.
+func refreshSessionSummary(ctx context.Context, tx *sql.Tx, sessionID *uuid.UUID) error {
+	if sessionID == nil {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, queryRefreshSessionSummary, *sessionID, sessionPreviewLength); err != nil {
+		log.Printf("❌ Failed to refresh summary of session %s: %v", sessionID, err)
+		return err
+	}
+	return nil
+}
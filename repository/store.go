@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"internal_chat_system/encryption"
+	"internal_chat_system/models"
+
+	"github.com/google/uuid"
+)
+
+// The interfaces below are what handlers depend on. The PostgreSQL repos in
+// this package implement them, and package memory provides an in-memory
+// implementation with the same semantics for tests and local runs.
+
+// MessageStore stores messages, their revisions and the numbered session
+// events every change produces.
+type MessageStore interface {
+	// SaveMessage creates or touches the session, assigns the next seq and
+	// queues delivery side effects, all atomically. It sets msg.SessionID and
+	// msg.Seq, and returns ErrDuplicateClientMessage when the sender already
+	// used msg.ClientMessageID.
+	SaveMessage(ctx context.Context, msg *models.Message) error
+	GetMessageByID(ctx context.Context, id string) (models.DBMessage, error)
+	GetMessageByClientID(ctx context.Context, senderID, clientMessageID string) (models.DBMessage, error)
+	GetConversation(ctx context.Context, locationID, contactID, userID string, page models.PageRequest) (models.HistoryPage, error)
+	GetThread(ctx context.Context, rootID string, after string, limit int) (models.ThreadPage, error)
+	SearchMessages(ctx context.Context, f models.SearchFilter) ([]models.SearchResult, error)
+
+	UpdateMessageContent(ctx context.Context, msgID, editorID, newContent string) (time.Time, models.SessionEvent, error)
+	GetMessageRevisions(ctx context.Context, msgID string) ([]models.MessageRevision, error)
+	DeleteMessage(ctx context.Context, id uuid.UUID) (models.SessionEvent, error)
+	AdminDeleteMessages(ctx context.Context, ids []uuid.UUID) ([]models.SessionEvent, error)
+	MarkMessagesRead(ctx context.Context, ids []string) ([]models.SessionEvent, error)
+	MarkMessagesDelivered(ctx context.Context, ids []uuid.UUID) error
+
+	GetSessionEvents(ctx context.Context, sessionID string, afterSeq int64, limit int) (models.SessionEventPage, error)
+	Sync(ctx context.Context, userID, contactID, locationID, token string, limit int) (models.SyncResponse, error)
+}
+
+// SessionStore manages doctor/patient chat sessions.
+type SessionStore interface {
+	GetOrCreateSession(ctx context.Context, contactID, userID, locationID string) (string, error)
+	GetSessionByID(ctx context.Context, id string) (models.ChatSession, error)
+	SessionExists(ctx context.Context, contactID, userID, locationID string) (bool, error)
+	// SessionCounterparts reports which of ids share a session with the
+	// doctor (userID) or, if userID is empty, the patient (contactID).
+	SessionCounterparts(ctx context.Context, userID, contactID, locationID string, ids []string) (map[string]bool, error)
+	ListEnrichedChatSessionsWithFilter(ctx context.Context, userID, contactID, locationID string, limit, offset int) ([]models.ChatSessionResponse, error)
+	AdminListAllSessions(ctx context.Context, locationID string, limit, offset int) ([]models.ChatSessionResponse, error)
+}
+
+// ReactionStore adds and removes emoji reactions. Changes that do nothing
+// (adding an existing reaction, removing a missing one) return the zero event.
+type ReactionStore interface {
+	AddReaction(ctx context.Context, msgID, userID, emoji string) (models.SessionEvent, error)
+	RemoveReaction(ctx context.Context, msgID, userID, emoji string) (models.SessionEvent, error)
+	GetReactions(ctx context.Context, msgID string) ([]models.MessageReaction, error)
+}
+
+// PinStore pins messages within their session.
+type PinStore interface {
+	TogglePinMessage(ctx context.Context, msgID string, pin bool) (models.SessionEvent, error)
+	GetPinnedMessages(ctx context.Context, sessionID string) ([]models.PinnedMessage, error)
+}
+
+// DeviceTokenStore keeps one push token per user or contact.
+type DeviceTokenStore interface {
+	GetDeviceToken(ctx context.Context, userID string) (string, error)
+	UpsertDeviceToken(ctx context.Context, userID, token string) error
+}
+
+// OfficeHoursStore keeps office hour schedules and holidays.
+type OfficeHoursStore interface {
+	GetEffectiveOfficeHours(ctx context.Context, locationID, userID string) (*models.OfficeHours, error)
+	GetEffectiveOfficeHoursForUsers(ctx context.Context, locationID string, userIDs []string) (map[string]*models.OfficeHours, error)
+	UpsertOfficeHours(ctx context.Context, s *models.OfficeHours) error
+	AddOfficeHoliday(ctx context.Context, h *models.OfficeHoliday) error
+	GetOfficeHoliday(ctx context.Context, id, locationID string) (models.OfficeHoliday, error)
+	DeleteOfficeHoliday(ctx context.Context, id, locationID string) error
+}
+
+// AuditStore keeps the append-only, hash-chained log of who read or
+// changed chat data.
+type AuditStore interface {
+	Record(ctx context.Context, e *models.AuditEntry) error
+	ListAuditEntries(ctx context.Context, f models.AuditFilter) ([]models.AuditEntry, error)
+	VerifyAuditChain(ctx context.Context, locationID string) (models.AuditChainStatus, error)
+}
+
+// OutboxStore hands queued side effects to the outbox relay.
+type OutboxStore interface {
+	Dispatch(ctx context.Context, batch int, handle func(context.Context, models.OutboxEvent) error) (int, error)
+}
+
+// RetentionStore keeps per-location retention policies and carries out the
+// purges they call for. GetRetentionPolicy returns ErrNoRetentionPolicy
+// for locations that keep everything.
+type RetentionStore interface {
+	GetRetentionPolicy(ctx context.Context, locationID string) (models.RetentionPolicy, error)
+	UpsertRetentionPolicy(ctx context.Context, p *models.RetentionPolicy) error
+	ListRetentionPolicies(ctx context.Context) ([]models.RetentionPolicy, error)
+	PurgeExpiredMessages(ctx context.Context, p models.RetentionPolicy, now time.Time) (models.RetentionReport, error)
+	SaveRetentionReport(ctx context.Context, r *models.RetentionReport) error
+	ListRetentionReports(ctx context.Context, locationID string, limit int) ([]models.RetentionReport, error)
+}
+
+// LegalHoldStore places and releases legal holds. While a hold is active,
+// the message stores refuse to edit or delete the messages it covers with
+// ErrLegalHold, and retention purges skip them.
+type LegalHoldStore interface {
+	PlaceLegalHold(ctx context.Context, h *models.LegalHold) error
+	ReleaseLegalHold(ctx context.Context, id, actorID, reason string) (models.LegalHold, error)
+	ListLegalHolds(ctx context.Context, locationID string, activeOnly bool) ([]models.LegalHold, error)
+}
+
+// EncryptionStore manages the data keys that seal message content at rest
+// and moves messages onto a location's active key. Rotating, rewrapping and
+// re-encrypting return ErrEncryptionDisabled when no keyring is set.
+type EncryptionStore interface {
+	GetEncryptionStatus(ctx context.Context, locationID string) (models.EncryptionStatus, error)
+	RotateEncryptionKey(ctx context.Context, locationID string) (models.EncryptionKey, error)
+	ReencryptMessages(ctx context.Context, locationID string, limit int) (models.ReencryptReport, error)
+	RewrapEncryptionKeys(ctx context.Context) (int, error)
+}
+
+// ArchiveStore manages the monthly messages partitions for the archiver.
+type ArchiveStore interface {
+	EnsureMessagePartitions(ctx context.Context, from time.Time, monthsAhead int) error
+	ListMessagePartitions(ctx context.Context) ([]string, error)
+	ArchiveMessagePartition(ctx context.Context, name string) (models.MessageArchive, error)
+}
+
+var (
+	_ MessageStore     = (*MessageRepo)(nil)
+	_ ReactionStore    = (*MessageRepo)(nil)
+	_ PinStore         = (*MessageRepo)(nil)
+	_ SessionStore     = (*ChatSessionRepo)(nil)
+	_ DeviceTokenStore = (*DeviceTokenRepo)(nil)
+	_ OfficeHoursStore = (*OfficeHoursRepo)(nil)
+	_ AuditStore       = (*AuditRepo)(nil)
+	_ OutboxStore      = (*OutboxRepo)(nil)
+	_ RetentionStore   = (*RetentionRepo)(nil)
+	_ LegalHoldStore   = (*LegalHoldRepo)(nil)
+	_ EncryptionStore  = (*EncryptionRepo)(nil)
+	_ ArchiveStore     = (*ArchiveRepo)(nil)
+
+	_ encryption.KeyStore = (*EncryptionRepo)(nil)
+)
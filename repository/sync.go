@@ -0,0 +1,303 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+
+	"internal_chat_system/models"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultSyncEvents = 500
+	maxSyncEvents     = 1000
+)
+
+var ErrInvalidSyncToken = errors.New("invalid sync token")
+
+const (
+	// The oldest transaction still running when the sync starts. Every
+	// event written by an older transaction has committed (or never will),
+	// so nothing below this mark can still appear.
+	querySelectSyncHorizon = `SELECT pg_snapshot_xmin(pg_current_snapshot())::text::bigint`
+
+	// Doctors sync by user_id, patients by contact_id
+	querySelectSyncEvents = `
+		SELECT e.event_id, e.session_id, e.seq, e.event_type, COALESCE(e.message_id::text, ''), e.data, e.created_at
+		FROM session_events e
+		JOIN chat_sessions s ON s.id = e.session_id
+		WHERE (($1 <> '' AND s.user_id::text = $1) OR ($2 <> '' AND s.contact_id::text = $2))
+		AND ($3 = '' OR s.location_id::text = $3)
+		AND e.txid >= $4 AND e.txid < $5 AND e.event_id > $6
+		ORDER BY e.event_id
+		LIMIT $7
+	`
+
+	querySelectSyncSessions = `
+		SELECT id, contact_id, user_id, location_id, started_at, last_message_at
+		FROM chat_sessions
+		WHERE id = ANY($1)
+		ORDER BY id
+	`
+
+	querySelectMessagesByIDs = `
+		SELECT ` + messageColumns + `
+		FROM messages
+		WHERE id = ANY($1) AND deleted_at IS NULL
+		ORDER BY session_id, seq
+	`
+)
+
+// SyncMark is the position a sync token records. Events written by
+// transactions in [From, To) with an event id above After are still to be
+// read; To is zero once that window is done, and the next sync opens a new
+// one from From. Stores that commit events in order can keep To at zero
+// and use From as their own position.
+type SyncMark struct {
+	LocationID string `json:"l,omitempty"`
+	From       int64  `json:"f"`
+	To         int64  `json:"t,omitempty"`
+	After      int64  `json:"a,omitempty"`
+}
+
+// syncToken is a SyncMark with a version. Version 1 tokens held a seq per
+// session; they are still accepted and restart the sync from scratch,
+// which is safe since messages are sent as they are now.
+type syncToken struct {
+	Version int `json:"v"`
+	SyncMark
+}
+
+// EncodeSyncToken returns the sync token for mark.
+func EncodeSyncToken(mark SyncMark) string {
+	raw, _ := json.Marshal(syncToken{Version: 2, SyncMark: mark})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// DecodeSyncToken parses a token made by EncodeSyncToken for a sync of
+// locationID. An empty token is the start of history. Tokens are only good
+// for the location filter they were made with, since the mark skips over
+// events outside it.
+func DecodeSyncToken(s, locationID string) (SyncMark, error) {
+	if s == "" {
+		return SyncMark{LocationID: locationID}, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return SyncMark{}, ErrInvalidSyncToken
+	}
+	var t syncToken
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return SyncMark{}, ErrInvalidSyncToken
+	}
+	switch {
+	case t.Version == 1:
+		return SyncMark{LocationID: locationID}, nil
+	case t.Version != 2 || t.LocationID != locationID || t.From < 0 || t.After < 0:
+		return SyncMark{}, ErrInvalidSyncToken
+	}
+	return t.SyncMark, nil
+}
+
+// Sync returns every change in the sessions of a doctor (userID) or patient
+// (contactID) since token, optionally limited to one location. An empty
+// token syncs everything. At most limit events are read per call.
+func (r *MessageRepo) Sync(ctx context.Context, userID, contactID, locationID, token string, limit int) (_ models.SyncResponse, err error) {
+	ctx, end := beginOp(ctx, timeouts.Search)
+	defer end(&err)
+
+	log.Printf("🔄 Sync for user=%s contact=%s location=%s", userID, contactID, locationID)
+
+	resp := models.SyncResponse{
+		Messages:          []models.DBMessage{},
+		DeletedMessageIDs: []string{},
+		Reactions:         []models.ReactionChange{},
+		Sessions:          []models.SyncSession{},
+	}
+
+	mark, err := DecodeSyncToken(token, locationID)
+	if err != nil {
+		return resp, err
+	}
+	if limit <= 0 {
+		limit = defaultSyncEvents
+	}
+	if limit > maxSyncEvents {
+		limit = maxSyncEvents
+	}
+
+	if mark.To == 0 {
+		if err := r.DB.QueryRowContext(ctx, querySelectSyncHorizon).Scan(&mark.To); err != nil {
+			log.Printf("❌ Failed to read sync horizon: %v", err)
+			return resp, err
+		}
+		mark.After = 0
+	}
+
+	lastSeqs, lastID, err := r.collectSyncEvents(ctx, &resp, userID, contactID, mark, limit)
+	if err != nil {
+		return resp, err
+	}
+	if err := r.loadSyncSessions(ctx, &resp, lastSeqs); err != nil {
+		return resp, err
+	}
+
+	if resp.HasMore {
+		mark.After = lastID
+	} else {
+		mark = SyncMark{LocationID: locationID, From: mark.To}
+	}
+	resp.SyncToken = EncodeSyncToken(mark)
+	return resp, nil
+}
+
+// collectSyncEvents reads up to limit events after mark and folds them into
+// resp. It returns the last seq read per session and the last event id.
+func (r *MessageRepo) collectSyncEvents(ctx context.Context, resp *models.SyncResponse, userID, contactID string, mark SyncMark, limit int) (map[string]int64, int64, error) {
+	rows, err := r.DB.QueryContext(ctx, querySelectSyncEvents, userID, contactID, mark.LocationID, mark.From, mark.To, mark.After, limit+1)
+	if err != nil {
+		log.Printf("❌ Failed to read sync events: %v", err)
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	lastSeqs := make(map[string]int64)
+	lastID := mark.After
+	changed := make(map[string]bool)
+	deleted := make(map[string]bool)
+	var changedOrder []string
+	n := 0
+	for rows.Next() {
+		if n == limit {
+			resp.HasMore = true
+			break
+		}
+		n++
+
+		var ev models.SessionEvent
+		var data []byte
+		if err := rows.Scan(&lastID, &ev.SessionID, &ev.Seq, &ev.Type, &ev.MessageID, &data, &ev.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		lastSeqs[ev.SessionID] = ev.Seq
+
+		switch ev.Type {
+		case models.EventMessageDeleted:
+			deleted[ev.MessageID] = true
+		case models.EventReactionAdded, models.EventReactionRemoved:
+			var rc struct {
+				UserID string `json:"user_id"`
+				Emoji  string `json:"emoji"`
+			}
+			_ = json.Unmarshal(data, &rc)
+			resp.Reactions = append(resp.Reactions, models.ReactionChange{
+				MessageID: ev.MessageID,
+				UserID:    rc.UserID,
+				Emoji:     rc.Emoji,
+				Removed:   ev.Type == models.EventReactionRemoved,
+				Seq:       ev.Seq,
+				At:        ev.CreatedAt,
+			})
+		case models.EventMessagesRead:
+			var read struct {
+				MessageIDs []string `json:"message_ids"`
+			}
+			_ = json.Unmarshal(data, &read)
+			for _, id := range read.MessageIDs {
+				if !changed[id] {
+					changed[id] = true
+					changedOrder = append(changedOrder, id)
+				}
+			}
+		default:
+			// created, edited, pinned, unpinned, anonymized: send the message as it is now
+			if ev.MessageID != "" && !changed[ev.MessageID] {
+				changed[ev.MessageID] = true
+				changedOrder = append(changedOrder, ev.MessageID)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	for id := range deleted {
+		resp.DeletedMessageIDs = append(resp.DeletedMessageIDs, id)
+	}
+
+	var ids []uuid.UUID
+	for _, id := range changedOrder {
+		if u, err := uuid.Parse(id); err == nil && !deleted[id] {
+			ids = append(ids, u)
+		}
+	}
+	if len(ids) == 0 {
+		return lastSeqs, lastID, nil
+	}
+	return lastSeqs, lastID, r.loadSyncMessages(ctx, resp, ids)
+}
+
+// loadSyncSessions reports the sessions that had events in this sync, with
+// the last seq the client now has in each.
+func (r *MessageRepo) loadSyncSessions(ctx context.Context, resp *models.SyncResponse, lastSeqs map[string]int64) error {
+	if len(lastSeqs) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, 0, len(lastSeqs))
+	for id := range lastSeqs {
+		if u, err := uuid.Parse(id); err == nil {
+			ids = append(ids, u)
+		}
+	}
+
+	rows, err := r.DB.QueryContext(ctx, querySelectSyncSessions, pq.Array(ids))
+	if err != nil {
+		log.Printf("❌ Failed to load synced sessions: %v", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.SyncSession
+		if err := rows.Scan(&s.ID, &s.ContactID, &s.UserID, &s.LocationID, &s.StartedAt, &s.LastMessageAt); err != nil {
+			return err
+		}
+		s.LastSeq = lastSeqs[s.ID]
+		resp.Sessions = append(resp.Sessions, s)
+	}
+	return rows.Err()
+}
+
+// loadSyncMessages fetches the current state of changed messages. Messages
+// deleted since are skipped; a later sync reports the deletion.
+func (r *MessageRepo) loadSyncMessages(ctx context.Context, resp *models.SyncResponse, ids []uuid.UUID) error {
+	rows, err := r.DB.QueryContext(ctx, querySelectMessagesByIDs, pq.Array(ids))
+	if err != nil {
+		log.Printf("❌ Failed to load synced messages: %v", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return err
+		}
+		resp.Messages = append(resp.Messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if err := openMessages(ctx, resp.Messages); err != nil {
+		return err
+	}
+	if err := r.attachReactions(ctx, r.DB, resp.Messages); err != nil {
+		return err
+	}
+	return r.attachThreadInfo(ctx, resp.Messages)
+}
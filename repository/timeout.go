@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Timeouts bounds how long each kind of database operation may run. The
+// deadline applies on top of the caller's context, which is normally the
+// HTTP request's, so a client that disconnects cancels its queries too.
+type Timeouts struct {
+	Read      time.Duration // lookups, pages and listings
+	Write     time.Duration // inserts, updates and their transactions
+	Search    time.Duration // full-text search and delta sync
+	Outbox    time.Duration // handling one outbox event
+	Archive   time.Duration // exporting a partition, or reading archived history
+	Purge     time.Duration // one batch of a retention purge
+	Audit     time.Duration // verifying a location's audit chain
+	Reencrypt time.Duration // one batch of re-encryption, or counting messages by key
+}
+
+var DefaultTimeouts = Timeouts{
+	Read:      5 * time.Second,
+	Write:     5 * time.Second,
+	Search:    15 * time.Second,
+	Outbox:    30 * time.Second,
+	Archive:   2 * time.Minute,
+	Purge:     time.Minute,
+	Audit:     2 * time.Minute,
+	Reencrypt: time.Minute,
+}
+
+var timeouts = DefaultTimeouts
+
+// SetTimeouts replaces the operation timeouts. Zero fields keep their default.
+func SetTimeouts(t Timeouts) {
+	if t.Read <= 0 {
+		t.Read = DefaultTimeouts.Read
+	}
+	if t.Write <= 0 {
+		t.Write = DefaultTimeouts.Write
+	}
+	if t.Search <= 0 {
+		t.Search = DefaultTimeouts.Search
+	}
+	if t.Outbox <= 0 {
+		t.Outbox = DefaultTimeouts.Outbox
+	}
+	if t.Archive <= 0 {
+		t.Archive = DefaultTimeouts.Archive
+	}
+	if t.Purge <= 0 {
+		t.Purge = DefaultTimeouts.Purge
+	}
+	if t.Audit <= 0 {
+		t.Audit = DefaultTimeouts.Audit
+	}
+	if t.Reencrypt <= 0 {
+		t.Reencrypt = DefaultTimeouts.Reencrypt
+	}
+	timeouts = t
+}
+
+var (
+	// ErrTimeout means an operation hit its deadline. It also matches
+	// context.DeadlineExceeded with errors.Is.
+	ErrTimeout = errors.New("database operation timed out")
+
+	// ErrCanceled means the caller gave up, e.g. the client disconnected.
+	// It also matches context.Canceled with errors.Is.
+	ErrCanceled = errors.New("database operation canceled")
+)
+
+// beginOp bounds an operation by d. The returned func must be deferred with
+// the operation's error: it releases the deadline and, if the context ended,
+// replaces the driver's error (e.g. "canceling statement due to user
+// request") with ErrTimeout or ErrCanceled.
+func beginOp(ctx context.Context, d time.Duration) (context.Context, func(*error)) {
+	ctx, cancel := context.WithTimeout(ctx, d)
+	return ctx, func(err *error) {
+		defer cancel()
+		if *err != nil {
+			*err = ContextError(ctx, *err)
+		}
+	}
+}
+
+// ContextError returns err, or ErrTimeout/ErrCanceled if ctx ended. Store
+// implementations outside this package use it to report context failures
+// the same way.
+func ContextError(ctx context.Context, err error) error {
+	switch ctxErr := ctx.Err(); {
+	case errors.Is(ctxErr, context.DeadlineExceeded):
+		return fmt.Errorf("%w: %w", ErrTimeout, ctxErr)
+	case errors.Is(ctxErr, context.Canceled):
+		return fmt.Errorf("%w: %w", ErrCanceled, ctxErr)
+	}
+	return err
+}
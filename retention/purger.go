@@ -0,0 +1,137 @@
+// Package retention runs the scheduled purge that applies each location's
+// retention policy and records a report of what it removed.
+package retention
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"internal_chat_system/models"
+	"internal_chat_system/repository"
+
+	"github.com/google/uuid"
+)
+
+const defaultInterval = 24 * time.Hour
+
+// auditPurge is the audit log action of one location's purge run. The
+// purger is recorded as the SYSTEM actor with the nil id.
+const auditPurge = "retention.purge"
+
+// FileRemover deletes attachments of purged messages from storage.
+type FileRemover interface {
+	DeleteFile(ctx context.Context, fileURL string) error
+}
+
+// Purger applies every retention policy each Interval. Attachments that
+// could not be removed are listed in the report, since the messages that
+// pointed at them are gone.
+type Purger struct {
+	Repo     repository.RetentionStore
+	Files    FileRemover
+	Audit    repository.AuditStore // records each location's run
+	Interval time.Duration
+
+	// OnPurged is told which sessions lost or changed messages, e.g. to
+	// drop cached history. Optional.
+	OnPurged func(ctx context.Context, locationID string, sessionIDs []string)
+}
+
+// Run purges until ctx is done.
+func (p *Purger) Run(ctx context.Context) {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Println("🧹 Retention purger started")
+	for {
+		p.RunOnce(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			log.Println("🧹 Retention purger stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce applies every policy as of now and returns the reports it saved.
+// A location that fails still gets a report with what was purged before the
+// failure and the error.
+func (p *Purger) RunOnce(ctx context.Context, now time.Time) []models.RetentionReport {
+	policies, err := p.Repo.ListRetentionPolicies(ctx)
+	if err != nil {
+		log.Printf("❌ Failed to list retention policies: %v", err)
+		return nil
+	}
+
+	var reports []models.RetentionReport
+	for _, policy := range policies {
+		if policy.MaxAgeDays == nil && policy.DeletedMaxAgeDays == nil {
+			continue
+		}
+		report, _ := p.Repo.PurgeExpiredMessages(ctx, policy, now)
+
+		for _, url := range report.FileURLs {
+			if p.Files == nil {
+				report.FailedAttachments = append(report.FailedAttachments, url)
+				continue
+			}
+			if err := p.Files.DeleteFile(ctx, url); err != nil {
+				report.FailedAttachments = append(report.FailedAttachments, url)
+				continue
+			}
+			report.AttachmentsRemoved++
+		}
+		if p.OnPurged != nil && len(report.SessionIDs) > 0 {
+			p.OnPurged(ctx, policy.LocationID, report.SessionIDs)
+		}
+
+		report.FinishedAt = time.Now()
+		if err := p.Repo.SaveRetentionReport(ctx, &report); err != nil {
+			log.Printf("❌ Failed to save retention report for location %s: %v", policy.LocationID, err)
+		}
+		p.audit(ctx, report)
+		log.Printf("🧹 Retention in location %s: %d deleted, %d anonymized, %d attachments removed, %d failed",
+			policy.LocationID, report.MessagesDeleted, report.MessagesAnonymized,
+			report.AttachmentsRemoved, len(report.FailedAttachments))
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// audit records what a location's run removed. Failures are logged; the
+// purge has already happened and its report is saved.
+func (p *Purger) audit(ctx context.Context, report models.RetentionReport) {
+	if p.Audit == nil {
+		return
+	}
+	details, _ := json.Marshal(map[string]any{
+		"action":              report.Action,
+		"messages_deleted":    report.MessagesDeleted,
+		"messages_anonymized": report.MessagesAnonymized,
+		"attachments_removed": report.AttachmentsRemoved,
+		"attachments_failed":  len(report.FailedAttachments),
+		"sessions":            report.SessionsAffected,
+		"error":               report.Error,
+	})
+	e := models.AuditEntry{
+		LocationID: report.LocationID,
+		ActorID:    uuid.Nil.String(),
+		ActorType:  "SYSTEM",
+		Action:     auditPurge,
+		TargetType: "retention_report",
+		TargetID:   report.ID,
+		Details:    details,
+	}
+	if err := p.Audit.Record(ctx, &e); err != nil {
+		log.Printf("❌ Failed to audit retention run in location %s: %v", report.LocationID, err)
+	}
+}
@@ -0,0 +1,60 @@
+package retention
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"internal_chat_system/models"
+	"internal_chat_system/repository/memory"
+
+	"github.com/google/uuid"
+)
+
+func TestRunOnce(t *testing.T) {
+	tests := []struct {
+		action    string
+		wantEvent string
+	}{
+		{models.RetentionDelete, models.EventMessageDeleted},
+		{models.RetentionAnonymize, models.EventMessageAnonymized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			ctx := context.Background()
+			store := memory.New()
+			loc := uuid.NewString()
+			msg := &models.Message{
+				ID: uuid.NewString(), LocationID: loc, SenderUserID: uuid.NewString(),
+				ReceiverContactID: uuid.NewString(), Content: "lab results",
+			}
+			if err := store.SaveMessage(ctx, msg); err != nil {
+				t.Fatal(err)
+			}
+			days := 30
+			if err := store.UpsertRetentionPolicy(ctx, &models.RetentionPolicy{LocationID: loc, Action: tt.action, MaxAgeDays: &days}); err != nil {
+				t.Fatal(err)
+			}
+
+			purger := &Purger{Repo: store, Audit: store}
+			reports := purger.RunOnce(ctx, time.Now().AddDate(0, 0, days+1))
+			if len(reports) != 1 || reports[0].MessagesDeleted+reports[0].MessagesAnonymized != 1 {
+				t.Fatalf("reports = %+v", reports)
+			}
+
+			// Synced clients are told about the change
+			page, err := store.GetSessionEvents(ctx, msg.SessionID, msg.Seq, 10)
+			if err != nil || len(page.Events) != 1 || page.Events[0].Type != tt.wantEvent || page.Events[0].MessageID != msg.ID {
+				t.Errorf("events after the purge = %+v, %v, want one %s", page.Events, err, tt.wantEvent)
+			}
+
+			entries, err := store.ListAuditEntries(ctx, models.AuditFilter{LocationID: loc, Action: auditPurge})
+			if err != nil || len(entries) != 1 {
+				t.Fatalf("audit entries = %+v, %v, want one run", entries, err)
+			}
+			if e := entries[0]; e.ActorType != "SYSTEM" || e.TargetID != reports[0].ID {
+				t.Errorf("audit entry = %+v", e)
+			}
+		})
+	}
+}
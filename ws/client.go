@@ -1,36 +1,67 @@
 package ws
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"time"
 
+	"internal_chat_system/middleware/auth"
 	"internal_chat_system/models"
 	"internal_chat_system/presence"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
+// SendBuffer is how many outgoing messages a client may have waiting
+// before the hub starts dropping or disconnecting it.
+const SendBuffer = 256
+
+// idleAfter is how long a connection may go without user activity before the
+// participant is shown as away.
+const idleAfter = 5 * time.Minute
+
 type Client struct {
 	Conn       *websocket.Conn
 	Send       chan []byte
-	UserID     string
-	ContactID  string
+	UserID     string // set for doctors
+	ContactID  string // set for patients
 	LocationID string
 	Hub        *Hub
+
+	// Auth is the caller verified when the socket was opened. Everything
+	// the client does over the socket acts as this identity.
+	Auth auth.AuthContext
+
+	connID       string // tells this connection apart in presence
+	lastActivity time.Time
 }
 
 func (c *Client) ReadPump() {
+	// ctx lives as long as the connection, so work started by a message is
+	// abandoned once the client goes away
+	ctx, cancel := context.WithCancel(context.Background())
 	defer func() {
-		// ❌ Mark user offline in Redis
-		presence.MarkUserOffline(c.UserID, c.ContactID, c.LocationID)
+		cancel()
+
+		// ❌ Release this connection's presence in Redis. The connection's
+		// context is gone by now, so this must not depend on it.
+		if err := presence.Disconnect(context.Background(), c.Participant(), c.connID); err != nil {
+			log.Printf("⚠️ Failed to update presence on disconnect: %v", err)
+		}
 
 		c.Hub.Unregister <- c
 		c.Conn.Close()
 	}()
 
+	c.connID = uuid.NewString()
+	c.lastActivity = time.Now()
+
 	// ✅ Mark user online on connect
-	presence.MarkUserOnline(c.UserID, c.ContactID, c.LocationID)
+	if err := presence.Connect(ctx, c.Participant(), c.connID); err != nil {
+		log.Printf("⚠️ Failed to update presence on connect: %v", err)
+	}
 
 	for {
 		_, msg, err := c.Conn.ReadMessage()
@@ -40,11 +71,15 @@ func (c *Client) ReadPump() {
 
 		// Detect base type
 		var base struct {
-			Type string `json:"type"`
+			Type   string `json:"type"`
+			Active bool   `json:"active,omitempty"` // set on pings sent after user interaction
 		}
 		if err := json.Unmarshal(msg, &base); err != nil {
 			continue
 		}
+		if base.Type != "ping" || base.Active {
+			c.lastActivity = time.Now()
+		}
 
 		switch base.Type {
 		case "typing":
@@ -53,15 +88,103 @@ func (c *Client) ReadPump() {
 				LocationID: c.LocationID,
 				RawData:    msg,
 			}
+		case "message":
+			c.handleSend(ctx, msg)
+		case "presence_subscribe", "presence_unsubscribe":
+			var sub PresenceSubscription
+			if err := json.Unmarshal(msg, &sub); err != nil {
+				continue
+			}
+			c.handlePresenceSubscription(ctx, sub)
 		case "ping":
-			// Refresh online status heartbeat
-			presence.MarkUserOnline(c.UserID, c.ContactID, c.LocationID)
+			// Refresh online status heartbeat; idle clients drop to away
+			idle := time.Since(c.lastActivity) > idleAfter
+			if err := presence.Heartbeat(ctx, c.Participant(), c.connID, idle); err != nil {
+				log.Printf("⚠️ Failed to refresh presence heartbeat: %v", err)
+			}
 		default:
 			// Unknown type, ignore
 		}
 	}
 }
 
+// handleSend passes a chat message to the hub's SendMessage hook and writes
+// the ack back, so clients can retry with the same client_message_id.
+func (c *Client) handleSend(ctx context.Context, raw []byte) {
+	var msg models.Message
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		c.writeJSON(MessageAck{Type: "message_error", Error: "Invalid message payload"})
+		return
+	}
+	if c.Hub.SendMessage == nil {
+		c.writeJSON(MessageAck{Type: "message_error", ClientMessageID: msg.ClientMessageID, Error: "Sending over WebSocket is not enabled"})
+		return
+	}
+	c.writeJSON(c.Hub.SendMessage(ctx, c, msg))
+}
+
+func (c *Client) writeJSON(v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("❌ Failed to encode socket reply: %v", err)
+		return
+	}
+	c.Deliver(data)
+}
+
+// Deliver queues data for this client only. It goes through the hub, which
+// owns Send, so it is safe from any goroutine and never blocks on a slow
+// writer.
+func (c *Client) Deliver(data []byte) {
+	c.Hub.Direct <- DirectMessage{Client: c, Data: data}
+}
+
+// Participant is the presence identity of this connection. A connection that
+// carries a contact_id belongs to the patient, otherwise to the user.
+func (c *Client) Participant() presence.Participant {
+	if c.ContactID != "" {
+		return presence.Contact(c.LocationID, c.ContactID)
+	}
+	return presence.User(c.LocationID, c.UserID)
+}
+
+// handlePresenceSubscription registers the requested watches with the hub and
+// replies with a snapshot of each newly watched participant, so the client
+// doesn't have to wait for the next transition to render a status.
+func (c *Client) handlePresenceSubscription(ctx context.Context, sub PresenceSubscription) {
+	unwatch := sub.Type == "presence_unsubscribe"
+
+	var participants []presence.Participant
+	for _, t := range sub.Targets {
+		if t.ID == "" || (t.Kind != presence.KindUser && t.Kind != presence.KindContact) {
+			continue
+		}
+		p := presence.Participant{LocationID: c.LocationID, Kind: t.Kind, ID: t.ID}
+		if !unwatch && c.Hub.CanWatch != nil && !c.Hub.CanWatch(ctx, c, p) {
+			log.Printf("🚫 Presence subscription denied: user=%s contact=%s target=%s:%s", c.UserID, c.ContactID, t.Kind, t.ID)
+			continue
+		}
+		participants = append(participants, p)
+	}
+	if len(participants) == 0 {
+		return
+	}
+
+	c.Hub.Watch <- WatchRequest{Client: c, Participants: participants, Unwatch: unwatch}
+	if unwatch {
+		return
+	}
+
+	for _, p := range participants {
+		status, err := presence.Get(ctx, p)
+		if err != nil {
+			log.Printf("⚠️ Presence snapshot failed for %s:%s: %v", p.Kind, p.ID, err)
+			continue
+		}
+		c.writeJSON(presence.NewEvent(p, status))
+	}
+}
+
 func (c *Client) WritePump() {
 	defer c.Conn.Close()
 	for {
@@ -2,14 +2,37 @@
 package ws
 
 import (
+	"context"
+	"encoding/json"
 	"internal_chat_system/models"
+	"internal_chat_system/presence"
 	"log"
+	"slices"
 )
 
 type BroadcastMessage struct {
 	LocationID string
 	Message    models.Message
 	RawData    []byte
+
+	// Participants limits delivery to these participants' connections.
+	// Anything about a conversation must set it, or every client in the
+	// location receives it.
+	Participants []presence.Participant
+}
+
+// DirectMessage is sent to a single client, e.g. a reply to something it
+// sent. The hub drops it if the client is gone or its buffer is full.
+type DirectMessage struct {
+	Client *Client
+	Data   []byte
+}
+
+// WatchRequest adds or removes presence subscriptions for a client.
+type WatchRequest struct {
+	Client       *Client
+	Participants []presence.Participant
+	Unwatch      bool
 }
 
 type Hub struct {
@@ -17,6 +40,20 @@ type Hub struct {
 	Register   chan *Client
 	Unregister chan *Client
 	Broadcast  chan BroadcastMessage
+	Direct     chan DirectMessage
+	Watch      chan WatchRequest
+	Presence   chan presence.Event
+
+	// CanWatch decides whether a client may follow a participant's presence.
+	// It runs on the client's read goroutine, so it may hit the database; ctx
+	// ends when the connection closes.
+	CanWatch func(ctx context.Context, c *Client, p presence.Participant) bool
+
+	// SendMessage stores and delivers a message sent over the socket. It runs
+	// on the client's read goroutine and its ack is written back to the client.
+	SendMessage func(ctx context.Context, c *Client, msg models.Message) MessageAck
+
+	watchers map[presence.Participant]map[*Client]bool
 }
 
 func NewHub() *Hub {
@@ -25,6 +62,10 @@ func NewHub() *Hub {
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
 		Broadcast:  make(chan BroadcastMessage),
+		Direct:     make(chan DirectMessage),
+		Watch:      make(chan WatchRequest),
+		Presence:   make(chan presence.Event),
+		watchers:   make(map[presence.Participant]map[*Client]bool),
 	}
 }
 
@@ -43,39 +84,100 @@ func (h *Hub) Run() {
 			if clients, ok := h.Clients[client.LocationID]; ok {
 				if _, ok := clients[client]; ok {
 					delete(clients, client)
+					h.unwatchAll(client)
 					close(client.Send)
 					log.Printf("👋 Client unregistered: user=%s contact=%s location=%s", client.UserID, client.ContactID, client.LocationID)
 				}
 			}
 
+		case msg := <-h.Direct:
+			// Only the hub closes Send, so checking registration here
+			// can't race with the close
+			if !h.Clients[msg.Client.LocationID][msg.Client] {
+				continue
+			}
+			select {
+			case msg.Client.Send <- msg.Data:
+			default:
+				log.Printf("⚠️ Dropped reply for busy client: user=%s contact=%s", msg.Client.UserID, msg.Client.ContactID)
+			}
+
+		case req := <-h.Watch:
+			for _, p := range req.Participants {
+				if req.Unwatch {
+					h.unwatch(req.Client, p)
+					continue
+				}
+				if h.watchers[p] == nil {
+					h.watchers[p] = make(map[*Client]bool)
+				}
+				h.watchers[p][req.Client] = true
+			}
+
+		case event := <-h.Presence:
+			watchers, ok := h.watchers[event.Participant()]
+			if !ok {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("❌ Failed to encode presence event: %v", err)
+				continue
+			}
+			for client := range watchers {
+				select {
+				case client.Send <- data:
+				default:
+					log.Printf("⚠️ Dropped presence event for busy client: user=%s contact=%s", client.UserID, client.ContactID)
+				}
+			}
+
 		case msg := <-h.Broadcast:
 			clients, ok := h.Clients[msg.LocationID]
 			if !ok {
 				log.Printf("⚠️ No clients to broadcast for location %s", msg.LocationID)
 				continue
 			}
-			data, err := EncodeMessage(msg.Message)
-			if err != nil {
-				log.Printf("❌ Failed to encode message for broadcast: %v", err)
-				continue
+			// Raw events (typing, edits, ...) are sent as-is instead of a message
+			data := msg.RawData
+			if data == nil {
+				encoded, err := EncodeMessage(msg.Message)
+				if err != nil {
+					log.Printf("❌ Failed to encode message for broadcast: %v", err)
+					continue
+				}
+				data = encoded
 			}
 			for client := range clients {
+				if len(msg.Participants) > 0 && !slices.Contains(msg.Participants, client.Participant()) {
+					continue
+				}
 				select {
 				case client.Send <- data:
 					log.Printf("📤 Message sent to client: user=%s contact=%s", client.UserID, client.ContactID)
 				default:
 					close(client.Send)
 					delete(clients, client)
+					h.unwatchAll(client)
 					log.Printf("⚠️ Client channel closed unexpectedly: user=%s contact=%s", client.UserID, client.ContactID)
 				}
 			}
-			if msg.RawData != nil {
-				for client := range h.Clients[msg.LocationID] {
-					client.Send <- msg.RawData
-				}
-				continue
-			}
 		}
 
 	}
 }
+
+func (h *Hub) unwatch(c *Client, p presence.Participant) {
+	if watchers, ok := h.watchers[p]; ok {
+		delete(watchers, c)
+		if len(watchers) == 0 {
+			delete(h.watchers, p)
+		}
+	}
+}
+
+func (h *Hub) unwatchAll(c *Client) {
+	for p := range h.watchers {
+		h.unwatch(c, p)
+	}
+}
@@ -6,3 +6,26 @@ type WebSocketPayload struct {
 	UserID    string `json:"user_id,omitempty"`
 	Typing    bool   `json:"typing,omitempty"`
 }
+
+// PresenceTarget names a participant whose presence a client wants to follow.
+type PresenceTarget struct {
+	Kind string `json:"kind"` // "user" or "contact"
+	ID   string `json:"id"`
+}
+
+// PresenceSubscription is sent by clients as "presence_subscribe" or
+// "presence_unsubscribe".
+type PresenceSubscription struct {
+	Type    string           `json:"type"`
+	Targets []PresenceTarget `json:"targets"`
+}
+
+// MessageAck answers a "message" sent over the socket. Retries with the same
+// client_message_id are acknowledged with the stored message and Duplicate set.
+type MessageAck struct {
+	Type            string `json:"type"` // "message_ack" or "message_error"
+	ClientMessageID string `json:"client_message_id,omitempty"`
+	Duplicate       bool   `json:"duplicate,omitempty"`
+	Message         any    `json:"message,omitempty"`
+	Error           string `json:"error,omitempty"`
+}